@@ -3,19 +3,47 @@ package account
 import (
 	"fmt"
 	"strings"
+	"time"
 
+	"github.com/derinil/links/links/css"
 	"github.com/derinil/links/links/generic"
+	"github.com/google/uuid"
 )
 
-type Account struct {
-	generic.DBStruct
-	Name     string `validate:"max=128" db:"name"`
-	Handle   string `validate:"handle" db:"handle"`
-	Password string `validate:"max=5000,css" db:"password"`
-	CSS      string `validate:"css" db:"css"`
-	Avi      []byte `db:"avi"`
-	Links    []Link `db:"-"`
-}
+type (
+	Account struct {
+		generic.DBStruct
+		Name     string `validate:"max=128" db:"name"`
+		Handle   string `validate:"handle" db:"handle"`
+		Password string `validate:"max=5000" db:"password"`
+		CSS      string `db:"css"`
+		Avi      []byte `db:"avi"`
+		// A smaller rendition of Avi for places like the navbar
+		AviThumbnail []byte `db:"avi_thumbnail"`
+		Links        []Link `db:"-"`
+		// IDs of links that were taken out of Links and
+		// have to be deleted when the account is saved
+		RemovedLinks []uuid.UUID `db:"-"`
+		// Only loaded when GetCmd.PreviousHandles is set, newest first. Saving
+		// a handle change adds the old handle in front.
+		PreviousHandles []PreviousHandle `db:"-"`
+		// Email is optional, it's only used once it's verified
+		Email           string     `validate:"omitempty,email,max=320" db:"email"`
+		EmailVerifiedAt *time.Time `db:"email_verified_at"`
+		// ID of a theme from the theme package, empty is the default theme
+		Theme string `validate:"max=64" db:"theme"`
+		// Set while the account waits out its grace period before it's purged
+		DeletionRequestedAt *time.Time `db:"deletion_requested_at"`
+	}
+
+	// PreviousHandle is a handle the account used to have. It stays
+	// reserved for the account so nobody else can claim it.
+	PreviousHandle struct {
+		Handle    string    `db:"handle"`
+		AccountID uuid.UUID `db:"account_id"`
+		ChangedAt time.Time `db:"changed_at"`
+	}
+)
 
 func New(name, handle, password string) *Account {
 	return &Account{
@@ -26,10 +54,108 @@ func New(name, handle, password string) *Account {
 	}
 }
 
+// Sanitize also drops whatever the CSS policy doesn't allow, what was left
+// out can be found with css.DefaultPolicy.Validate on the submitted CSS
 func (a *Account) Sanitize() {
-	a.CSS = strings.TrimSpace(a.CSS)
+	a.CSS = SanitizeCSS(a.CSS)
 	a.Name = strings.TrimSpace(a.Name)
 	a.Handle = strings.ToLower(strings.TrimSpace(a.Handle))
+	a.Email = strings.TrimSpace(a.Email)
+}
+
+// EmailVerified reports whether the current email was verified
+func (a *Account) EmailVerified() bool {
+	return a.Email != "" && a.EmailVerifiedAt != nil
+}
+
+// PendingDeletion reports whether the account is waiting to be purged,
+// its public page is hidden in the meantime
+func (a *Account) PendingDeletion() bool {
+	return a.DeletionRequestedAt != nil
+}
+
+// Scaffolds returns scaffolds for the account's links in their current order
+func (a *Account) Scaffolds() []LinkScaffold {
+	scaffolds := make([]LinkScaffold, len(a.Links))
+	for i := range a.Links {
+		scaffolds[i] = LinkScaffold{
+			ID:    a.Links[i].ID,
+			Title: a.Links[i].Title,
+			Link:  a.Links[i].Link,
+		}
+	}
+
+	return scaffolds
+}
+
+// SetLinks diffs the scaffolds against the account's current links. Scaffolds
+// are matched to existing links by ID first and then by URL, matched links are
+// updated in place, the rest are created, and links that are left over are
+// moved to RemovedLinks. The order of the scaffolds becomes the order of the links.
+func (a *Account) SetLinks(scaffolds []LinkScaffold) error {
+	var (
+		byID  = make(map[uuid.UUID]*Link, len(a.Links))
+		byURL = make(map[string]*Link, len(a.Links))
+		seen  = make(map[string]struct{}, len(scaffolds))
+		links = make([]Link, 0, len(scaffolds))
+		claim = func(l *Link) {
+			delete(byID, l.ID)
+			delete(byURL, l.Link)
+		}
+	)
+
+	for i := range a.Links {
+		l := &a.Links[i]
+		byID[l.ID] = l
+		byURL[l.Link] = l
+	}
+
+	for i := range scaffolds {
+		sc := &scaffolds[i]
+
+		nl := NewLink(a.ID, sc.Title, sc.Link, i)
+
+		nl.Sanitize()
+		if err := nl.Validate(); err != nil {
+			return fmt.Errorf("failed to validate link: %w", err)
+		}
+
+		if _, ok := seen[nl.Link]; ok {
+			return ErrDuplicateLink
+		}
+		seen[nl.Link] = struct{}{}
+
+		ol, ok := byID[sc.ID]
+		if !ok {
+			ol, ok = byURL[nl.Link]
+		}
+
+		if ok {
+			claim(ol)
+
+			el := *ol
+			// The favicon belongs to the old URL
+			if el.Link != nl.Link {
+				el.Favicon = nil
+			}
+			el.Title = nl.Title
+			el.Link = nl.Link
+			el.Index = i
+			nl = &el
+		}
+
+		links = append(links, *nl)
+	}
+
+	for i := range a.Links {
+		if _, ok := byID[a.Links[i].ID]; ok {
+			a.RemovedLinks = append(a.RemovedLinks, a.Links[i].ID)
+		}
+	}
+
+	a.Links = links
+
+	return nil
 }
 
 func (a *Account) Validate() error {
@@ -59,3 +185,10 @@ func (a *Account) BeforeSave() error {
 func (a *Account) AfterLoad() error {
 	return nil
 }
+
+// SanitizeCSS changes nothing in CSS it already sanitized. Stored CSS went
+// through it when it was saved, so pages render it as it is.
+func SanitizeCSS(src string) string {
+	out, _ := css.DefaultPolicy.Sanitize(src)
+	return strings.TrimSpace(out)
+}
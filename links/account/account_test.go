@@ -0,0 +1,222 @@
+package account_test
+
+import (
+	"testing"
+
+	"github.com/derinil/links/links/account"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetLinks(t *testing.T) {
+	var (
+		a     = account.New("name", "handle", "password")
+		first = *account.NewLink(a.ID, "First", "https://first.com", 0)
+		other = *account.NewLink(a.ID, "Other", "https://other.com", 1)
+		third = *account.NewLink(a.ID, "Third", "https://third.com", 2)
+	)
+
+	type expectedLink struct {
+		id    uuid.UUID
+		title string
+		link  string
+	}
+
+	testCases := []struct {
+		name      string
+		scaffolds []account.LinkScaffold
+		expected  []expectedLink
+		removed   []uuid.UUID
+		err       error
+		errStr    string
+	}{
+		{
+			name: "keep everything as is",
+			scaffolds: []account.LinkScaffold{
+				{ID: first.ID, Title: "First", Link: "https://first.com"},
+				{ID: other.ID, Title: "Other", Link: "https://other.com"},
+				{ID: third.ID, Title: "Third", Link: "https://third.com"},
+			},
+			expected: []expectedLink{
+				{first.ID, "First", "https://first.com"},
+				{other.ID, "Other", "https://other.com"},
+				{third.ID, "Third", "https://third.com"},
+			},
+		},
+		{
+			name: "reorder",
+			scaffolds: []account.LinkScaffold{
+				{ID: third.ID, Title: "Third", Link: "https://third.com"},
+				{ID: first.ID, Title: "First", Link: "https://first.com"},
+				{ID: other.ID, Title: "Other", Link: "https://other.com"},
+			},
+			expected: []expectedLink{
+				{third.ID, "Third", "https://third.com"},
+				{first.ID, "First", "https://first.com"},
+				{other.ID, "Other", "https://other.com"},
+			},
+		},
+		{
+			name: "delete the middle link",
+			scaffolds: []account.LinkScaffold{
+				{ID: first.ID, Title: "First", Link: "https://first.com"},
+				{ID: third.ID, Title: "Third", Link: "https://third.com"},
+			},
+			expected: []expectedLink{
+				{first.ID, "First", "https://first.com"},
+				{third.ID, "Third", "https://third.com"},
+			},
+			removed: []uuid.UUID{other.ID},
+		},
+		{
+			name:      "delete everything",
+			scaffolds: []account.LinkScaffold{},
+			expected:  []expectedLink{},
+			removed:   []uuid.UUID{first.ID, other.ID, third.ID},
+		},
+		{
+			name: "change url by id",
+			scaffolds: []account.LinkScaffold{
+				{ID: first.ID, Title: "First", Link: "https://changed.com"},
+				{ID: other.ID, Title: "Other", Link: "https://other.com"},
+				{ID: third.ID, Title: "Third", Link: "https://third.com"},
+			},
+			expected: []expectedLink{
+				{first.ID, "First", "https://changed.com"},
+				{other.ID, "Other", "https://other.com"},
+				{third.ID, "Third", "https://third.com"},
+			},
+		},
+		{
+			name: "swap urls by id",
+			scaffolds: []account.LinkScaffold{
+				{ID: first.ID, Title: "First", Link: "https://other.com"},
+				{ID: other.ID, Title: "Other", Link: "https://first.com"},
+				{ID: third.ID, Title: "Third", Link: "https://third.com"},
+			},
+			expected: []expectedLink{
+				{first.ID, "First", "https://other.com"},
+				{other.ID, "Other", "https://first.com"},
+				{third.ID, "Third", "https://third.com"},
+			},
+		},
+		{
+			name: "match by url without id",
+			scaffolds: []account.LinkScaffold{
+				{Title: "New Title", Link: "https://other.com"},
+			},
+			expected: []expectedLink{
+				{other.ID, "New Title", "https://other.com"},
+			},
+			removed: []uuid.UUID{first.ID, third.ID},
+		},
+		{
+			name: "add a new link at the top",
+			scaffolds: []account.LinkScaffold{
+				{Title: "New", Link: "https://new.com"},
+				{ID: first.ID, Title: "First", Link: "https://first.com"},
+				{ID: other.ID, Title: "Other", Link: "https://other.com"},
+				{ID: third.ID, Title: "Third", Link: "https://third.com"},
+			},
+			expected: []expectedLink{
+				{uuid.Nil, "New", "https://new.com"},
+				{first.ID, "First", "https://first.com"},
+				{other.ID, "Other", "https://other.com"},
+				{third.ID, "Third", "https://third.com"},
+			},
+		},
+		{
+			name: "unknown id creates a new link",
+			scaffolds: []account.LinkScaffold{
+				{ID: uuid.New(), Title: "New", Link: "https://new.com"},
+			},
+			expected: []expectedLink{
+				{uuid.Nil, "New", "https://new.com"},
+			},
+			removed: []uuid.UUID{first.ID, other.ID, third.ID},
+		},
+		{
+			name: "duplicate url",
+			scaffolds: []account.LinkScaffold{
+				{ID: first.ID, Title: "First", Link: "https://first.com"},
+				{Title: "Again", Link: " https://first.com "},
+			},
+			err: account.ErrDuplicateLink,
+		},
+		{
+			name: "invalid url",
+			scaffolds: []account.LinkScaffold{
+				{ID: first.ID, Title: "First", Link: "first"},
+			},
+			errStr: "Link.Link",
+		},
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			b := *a
+			b.Links = []account.Link{first, other, third}
+
+			err := b.SetLinks(c.scaffolds)
+			if c.err != nil || c.errStr != "" {
+				if c.err != nil {
+					require.ErrorIs(t, err, c.err)
+				} else {
+					require.ErrorContains(t, err, c.errStr)
+				}
+				return
+			}
+
+			require.Nil(t, err)
+			require.Equal(t, c.removed, b.RemovedLinks)
+			require.Len(t, b.Links, len(c.expected))
+
+			for i, el := range c.expected {
+				l := &b.Links[i]
+				if el.id == uuid.Nil {
+					require.NotContains(t, []uuid.UUID{first.ID, other.ID, third.ID}, l.ID)
+				} else {
+					require.Equal(t, el.id, l.ID)
+				}
+				require.Equal(t, el.title, l.Title)
+				require.Equal(t, el.link, l.Link)
+				require.Equal(t, i, l.Index)
+				require.Equal(t, a.ID, l.AccountID)
+			}
+		})
+	}
+}
+
+func TestSetLinksFavicons(t *testing.T) {
+	var (
+		a     = account.New("name", "handle", "password")
+		first = *account.NewLink(a.ID, "First", "https://first.com", 0)
+		other = *account.NewLink(a.ID, "Other", "https://other.com", 1)
+	)
+
+	first.Favicon = []byte("first")
+	other.Favicon = []byte("other")
+	a.Links = []account.Link{first, other}
+
+	err := a.SetLinks([]account.LinkScaffold{
+		{ID: first.ID, Title: "Renamed", Link: "https://first.com"},
+		{ID: other.ID, Title: "Other", Link: "https://changed.com"},
+	})
+	require.Nil(t, err)
+
+	require.Equal(t, []byte("first"), a.Links[0].Favicon)
+	require.Nil(t, a.Links[1].Favicon)
+}
+
+func TestBeforeSaveLegacyCSS(t *testing.T) {
+	// Stored before the CSS policy, this has to keep the account saveable
+	a := account.New("name", "handle", "password")
+	a.CSS = "@import url(https://evil.example.com/x.css);\na { color: red; pointer-events: none }"
+
+	require.Nil(t, a.BeforeSave())
+	require.Equal(t, "#profile a {\n  color: red;\n}", a.CSS)
+
+	// Saving again doesn't change it
+	require.Nil(t, a.BeforeSave())
+	require.Equal(t, "#profile a {\n  color: red;\n}", a.CSS)
+}
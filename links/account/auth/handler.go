@@ -4,7 +4,10 @@ import (
 	"context"
 
 	"github.com/derinil/links/links/account"
+	"github.com/derinil/links/links/account/oidc"
+	"github.com/derinil/links/links/account/passkey"
 	"github.com/derinil/links/links/account/session"
+	"github.com/derinil/links/links/account/token"
 	"github.com/derinil/links/links/generic"
 )
 
@@ -21,6 +24,20 @@ type (
 		SessionToken string
 		Account      *account.Account
 		Session      *session.Session
+		// Only set when authenticating with an access token
+		Token *token.Token
+		// Set instead of a session when the password was right but the
+		// account has two factor authentication on, see TwoFactor
+		PendingToken string
+		// Set by the passkey methods to the passkey that was used or registered
+		Passkey *passkey.Credential
+		// Set by OIDC when the identity was linked to the logged in account
+		Identity *oidc.Identity
+		// Set by OIDC instead of a session when nobody has linked the
+		// identity yet, see OIDCSignup
+		SignupToken string
+		// Set when logging in cancelled the account's pending deletion
+		DeletionCancelled bool
 	}
 
 	AuthCmd struct {
@@ -41,6 +58,21 @@ const (
 	Register Method = "register"
 	Logout   Method = "logout"
 	Login    Method = "login"
+	// Authenticates API requests with a personal access token instead of a
+	// session cookie, the resulting session is never stored anywhere
+	AccessToken Method = "access_token"
+	// Finishes a login that is waiting for a two factor code
+	TwoFactor Method = "two_factor"
+	// Logs in with a passkey, which skips two factor authentication
+	// since passkeys require user verification
+	Passkey Method = "passkey"
+	// Adds a passkey to an account that is already logged in
+	PasskeyRegistration Method = "passkey_registration"
+	// Finishes a login at an OpenID Connect provider, which either logs in,
+	// links the identity to the logged in account or starts a signup
+	OIDC Method = "oidc"
+	// Creates an account for an identity nobody has linked yet
+	OIDCSignup Method = "oidc_signup"
 )
 
 var _ Handler = (*HandlerImpl)(nil)
@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/derinil/links/links/account"
+	"github.com/derinil/links/links/account/auth"
+	"github.com/derinil/links/links/account/session"
+	"github.com/derinil/links/links/account/token"
+)
+
+type AccessTokenCmd struct {
+	Token string
+}
+
+func AccessTokenHandler(
+	accountHandler account.Handler,
+	tokenHandler token.Handler,
+) *Handler {
+	return &Handler{
+		method: auth.AccessToken,
+		handle: func(ctx context.Context, cmda any) (*auth.Auth, error) {
+			cmd := cmda.(*AccessTokenCmd)
+
+			t, err := tokenHandler.Authenticate(ctx, cmd.Token)
+			if err != nil {
+				return nil, fmt.Errorf("failed to authenticate token: %w", err)
+			}
+
+			a, err := accountHandler.Get(ctx, &account.GetCmd{ID: t.AccountID, Shallow: true})
+			if err != nil {
+				return nil, fmt.Errorf("failed to get account: %w", err)
+			}
+
+			// Tokens stop working with the sessions when deletion is requested
+			if a == nil || a.PendingDeletion() {
+				return nil, token.ErrInvalidToken
+			}
+
+			// The session only lives as long as the request, so
+			// everything downstream can treat both the same way
+			s := session.New(a.ID, a.Handle)
+			s.ExpiresAt = t.ExpiresAt
+
+			return &auth.Auth{
+				Account: a,
+				Session: s,
+				Token:   t,
+			}, nil
+		},
+	}
+}
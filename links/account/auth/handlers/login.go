@@ -2,60 +2,223 @@ package handlers
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/derinil/links/links/account"
 	"github.com/derinil/links/links/account/auth"
 	"github.com/derinil/links/links/account/session"
+	"github.com/derinil/links/links/account/totp"
 	"github.com/derinil/links/links/crypto"
 	"github.com/derinil/links/links/generic"
+	"github.com/derinil/links/links/ratelimit"
+	"github.com/google/uuid"
 )
 
 type LoginCmd struct {
 	Handle   string
 	Password string
+	// Remember issues a session with the longer idle timeout
+	Remember bool
 }
 
 var ErrLoginInvalid = generic.NewWebError(http.StatusBadRequest, "login_invalid", "Login failed")
 
+var (
+	// Guessing the password of one account from anywhere. Anyone can lock an account
+	// out with this, so the lockouts start short and only grow for whoever keeps at it.
+	LoginHandleRule = &ratelimit.Rule{
+		Name:       "login-handle",
+		Limit:      5,
+		Window:     15 * time.Minute,
+		Lockout:    time.Minute,
+		MaxLockout: time.Hour,
+	}
+	// Guessing the passwords of many accounts from one IP
+	LoginIPRule = &ratelimit.Rule{
+		Name:       "login-ip",
+		Limit:      20,
+		Window:     15 * time.Minute,
+		Lockout:    5 * time.Minute,
+		MaxLockout: 24 * time.Hour,
+	}
+)
+
 func LoginHandler(
 	accountHandler account.Handler,
 	sessionHandler session.Handler,
+	totpHandler totp.Handler,
+	limiter ratelimit.Limiter,
 ) *Handler {
 	return &Handler{
 		method: auth.Login,
 		handle: func(ctx context.Context, cmda any) (*auth.Auth, error) {
 			cmd := cmda.(*LoginCmd)
+			keys := loginKeys(ctx, cmd.Handle)
+
+			for rule, key := range keys {
+				if err := limiter.Check(ctx, rule, key); err != nil {
+					return nil, err
+				}
+			}
 
+			// Unknown handles fail like wrong passwords do, so they're counted
+			// the same and don't tell anyone which handles exist
 			a, err := accountHandler.Get(ctx, &account.GetCmd{Handle: cmd.Handle})
+			if errors.Is(err, account.ErrAccountNotFound) {
+				return nil, loginFailed(ctx, limiter, keys)
+			}
+
 			if err != nil {
 				return nil, fmt.Errorf("failed to get account: %w", err)
 			}
 
-			if a == nil {
-				return nil, ErrLoginInvalid
+			// Accounts created through a login provider have no password
+			if a.Password == "" {
+				return nil, loginFailed(ctx, limiter, keys)
 			}
 
-			ok, err := crypto.CompareSha256(cmd.Password, a.Handle, a.Password)
+			ok, err := crypto.ComparePassword(cmd.Password, a.Handle, a.Password)
 			if err != nil {
 				return nil, fmt.Errorf("failed to compare passwords: %w", err)
 			}
 
 			if !ok {
-				return nil, ErrLoginInvalid
+				return nil, loginFailed(ctx, limiter, keys)
 			}
 
-			s, t, err := sessionHandler.Issue(ctx, a.ID, a.Handle)
-			if err != nil {
-				return nil, fmt.Errorf("failed to issue session: %w", err)
+			// Failures from other IPs shouldn't lock the owner out after they got in
+			if err := limiter.Reset(ctx, LoginHandleRule, keys[LoginHandleRule]); err != nil {
+				log.Println("failed to reset login rate limit", err)
+			}
+
+			// We only know the plaintext password right now, so this is
+			// the only chance to upgrade legacy or outdated hashes
+			if crypto.NeedsRehash(a.Password) {
+				pw, err := crypto.HashPassword(cmd.Password)
+				if err != nil {
+					return nil, fmt.Errorf("failed to hash password: %w", err)
+				}
+
+				if err := accountHandler.UpdatePassword(ctx, &account.UpdatePasswordCmd{
+					AccountID: a.ID,
+					Password:  pw,
+				}); err != nil {
+					return nil, fmt.Errorf("failed to rehash password: %w", err)
+				}
+
+				a.Password = pw
 			}
 
-			return &auth.Auth{
-				Account:      a,
-				SessionToken: t,
-				Session:      s,
-			}, nil
+			return issueSession(ctx, a, cmd.Remember, accountHandler, sessionHandler, totpHandler)
 		},
 	}
 }
+
+// loginKeys is what a login attempt is counted under for each rule
+func loginKeys(ctx context.Context, handle string) map[*ratelimit.Rule]string {
+	keys := map[*ratelimit.Rule]string{
+		LoginHandleRule: strings.ToLower(handle),
+	}
+
+	if c, ok := ctx.Value(session.ClientKey).(*session.Client); ok && c.IP != "" {
+		keys[LoginIPRule] = ratelimit.IPKey(c.IP)
+	}
+
+	return keys
+}
+
+// loginFailed counts the failure and returns the error for it, which says
+// how long to wait if this was the failure that went over a limit
+func loginFailed(ctx context.Context, limiter ratelimit.Limiter, keys map[*ratelimit.Rule]string) error {
+	var limited error
+
+	for rule, key := range keys {
+		err := limiter.Hit(ctx, rule, key)
+		if errors.Is(err, ratelimit.ErrLimited) {
+			limited = err
+			continue
+		}
+
+		if err != nil {
+			log.Println("failed to count login failure", err)
+		}
+	}
+
+	if limited != nil {
+		return limited
+	}
+
+	return ErrLoginInvalid
+}
+
+// issueSession logs the account in, or starts a two factor login if the account has it on
+func issueSession(
+	ctx context.Context,
+	a *account.Account,
+	remember bool,
+	accountHandler account.Handler,
+	sessionHandler session.Handler,
+	totpHandler totp.Handler,
+) (*auth.Auth, error) {
+	tp, err := totpHandler.Get(ctx, &totp.GetCmd{AccountID: a.ID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get totp: %w", err)
+	}
+
+	if tp != nil && tp.Confirmed() {
+		pt, err := totpHandler.BeginLogin(ctx, &totp.BeginLoginCmd{
+			AccountID: a.ID,
+			Handle:    a.Handle,
+			Remember:  remember,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to begin two factor login: %w", err)
+		}
+
+		return &auth.Auth{
+			Account:      a,
+			PendingToken: pt,
+		}, nil
+	}
+
+	au, err := startSession(ctx, a.ID, a.Handle, remember, accountHandler, sessionHandler)
+	if err != nil {
+		return nil, err
+	}
+
+	au.Account = a
+
+	return au, nil
+}
+
+// startSession issues a session once the login is complete. Logging back in
+// is how users keep an account they asked to delete, so it cancels that.
+func startSession(
+	ctx context.Context,
+	accountID uuid.UUID,
+	handle string,
+	remember bool,
+	accountHandler account.Handler,
+	sessionHandler session.Handler,
+) (*auth.Auth, error) {
+	cancelled, err := accountHandler.CancelDeletion(ctx, &account.CancelDeletionCmd{AccountID: accountID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to cancel deletion: %w", err)
+	}
+
+	s, t, err := sessionHandler.Issue(ctx, accountID, handle, remember)
+	if err != nil {
+		return nil, fmt.Errorf("failed to issue session: %w", err)
+	}
+
+	return &auth.Auth{
+		SessionToken:      t,
+		Session:           s,
+		DeletionCancelled: cancelled,
+	}, nil
+}
@@ -0,0 +1,100 @@
+package handlers_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/derinil/links/links/account"
+	"github.com/derinil/links/links/account/auth/handlers"
+	"github.com/derinil/links/links/account/session"
+	"github.com/derinil/links/links/account/totp"
+	"github.com/derinil/links/links/cache"
+	"github.com/derinil/links/links/crypto"
+	"github.com/derinil/links/links/ratelimit"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+// FakeStore is an account reader and writer backed by a map of handles
+type FakeStore struct {
+	accounts map[string]account.Account
+}
+
+func (s *FakeStore) Get(ctx context.Context, cmd *account.GetCmd) (*account.Account, error) {
+	for _, a := range s.accounts {
+		if a.Handle == cmd.Handle || a.ID == cmd.ID {
+			return &a, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func (s *FakeStore) GetLink(ctx context.Context, cmd *account.GetLinkCmd) (*account.Link, error) {
+	return nil, nil
+}
+
+func (s *FakeStore) SaveAccount(ctx context.Context, a *account.Account) error {
+	s.accounts[a.Handle] = *a
+	return nil
+}
+
+// NoTOTP is a totp reader for accounts that never set up two factor authentication
+type NoTOTP struct{}
+
+func (NoTOTP) GetTOTP(ctx context.Context, accountID uuid.UUID) (*totp.TOTP, error) {
+	return nil, nil
+}
+
+func (NoTOTP) CountUnusedRecoveryCodes(ctx context.Context, accountID uuid.UUID) (int, error) {
+	return 0, nil
+}
+
+func newLoginHandler(accounts ...*account.Account) *handlers.Handler {
+	store := &FakeStore{accounts: make(map[string]account.Account)}
+	for _, a := range accounts {
+		store.accounts[a.Handle] = *a
+	}
+
+	var (
+		kv             = cache.NewMemory(cache.MemoryConfig{})
+		accountHandler = account.NewHandler(store, store, time.Hour)
+		sessionHandler = session.NewHandler(kv, session.Config{})
+		totpHandler    = totp.NewHandler(NoTOTP{}, nil, kv, accountHandler)
+	)
+
+	return handlers.LoginHandler(accountHandler, sessionHandler, totpHandler, ratelimit.NewLimiter(kv))
+}
+
+func TestLoginUnknownHandle(t *testing.T) {
+	pw, err := crypto.HashPassword("password")
+	require.NoError(t, err)
+
+	var (
+		ctx   = context.Background()
+		jane  = account.New("Jane", "jane", pw)
+		login = newLoginHandler(jane)
+	)
+
+	// Unknown handles fail the same way a wrong password does
+	_, err = login.Handle(ctx, &handlers.LoginCmd{Handle: "jane", Password: "wrong"})
+	require.ErrorIs(t, err, handlers.ErrLoginInvalid)
+
+	for i := 0; i < handlers.LoginHandleRule.Limit; i++ {
+		_, err = login.Handle(ctx, &handlers.LoginCmd{Handle: "nobody", Password: "password"})
+		require.ErrorIs(t, err, handlers.ErrLoginInvalid)
+	}
+
+	// And count towards the lockout
+	_, err = login.Handle(ctx, &handlers.LoginCmd{Handle: "nobody", Password: "password"})
+	require.ErrorIs(t, err, ratelimit.ErrLimited)
+
+	_, err = login.Handle(ctx, &handlers.LoginCmd{Handle: "nobody", Password: "password"})
+	require.ErrorIs(t, err, ratelimit.ErrLimited)
+
+	// Other handles are still fine
+	au, err := login.Handle(ctx, &handlers.LoginCmd{Handle: "jane", Password: "password"})
+	require.NoError(t, err)
+	require.NotEmpty(t, au.SessionToken)
+}
@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/derinil/links/links/account"
+	"github.com/derinil/links/links/account/auth"
+	"github.com/derinil/links/links/account/oidc"
+	"github.com/derinil/links/links/account/session"
+	"github.com/derinil/links/links/account/totp"
+	"github.com/google/uuid"
+)
+
+type OIDCCmd struct {
+	State string
+	Code  string
+	// Error is what the provider sent back instead of a code, if anything
+	Error string
+	// The logged in account, if there is one
+	AccountID uuid.UUID
+}
+
+type OIDCSignupCmd struct {
+	SignupToken string
+	Name        string
+	Handle      string
+}
+
+func OIDCHandler(
+	accountHandler account.Handler,
+	sessionHandler session.Handler,
+	totpHandler totp.Handler,
+	oidcHandler oidc.Handler,
+) *Handler {
+	return &Handler{
+		method: auth.OIDC,
+		handle: func(ctx context.Context, cmda any) (*auth.Auth, error) {
+			cmd := cmda.(*OIDCCmd)
+
+			l, err := oidcHandler.Finish(ctx, &oidc.FinishCmd{
+				State: cmd.State,
+				Code:  cmd.Code,
+				Error: cmd.Error,
+			})
+			if err != nil {
+				return nil, fmt.Errorf("failed to finish oidc login: %w", err)
+			}
+
+			// Started from the account page, so link instead of logging in.
+			// The account has to be the one that started it.
+			if l.AccountID != uuid.Nil {
+				if l.AccountID != cmd.AccountID {
+					return nil, oidc.ErrStateInvalid
+				}
+
+				i, err := oidcHandler.Link(ctx, &oidc.LinkCmd{
+					AccountID: l.AccountID,
+					Provider:  l.Provider,
+					Subject:   l.Claims.Subject,
+					Email:     l.Claims.Email,
+				})
+				if err != nil {
+					return nil, fmt.Errorf("failed to link identity: %w", err)
+				}
+
+				return &auth.Auth{Identity: i}, nil
+			}
+
+			i, err := oidcHandler.GetIdentity(ctx, &oidc.GetIdentityCmd{
+				Provider: l.Provider,
+				Subject:  l.Claims.Subject,
+			})
+			if err != nil {
+				return nil, fmt.Errorf("failed to get identity: %w", err)
+			}
+
+			if i == nil {
+				st, err := oidcHandler.BeginSignup(ctx, &oidc.BeginSignupCmd{Login: l})
+				if err != nil {
+					return nil, fmt.Errorf("failed to begin signup: %w", err)
+				}
+
+				return &auth.Auth{SignupToken: st}, nil
+			}
+
+			a, err := accountHandler.Get(ctx, &account.GetCmd{ID: i.AccountID, Shallow: true})
+			if err != nil {
+				return nil, fmt.Errorf("failed to get account: %w", err)
+			}
+
+			return issueSession(ctx, a, false, accountHandler, sessionHandler, totpHandler)
+		},
+	}
+}
+
+func OIDCSignupHandler(
+	accountHandler account.Handler,
+	sessionHandler session.Handler,
+	oidcHandler oidc.Handler,
+) *Handler {
+	return &Handler{
+		method: auth.OIDCSignup,
+		handle: func(ctx context.Context, cmda any) (*auth.Auth, error) {
+			cmd := cmda.(*OIDCSignupCmd)
+
+			// Taken before the account is made, so submitting twice can't
+			// make a second account
+			signupCmd := &oidc.SignupCmd{Token: cmd.SignupToken}
+			su, err := oidcHandler.TakeSignup(ctx, signupCmd)
+			if err != nil {
+				return nil, fmt.Errorf("failed to take signup: %w", err)
+			}
+
+			// No password, the provider is how this account logs in
+			a, err := accountHandler.Create(ctx, &account.CreateCmd{
+				Name:   cmd.Name,
+				Handle: cmd.Handle,
+			})
+			if err != nil {
+				// The handle might be taken, let the user try another one
+				if rerr := oidcHandler.ReturnSignup(ctx, signupCmd, su); rerr != nil {
+					return nil, fmt.Errorf("failed to return signup: %w", rerr)
+				}
+				return nil, fmt.Errorf("failed to create account: %w", err)
+			}
+
+			i, err := oidcHandler.Link(ctx, &oidc.LinkCmd{
+				AccountID: a.ID,
+				Provider:  su.Provider,
+				Subject:   su.Subject,
+				Email:     su.Email,
+			})
+			if err != nil {
+				return nil, fmt.Errorf("failed to link identity: %w", err)
+			}
+
+			s, t, err := sessionHandler.Issue(ctx, a.ID, a.Handle, false)
+			if err != nil {
+				return nil, fmt.Errorf("failed to issue session: %w", err)
+			}
+
+			return &auth.Auth{
+				Account:      a,
+				SessionToken: t,
+				Session:      s,
+				Identity:     i,
+			}, nil
+		},
+	}
+}
@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/derinil/links/links/account"
+	"github.com/derinil/links/links/account/auth"
+	"github.com/derinil/links/links/account/passkey"
+	"github.com/derinil/links/links/account/session"
+	"github.com/google/uuid"
+)
+
+type PasskeyCmd struct {
+	// The JSON encoded assertion from navigator.credentials.get
+	Response []byte
+}
+
+type PasskeyRegistrationCmd struct {
+	AccountID uuid.UUID
+	Name      string
+	// The JSON encoded attestation from navigator.credentials.create
+	Response []byte
+}
+
+func PasskeyHandler(
+	accountHandler account.Handler,
+	sessionHandler session.Handler,
+	passkeyHandler passkey.Handler,
+) *Handler {
+	return &Handler{
+		method: auth.Passkey,
+		handle: func(ctx context.Context, cmda any) (*auth.Auth, error) {
+			cmd := cmda.(*PasskeyCmd)
+
+			c, err := passkeyHandler.FinishLogin(ctx, &passkey.FinishLoginCmd{Response: cmd.Response})
+			if err != nil {
+				return nil, fmt.Errorf("failed to finish passkey login: %w", err)
+			}
+
+			a, err := accountHandler.Get(ctx, &account.GetCmd{ID: c.AccountID, Shallow: true})
+			if err != nil {
+				return nil, fmt.Errorf("failed to get account: %w", err)
+			}
+
+			au, err := startSession(ctx, a.ID, a.Handle, false, accountHandler, sessionHandler)
+			if err != nil {
+				return nil, err
+			}
+
+			au.Account = a
+			au.Passkey = c
+
+			return au, nil
+		},
+	}
+}
+
+func PasskeyRegistrationHandler(passkeyHandler passkey.Handler) *Handler {
+	return &Handler{
+		method: auth.PasskeyRegistration,
+		handle: func(ctx context.Context, cmda any) (*auth.Auth, error) {
+			cmd := cmda.(*PasskeyRegistrationCmd)
+
+			c, err := passkeyHandler.FinishRegistration(ctx, &passkey.FinishRegistrationCmd{
+				AccountID: cmd.AccountID,
+				Name:      cmd.Name,
+				Response:  cmd.Response,
+			})
+			if err != nil {
+				return nil, fmt.Errorf("failed to register passkey: %w", err)
+			}
+
+			return &auth.Auth{Passkey: c}, nil
+		},
+	}
+}
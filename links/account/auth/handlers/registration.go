@@ -25,7 +25,7 @@ func RegistrationHandler(
 		handle: func(ctx context.Context, cmda any) (*auth.Auth, error) {
 			cmd := cmda.(*RegistrationCmd)
 
-			pw, err := crypto.Sha256(cmd.Password, cmd.Handle)
+			pw, err := crypto.HashPassword(cmd.Password)
 			if err != nil {
 				return nil, fmt.Errorf("failed to hash password: %w", err)
 			}
@@ -39,7 +39,7 @@ func RegistrationHandler(
 				return nil, fmt.Errorf("failed to create account: %w", err)
 			}
 
-			s, t, err := sessionHandler.Issue(ctx, a.ID, a.Handle)
+			s, t, err := sessionHandler.Issue(ctx, a.ID, a.Handle, false)
 			if err != nil {
 				return nil, fmt.Errorf("failed to issue session: %w", err)
 			}
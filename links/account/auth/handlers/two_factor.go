@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/derinil/links/links/account"
+	"github.com/derinil/links/links/account/auth"
+	"github.com/derinil/links/links/account/session"
+	"github.com/derinil/links/links/account/totp"
+	"github.com/derinil/links/links/ratelimit"
+)
+
+type TwoFactorCmd struct {
+	PendingToken string
+	// Either a code from the authenticator app or a recovery code
+	Code string
+}
+
+// Guessing the codes of one account. Every right password starts a pending login
+// with its own attempts, so this is what stops someone who knows the password.
+// Getting the password right doesn't reset it, only a right code does.
+var TwoFactorAccountRule = &ratelimit.Rule{
+	Name:       "two-factor-account",
+	Limit:      10,
+	Window:     time.Hour,
+	Lockout:    15 * time.Minute,
+	MaxLockout: 24 * time.Hour,
+}
+
+func TwoFactorHandler(
+	accountHandler account.Handler,
+	sessionHandler session.Handler,
+	totpHandler totp.Handler,
+	limiter ratelimit.Limiter,
+) *Handler {
+	return &Handler{
+		method: auth.TwoFactor,
+		handle: func(ctx context.Context, cmda any) (*auth.Auth, error) {
+			cmd := cmda.(*TwoFactorCmd)
+
+			pl, err := totpHandler.GetLogin(ctx, cmd.PendingToken)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get pending login: %w", err)
+			}
+
+			key := pl.AccountID.String()
+			if err := limiter.Check(ctx, TwoFactorAccountRule, key); err != nil {
+				return nil, err
+			}
+
+			pl, err = totpHandler.FinishLogin(ctx, &totp.FinishLoginCmd{
+				Token: cmd.PendingToken,
+				Code:  cmd.Code,
+			})
+			if errors.Is(err, totp.ErrInvalidCode) || errors.Is(err, totp.ErrPendingLoginGone) {
+				if err := limiter.Hit(ctx, TwoFactorAccountRule, key); errors.Is(err, ratelimit.ErrLimited) {
+					return nil, err
+				} else if err != nil {
+					log.Println("failed to count two factor failure", err)
+				}
+			}
+
+			if err != nil {
+				return nil, fmt.Errorf("failed to finish two factor login: %w", err)
+			}
+
+			if err := limiter.Reset(ctx, TwoFactorAccountRule, key); err != nil {
+				log.Println("failed to reset two factor rate limit", err)
+			}
+
+			return startSession(ctx, pl.AccountID, pl.Handle, pl.Remember, accountHandler, sessionHandler)
+		},
+	}
+}
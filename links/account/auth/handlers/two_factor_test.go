@@ -0,0 +1,109 @@
+package handlers_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/derinil/links/links/account"
+	"github.com/derinil/links/links/account/auth/handlers"
+	"github.com/derinil/links/links/account/session"
+	"github.com/derinil/links/links/account/totp"
+	"github.com/derinil/links/links/cache"
+	"github.com/derinil/links/links/crypto"
+	"github.com/derinil/links/links/ratelimit"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+// FakeTOTP is a totp reader and writer for one account with two factor
+// authentication on and no recovery codes left
+type FakeTOTP struct {
+	totp *totp.TOTP
+}
+
+func (f *FakeTOTP) GetTOTP(ctx context.Context, accountID uuid.UUID) (*totp.TOTP, error) {
+	t := *f.totp
+	return &t, nil
+}
+
+func (f *FakeTOTP) CountUnusedRecoveryCodes(ctx context.Context, accountID uuid.UUID) (int, error) {
+	return 0, nil
+}
+
+func (f *FakeTOTP) SaveTOTP(ctx context.Context, t *totp.TOTP) error {
+	return nil
+}
+
+func (f *FakeTOTP) ConfirmTOTP(ctx context.Context, t *totp.TOTP, codes []totp.RecoveryCode) error {
+	return nil
+}
+
+func (f *FakeTOTP) UseStep(ctx context.Context, accountID uuid.UUID, step int64) (bool, error) {
+	return true, nil
+}
+
+func (f *FakeTOTP) UseRecoveryCode(ctx context.Context, accountID uuid.UUID, hash []byte, at time.Time) (bool, error) {
+	return false, nil
+}
+
+func (f *FakeTOTP) DeleteTOTP(ctx context.Context, accountID uuid.UUID) error {
+	return nil
+}
+
+func TestTwoFactorLockout(t *testing.T) {
+	pw, err := crypto.HashPassword("password")
+	require.NoError(t, err)
+
+	jane := account.New("Jane", "jane", pw)
+
+	tp, err := totp.New(jane.ID)
+	require.NoError(t, err)
+
+	now := time.Now()
+	tp.ConfirmedAt = &now
+
+	var (
+		ctx            = context.Background()
+		store          = &FakeStore{accounts: map[string]account.Account{jane.Handle: *jane}}
+		fake           = &FakeTOTP{totp: tp}
+		kv             = cache.NewMemory(cache.MemoryConfig{})
+		limiter        = ratelimit.NewLimiter(kv)
+		accountHandler = account.NewHandler(store, store, time.Hour)
+		sessionHandler = session.NewHandler(kv, session.Config{})
+		totpHandler    = totp.NewHandler(fake, fake, kv, accountHandler)
+		login          = handlers.LoginHandler(accountHandler, sessionHandler, totpHandler, limiter)
+		twoFactor      = handlers.TwoFactorHandler(accountHandler, sessionHandler, totpHandler, limiter)
+	)
+
+	pending := func() string {
+		au, err := login.Handle(ctx, &handlers.LoginCmd{Handle: "jane", Password: "password"})
+		require.NoError(t, err)
+		require.NotEmpty(t, au.PendingToken)
+		return au.PendingToken
+	}
+
+	// Logging in again with the password starts a new pending login,
+	// the wrong codes before it still count
+	var pt string
+	for i := 0; i < handlers.TwoFactorAccountRule.Limit; i++ {
+		if i%(totp.MaxAttempts-1) == 0 {
+			pt = pending()
+		}
+
+		_, err := twoFactor.Handle(ctx, &handlers.TwoFactorCmd{PendingToken: pt, Code: "not-a-code"})
+		require.ErrorIs(t, err, totp.ErrInvalidCode)
+	}
+
+	pt = pending()
+
+	_, err = twoFactor.Handle(ctx, &handlers.TwoFactorCmd{PendingToken: pt, Code: "not-a-code"})
+	require.ErrorIs(t, err, ratelimit.ErrLimited)
+
+	// Not even the right code gets through during the lockout
+	_, err = twoFactor.Handle(ctx, &handlers.TwoFactorCmd{
+		PendingToken: pending(),
+		Code:         totp.Code(tp.Secret, totp.Step(time.Now())),
+	})
+	require.ErrorIs(t, err, ratelimit.ErrLimited)
+}
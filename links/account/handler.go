@@ -4,8 +4,12 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"strings"
+	"time"
 
+	"github.com/derinil/links/links/crypto"
 	"github.com/derinil/links/links/generic"
+	"github.com/derinil/links/links/imaging"
 	"github.com/google/uuid"
 )
 
@@ -14,15 +18,35 @@ type (
 		Get(ctx context.Context, cmd *GetCmd) (*Account, error)
 		Create(ctx context.Context, cmd *CreateCmd) (*Account, error)
 		Update(ctx context.Context, cmd *UpdateCmd) (*Account, error)
+		Resolve(ctx context.Context, cmd *ResolveCmd) (*Account, error)
+		GetLink(ctx context.Context, cmd *GetLinkCmd) (*Link, error)
+		// UpdatePassword only stores the hash, the caller ends the sessions
+		// that shouldn't outlive the old password
+		UpdatePassword(ctx context.Context, cmd *UpdatePasswordCmd) error
+		UpdateEmail(ctx context.Context, cmd *UpdateEmailCmd) (*Account, error)
+		VerifyEmail(ctx context.Context, cmd *VerifyEmailCmd) (*Account, error)
+		UpdateAvatar(ctx context.Context, cmd *UpdateAvatarCmd) (*Account, error)
+		CreateLink(ctx context.Context, cmd *CreateLinkCmd) (*Link, error)
+		UpdateLink(ctx context.Context, cmd *UpdateLinkCmd) (*Link, error)
+		DeleteLink(ctx context.Context, cmd *DeleteLinkCmd) error
+		ReorderLinks(ctx context.Context, cmd *ReorderLinksCmd) (*Account, error)
+		// RequestDeletion hides the account and leaves it for the purge,
+		// the caller ends its sessions
+		RequestDeletion(ctx context.Context, cmd *RequestDeletionCmd) (*Account, error)
+		// CancelDeletion reports whether the account was pending deletion
+		CancelDeletion(ctx context.Context, cmd *CancelDeletionCmd) (bool, error)
 	}
 
 	HandlerImpl struct {
 		reader Reader
 		writer Writer
+		// How long a previous handle keeps resolving to its account
+		handleGracePeriod time.Duration
 	}
 
 	Reader interface {
 		Get(ctx context.Context, cmd *GetCmd) (*Account, error)
+		GetLink(ctx context.Context, cmd *GetLinkCmd) (*Link, error)
 	}
 
 	Writer interface {
@@ -39,6 +63,25 @@ type (
 		ID      uuid.UUID
 		Handle  string
 		Shallow bool
+		// Match Handle against previous handles as well
+		// and load the account's handle history
+		PreviousHandles bool
+		// Email only matches verified emails, ignoring case
+		Email string
+	}
+
+	ResolveCmd struct {
+		Handle string
+	}
+
+	// Handle is matched against previous handles as well, links
+	// of accounts that are pending deletion aren't found
+	GetLinkCmd struct {
+		ID     uuid.UUID
+		Handle string
+		// Previous handles only match if they were changed after this,
+		// GetLink sets it from the grace period
+		HandleChangedAfter time.Time
 	}
 
 	UpdateCmd struct {
@@ -46,24 +89,108 @@ type (
 		Name      string
 		Handle    string
 		CSS       string
-		Links     []LinkScaffold
+		// Theme is checked against the themes by the caller
+		Theme string
+		// Links replaces the account's links in the given order when it is
+		// not nil, so an empty slice removes all of them
+		Links []LinkScaffold
+	}
+
+	// Password must already be hashed
+	UpdatePasswordCmd struct {
+		AccountID uuid.UUID
+		Password  string
+	}
+
+	// Changing the email unverifies it, an empty Email removes it
+	UpdateEmailCmd struct {
+		AccountID uuid.UUID
+		Email     string
+	}
+
+	// Email is the address the verification was sent to,
+	// it has to still be the account's email
+	VerifyEmailCmd struct {
+		AccountID uuid.UUID
+		Email     string
+	}
+
+	// Image is the uploaded file as is, an empty Image removes the avatar
+	UpdateAvatarCmd struct {
+		AccountID uuid.UUID
+		Image     []byte
+	}
+
+	// The link is added after the account's other links
+	CreateLinkCmd struct {
+		AccountID uuid.UUID
+		Title     string
+		Link      string
+	}
+
+	// Empty fields are left as they are
+	UpdateLinkCmd struct {
+		AccountID uuid.UUID
+		ID        uuid.UUID
+		Title     string
+		Link      string
+	}
+
+	DeleteLinkCmd struct {
+		AccountID uuid.UUID
+		ID        uuid.UUID
+	}
+
+	// IDs must contain the ID of every link of the account exactly once
+	ReorderLinksCmd struct {
+		AccountID uuid.UUID
+		IDs       []uuid.UUID
+	}
+
+	// Password is the plaintext password, accounts have to have one
+	RequestDeletionCmd struct {
+		AccountID uuid.UUID
+		Password  string
+	}
+
+	CancelDeletionCmd struct {
+		AccountID uuid.UUID
 	}
 
+	// ID is the ID of the link the scaffold edits, or uuid.Nil for a new link
 	LinkScaffold struct {
+		ID    uuid.UUID
 		Title string
 		Link  string
 	}
 )
 
+// Avatars are cropped to squares and stored in these sizes
+const (
+	AvatarSize          = 256
+	AvatarThumbnailSize = 64
+)
+
 var (
 	ErrAccountNotFound = generic.NewWebError(http.StatusNotFound, "account_not_found", "Account not found")
 	ErrHandleTaken     = generic.NewWebError(http.StatusBadRequest, "handle_taken", "Handle is already taken")
+	ErrLinkNotFound    = generic.NewWebError(http.StatusNotFound, "link_not_found", "Link not found")
+	ErrDuplicateLink   = generic.NewWebError(http.StatusBadRequest, "duplicate_link", "Each link can only be added once")
+	ErrLinkOrder       = generic.NewWebError(http.StatusBadRequest, "link_order_invalid", "Order must contain every link exactly once")
+	ErrEmailTaken      = generic.NewWebError(http.StatusBadRequest, "email_taken", "Email is already used by another account")
+	ErrEmailChanged    = generic.NewWebError(http.StatusBadRequest, "email_changed", "The email was changed since this link was sent")
+	ErrPasswordInvalid = generic.NewWebError(http.StatusBadRequest, "password_invalid", "Password is incorrect")
+	ErrPasswordNotSet  = generic.NewWebError(http.StatusBadRequest, "password_not_set", "Set a password with a reset link before deleting your account")
 )
 
 var _ Handler = (*HandlerImpl)(nil)
 
-func NewHandler(reader Reader, writer Writer) *HandlerImpl {
-	return &HandlerImpl{reader: reader, writer: writer}
+func NewHandler(reader Reader, writer Writer, handleGracePeriod time.Duration) *HandlerImpl {
+	return &HandlerImpl{
+		reader:            reader,
+		writer:            writer,
+		handleGracePeriod: handleGracePeriod,
+	}
 }
 
 func (s *HandlerImpl) Create(ctx context.Context, cmd *CreateCmd) (*Account, error) {
@@ -74,7 +201,7 @@ func (s *HandlerImpl) Create(ctx context.Context, cmd *CreateCmd) (*Account, err
 		return nil, fmt.Errorf("failed to validate account: %w", err)
 	}
 
-	ea, err := s.reader.Get(ctx, &GetCmd{Handle: a.Handle})
+	ea, err := s.reader.Get(ctx, &GetCmd{Handle: a.Handle, PreviousHandles: true})
 	if err != nil {
 		return nil, fmt.Errorf("failed to check if handle is taken: %w", err)
 	}
@@ -100,6 +227,8 @@ func (s *HandlerImpl) Update(ctx context.Context, cmd *UpdateCmd) (*Account, err
 		return nil, ErrAccountNotFound
 	}
 
+	oldHandle := a.Handle
+
 	if cmd.Name != "" {
 		a.Name = cmd.Name
 	}
@@ -109,46 +238,148 @@ func (s *HandlerImpl) Update(ctx context.Context, cmd *UpdateCmd) (*Account, err
 	if cmd.CSS != "" {
 		a.CSS = cmd.CSS
 	}
+	if cmd.Theme != "" {
+		a.Theme = cmd.Theme
+	}
 
 	a.Sanitize()
+
 	if err := a.Validate(); err != nil {
 		return nil, fmt.Errorf("failed to validate account: %w", err)
 	}
 
-	oldLinks := make(map[string]*Link, len(a.Links))
-	for i := range a.Links {
-		l := &a.Links[i]
-		oldLinks[l.Link] = l
+	// Legacy password hashes are seeded with the handle, so
+	// we have to pin the old one before it is gone
+	if a.Handle != oldHandle {
+		a.Password = crypto.PinLegacySeed(a.Password, oldHandle)
 	}
 
-	for i := range cmd.Links {
-		l := &cmd.Links[i]
+	if cmd.Links != nil {
+		if err := a.SetLinks(cmd.Links); err != nil {
+			return nil, fmt.Errorf("failed to set links: %w", err)
+		}
+	}
 
-		nl := NewLink(a.ID, l.Title, l.Link, i)
+	ea, err := s.reader.Get(ctx, &GetCmd{Handle: a.Handle, PreviousHandles: true})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get account by new handle: %w", err)
+	}
 
-		nl.Sanitize()
-		if err := nl.Validate(); err != nil {
-			return nil, fmt.Errorf("failed to validate link: %w", err)
-		}
+	if ea != nil && ea.ID != a.ID {
+		return nil, ErrHandleTaken
+	}
 
-		ol, ok := oldLinks[nl.Link]
-		if ok {
-			ol.Title = nl.Title
-			continue
+	if err := s.writer.SaveAccount(ctx, a); err != nil {
+		return nil, fmt.Errorf("failed to save account: %w", err)
+	}
+
+	return a, nil
+}
+
+// Resolve finds the account that currently uses the handle, or the account
+// that used it last if it was changed within the grace period. Callers can
+// compare the handles to find out whether they should redirect.
+func (s *HandlerImpl) Resolve(ctx context.Context, cmd *ResolveCmd) (*Account, error) {
+	a, err := s.reader.Get(ctx, &GetCmd{Handle: cmd.Handle, PreviousHandles: true})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get account by handle: %w", err)
+	}
+
+	if a == nil {
+		return nil, ErrAccountNotFound
+	}
+
+	if a.PendingDeletion() {
+		return nil, ErrAccountNotFound
+	}
+
+	if a.Handle == cmd.Handle {
+		return a, nil
+	}
+
+	for i := range a.PreviousHandles {
+		ph := &a.PreviousHandles[i]
+		if ph.Handle == cmd.Handle && time.Since(ph.ChangedAt) < s.handleGracePeriod {
+			return a, nil
 		}
+	}
+
+	return nil, ErrAccountNotFound
+}
 
-		a.Links = append(a.Links, *nl)
+func (s *HandlerImpl) UpdatePassword(ctx context.Context, cmd *UpdatePasswordCmd) error {
+	a, err := s.reader.Get(ctx, &GetCmd{ID: cmd.AccountID})
+	if err != nil {
+		return fmt.Errorf("failed to get account by id: %w", err)
 	}
 
-	ea, err := s.reader.Get(ctx, &GetCmd{Handle: a.Handle})
+	if a == nil {
+		return ErrAccountNotFound
+	}
+
+	a.Password = cmd.Password
+
+	if err := s.writer.SaveAccount(ctx, a); err != nil {
+		return fmt.Errorf("failed to save account: %w", err)
+	}
+
+	return nil
+}
+
+func (s *HandlerImpl) UpdateEmail(ctx context.Context, cmd *UpdateEmailCmd) (*Account, error) {
+	a, err := s.getByID(ctx, cmd.AccountID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get account by new handle: %w", err)
+		return nil, err
+	}
+
+	email := strings.TrimSpace(cmd.Email)
+	if strings.EqualFold(a.Email, email) {
+		return a, nil
+	}
+
+	a.Email = email
+	a.EmailVerifiedAt = nil
+
+	a.Sanitize()
+	if err := a.Validate(); err != nil {
+		return nil, fmt.Errorf("failed to validate account: %w", err)
+	}
+
+	if err := s.writer.SaveAccount(ctx, a); err != nil {
+		return nil, fmt.Errorf("failed to save account: %w", err)
+	}
+
+	return a, nil
+}
+
+// VerifyEmail marks the email as verified. Unverified emails can be typed in
+// by anyone, so they only clash with other accounts once they're verified.
+func (s *HandlerImpl) VerifyEmail(ctx context.Context, cmd *VerifyEmailCmd) (*Account, error) {
+	a, err := s.getByID(ctx, cmd.AccountID)
+	if err != nil {
+		return nil, err
+	}
+
+	if a.Email == "" || !strings.EqualFold(a.Email, cmd.Email) {
+		return nil, ErrEmailChanged
+	}
+
+	if a.EmailVerified() {
+		return a, nil
+	}
+
+	ea, err := s.reader.Get(ctx, &GetCmd{Email: a.Email, Shallow: true})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get account by email: %w", err)
 	}
 
 	if ea != nil && ea.ID != a.ID {
-		return nil, ErrHandleTaken
+		return nil, ErrEmailTaken
 	}
 
+	now := time.Now()
+	a.EmailVerifiedAt = &now
+
 	if err := s.writer.SaveAccount(ctx, a); err != nil {
 		return nil, fmt.Errorf("failed to save account: %w", err)
 	}
@@ -168,3 +399,272 @@ func (s *HandlerImpl) Get(ctx context.Context, cmd *GetCmd) (*Account, error) {
 
 	return a, nil
 }
+
+func (s *HandlerImpl) UpdateAvatar(ctx context.Context, cmd *UpdateAvatarCmd) (*Account, error) {
+	var avi, thumbnail []byte
+
+	if len(cmd.Image) > 0 {
+		var err error
+		if avi, thumbnail, err = EncodeAvatar(cmd.Image); err != nil {
+			return nil, err
+		}
+	}
+
+	a, err := s.reader.Get(ctx, &GetCmd{ID: cmd.AccountID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get account by id: %w", err)
+	}
+
+	if a == nil {
+		return nil, ErrAccountNotFound
+	}
+
+	a.Avi = avi
+	a.AviThumbnail = thumbnail
+
+	if err := s.writer.SaveAccount(ctx, a); err != nil {
+		return nil, fmt.Errorf("failed to save account: %w", err)
+	}
+
+	return a, nil
+}
+
+func (s *HandlerImpl) RequestDeletion(ctx context.Context, cmd *RequestDeletionCmd) (*Account, error) {
+	a, err := s.getByID(ctx, cmd.AccountID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Accounts created through a login provider have no password
+	if a.Password == "" {
+		return nil, ErrPasswordNotSet
+	}
+
+	ok, err := crypto.ComparePassword(cmd.Password, a.Handle, a.Password)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compare passwords: %w", err)
+	}
+
+	if !ok {
+		return nil, ErrPasswordInvalid
+	}
+
+	// Asking twice doesn't push the purge back
+	if a.PendingDeletion() {
+		return a, nil
+	}
+
+	now := time.Now().UTC()
+	a.DeletionRequestedAt = &now
+
+	if err := s.writer.SaveAccount(ctx, a); err != nil {
+		return nil, fmt.Errorf("failed to save account: %w", err)
+	}
+
+	return a, nil
+}
+
+func (s *HandlerImpl) CancelDeletion(ctx context.Context, cmd *CancelDeletionCmd) (bool, error) {
+	a, err := s.getByID(ctx, cmd.AccountID)
+	if err != nil {
+		return false, err
+	}
+
+	if !a.PendingDeletion() {
+		return false, nil
+	}
+
+	a.DeletionRequestedAt = nil
+
+	if err := s.writer.SaveAccount(ctx, a); err != nil {
+		return false, fmt.Errorf("failed to save account: %w", err)
+	}
+
+	return true, nil
+}
+
+// EncodeAvatar crops the image to a square and encodes it in both avatar sizes
+func EncodeAvatar(image []byte) (avi, thumbnail []byte, err error) {
+	img, _, err := imaging.Decode(image, imaging.DefaultMaxPixels)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to decode avatar: %w", err)
+	}
+
+	img = imaging.CropSquare(img)
+
+	avi, _, err = imaging.Encode(imaging.Resize(img, AvatarSize, AvatarSize))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to encode avatar: %w", err)
+	}
+
+	thumbnail, _, err = imaging.Encode(imaging.Resize(img, AvatarThumbnailSize, AvatarThumbnailSize))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to encode avatar thumbnail: %w", err)
+	}
+
+	return avi, thumbnail, nil
+}
+
+func (s *HandlerImpl) GetLink(ctx context.Context, cmd *GetLinkCmd) (*Link, error) {
+	// Previous handles reach the links as long as Resolve finds the page
+	q := *cmd
+	q.HandleChangedAfter = time.Now().Add(-s.handleGracePeriod)
+
+	l, err := s.reader.GetLink(ctx, &q)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get link: %w", err)
+	}
+
+	if l == nil {
+		return nil, ErrLinkNotFound
+	}
+
+	return l, nil
+}
+
+func (s *HandlerImpl) CreateLink(ctx context.Context, cmd *CreateLinkCmd) (*Link, error) {
+	a, err := s.getByID(ctx, cmd.AccountID)
+	if err != nil {
+		return nil, err
+	}
+
+	scaffolds := append(a.Scaffolds(), LinkScaffold{
+		Title: cmd.Title,
+		Link:  cmd.Link,
+	})
+
+	if err := a.SetLinks(scaffolds); err != nil {
+		return nil, fmt.Errorf("failed to set links: %w", err)
+	}
+
+	if err := s.writer.SaveAccount(ctx, a); err != nil {
+		return nil, fmt.Errorf("failed to save account: %w", err)
+	}
+
+	return &a.Links[len(a.Links)-1], nil
+}
+
+func (s *HandlerImpl) UpdateLink(ctx context.Context, cmd *UpdateLinkCmd) (*Link, error) {
+	a, err := s.getByID(ctx, cmd.AccountID)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		scaffolds = a.Scaffolds()
+		index     = -1
+	)
+
+	for i := range scaffolds {
+		if scaffolds[i].ID != cmd.ID {
+			continue
+		}
+
+		if cmd.Title != "" {
+			scaffolds[i].Title = cmd.Title
+		}
+		if cmd.Link != "" {
+			scaffolds[i].Link = cmd.Link
+		}
+
+		index = i
+	}
+
+	if index == -1 {
+		return nil, ErrLinkNotFound
+	}
+
+	if err := a.SetLinks(scaffolds); err != nil {
+		return nil, fmt.Errorf("failed to set links: %w", err)
+	}
+
+	if err := s.writer.SaveAccount(ctx, a); err != nil {
+		return nil, fmt.Errorf("failed to save account: %w", err)
+	}
+
+	return &a.Links[index], nil
+}
+
+func (s *HandlerImpl) DeleteLink(ctx context.Context, cmd *DeleteLinkCmd) error {
+	a, err := s.getByID(ctx, cmd.AccountID)
+	if err != nil {
+		return err
+	}
+
+	var (
+		current   = a.Scaffolds()
+		scaffolds = make([]LinkScaffold, 0, len(current))
+	)
+
+	for i := range current {
+		if current[i].ID != cmd.ID {
+			scaffolds = append(scaffolds, current[i])
+		}
+	}
+
+	if len(scaffolds) == len(current) {
+		return ErrLinkNotFound
+	}
+
+	if err := a.SetLinks(scaffolds); err != nil {
+		return fmt.Errorf("failed to set links: %w", err)
+	}
+
+	if err := s.writer.SaveAccount(ctx, a); err != nil {
+		return fmt.Errorf("failed to save account: %w", err)
+	}
+
+	return nil
+}
+
+func (s *HandlerImpl) ReorderLinks(ctx context.Context, cmd *ReorderLinksCmd) (*Account, error) {
+	a, err := s.getByID(ctx, cmd.AccountID)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(cmd.IDs) != len(a.Links) {
+		return nil, ErrLinkOrder
+	}
+
+	byID := make(map[uuid.UUID]LinkScaffold, len(a.Links))
+	for _, sc := range a.Scaffolds() {
+		byID[sc.ID] = sc
+	}
+
+	scaffolds := make([]LinkScaffold, 0, len(cmd.IDs))
+	for _, id := range cmd.IDs {
+		sc, ok := byID[id]
+		if !ok {
+			return nil, ErrLinkOrder
+		}
+
+		// So the same ID can't be used twice
+		delete(byID, id)
+
+		scaffolds = append(scaffolds, sc)
+	}
+
+	if err := a.SetLinks(scaffolds); err != nil {
+		return nil, fmt.Errorf("failed to set links: %w", err)
+	}
+
+	if err := s.writer.SaveAccount(ctx, a); err != nil {
+		return nil, fmt.Errorf("failed to save account: %w", err)
+	}
+
+	return a, nil
+}
+
+func (s *HandlerImpl) getByID(ctx context.Context, id uuid.UUID) (*Account, error) {
+	a, err := s.reader.Get(ctx, &GetCmd{ID: id})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get account by id: %w", err)
+	}
+
+	if a == nil {
+		return nil, ErrAccountNotFound
+	}
+
+	return a, nil
+}
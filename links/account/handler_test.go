@@ -1,12 +1,20 @@
 package account_test
 
 import (
+	"bytes"
 	"context"
 	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
 	"testing"
+	"time"
 
 	"github.com/derinil/links/links/account"
+	"github.com/derinil/links/links/crypto"
 	"github.com/derinil/links/links/generic"
+	"github.com/derinil/links/links/imaging"
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
@@ -22,6 +30,11 @@ func (r *MockReader) Get(ctx context.Context, cmd *account.GetCmd) (*account.Acc
 	return args.Get(0).(*account.Account), args.Error(1)
 }
 
+func (r *MockReader) GetLink(ctx context.Context, cmd *account.GetLinkCmd) (*account.Link, error) {
+	args := r.Called(ctx, cmd)
+	return args.Get(0).(*account.Link), args.Error(1)
+}
+
 func (w *MockWriter) SaveAccount(ctx context.Context, a *account.Account) error {
 	args := w.Called(ctx, a)
 	return args.Error(0)
@@ -94,7 +107,7 @@ func TestGet(t *testing.T) {
 				ctx            = context.Background()
 				reader         = new(MockReader)
 				writer         = new(MockWriter)
-				accountHandler = account.NewHandler(reader, writer)
+				accountHandler = account.NewHandler(reader, writer, time.Hour)
 			)
 
 			reader.On("Get", ctx, c.cmd).Return(c.a, c.readerErr).Once()
@@ -194,7 +207,7 @@ func TestCreate(t *testing.T) {
 				ctx            = context.Background()
 				reader         = new(MockReader)
 				writer         = new(MockWriter)
-				accountHandler = account.NewHandler(reader, writer)
+				accountHandler = account.NewHandler(reader, writer, time.Hour)
 			)
 
 			if !c.skipReader {
@@ -248,6 +261,11 @@ func TestUpdate(t *testing.T) {
 			b := *a
 			return &b
 		}
+		withPassword = func(a *account.Account, password string) *account.Account {
+			a.Password = password
+			return a
+		}
+		argonHash = "$argon2id$v=19$m=65536,t=3,p=2$c2FsdHNhbHRzYWx0c2FsdA$a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2U"
 	)
 
 	testCases := []struct {
@@ -290,11 +308,20 @@ func TestUpdate(t *testing.T) {
 					},
 				},
 			},
-			expected: defaultAccountWith("newhandle", "body { color: white; }", []account.Link{
+			expected: withPassword(defaultAccountWith("newhandle", "#profile {\n  color: white;\n}", []account.Link{
 				*account.NewLink(defaultAccount.ID, "Link", "https://example.com", 0),
-			}),
+			}), "$sha256$s=handle$password"),
 			exists: copy(defaultAccount),
 		},
+		{
+			name: "handle change keeps argon2id hash",
+			cmd: &account.UpdateCmd{
+				AccountID: defaultAccount.ID,
+				Handle:    "newhandle",
+			},
+			expected: withPassword(defaultAccountWith("newhandle", "", nil), argonHash),
+			exists:   withPassword(copy(defaultAccount), argonHash),
+		},
 		{
 			name: "invalid link",
 			cmd: &account.UpdateCmd{
@@ -308,7 +335,7 @@ func TestUpdate(t *testing.T) {
 					},
 				},
 			},
-			expected: defaultAccountWith("newhandle", "body { color: white; }", []account.Link{
+			expected: defaultAccountWith("newhandle", "#profile {\n  color: white;\n}", []account.Link{
 				*account.NewLink(defaultAccount.ID, "Link", "https://example.com", 0),
 			}),
 			errStr:     "Link.Link",
@@ -325,17 +352,15 @@ func TestUpdate(t *testing.T) {
 				Links: []account.LinkScaffold{
 					{
 						Title: "Link",
-						Link:  "examplecom",
+						Link:  "https://example.com",
 					},
 				},
 			},
-			expected: defaultAccountWith("newhandle", "body { color: white; }", []account.Link{
+			// What the policy doesn't allow is left out instead of failing the update
+			expected: withPassword(defaultAccountWith("newhandle", "", []account.Link{
 				*account.NewLink(defaultAccount.ID, "Link", "https://example.com", 0),
-			}),
-			errStr:     "Account.CSS",
-			skipWriter: true,
-			skipReader: true,
-			exists:     copy(defaultAccount),
+			}), "$sha256$s=handle$password"),
+			exists: copy(defaultAccount),
 		},
 		{
 			name: "valid update with links and no new handle",
@@ -353,7 +378,7 @@ func TestUpdate(t *testing.T) {
 					},
 				},
 			},
-			expected: defaultAccountWith("handle", "body { color: white; }", []account.Link{
+			expected: defaultAccountWith("handle", "#profile {\n  color: white;\n}", []account.Link{
 				*account.NewLink(defaultAccount.ID, "Link", "https://example.com", 0),
 				*account.NewLink(defaultAccount.ID, "Link 2", "https://google.com", 1),
 			}),
@@ -385,7 +410,7 @@ func TestUpdate(t *testing.T) {
 				ctx            = context.Background()
 				reader         = new(MockReader)
 				writer         = new(MockWriter)
-				accountHandler = account.NewHandler(reader, writer)
+				accountHandler = account.NewHandler(reader, writer, time.Hour)
 			)
 
 			getFirst := reader.On("Get", ctx, mock.MatchedBy(func(cmd *account.GetCmd) bool {
@@ -431,6 +456,7 @@ func TestUpdate(t *testing.T) {
 			require.Equal(t, c.expected.Name, a.Name)
 			require.Equal(t, c.expected.Handle, a.Handle)
 			require.Equal(t, c.expected.Password, a.Password)
+			require.Equal(t, c.expected.CSS, a.CSS)
 			require.Equal(t, len(a.Links), len(c.expected.Links))
 			for i := range c.expected.Links {
 				el := &c.expected.Links[i]
@@ -440,3 +466,902 @@ func TestUpdate(t *testing.T) {
 		})
 	}
 }
+
+func TestUpdatePassword(t *testing.T) {
+	defaultAccount := account.New("name", "handle", "oldpassword")
+
+	testCases := []struct {
+		name       string
+		cmd        *account.UpdatePasswordCmd
+		exists     *account.Account
+		err        error
+		skipWriter bool
+	}{
+		{
+			name: "account not found",
+			cmd: &account.UpdatePasswordCmd{
+				AccountID: defaultAccount.ID,
+				Password:  "newpassword",
+			},
+			err:        account.ErrAccountNotFound,
+			skipWriter: true,
+		},
+		{
+			name: "valid update",
+			cmd: &account.UpdatePasswordCmd{
+				AccountID: defaultAccount.ID,
+				Password:  "newpassword",
+			},
+			exists: defaultAccount,
+		},
+		{
+			name: "writer error",
+			cmd: &account.UpdatePasswordCmd{
+				AccountID: defaultAccount.ID,
+				Password:  "newpassword",
+			},
+			exists: defaultAccount,
+			err:    errors.New("haha"),
+		},
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			var (
+				ctx            = context.Background()
+				reader         = new(MockReader)
+				writer         = new(MockWriter)
+				accountHandler = account.NewHandler(reader, writer, time.Hour)
+			)
+
+			var exists *account.Account
+			if c.exists != nil {
+				b := *c.exists
+				exists = &b
+			}
+
+			reader.On("Get", ctx, mock.MatchedBy(func(cmd *account.GetCmd) bool {
+				return cmd.ID == c.cmd.AccountID
+			})).Return(exists, nil).Once()
+
+			if !c.skipWriter {
+				writer.On("SaveAccount", ctx, mock.MatchedBy(func(a *account.Account) bool {
+					return a.ID == c.cmd.AccountID &&
+						a.Password == c.cmd.Password &&
+						a.Handle == c.exists.Handle
+				})).Return(c.err).Once()
+			}
+
+			err := accountHandler.UpdatePassword(ctx, c.cmd)
+			require.ErrorIs(t, err, c.err)
+
+			reader.AssertExpectations(t)
+			writer.AssertExpectations(t)
+		})
+	}
+}
+
+func TestUpdateEmail(t *testing.T) {
+	verifiedAt := time.Now()
+
+	testCases := []struct {
+		name     string
+		email    string
+		current  string
+		verified bool
+		// Expected email and whether it stays verified
+		wantEmail    string
+		wantVerified bool
+		errStr       string
+		skipWriter   bool
+	}{
+		{
+			name:      "add",
+			email:     " jane@example.com ",
+			wantEmail: "jane@example.com",
+		},
+		{
+			name:      "change unverifies",
+			email:     "jane@example.org",
+			current:   "jane@example.com",
+			verified:  true,
+			wantEmail: "jane@example.org",
+		},
+		{
+			name:         "same email in another case",
+			email:        "Jane@Example.com",
+			current:      "jane@example.com",
+			verified:     true,
+			wantEmail:    "jane@example.com",
+			wantVerified: true,
+			skipWriter:   true,
+		},
+		{
+			name:     "remove",
+			current:  "jane@example.com",
+			verified: true,
+		},
+		{
+			name:       "invalid",
+			email:      "not an email",
+			errStr:     "Account.Email",
+			skipWriter: true,
+		},
+	}
+
+	for _, c := range testCases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			var (
+				ctx            = context.Background()
+				reader         = new(MockReader)
+				writer         = new(MockWriter)
+				accountHandler = account.NewHandler(reader, writer, time.Hour)
+				a              = account.New("name", "handle", "")
+			)
+
+			a.Email = c.current
+			if c.verified {
+				a.EmailVerifiedAt = &verifiedAt
+			}
+
+			reader.On("Get", ctx, &account.GetCmd{ID: a.ID}).Return(a, nil).Once()
+			writer.On("SaveAccount", ctx, a).Return(nil).Once()
+
+			ua, err := accountHandler.UpdateEmail(ctx, &account.UpdateEmailCmd{
+				AccountID: a.ID,
+				Email:     c.email,
+			})
+			if c.errStr != "" || err != nil {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), c.errStr)
+				writer.AssertNotCalled(t, "SaveAccount", mock.Anything, mock.Anything)
+				return
+			}
+
+			require.Equal(t, c.wantEmail, ua.Email)
+			require.Equal(t, c.wantVerified, ua.EmailVerified())
+
+			if c.skipWriter {
+				writer.AssertNotCalled(t, "SaveAccount", mock.Anything, mock.Anything)
+			} else {
+				writer.AssertExpectations(t)
+			}
+		})
+	}
+}
+
+func TestVerifyEmail(t *testing.T) {
+	otherAccount := account.New("other", "other", "")
+
+	testCases := []struct {
+		name    string
+		email   string
+		current string
+		// Account that already verified the email
+		taken *account.Account
+		err   error
+	}{
+		{
+			name:    "verify",
+			email:   "jane@example.com",
+			current: "jane@example.com",
+		},
+		{
+			name:    "email changed",
+			email:   "jane@example.com",
+			current: "jane@example.org",
+			err:     account.ErrEmailChanged,
+		},
+		{
+			name:  "email removed",
+			email: "jane@example.com",
+			err:   account.ErrEmailChanged,
+		},
+		{
+			name:    "verified by another account",
+			email:   "jane@example.com",
+			current: "jane@example.com",
+			taken:   otherAccount,
+			err:     account.ErrEmailTaken,
+		},
+	}
+
+	for _, c := range testCases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			var (
+				ctx            = context.Background()
+				reader         = new(MockReader)
+				writer         = new(MockWriter)
+				accountHandler = account.NewHandler(reader, writer, time.Hour)
+				a              = account.New("name", "handle", "")
+			)
+
+			a.Email = c.current
+
+			reader.On("Get", ctx, &account.GetCmd{ID: a.ID}).Return(a, nil).Once()
+			reader.On("Get", ctx, &account.GetCmd{Email: c.current, Shallow: true}).Return(c.taken, nil).Once()
+			writer.On("SaveAccount", ctx, a).Return(nil).Once()
+
+			va, err := accountHandler.VerifyEmail(ctx, &account.VerifyEmailCmd{
+				AccountID: a.ID,
+				Email:     c.email,
+			})
+			if c.err != nil || err != nil {
+				require.ErrorIs(t, err, c.err)
+				writer.AssertNotCalled(t, "SaveAccount", mock.Anything, mock.Anything)
+				return
+			}
+
+			require.True(t, va.EmailVerified())
+			writer.AssertExpectations(t)
+		})
+	}
+}
+
+func TestResolve(t *testing.T) {
+	var (
+		current = account.New("name", "current", "password")
+		recent  = account.PreviousHandle{
+			Handle:    "recent",
+			AccountID: current.ID,
+			ChangedAt: time.Now().Add(-time.Minute),
+		}
+		expired = account.PreviousHandle{
+			Handle:    "expired",
+			AccountID: current.ID,
+			ChangedAt: time.Now().Add(-2 * time.Hour),
+		}
+	)
+
+	current.PreviousHandles = []account.PreviousHandle{recent, expired}
+
+	deleting := *current
+	requestedAt := time.Now()
+	deleting.DeletionRequestedAt = &requestedAt
+
+	testCases := []struct {
+		name   string
+		handle string
+		exists *account.Account
+		err    error
+	}{
+		{
+			name:   "current handle",
+			handle: "current",
+			exists: current,
+		},
+		{
+			name:   "previous handle within grace period",
+			handle: "recent",
+			exists: current,
+		},
+		{
+			name:   "previous handle past grace period",
+			handle: "expired",
+			exists: current,
+			err:    account.ErrAccountNotFound,
+		},
+		{
+			name:   "unknown handle",
+			handle: "unknown",
+			err:    account.ErrAccountNotFound,
+		},
+		{
+			name:   "pending deletion",
+			handle: "current",
+			exists: &deleting,
+			err:    account.ErrAccountNotFound,
+		},
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			var (
+				ctx            = context.Background()
+				reader         = new(MockReader)
+				writer         = new(MockWriter)
+				accountHandler = account.NewHandler(reader, writer, time.Hour)
+			)
+
+			reader.On("Get", ctx, &account.GetCmd{
+				Handle:          c.handle,
+				PreviousHandles: true,
+			}).Return(c.exists, nil).Once()
+
+			a, err := accountHandler.Resolve(ctx, &account.ResolveCmd{Handle: c.handle})
+			require.ErrorIs(t, err, c.err)
+
+			reader.AssertExpectations(t)
+
+			if c.err != nil {
+				return
+			}
+
+			require.Equal(t, current.ID, a.ID)
+			require.Equal(t, "current", a.Handle)
+		})
+	}
+}
+
+func TestRequestDeletion(t *testing.T) {
+	hash, err := crypto.HashPassword("password")
+	require.NoError(t, err)
+
+	var (
+		requestedAt = time.Now().Add(-time.Hour)
+		pending     = account.New("name", "handle", hash)
+	)
+
+	pending.DeletionRequestedAt = &requestedAt
+
+	testCases := []struct {
+		name     string
+		exists   *account.Account
+		password string
+		err      error
+		save     bool
+	}{
+		{
+			name:     "valid request",
+			exists:   account.New("name", "handle", hash),
+			password: "password",
+			save:     true,
+		},
+		{
+			name:     "wrong password",
+			exists:   account.New("name", "handle", hash),
+			password: "wrong",
+			err:      account.ErrPasswordInvalid,
+		},
+		{
+			name:     "no password",
+			exists:   account.New("name", "handle", ""),
+			password: "",
+			err:      account.ErrPasswordNotSet,
+		},
+		{
+			name:     "already pending",
+			exists:   pending,
+			password: "password",
+		},
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			var (
+				ctx            = context.Background()
+				reader         = new(MockReader)
+				writer         = new(MockWriter)
+				accountHandler = account.NewHandler(reader, writer, time.Hour)
+				exists         = *c.exists
+			)
+
+			reader.On("Get", ctx, &account.GetCmd{ID: exists.ID}).Return(&exists, nil).Once()
+
+			if c.save {
+				writer.On("SaveAccount", ctx, mock.MatchedBy(func(a *account.Account) bool {
+					return a.PendingDeletion()
+				})).Return(nil).Once()
+			}
+
+			a, err := accountHandler.RequestDeletion(ctx, &account.RequestDeletionCmd{
+				AccountID: exists.ID,
+				Password:  c.password,
+			})
+			require.ErrorIs(t, err, c.err)
+
+			reader.AssertExpectations(t)
+			writer.AssertExpectations(t)
+
+			if c.err != nil {
+				return
+			}
+
+			require.True(t, a.PendingDeletion())
+
+			// Asking again keeps the original date
+			if c.exists.PendingDeletion() {
+				require.Equal(t, requestedAt, *a.DeletionRequestedAt)
+			}
+		})
+	}
+}
+
+func TestCancelDeletion(t *testing.T) {
+	var (
+		ctx         = context.Background()
+		reader      = new(MockReader)
+		writer      = new(MockWriter)
+		handler     = account.NewHandler(reader, writer, time.Hour)
+		requestedAt = time.Now()
+		pending     = account.New("name", "handle", "password")
+		active      = account.New("name", "other", "password")
+	)
+
+	pending.DeletionRequestedAt = &requestedAt
+
+	reader.On("Get", ctx, &account.GetCmd{ID: pending.ID}).Return(pending, nil).Once()
+	reader.On("Get", ctx, &account.GetCmd{ID: active.ID}).Return(active, nil).Once()
+	writer.On("SaveAccount", ctx, mock.MatchedBy(func(a *account.Account) bool {
+		return a.ID == pending.ID && !a.PendingDeletion()
+	})).Return(nil).Once()
+
+	cancelled, err := handler.CancelDeletion(ctx, &account.CancelDeletionCmd{AccountID: pending.ID})
+	require.NoError(t, err)
+	require.True(t, cancelled)
+
+	// Nothing to cancel, nothing to save
+	cancelled, err = handler.CancelDeletion(ctx, &account.CancelDeletionCmd{AccountID: active.ID})
+	require.NoError(t, err)
+	require.False(t, cancelled)
+
+	reader.AssertExpectations(t)
+	writer.AssertExpectations(t)
+}
+
+func TestGetLink(t *testing.T) {
+	var (
+		defaultLink = account.NewLink(uuid.New(), "Link", "https://example.com", 0)
+		funnyErr    = errors.New("haha")
+	)
+
+	testCases := []struct {
+		name      string
+		cmd       *account.GetLinkCmd
+		l         *account.Link
+		readerErr error
+		err       error
+	}{
+		{
+			name: "found",
+			cmd:  &account.GetLinkCmd{ID: defaultLink.ID, Handle: "handle"},
+			l:    defaultLink,
+		},
+		{
+			name: "not found",
+			cmd:  &account.GetLinkCmd{ID: uuid.New(), Handle: "handle"},
+			err:  account.ErrLinkNotFound,
+		},
+		{
+			name:      "reader error out",
+			cmd:       &account.GetLinkCmd{ID: defaultLink.ID, Handle: "handle"},
+			readerErr: funnyErr,
+			err:       funnyErr,
+		},
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			var (
+				ctx            = context.Background()
+				reader         = new(MockReader)
+				writer         = new(MockWriter)
+				accountHandler = account.NewHandler(reader, writer, time.Hour)
+			)
+
+			// Previous handles only reach the link within the grace period
+			matches := mock.MatchedBy(func(cmd *account.GetLinkCmd) bool {
+				return cmd.ID == c.cmd.ID && cmd.Handle == c.cmd.Handle &&
+					time.Since(cmd.HandleChangedAfter.Add(time.Hour)) < time.Second
+			})
+			reader.On("GetLink", ctx, matches).Return(c.l, c.readerErr).Once()
+
+			l, err := accountHandler.GetLink(ctx, c.cmd)
+			require.ErrorIs(t, err, c.err)
+
+			reader.AssertExpectations(t)
+
+			if c.err != nil {
+				return
+			}
+
+			require.Equal(t, c.l.ID, l.ID)
+		})
+	}
+}
+
+func TestUpdateAvatar(t *testing.T) {
+	var (
+		defaultAccount = account.New("name", "handle", "password")
+		withAvatar     = *defaultAccount
+		buf            bytes.Buffer
+	)
+
+	withAvatar.Avi = []byte("avi")
+	withAvatar.AviThumbnail = []byte("thumbnail")
+
+	// Wider than it is tall so it has to be cropped
+	src := image.NewRGBA(image.Rect(0, 0, 600, 300))
+	draw.Draw(src, src.Bounds(), image.NewUniform(color.RGBA{R: 255, A: 255}), image.Point{}, draw.Src)
+	require.Nil(t, png.Encode(&buf, src))
+
+	testCases := []struct {
+		name       string
+		cmd        *account.UpdateAvatarCmd
+		exists     *account.Account
+		removed    bool
+		err        error
+		skipReader bool
+		skipWriter bool
+	}{
+		{
+			name: "valid upload",
+			cmd: &account.UpdateAvatarCmd{
+				AccountID: defaultAccount.ID,
+				Image:     buf.Bytes(),
+			},
+			exists: defaultAccount,
+		},
+		{
+			name: "not an image",
+			cmd: &account.UpdateAvatarCmd{
+				AccountID: defaultAccount.ID,
+				Image:     []byte("definitely not an image"),
+			},
+			err:        imaging.ErrUnsupportedFormat,
+			skipReader: true,
+			skipWriter: true,
+		},
+		{
+			name: "remove avatar",
+			cmd: &account.UpdateAvatarCmd{
+				AccountID: defaultAccount.ID,
+			},
+			exists:  &withAvatar,
+			removed: true,
+		},
+		{
+			name: "account not found",
+			cmd: &account.UpdateAvatarCmd{
+				AccountID: defaultAccount.ID,
+				Image:     buf.Bytes(),
+			},
+			err:        account.ErrAccountNotFound,
+			skipWriter: true,
+		},
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			var (
+				ctx            = context.Background()
+				reader         = new(MockReader)
+				writer         = new(MockWriter)
+				accountHandler = account.NewHandler(reader, writer, time.Hour)
+			)
+
+			var exists *account.Account
+			if c.exists != nil {
+				b := *c.exists
+				exists = &b
+			}
+
+			if !c.skipReader {
+				reader.On("Get", ctx, mock.MatchedBy(func(cmd *account.GetCmd) bool {
+					return cmd.ID == c.cmd.AccountID
+				})).Return(exists, nil).Once()
+			}
+
+			if !c.skipWriter {
+				writer.On("SaveAccount", ctx, mock.MatchedBy(func(a *account.Account) bool {
+					return a.ID == c.cmd.AccountID
+				})).Return(nil).Once()
+			}
+
+			a, err := accountHandler.UpdateAvatar(ctx, c.cmd)
+			require.ErrorIs(t, err, c.err)
+
+			reader.AssertExpectations(t)
+			writer.AssertExpectations(t)
+
+			if c.err != nil {
+				return
+			}
+
+			if c.removed {
+				require.Nil(t, a.Avi)
+				require.Nil(t, a.AviThumbnail)
+				return
+			}
+
+			for size, b := range map[int][]byte{
+				account.AvatarSize:          a.Avi,
+				account.AvatarThumbnailSize: a.AviThumbnail,
+			} {
+				cfg, _, err := image.DecodeConfig(bytes.NewReader(b))
+				require.Nil(t, err)
+				require.Equal(t, size, cfg.Width)
+				require.Equal(t, size, cfg.Height)
+			}
+		})
+	}
+}
+
+// linksAccount returns an account with three links, a new one each time
+// since the handler changes the account it gets from the reader
+func linksAccount() *account.Account {
+	a := account.New("name", "handle", "password")
+	a.Links = []account.Link{
+		*account.NewLink(a.ID, "First", "https://first.com", 0),
+		*account.NewLink(a.ID, "Second", "https://second.com", 1),
+		*account.NewLink(a.ID, "Third", "https://third.com", 2),
+	}
+
+	return a
+}
+
+// expectLinks sets up the reader to return a copy of exists, and the writer
+// to expect the links in the given order unless links is nil
+func expectLinks(
+	ctx context.Context,
+	reader *MockReader,
+	writer *MockWriter,
+	id uuid.UUID,
+	exists *account.Account,
+	links []string,
+) {
+	var e *account.Account
+	if exists != nil {
+		b := *exists
+		b.Links = append([]account.Link(nil), exists.Links...)
+		e = &b
+	}
+
+	reader.On("Get", ctx, mock.MatchedBy(func(cmd *account.GetCmd) bool {
+		return cmd.ID == id
+	})).Return(e, nil).Once()
+
+	if links == nil {
+		return
+	}
+
+	writer.On("SaveAccount", ctx, mock.MatchedBy(func(a *account.Account) bool {
+		if len(a.Links) != len(links) {
+			return false
+		}
+
+		for i := range links {
+			if a.Links[i].Link != links[i] || a.Links[i].Index != i {
+				return false
+			}
+		}
+
+		return true
+	})).Return(nil).Once()
+}
+
+func TestCreateLink(t *testing.T) {
+	a := linksAccount()
+
+	testCases := []struct {
+		name   string
+		cmd    *account.CreateLinkCmd
+		exists *account.Account
+		links  []string
+		err    error
+		errStr string
+	}{
+		{
+			name:   "appended",
+			cmd:    &account.CreateLinkCmd{AccountID: a.ID, Title: "Fourth", Link: "https://fourth.com"},
+			exists: a,
+			links:  []string{"https://first.com", "https://second.com", "https://third.com", "https://fourth.com"},
+		},
+		{
+			name:   "duplicate",
+			cmd:    &account.CreateLinkCmd{AccountID: a.ID, Title: "Again", Link: "https://second.com"},
+			exists: a,
+			err:    account.ErrDuplicateLink,
+		},
+		{
+			name:   "invalid url",
+			cmd:    &account.CreateLinkCmd{AccountID: a.ID, Title: "Bad", Link: "bad"},
+			exists: a,
+			errStr: "Link.Link",
+		},
+		{
+			name: "account not found",
+			cmd:  &account.CreateLinkCmd{AccountID: a.ID, Title: "Fourth", Link: "https://fourth.com"},
+			err:  account.ErrAccountNotFound,
+		},
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			var (
+				ctx            = context.Background()
+				reader         = new(MockReader)
+				writer         = new(MockWriter)
+				accountHandler = account.NewHandler(reader, writer, time.Hour)
+			)
+
+			expectLinks(ctx, reader, writer, c.cmd.AccountID, c.exists, c.links)
+
+			l, err := accountHandler.CreateLink(ctx, c.cmd)
+
+			reader.AssertExpectations(t)
+			writer.AssertExpectations(t)
+
+			if c.err != nil {
+				require.ErrorIs(t, err, c.err)
+				return
+			}
+
+			if c.errStr != "" {
+				require.ErrorContains(t, err, c.errStr)
+				return
+			}
+
+			require.Nil(t, err)
+			require.Equal(t, c.cmd.Title, l.Title)
+			require.Equal(t, c.cmd.Link, l.Link)
+			require.Equal(t, len(c.links)-1, l.Index)
+		})
+	}
+}
+
+func TestUpdateLink(t *testing.T) {
+	a := linksAccount()
+
+	testCases := []struct {
+		name  string
+		cmd   *account.UpdateLinkCmd
+		links []string
+		title string
+		err   error
+	}{
+		{
+			name:  "change url",
+			cmd:   &account.UpdateLinkCmd{AccountID: a.ID, ID: a.Links[1].ID, Link: "https://changed.com"},
+			links: []string{"https://first.com", "https://changed.com", "https://third.com"},
+			title: "Second",
+		},
+		{
+			name:  "change title",
+			cmd:   &account.UpdateLinkCmd{AccountID: a.ID, ID: a.Links[1].ID, Title: "Renamed"},
+			links: []string{"https://first.com", "https://second.com", "https://third.com"},
+			title: "Renamed",
+		},
+		{
+			name: "duplicate url",
+			cmd:  &account.UpdateLinkCmd{AccountID: a.ID, ID: a.Links[1].ID, Link: "https://first.com"},
+			err:  account.ErrDuplicateLink,
+		},
+		{
+			name: "unknown link",
+			cmd:  &account.UpdateLinkCmd{AccountID: a.ID, ID: uuid.New(), Title: "Renamed"},
+			err:  account.ErrLinkNotFound,
+		},
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			var (
+				ctx            = context.Background()
+				reader         = new(MockReader)
+				writer         = new(MockWriter)
+				accountHandler = account.NewHandler(reader, writer, time.Hour)
+			)
+
+			expectLinks(ctx, reader, writer, a.ID, a, c.links)
+
+			l, err := accountHandler.UpdateLink(ctx, c.cmd)
+			require.ErrorIs(t, err, c.err)
+
+			reader.AssertExpectations(t)
+			writer.AssertExpectations(t)
+
+			if c.err != nil {
+				return
+			}
+
+			require.Equal(t, c.cmd.ID, l.ID)
+			require.Equal(t, c.title, l.Title)
+		})
+	}
+}
+
+func TestDeleteLink(t *testing.T) {
+	a := linksAccount()
+
+	testCases := []struct {
+		name  string
+		cmd   *account.DeleteLinkCmd
+		links []string
+		err   error
+	}{
+		{
+			name:  "delete the middle link",
+			cmd:   &account.DeleteLinkCmd{AccountID: a.ID, ID: a.Links[1].ID},
+			links: []string{"https://first.com", "https://third.com"},
+		},
+		{
+			name: "unknown link",
+			cmd:  &account.DeleteLinkCmd{AccountID: a.ID, ID: uuid.New()},
+			err:  account.ErrLinkNotFound,
+		},
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			var (
+				ctx            = context.Background()
+				reader         = new(MockReader)
+				writer         = new(MockWriter)
+				accountHandler = account.NewHandler(reader, writer, time.Hour)
+			)
+
+			expectLinks(ctx, reader, writer, a.ID, a, c.links)
+
+			err := accountHandler.DeleteLink(ctx, c.cmd)
+			require.ErrorIs(t, err, c.err)
+
+			reader.AssertExpectations(t)
+			writer.AssertExpectations(t)
+		})
+	}
+}
+
+func TestReorderLinks(t *testing.T) {
+	var (
+		a                    = linksAccount()
+		first, second, third = a.Links[0].ID, a.Links[1].ID, a.Links[2].ID
+	)
+
+	testCases := []struct {
+		name  string
+		ids   []uuid.UUID
+		links []string
+		err   error
+	}{
+		{
+			name:  "reverse",
+			ids:   []uuid.UUID{third, second, first},
+			links: []string{"https://third.com", "https://second.com", "https://first.com"},
+		},
+		{
+			name: "missing link",
+			ids:  []uuid.UUID{third, first},
+			err:  account.ErrLinkOrder,
+		},
+		{
+			name: "same link twice",
+			ids:  []uuid.UUID{third, first, first},
+			err:  account.ErrLinkOrder,
+		},
+		{
+			name: "unknown link",
+			ids:  []uuid.UUID{third, first, uuid.New()},
+			err:  account.ErrLinkOrder,
+		},
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			var (
+				ctx            = context.Background()
+				reader         = new(MockReader)
+				writer         = new(MockWriter)
+				accountHandler = account.NewHandler(reader, writer, time.Hour)
+			)
+
+			expectLinks(ctx, reader, writer, a.ID, a, c.links)
+
+			r, err := accountHandler.ReorderLinks(ctx, &account.ReorderLinksCmd{AccountID: a.ID, IDs: c.ids})
+			require.ErrorIs(t, err, c.err)
+
+			reader.AssertExpectations(t)
+			writer.AssertExpectations(t)
+
+			if c.err != nil {
+				return
+			}
+
+			for i := range c.ids {
+				require.Equal(t, c.ids[i], r.Links[i].ID)
+			}
+		})
+	}
+}
@@ -0,0 +1,556 @@
+package oidc
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/derinil/links/links/account"
+	"github.com/derinil/links/links/cache"
+	"github.com/derinil/links/links/crypto"
+	"github.com/derinil/links/links/generic"
+	"github.com/google/uuid"
+)
+
+type (
+	Handler interface {
+		Providers() []Provider
+		// Begin returns where to send the browser to log in at the provider
+		Begin(ctx context.Context, cmd *BeginCmd) (*Redirect, error)
+		// Finish checks the callback and returns who the provider says logged in
+		Finish(ctx context.Context, cmd *FinishCmd) (*Login, error)
+
+		// GetIdentity returns nil if nobody linked the identity yet
+		GetIdentity(ctx context.Context, cmd *GetIdentityCmd) (*Identity, error)
+		List(ctx context.Context, cmd *ListCmd) ([]Identity, error)
+		Link(ctx context.Context, cmd *LinkCmd) (*Identity, error)
+		Unlink(ctx context.Context, cmd *UnlinkCmd) error
+
+		// BeginSignup keeps a login without an account around while the user picks a handle
+		BeginSignup(ctx context.Context, cmd *BeginSignupCmd) (string, error)
+		GetSignup(ctx context.Context, cmd *SignupCmd) (*Signup, error)
+		// TakeSignup gets the signup and makes sure it can't be used again,
+		// only the first caller gets it
+		TakeSignup(ctx context.Context, cmd *SignupCmd) (*Signup, error)
+		// ReturnSignup puts back a taken signup that didn't make an account,
+		// so the user can pick another handle
+		ReturnSignup(ctx context.Context, cmd *SignupCmd, su *Signup) error
+	}
+
+	HandlerImpl struct {
+		reader         Reader
+		writer         Writer
+		cache          cache.Cache
+		accountHandler account.Handler
+		redirectURL    string
+		providers      map[string]*provider
+		// In the order they were configured
+		names []string
+	}
+
+	Config struct {
+		// RedirectURL is our callback, every provider has to allow it
+		RedirectURL string
+		Providers   []ProviderConfig
+	}
+
+	Reader interface {
+		// GetIdentity returns nil if there is no such identity
+		GetIdentity(ctx context.Context, provider, subject string) (*Identity, error)
+		ListIdentitiesByAccountID(ctx context.Context, accountID uuid.UUID) ([]Identity, error)
+	}
+
+	Writer interface {
+		SaveIdentity(ctx context.Context, i *Identity) error
+		// DeleteIdentity reports whether the account had an identity with the ID
+		DeleteIdentity(ctx context.Context, accountID, id uuid.UUID) (bool, error)
+	}
+
+	Redirect struct {
+		URL string
+		// State is what the callback has to come back with
+		State string
+	}
+
+	// Login is a finished login at a provider
+	Login struct {
+		Provider string
+		Claims   *Claims
+		// Set when the login was started to link the identity to this account
+		AccountID uuid.UUID
+	}
+
+	// Signup is a login at a provider waiting for an account
+	Signup struct {
+		Provider string
+		Subject  string
+		Email    string
+		Name     string
+		// Handle is a suggestion made from the claims, it might be taken
+		Handle    string
+		ExpiresAt time.Time
+	}
+
+	// pendingState is kept in the cache between Begin and Finish
+	pendingState struct {
+		Provider  string
+		Nonce     string
+		Verifier  string
+		AccountID uuid.UUID
+	}
+
+	BeginCmd struct {
+		Provider string
+		// Set to link the identity to the account instead of logging in
+		AccountID uuid.UUID
+	}
+
+	FinishCmd struct {
+		State string
+		Code  string
+		// Error is set when the provider sent the user back without a code
+		Error string
+	}
+
+	GetIdentityCmd struct {
+		Provider string
+		Subject  string
+	}
+
+	ListCmd struct {
+		AccountID uuid.UUID
+	}
+
+	LinkCmd struct {
+		AccountID uuid.UUID
+		Provider  string
+		Subject   string
+		Email     string
+	}
+
+	UnlinkCmd struct {
+		AccountID uuid.UUID
+		ID        uuid.UUID
+	}
+
+	BeginSignupCmd struct {
+		Login *Login
+	}
+
+	SignupCmd struct {
+		Token string
+	}
+)
+
+const (
+	StateLifetime  = 10 * time.Minute
+	SignupLifetime = 15 * time.Minute
+
+	stateLength    = 32
+	verifierLength = 32
+	signupLength   = 32
+)
+
+var (
+	ErrUnknownProvider = generic.NewWebError(http.StatusNotFound, "oidc_provider_unknown", "Unknown login provider")
+	ErrStateInvalid    = generic.NewWebError(http.StatusBadRequest, "oidc_state_invalid", "Login expired, try again")
+	ErrProviderFailed  = generic.NewWebError(http.StatusBadGateway, "oidc_provider_failed", "Couldn't log in with the provider, try again")
+	ErrLoginDenied     = generic.NewWebError(http.StatusBadRequest, "oidc_login_denied", "Login was cancelled at the provider")
+	ErrIdentityTaken   = generic.NewWebError(http.StatusBadRequest, "oidc_identity_taken", "That login is already connected to another account")
+	ErrIdentityMissing = generic.NewWebError(http.StatusNotFound, "oidc_identity_not_found", "Connected login not found")
+	ErrLastIdentity    = generic.NewWebError(http.StatusBadRequest, "oidc_last_identity", "This is the only way you can log in, it can't be disconnected")
+	ErrSignupGone      = generic.NewWebError(http.StatusBadRequest, "oidc_signup_expired", "Signup expired, log in with the provider again")
+)
+
+var _ Handler = (*HandlerImpl)(nil)
+
+// NewHandler uses client for every request to the providers, a nil client gets a default one
+func NewHandler(
+	reader Reader,
+	writer Writer,
+	cache cache.Cache,
+	accountHandler account.Handler,
+	client *http.Client,
+	config Config,
+) *HandlerImpl {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	s := &HandlerImpl{
+		reader:         reader,
+		writer:         writer,
+		cache:          cache,
+		accountHandler: accountHandler,
+		redirectURL:    config.RedirectURL,
+		providers:      make(map[string]*provider, len(config.Providers)),
+	}
+
+	for i := range config.Providers {
+		p := newProvider(config.Providers[i], client)
+		s.providers[p.config.Name] = p
+		s.names = append(s.names, p.config.Name)
+	}
+
+	return s
+}
+
+func (s *HandlerImpl) Providers() []Provider {
+	ps := make([]Provider, len(s.names))
+	for i, n := range s.names {
+		ps[i] = Provider{Name: n, DisplayName: s.providers[n].config.DisplayName}
+	}
+
+	return ps
+}
+
+func (s *HandlerImpl) Begin(ctx context.Context, cmd *BeginCmd) (*Redirect, error) {
+	p, ok := s.providers[cmd.Provider]
+	if !ok {
+		return nil, ErrUnknownProvider
+	}
+
+	m, err := p.discover(ctx)
+	if err != nil {
+		log.Println("failed to discover provider", cmd.Provider, err)
+		return nil, ErrProviderFailed
+	}
+
+	state, err := crypto.ReadHex(stateLength)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state: %w", err)
+	}
+
+	nonce, err := crypto.ReadHex(stateLength)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read nonce: %w", err)
+	}
+
+	vb, err := crypto.ReadBytes(verifierLength)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read code verifier: %w", err)
+	}
+
+	ps := &pendingState{
+		Provider:  cmd.Provider,
+		Nonce:     nonce,
+		Verifier:  base64.RawURLEncoding.EncodeToString(vb),
+		AccountID: cmd.AccountID,
+	}
+
+	if err := s.put(ctx, stateCacheKey(state), ps, StateLifetime); err != nil {
+		return nil, fmt.Errorf("failed to store state: %w", err)
+	}
+
+	challenge := sha256.Sum256([]byte(ps.Verifier))
+
+	return &Redirect{
+		URL:   p.authCodeURL(m, s.redirectURL, state, nonce, base64.RawURLEncoding.EncodeToString(challenge[:])),
+		State: state,
+	}, nil
+}
+
+func (s *HandlerImpl) Finish(ctx context.Context, cmd *FinishCmd) (*Login, error) {
+	var ps pendingState
+	if err := s.take(ctx, stateCacheKey(cmd.State), &ps); err != nil {
+		return nil, err
+	}
+
+	if cmd.Error != "" {
+		return nil, ErrLoginDenied
+	}
+
+	if cmd.Code == "" {
+		return nil, ErrStateInvalid
+	}
+
+	p, ok := s.providers[ps.Provider]
+	if !ok {
+		return nil, ErrUnknownProvider
+	}
+
+	m, err := p.discover(ctx)
+	if err != nil {
+		log.Println("failed to discover provider", ps.Provider, err)
+		return nil, ErrProviderFailed
+	}
+
+	raw, err := p.exchange(ctx, m, s.redirectURL, cmd.Code, ps.Verifier)
+	if err != nil {
+		log.Println("failed to exchange code", ps.Provider, err)
+		return nil, ErrProviderFailed
+	}
+
+	c, err := p.verifyIDToken(ctx, m, raw, ps.Nonce)
+	if err != nil {
+		log.Println("invalid id token", ps.Provider, err)
+		return nil, ErrProviderFailed
+	}
+
+	return &Login{
+		Provider:  ps.Provider,
+		Claims:    c,
+		AccountID: ps.AccountID,
+	}, nil
+}
+
+func (s *HandlerImpl) GetIdentity(ctx context.Context, cmd *GetIdentityCmd) (*Identity, error) {
+	i, err := s.reader.GetIdentity(ctx, cmd.Provider, cmd.Subject)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get identity: %w", err)
+	}
+
+	return i, nil
+}
+
+func (s *HandlerImpl) List(ctx context.Context, cmd *ListCmd) ([]Identity, error) {
+	is, err := s.reader.ListIdentitiesByAccountID(ctx, cmd.AccountID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list identities: %w", err)
+	}
+
+	return is, nil
+}
+
+func (s *HandlerImpl) Link(ctx context.Context, cmd *LinkCmd) (*Identity, error) {
+	if _, ok := s.providers[cmd.Provider]; !ok {
+		return nil, ErrUnknownProvider
+	}
+
+	i, err := s.GetIdentity(ctx, &GetIdentityCmd{Provider: cmd.Provider, Subject: cmd.Subject})
+	if err != nil {
+		return nil, err
+	}
+
+	if i != nil && i.AccountID != cmd.AccountID {
+		return nil, ErrIdentityTaken
+	}
+
+	// Linking again just refreshes the email
+	if i != nil {
+		i.Email = cmd.Email
+	} else {
+		i = NewIdentity(cmd.AccountID, cmd.Provider, cmd.Subject, cmd.Email)
+	}
+
+	if err := s.writer.SaveIdentity(ctx, i); err != nil {
+		return nil, fmt.Errorf("failed to save identity: %w", err)
+	}
+
+	return i, nil
+}
+
+func (s *HandlerImpl) Unlink(ctx context.Context, cmd *UnlinkCmd) error {
+	a, err := s.accountHandler.Get(ctx, &account.GetCmd{ID: cmd.AccountID, Shallow: true})
+	if err != nil {
+		return fmt.Errorf("failed to get account: %w", err)
+	}
+
+	is, err := s.List(ctx, &ListCmd{AccountID: cmd.AccountID})
+	if err != nil {
+		return err
+	}
+
+	if a.Password == "" && len(is) <= 1 {
+		return ErrLastIdentity
+	}
+
+	ok, err := s.writer.DeleteIdentity(ctx, cmd.AccountID, cmd.ID)
+	if err != nil {
+		return fmt.Errorf("failed to delete identity: %w", err)
+	}
+
+	if !ok {
+		return ErrIdentityMissing
+	}
+
+	return nil
+}
+
+func (s *HandlerImpl) BeginSignup(ctx context.Context, cmd *BeginSignupCmd) (string, error) {
+	token, err := crypto.ReadHex(signupLength)
+	if err != nil {
+		return "", fmt.Errorf("failed to read signup token: %w", err)
+	}
+
+	c := cmd.Login.Claims
+	su := &Signup{
+		Provider: cmd.Login.Provider,
+		Subject:  c.Subject,
+		Email:    c.Email,
+		Name:     c.Name,
+		Handle:   suggestHandle(c),
+		// ReturnSignup keeps the expiry, so failing doesn't extend it
+		ExpiresAt: time.Now().Add(SignupLifetime),
+	}
+
+	if err := s.put(ctx, signupCacheKey(token), su, SignupLifetime); err != nil {
+		return "", fmt.Errorf("failed to store signup: %w", err)
+	}
+
+	return token, nil
+}
+
+func (s *HandlerImpl) GetSignup(ctx context.Context, cmd *SignupCmd) (*Signup, error) {
+	if cmd.Token == "" {
+		return nil, ErrSignupGone
+	}
+
+	b, err := s.cache.Get(ctx, signupCacheKey(cmd.Token))
+	if err != nil || len(b) == 0 {
+		return nil, ErrSignupGone
+	}
+
+	var su Signup
+	if err := gob.NewDecoder(bytes.NewReader(b)).Decode(&su); err != nil {
+		return nil, fmt.Errorf("failed to decode signup: %w", err)
+	}
+
+	return &su, nil
+}
+
+func (s *HandlerImpl) TakeSignup(ctx context.Context, cmd *SignupCmd) (*Signup, error) {
+	if cmd.Token == "" {
+		return nil, ErrSignupGone
+	}
+
+	var su Signup
+	if err := s.take(ctx, signupCacheKey(cmd.Token), &su); err != nil {
+		if errors.Is(err, ErrStateInvalid) {
+			return nil, ErrSignupGone
+		}
+		return nil, fmt.Errorf("failed to take signup: %w", err)
+	}
+
+	return &su, nil
+}
+
+func (s *HandlerImpl) ReturnSignup(ctx context.Context, cmd *SignupCmd, su *Signup) error {
+	ttl := time.Until(su.ExpiresAt)
+	if ttl <= 0 {
+		return ErrSignupGone
+	}
+
+	if err := s.put(ctx, signupCacheKey(cmd.Token), su, ttl); err != nil {
+		return fmt.Errorf("failed to return signup: %w", err)
+	}
+
+	return nil
+}
+
+func (s *HandlerImpl) put(ctx context.Context, key string, v any, ttl time.Duration) error {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return fmt.Errorf("failed to encode: %w", err)
+	}
+
+	return s.cache.PutWithTTL(ctx, key, buf.Bytes(), ttl)
+}
+
+// take gets and deletes the value under key, only the first caller gets it
+func (s *HandlerImpl) take(ctx context.Context, key string, v any) error {
+	b, err := s.cache.Get(ctx, key)
+	if err != nil || len(b) == 0 {
+		return ErrStateInvalid
+	}
+
+	ok, err := s.cache.Invalidate(ctx, key)
+	if err != nil {
+		return fmt.Errorf("failed to invalidate state: %w", err)
+	}
+
+	if !ok {
+		return ErrStateInvalid
+	}
+
+	if err := gob.NewDecoder(bytes.NewReader(b)).Decode(v); err != nil {
+		return fmt.Errorf("failed to decode state: %w", err)
+	}
+
+	return nil
+}
+
+// suggestHandle makes a handle out of the username or email, the user can change it before signing up
+func suggestHandle(c *Claims) string {
+	src := c.PreferredUsername
+	if src == "" {
+		src, _, _ = strings.Cut(c.Email, "@")
+	}
+
+	var b strings.Builder
+	for _, r := range strings.ToLower(src) {
+		if b.Len() == 24 {
+			break
+		}
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+		}
+	}
+
+	return b.String()
+}
+
+// The state and signup tokens are secrets, so only their hashes go in cache keys
+func stateCacheKey(state string) string {
+	h := sha256.Sum256([]byte(state))
+	return "oidc-state-" + hex.EncodeToString(h[:])
+}
+
+func signupCacheKey(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return "oidc-signup-" + hex.EncodeToString(h[:])
+}
+
+const (
+	// StateCookieName ties the callback to the browser that started the login
+	StateCookieName = "oidc_state"
+	// SignupCookieName holds the signup token while the user picks a handle
+	SignupCookieName = "oidc_signup"
+
+	cookiePath = "/login/oidc"
+)
+
+// StateCookie has to be Lax, the callback is a redirect from another site
+func StateCookie(state string) *http.Cookie {
+	return &http.Cookie{
+		Name:     StateCookieName,
+		Value:    state,
+		Path:     cookiePath,
+		Expires:  time.Now().Add(StateLifetime),
+		MaxAge:   int(StateLifetime.Seconds()),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+func SignupCookie(token string) *http.Cookie {
+	return &http.Cookie{
+		Name:     SignupCookieName,
+		Value:    token,
+		Path:     cookiePath,
+		Expires:  time.Now().Add(SignupLifetime),
+		MaxAge:   int(SignupLifetime.Seconds()),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+func RemoveCookie(name string) *http.Cookie {
+	return &http.Cookie{
+		Name:     name,
+		Value:    "",
+		Path:     cookiePath,
+		Expires:  time.Now().Add(-time.Hour),
+		MaxAge:   -1,
+		HttpOnly: true,
+	}
+}
@@ -0,0 +1,512 @@
+package oidc_test
+
+import (
+	"context"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/derinil/links/links/account"
+	"github.com/derinil/links/links/account/oidc"
+	"github.com/derinil/links/links/account/oidc/oidctest"
+	"github.com/derinil/links/links/cache"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type (
+	MockReader struct{ mock.Mock }
+	MockWriter struct{ mock.Mock }
+
+	// FakeAccounts always returns the same account
+	FakeAccounts struct {
+		account *account.Account
+	}
+)
+
+const (
+	clientID     = "links"
+	clientSecret = "hunter2"
+	redirectURL  = "https://links.test/login/oidc/callback"
+)
+
+func (m *MockReader) GetIdentity(ctx context.Context, provider, subject string) (*oidc.Identity, error) {
+	args := m.Called(ctx, provider, subject)
+	return args.Get(0).(*oidc.Identity), args.Error(1)
+}
+
+func (m *MockReader) ListIdentitiesByAccountID(ctx context.Context, accountID uuid.UUID) ([]oidc.Identity, error) {
+	args := m.Called(ctx, accountID)
+	return args.Get(0).([]oidc.Identity), args.Error(1)
+}
+
+func (m *MockWriter) SaveIdentity(ctx context.Context, i *oidc.Identity) error {
+	args := m.Called(ctx, i)
+	return args.Error(0)
+}
+
+func (m *MockWriter) DeleteIdentity(ctx context.Context, accountID, id uuid.UUID) (bool, error) {
+	args := m.Called(ctx, accountID, id)
+	return args.Bool(0), args.Error(1)
+}
+
+func (f *FakeAccounts) Get(ctx context.Context, cmd *account.GetCmd) (*account.Account, error) {
+	return f.account, nil
+}
+
+func (f *FakeAccounts) GetLink(ctx context.Context, cmd *account.GetLinkCmd) (*account.Link, error) {
+	return nil, nil
+}
+
+func newHandler(reader *MockReader, writer *MockWriter, a *account.Account, providers ...oidc.ProviderConfig) *oidc.HandlerImpl {
+	return oidc.NewHandler(
+		reader,
+		writer,
+		cache.NewMemory(cache.MemoryConfig{}),
+		account.NewHandler(&FakeAccounts{account: a}, nil, time.Hour),
+		nil,
+		oidc.Config{RedirectURL: redirectURL, Providers: providers},
+	)
+}
+
+func providerConfig(p *oidctest.Provider) oidc.ProviderConfig {
+	return oidc.ProviderConfig{
+		Name:         "corp",
+		DisplayName:  "Corp SSO",
+		Issuer:       p.Issuer(),
+		ClientID:     p.ClientID,
+		ClientSecret: p.ClientSecret,
+		Scopes:       []string{"email", "profile"},
+	}
+}
+
+// login goes through the whole flow the way a browser would
+func login(t *testing.T, h *oidc.HandlerImpl, p *oidctest.Provider, accountID uuid.UUID) (*oidc.Login, error) {
+	ctx := context.Background()
+
+	rd, err := h.Begin(ctx, &oidc.BeginCmd{Provider: "corp", AccountID: accountID})
+	require.NoError(t, err)
+
+	cb, err := p.Authorize(rd.URL)
+	require.NoError(t, err)
+	require.Equal(t, rd.State, cb.Query().Get("state"))
+
+	return h.Finish(ctx, &oidc.FinishCmd{
+		State: cb.Query().Get("state"),
+		Code:  cb.Query().Get("code"),
+	})
+}
+
+func TestLogin(t *testing.T) {
+	testCases := []struct {
+		name      string
+		accountID uuid.UUID
+		modify    func(header, claims map[string]any)
+		// Changes the config we use for the provider
+		config func(c *oidc.ProviderConfig)
+		err    error
+	}{
+		{
+			name: "login",
+		},
+		{
+			name:      "link",
+			accountID: uuid.New(),
+		},
+		{
+			name: "audience list with azp",
+			modify: func(header, claims map[string]any) {
+				claims["aud"] = []string{clientID, "someone-else"}
+				claims["azp"] = clientID
+			},
+		},
+		{
+			name: "public client",
+			config: func(c *oidc.ProviderConfig) {
+				c.ClientSecret = ""
+			},
+			err: oidc.ErrProviderFailed,
+		},
+		{
+			name: "wrong client secret",
+			config: func(c *oidc.ProviderConfig) {
+				c.ClientSecret = "hunter3"
+			},
+			err: oidc.ErrProviderFailed,
+		},
+		{
+			name: "wrong nonce",
+			modify: func(header, claims map[string]any) {
+				claims["nonce"] = "something else"
+			},
+			err: oidc.ErrProviderFailed,
+		},
+		{
+			name: "wrong audience",
+			modify: func(header, claims map[string]any) {
+				claims["aud"] = "someone-else"
+			},
+			err: oidc.ErrProviderFailed,
+		},
+		{
+			name: "audience list without azp",
+			modify: func(header, claims map[string]any) {
+				claims["aud"] = []string{clientID, "someone-else"}
+			},
+			err: oidc.ErrProviderFailed,
+		},
+		{
+			name: "wrong issuer",
+			modify: func(header, claims map[string]any) {
+				claims["iss"] = "https://evil.test"
+			},
+			err: oidc.ErrProviderFailed,
+		},
+		{
+			name: "expired",
+			modify: func(header, claims map[string]any) {
+				claims["exp"] = time.Now().Add(-time.Hour).Unix()
+			},
+			err: oidc.ErrProviderFailed,
+		},
+		{
+			name: "issued in the future",
+			modify: func(header, claims map[string]any) {
+				claims["iat"] = time.Now().Add(time.Hour).Unix()
+			},
+			err: oidc.ErrProviderFailed,
+		},
+		{
+			name: "no subject",
+			modify: func(header, claims map[string]any) {
+				delete(claims, "sub")
+			},
+			err: oidc.ErrProviderFailed,
+		},
+		{
+			name: "alg none",
+			modify: func(header, claims map[string]any) {
+				header["alg"] = "none"
+			},
+			err: oidc.ErrProviderFailed,
+		},
+		{
+			name: "alg confusion",
+			modify: func(header, claims map[string]any) {
+				header["alg"] = "HS256"
+			},
+			err: oidc.ErrProviderFailed,
+		},
+		{
+			name: "unknown key",
+			modify: func(header, claims map[string]any) {
+				header["kid"] = "made-up"
+			},
+			err: oidc.ErrProviderFailed,
+		},
+	}
+
+	for _, c := range testCases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			p := oidctest.NewProvider(clientID, clientSecret)
+			defer p.Close()
+
+			p.Modify = c.modify
+
+			pc := providerConfig(p)
+			if c.config != nil {
+				c.config(&pc)
+			}
+
+			h := newHandler(&MockReader{}, &MockWriter{}, nil, pc)
+
+			l, err := login(t, h, p, c.accountID)
+			if c.err != nil || err != nil {
+				require.ErrorIs(t, err, c.err)
+				return
+			}
+
+			require.Equal(t, "corp", l.Provider)
+			require.Equal(t, c.accountID, l.AccountID)
+			require.Equal(t, oidctest.DefaultSubject, l.Claims.Subject)
+			require.Equal(t, "jane@example.com", l.Claims.Email)
+			require.True(t, bool(l.Claims.EmailVerified))
+		})
+	}
+}
+
+func TestFinish(t *testing.T) {
+	p := oidctest.NewProvider(clientID, clientSecret)
+	defer p.Close()
+
+	var (
+		ctx = context.Background()
+		h   = newHandler(&MockReader{}, &MockWriter{}, nil, providerConfig(p))
+	)
+
+	// The query of the callback as the provider sent it
+	callback := func(t *testing.T) url.Values {
+		rd, err := h.Begin(ctx, &oidc.BeginCmd{Provider: "corp"})
+		require.NoError(t, err)
+
+		cb, err := p.Authorize(rd.URL)
+		require.NoError(t, err)
+
+		return cb.Query()
+	}
+
+	t.Run("state only works once", func(t *testing.T) {
+		q := callback(t)
+
+		_, err := h.Finish(ctx, &oidc.FinishCmd{State: q.Get("state"), Code: q.Get("code")})
+		require.NoError(t, err)
+
+		_, err = h.Finish(ctx, &oidc.FinishCmd{State: q.Get("state"), Code: q.Get("code")})
+		require.ErrorIs(t, err, oidc.ErrStateInvalid)
+	})
+
+	t.Run("unknown state", func(t *testing.T) {
+		q := callback(t)
+
+		_, err := h.Finish(ctx, &oidc.FinishCmd{State: "made-up", Code: q.Get("code")})
+		require.ErrorIs(t, err, oidc.ErrStateInvalid)
+	})
+
+	t.Run("code from another login", func(t *testing.T) {
+		var (
+			q     = callback(t)
+			other = callback(t)
+		)
+
+		// The other login's verifier doesn't match, so PKCE stops this
+		_, err := h.Finish(ctx, &oidc.FinishCmd{State: q.Get("state"), Code: other.Get("code")})
+		require.ErrorIs(t, err, oidc.ErrProviderFailed)
+	})
+
+	t.Run("denied at the provider", func(t *testing.T) {
+		q := callback(t)
+
+		_, err := h.Finish(ctx, &oidc.FinishCmd{State: q.Get("state"), Error: "access_denied"})
+		require.ErrorIs(t, err, oidc.ErrLoginDenied)
+
+		// The state is gone either way
+		_, err = h.Finish(ctx, &oidc.FinishCmd{State: q.Get("state"), Code: q.Get("code")})
+		require.ErrorIs(t, err, oidc.ErrStateInvalid)
+	})
+
+	t.Run("unknown provider", func(t *testing.T) {
+		_, err := h.Begin(ctx, &oidc.BeginCmd{Provider: "nope"})
+		require.ErrorIs(t, err, oidc.ErrUnknownProvider)
+	})
+
+	t.Run("key rotation", func(t *testing.T) {
+		_, err := login(t, h, p, uuid.Nil)
+		require.NoError(t, err)
+
+		p.RotateKey()
+
+		_, err = login(t, h, p, uuid.Nil)
+		require.NoError(t, err)
+	})
+}
+
+func TestLink(t *testing.T) {
+	var (
+		accountID = uuid.New()
+		existing  = oidc.NewIdentity(accountID, "corp", "123", "old@example.com")
+	)
+
+	testCases := []struct {
+		name     string
+		provider string
+		stored   *oidc.Identity
+		err      error
+	}{
+		{
+			name:     "new",
+			provider: "corp",
+		},
+		{
+			name:     "again",
+			provider: "corp",
+			stored:   existing,
+		},
+		{
+			name:     "linked to another account",
+			provider: "corp",
+			stored:   oidc.NewIdentity(uuid.New(), "corp", "123", ""),
+			err:      oidc.ErrIdentityTaken,
+		},
+		{
+			name:     "unknown provider",
+			provider: "nope",
+			err:      oidc.ErrUnknownProvider,
+		},
+	}
+
+	for _, c := range testCases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			var (
+				ctx    = context.Background()
+				reader = &MockReader{}
+				writer = &MockWriter{}
+				h      = newHandler(reader, writer, nil, oidc.ProviderConfig{Name: "corp"})
+			)
+
+			reader.On("GetIdentity", ctx, "corp", "123").Return(c.stored, nil)
+			writer.On("SaveIdentity", ctx, mock.Anything).Return(nil)
+
+			i, err := h.Link(ctx, &oidc.LinkCmd{
+				AccountID: accountID,
+				Provider:  c.provider,
+				Subject:   "123",
+				Email:     "jane@example.com",
+			})
+			if c.err != nil || err != nil {
+				require.ErrorIs(t, err, c.err)
+				writer.AssertNotCalled(t, "SaveIdentity", mock.Anything, mock.Anything)
+				return
+			}
+
+			if c.stored != nil {
+				require.Equal(t, c.stored.ID, i.ID)
+			}
+
+			require.Equal(t, accountID, i.AccountID)
+			require.Equal(t, "jane@example.com", i.Email)
+			writer.AssertCalled(t, "SaveIdentity", ctx, i)
+		})
+	}
+}
+
+func TestUnlink(t *testing.T) {
+	var (
+		accountID = uuid.New()
+		id        = uuid.New()
+		one       = []oidc.Identity{{AccountID: accountID}}
+		two       = []oidc.Identity{{AccountID: accountID}, {AccountID: accountID}}
+	)
+
+	testCases := []struct {
+		name       string
+		password   string
+		identities []oidc.Identity
+		deleted    bool
+		err        error
+	}{
+		{
+			name:       "has a password",
+			password:   "$argon2id$...",
+			identities: one,
+			deleted:    true,
+		},
+		{
+			name:       "has another identity",
+			identities: two,
+			deleted:    true,
+		},
+		{
+			name:       "last way to log in",
+			identities: one,
+			err:        oidc.ErrLastIdentity,
+		},
+		{
+			name:       "not found",
+			password:   "$argon2id$...",
+			identities: one,
+			err:        oidc.ErrIdentityMissing,
+		},
+	}
+
+	for _, c := range testCases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			var (
+				ctx    = context.Background()
+				reader = &MockReader{}
+				writer = &MockWriter{}
+				a      = &account.Account{Password: c.password}
+				h      = newHandler(reader, writer, a)
+			)
+
+			a.ID = accountID
+
+			reader.On("ListIdentitiesByAccountID", ctx, accountID).Return(c.identities, nil)
+			writer.On("DeleteIdentity", ctx, accountID, id).Return(c.deleted, nil)
+
+			err := h.Unlink(ctx, &oidc.UnlinkCmd{AccountID: accountID, ID: id})
+			if c.err != nil || err != nil {
+				require.ErrorIs(t, err, c.err)
+				return
+			}
+
+			writer.AssertCalled(t, "DeleteIdentity", ctx, accountID, id)
+		})
+	}
+}
+
+func TestSignup(t *testing.T) {
+	testCases := []struct {
+		name   string
+		claims *oidc.Claims
+		handle string
+	}{
+		{
+			name:   "preferred username",
+			claims: &oidc.Claims{Subject: "1", PreferredUsername: "Jane.Doe", Email: "jd@example.com"},
+			handle: "janedoe",
+		},
+		{
+			name:   "email",
+			claims: &oidc.Claims{Subject: "1", Email: "j_d+links@example.com"},
+			handle: "jdlinks",
+		},
+		{
+			name:   "too long",
+			claims: &oidc.Claims{Subject: "1", PreferredUsername: "abcdefghijklmnopqrstuvwxyz"},
+			handle: "abcdefghijklmnopqrstuvwx",
+		},
+		{
+			name:   "nothing to go on",
+			claims: &oidc.Claims{Subject: "1"},
+		},
+	}
+
+	for _, c := range testCases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			var (
+				ctx = context.Background()
+				h   = newHandler(&MockReader{}, &MockWriter{}, nil)
+			)
+
+			token, err := h.BeginSignup(ctx, &oidc.BeginSignupCmd{Login: &oidc.Login{Provider: "corp", Claims: c.claims}})
+			require.NoError(t, err)
+
+			su, err := h.GetSignup(ctx, &oidc.SignupCmd{Token: token})
+			require.NoError(t, err)
+			require.Equal(t, "corp", su.Provider)
+			require.Equal(t, c.claims.Subject, su.Subject)
+			require.Equal(t, c.handle, su.Handle)
+
+			taken, err := h.TakeSignup(ctx, &oidc.SignupCmd{Token: token})
+			require.NoError(t, err)
+			require.Equal(t, su, taken)
+
+			_, err = h.GetSignup(ctx, &oidc.SignupCmd{Token: token})
+			require.ErrorIs(t, err, oidc.ErrSignupGone)
+			_, err = h.TakeSignup(ctx, &oidc.SignupCmd{Token: token})
+			require.ErrorIs(t, err, oidc.ErrSignupGone)
+
+			// Returned after the account couldn't be made, it works once more
+			require.NoError(t, h.ReturnSignup(ctx, &oidc.SignupCmd{Token: token}, taken))
+			_, err = h.TakeSignup(ctx, &oidc.SignupCmd{Token: token})
+			require.NoError(t, err)
+			_, err = h.TakeSignup(ctx, &oidc.SignupCmd{Token: token})
+			require.ErrorIs(t, err, oidc.ErrSignupGone)
+		})
+	}
+}
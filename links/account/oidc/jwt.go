@@ -0,0 +1,280 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+type (
+	// Claims are the parts of an ID token we look at
+	Claims struct {
+		Issuer            string   `json:"iss"`
+		Subject           string   `json:"sub"`
+		Audience          audience `json:"aud"`
+		AuthorizedParty   string   `json:"azp"`
+		ExpiresAt         int64    `json:"exp"`
+		IssuedAt          int64    `json:"iat"`
+		Nonce             string   `json:"nonce"`
+		Email             string   `json:"email"`
+		EmailVerified     boolish  `json:"email_verified"`
+		Name              string   `json:"name"`
+		PreferredUsername string   `json:"preferred_username"`
+	}
+
+	// audience is either a single string or a list of them
+	audience []string
+
+	// boolish is a bool some providers send as a string
+	boolish bool
+
+	jsonWebKey struct {
+		Kty string `json:"kty"`
+		Kid string `json:"kid"`
+		Use string `json:"use"`
+		Alg string `json:"alg"`
+		Crv string `json:"crv"`
+		N   string `json:"n"`
+		E   string `json:"e"`
+		X   string `json:"x"`
+		Y   string `json:"y"`
+	}
+
+	jwt struct {
+		alg     string
+		kid     string
+		payload []byte
+		// The header and payload as they were signed
+		signed    string
+		signature []byte
+	}
+)
+
+const (
+	algRS256 = "RS256"
+	algES256 = "ES256"
+
+	// ClockSkew is how far off the provider's clock can be from ours
+	ClockSkew = time.Minute
+
+	minRSABits = 2048
+)
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*a = audience{s}
+		return nil
+	}
+
+	var ss []string
+	if err := json.Unmarshal(b, &ss); err != nil {
+		return fmt.Errorf("failed to decode audience: %w", err)
+	}
+
+	*a = ss
+	return nil
+}
+
+func (a audience) contains(s string) bool {
+	for _, v := range a {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (v *boolish) UnmarshalJSON(b []byte) error {
+	switch string(b) {
+	case "true", `"true"`:
+		*v = true
+	default:
+		*v = false
+	}
+
+	return nil
+}
+
+// parseJWT splits a compact JWS without checking the signature
+func parseJWT(raw string) (*jwt, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("token is not a compact jws")
+	}
+
+	hb, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode token header: %w", err)
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := json.Unmarshal(hb, &header); err != nil {
+		return nil, fmt.Errorf("failed to decode token header: %w", err)
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode token payload: %w", err)
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode token signature: %w", err)
+	}
+
+	return &jwt{
+		alg:       header.Alg,
+		kid:       header.Kid,
+		payload:   payload,
+		signed:    parts[0] + "." + parts[1],
+		signature: sig,
+	}, nil
+}
+
+// verify checks the signature with key, which has to be of the kind the algorithm calls for
+func (t *jwt) verify(key crypto.PublicKey) error {
+	h := sha256.Sum256([]byte(t.signed))
+
+	switch t.alg {
+	case algRS256:
+		k, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return errors.New("token is RS256 but the key isn't RSA")
+		}
+
+		if err := rsa.VerifyPKCS1v15(k, crypto.SHA256, h[:], t.signature); err != nil {
+			return fmt.Errorf("failed to verify token signature: %w", err)
+		}
+
+		return nil
+	case algES256:
+		k, ok := key.(*ecdsa.PublicKey)
+		if !ok || k.Curve != elliptic.P256() {
+			return errors.New("token is ES256 but the key isn't P-256")
+		}
+
+		// JWS signatures are r and s back to back instead of ASN.1
+		if len(t.signature) != 64 {
+			return errors.New("token signature has the wrong length")
+		}
+
+		var (
+			r = new(big.Int).SetBytes(t.signature[:32])
+			s = new(big.Int).SetBytes(t.signature[32:])
+		)
+
+		if !ecdsa.Verify(k, h[:], r, s) {
+			return errors.New("failed to verify token signature")
+		}
+
+		return nil
+	}
+
+	return fmt.Errorf("token algorithm %q is not supported", t.alg)
+}
+
+// validate checks the claims OpenID Connect Core 3.1.3.7 asks for
+func (c *Claims) validate(issuer, clientID, nonce string, now time.Time) error {
+	if c.Issuer != issuer {
+		return fmt.Errorf("token issuer %q doesn't match %q", c.Issuer, issuer)
+	}
+
+	if c.Subject == "" {
+		return errors.New("token has no subject")
+	}
+
+	if !c.Audience.contains(clientID) {
+		return errors.New("token isn't for us")
+	}
+
+	if (len(c.Audience) > 1 || c.AuthorizedParty != "") && c.AuthorizedParty != clientID {
+		return errors.New("token was issued to another party")
+	}
+
+	if now.Add(-ClockSkew).Unix() >= c.ExpiresAt {
+		return errors.New("token is expired")
+	}
+
+	if now.Add(ClockSkew).Unix() < c.IssuedAt {
+		return errors.New("token is issued in the future")
+	}
+
+	if nonce == "" || c.Nonce != nonce {
+		return errors.New("token nonce doesn't match")
+	}
+
+	return nil
+}
+
+// publicKey returns the key if it's one we can verify ID tokens with
+func (k *jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	if k.Use != "" && k.Use != "sig" {
+		return nil, errors.New("key isn't for signatures")
+	}
+
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode modulus: %w", err)
+		}
+
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode exponent: %w", err)
+		}
+
+		if len(n)*8 < minRSABits || len(e) == 0 || len(e) > 4 {
+			return nil, errors.New("rsa key is too small or malformed")
+		}
+
+		var exp int
+		for _, c := range e {
+			exp = exp<<8 | int(c)
+		}
+
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: exp}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("curve %q is not supported", k.Crv)
+		}
+
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode x: %w", err)
+		}
+
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode y: %w", err)
+		}
+
+		pub := &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}
+
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, errors.New("ec key is not on the curve")
+		}
+
+		return pub, nil
+	}
+
+	return nil, fmt.Errorf("key type %q is not supported", k.Kty)
+}
@@ -0,0 +1,90 @@
+package oidc
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/derinil/links/links/generic"
+	"github.com/google/uuid"
+)
+
+/*
+	OpenID Connect:
+		- Providers are configured up front, anything that serves a discovery
+			document at /.well-known/openid-configuration works. The document
+			and the signing keys are fetched on first use and kept in memory,
+			keys are fetched again when a token is signed with one we haven't
+			seen. If the provider doesn't have it either, we don't ask again
+			for KeysRefreshInterval.
+		- Logins use the authorization code flow with PKCE. The state, nonce
+			and code verifier live in the cache for StateLifetime and the state
+			only works once. The web package also keeps the state in a cookie,
+			so a callback only works in the browser that started it.
+		- ID tokens come straight from the token endpoint over TLS, but their
+			signatures are still checked against the provider's keys. Only
+			RS256 and ES256 are accepted.
+		- An identity is the provider and the subject claim, emails and names
+			change, subjects don't. Identities are only ever linked to an
+			account on purpose, either from the account page while logged in
+			or by picking a handle for a brand new account. Emails are never
+			used to match accounts.
+		- Accounts created through a provider have no password, so the last
+			identity of such an account can't be disconnected.
+*/
+
+type (
+	// Identity is an account at a provider that can log in to one of our accounts
+	Identity struct {
+		generic.DBStruct
+		AccountID uuid.UUID `db:"account_id"`
+		Provider  string    `validate:"min=1,max=64" db:"provider"`
+		Subject   string    `validate:"min=1,max=255" db:"subject"`
+		// Whatever the provider said last time, only for showing on the account page
+		Email string `validate:"max=320" db:"email"`
+	}
+
+	ProviderConfig struct {
+		// Name is what goes in our URLs and the database, it mustn't change
+		Name string `json:"name"`
+		// DisplayName goes on the "Sign in with" button
+		DisplayName  string `json:"display_name"`
+		Issuer       string `json:"issuer"`
+		ClientID     string `json:"client_id"`
+		ClientSecret string `json:"client_secret"`
+		// Scopes are asked for on top of openid
+		Scopes []string `json:"scopes"`
+	}
+)
+
+func NewIdentity(accountID uuid.UUID, provider, subject, email string) *Identity {
+	return &Identity{
+		DBStruct:  generic.NewDBStruct(),
+		AccountID: accountID,
+		Provider:  provider,
+		Subject:   subject,
+		Email:     email,
+	}
+}
+
+func (i *Identity) Sanitize() {
+	i.Email = strings.TrimSpace(i.Email)
+}
+
+func (i *Identity) Validate() error {
+	if err := generic.Validator.Struct(i); err != nil {
+		return fmt.Errorf("failed to validate identity: %w", err)
+	}
+
+	return nil
+}
+
+func (i *Identity) BeforeSave() error {
+	i.Sanitize()
+	if err := i.Validate(); err != nil {
+		return fmt.Errorf("failed to validate identity: %w", err)
+	}
+
+	i.SetUpdatedAt()
+
+	return nil
+}
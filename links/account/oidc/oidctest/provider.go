@@ -0,0 +1,269 @@
+// Package oidctest runs an OpenID Connect provider in process for tests. It logs
+// everyone in as Subject without asking and signs ID tokens with a fresh RSA key.
+package oidctest
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+)
+
+type (
+	Provider struct {
+		*httptest.Server
+
+		ClientID     string
+		ClientSecret string
+
+		sync.Mutex
+		// Claims go in the next ID tokens on top of the ones the provider always sets
+		Claims map[string]any
+		// Modify can change the claims or header of ID tokens right before they're signed
+		Modify func(header, claims map[string]any)
+
+		key   *rsa.PrivateKey
+		kid   string
+		codes map[string]*grant
+	}
+
+	grant struct {
+		nonce       string
+		challenge   string
+		redirectURI string
+	}
+)
+
+const (
+	DefaultSubject = "248289761001"
+	keyBits        = 2048
+)
+
+// NewProvider starts a provider, call Close when done with it
+func NewProvider(clientID, clientSecret string) *Provider {
+	p := &Provider{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		Claims: map[string]any{
+			"sub":                DefaultSubject,
+			"email":              "jane@example.com",
+			"email_verified":     true,
+			"name":               "Jane Doe",
+			"preferred_username": "jane.doe",
+		},
+		codes: make(map[string]*grant),
+	}
+
+	p.RotateKey()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", p.discovery)
+	mux.HandleFunc("/authorize", p.authorize)
+	mux.HandleFunc("/token", p.token)
+	mux.HandleFunc("/jwks", p.jwks)
+
+	p.Server = httptest.NewServer(mux)
+
+	return p
+}
+
+// Issuer is the provider's URL, which is what goes in the config
+func (p *Provider) Issuer() string {
+	return p.URL
+}
+
+// RotateKey replaces the signing key, like providers do now and then
+func (p *Provider) RotateKey() {
+	k, err := rsa.GenerateKey(rand.Reader, keyBits)
+	if err != nil {
+		panic(err)
+	}
+
+	p.Lock()
+	defer p.Unlock()
+
+	p.key = k
+	p.kid = randomString()
+}
+
+// Authorize does what the browser would, it follows the URL from Begin and
+// returns the URL the provider redirects back to, which has the code and state
+func (p *Provider) Authorize(authURL string) (*url.URL, error) {
+	c := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	res, err := c.Get(authURL)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	return res.Location()
+}
+
+// IDToken signs a token with the current key, for tests that need one without going through the flow
+func (p *Provider) IDToken(nonce string) string {
+	p.Lock()
+	defer p.Unlock()
+
+	return p.idToken(nonce)
+}
+
+func (p *Provider) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"issuer":                                p.URL,
+		"authorization_endpoint":                p.URL + "/authorize",
+		"token_endpoint":                        p.URL + "/token",
+		"jwks_uri":                              p.URL + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (p *Provider) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	redirect, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil || q.Get("client_id") != p.ClientID || q.Get("response_type") != "code" ||
+		q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+		http.Error(w, "invalid_request", http.StatusBadRequest)
+		return
+	}
+
+	code := randomString()
+
+	p.Lock()
+	p.codes[code] = &grant{
+		nonce:       q.Get("nonce"),
+		challenge:   q.Get("code_challenge"),
+		redirectURI: q.Get("redirect_uri"),
+	}
+	p.Unlock()
+
+	v := redirect.Query()
+	v.Set("code", code)
+	v.Set("state", q.Get("state"))
+	redirect.RawQuery = v.Encode()
+
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (p *Provider) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil || r.PostForm.Get("grant_type") != "authorization_code" {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "invalid_request"})
+		return
+	}
+
+	id, secret, ok := r.BasicAuth()
+	if ok {
+		id, _ = url.QueryUnescape(id)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		id = r.PostForm.Get("client_id")
+	}
+
+	if id != p.ClientID || secret != p.ClientSecret {
+		writeJSON(w, http.StatusUnauthorized, map[string]any{"error": "invalid_client"})
+		return
+	}
+
+	p.Lock()
+	defer p.Unlock()
+
+	// Codes only work once
+	g, ok := p.codes[r.PostForm.Get("code")]
+	delete(p.codes, r.PostForm.Get("code"))
+
+	h := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !ok || g.redirectURI != r.PostForm.Get("redirect_uri") ||
+		base64.RawURLEncoding.EncodeToString(h[:]) != g.challenge {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "invalid_grant"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"access_token": randomString(),
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     p.idToken(g.nonce),
+	})
+}
+
+func (p *Provider) jwks(w http.ResponseWriter, r *http.Request) {
+	p.Lock()
+	defer p.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"keys": []map[string]any{{
+			"kty": "RSA",
+			"kid": p.kid,
+			"use": "sig",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(p.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(p.key.E)).Bytes()),
+		}},
+	})
+}
+
+// idToken has to be called with the lock held
+func (p *Provider) idToken(nonce string) string {
+	now := time.Now()
+
+	header := map[string]any{"alg": "RS256", "typ": "JWT", "kid": p.kid}
+	claims := map[string]any{
+		"iss":   p.URL,
+		"aud":   p.ClientID,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+		"nonce": nonce,
+	}
+
+	for k, v := range p.Claims {
+		claims[k] = v
+	}
+
+	if p.Modify != nil {
+		p.Modify(header, claims)
+	}
+
+	hb, _ := json.Marshal(header)
+	cb, _ := json.Marshal(claims)
+
+	signed := base64.RawURLEncoding.EncodeToString(hb) + "." + base64.RawURLEncoding.EncodeToString(cb)
+	h := sha256.Sum256([]byte(signed))
+
+	sig, err := rsa.SignPKCS1v15(rand.Reader, p.key, crypto.SHA256, h[:])
+	if err != nil {
+		panic(err)
+	}
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func randomString() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b)
+}
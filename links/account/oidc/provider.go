@@ -0,0 +1,269 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// Provider is what the login and account pages need to know about a provider
+	Provider struct {
+		Name        string
+		DisplayName string
+	}
+
+	provider struct {
+		config ProviderConfig
+		client *http.Client
+
+		sync.Mutex
+		metadata *metadata
+		keys     map[string]crypto.PublicKey
+		// When fetching the keys last failed to turn up a key ID
+		missedAt time.Time
+	}
+
+	// metadata is the part of the discovery document we use
+	metadata struct {
+		Issuer                string `json:"issuer"`
+		AuthorizationEndpoint string `json:"authorization_endpoint"`
+		TokenEndpoint         string `json:"token_endpoint"`
+		JWKSURI               string `json:"jwks_uri"`
+	}
+
+	tokenResponse struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+)
+
+const (
+	// KeysRefreshInterval is how long we wait to fetch keys again after a token
+	// came with a key ID the provider doesn't have, so made up IDs can't make
+	// us fetch keys all the time
+	KeysRefreshInterval = time.Minute
+	// Discovery documents, key sets and token responses are all small
+	maxResponseSize = 1 << 20
+)
+
+func newProvider(config ProviderConfig, client *http.Client) *provider {
+	config.Issuer = strings.TrimSuffix(config.Issuer, "/")
+	if config.DisplayName == "" {
+		config.DisplayName = config.Name
+	}
+
+	return &provider{config: config, client: client}
+}
+
+// discover fetches the discovery document the first time it's needed
+func (p *provider) discover(ctx context.Context) (*metadata, error) {
+	p.Lock()
+	defer p.Unlock()
+
+	if p.metadata != nil {
+		return p.metadata, nil
+	}
+
+	var m metadata
+	if err := p.getJSON(ctx, p.config.Issuer+"/.well-known/openid-configuration", &m); err != nil {
+		return nil, fmt.Errorf("failed to get discovery document: %w", err)
+	}
+
+	if strings.TrimSuffix(m.Issuer, "/") != p.config.Issuer {
+		return nil, fmt.Errorf("discovery document is for %q instead of %q", m.Issuer, p.config.Issuer)
+	}
+
+	if m.AuthorizationEndpoint == "" || m.TokenEndpoint == "" || m.JWKSURI == "" {
+		return nil, errors.New("discovery document is missing endpoints")
+	}
+
+	p.metadata = &m
+	return p.metadata, nil
+}
+
+// key returns the signing key with the ID, fetching the key set again if we don't know it
+func (p *provider) key(ctx context.Context, m *metadata, kid string) (crypto.PublicKey, error) {
+	p.Lock()
+	defer p.Unlock()
+
+	if k, ok := p.lookupKey(kid); ok {
+		return k, nil
+	}
+
+	if time.Since(p.missedAt) < KeysRefreshInterval {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := p.getJSON(ctx, m.JWKSURI, &set); err != nil {
+		return nil, fmt.Errorf("failed to get signing keys: %w", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for i := range set.Keys {
+		k, err := set.Keys[i].publicKey()
+		if err != nil {
+			log.Println("skipping signing key", p.config.Name, set.Keys[i].Kid, err)
+			continue
+		}
+		keys[set.Keys[i].Kid] = k
+	}
+
+	p.keys = keys
+
+	if k, ok := p.lookupKey(kid); ok {
+		return k, nil
+	}
+
+	p.missedAt = time.Now()
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// lookupKey has to be called with the lock held. Tokens without a key ID
+// are only accepted when the provider has a single key.
+func (p *provider) lookupKey(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(p.keys) == 1 {
+		for _, k := range p.keys {
+			return k, true
+		}
+	}
+
+	k, ok := p.keys[kid]
+	return k, ok
+}
+
+func (p *provider) authCodeURL(m *metadata, redirectURL, state, nonce, challenge string) string {
+	v := url.Values{}
+	v.Set("response_type", "code")
+	v.Set("client_id", p.config.ClientID)
+	v.Set("redirect_uri", redirectURL)
+	v.Set("scope", strings.Join(append([]string{"openid"}, p.config.Scopes...), " "))
+	v.Set("state", state)
+	v.Set("nonce", nonce)
+	v.Set("code_challenge", challenge)
+	v.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(m.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+
+	return m.AuthorizationEndpoint + sep + v.Encode()
+}
+
+// exchange trades the code for the raw ID token
+func (p *provider) exchange(ctx context.Context, m *metadata, redirectURL, code, verifier string) (string, error) {
+	v := url.Values{}
+	v.Set("grant_type", "authorization_code")
+	v.Set("code", code)
+	v.Set("redirect_uri", redirectURL)
+	v.Set("code_verifier", verifier)
+
+	// Public clients only have PKCE to go on
+	if p.config.ClientSecret == "" {
+		v.Set("client_id", p.config.ClientID)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.TokenEndpoint, strings.NewReader(v.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("failed to create token request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	if p.config.ClientSecret != "" {
+		// RFC 6749 2.3.1 wants both form encoded before they go in the header
+		req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+	}
+
+	res, err := p.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to request token: %w", err)
+	}
+	defer res.Body.Close()
+
+	var tr tokenResponse
+	if err := json.NewDecoder(io.LimitReader(res.Body, maxResponseSize)).Decode(&tr); err != nil {
+		return "", fmt.Errorf("failed to decode token response with status %d: %w", res.StatusCode, err)
+	}
+
+	if res.StatusCode != http.StatusOK || tr.Error != "" {
+		return "", fmt.Errorf("token endpoint returned %d: %s %s", res.StatusCode, tr.Error, tr.ErrorDescription)
+	}
+
+	if tr.IDToken == "" {
+		return "", errors.New("token response has no id token")
+	}
+
+	return tr.IDToken, nil
+}
+
+func (p *provider) verifyIDToken(ctx context.Context, m *metadata, raw, nonce string) (*Claims, error) {
+	t, err := parseJWT(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	if t.alg != algRS256 && t.alg != algES256 {
+		return nil, fmt.Errorf("token algorithm %q is not supported", t.alg)
+	}
+
+	k, err := p.key(ctx, m, t.kid)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := t.verify(k); err != nil {
+		return nil, err
+	}
+
+	var c Claims
+	if err := json.Unmarshal(t.payload, &c); err != nil {
+		return nil, fmt.Errorf("failed to decode token claims: %w", err)
+	}
+
+	if err := c.validate(m.Issuer, p.config.ClientID, nonce, time.Now()); err != nil {
+		return nil, err
+	}
+
+	return &c, nil
+}
+
+func (p *provider) getJSON(ctx context.Context, u string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Accept", "application/json")
+
+	res, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to do request: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %d", u, res.StatusCode)
+	}
+
+	if err := json.NewDecoder(io.LimitReader(res.Body, maxResponseSize)).Decode(v); err != nil {
+		return fmt.Errorf("failed to decode %s: %w", u, err)
+	}
+
+	return nil
+}
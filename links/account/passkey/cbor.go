@@ -0,0 +1,208 @@
+package passkey
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Just enough of RFC 8949 to read what authenticators send. Integers come out
+// as int64, byte strings as []byte, text as string, arrays as []any and maps
+// as map[any]any. Indefinite lengths are rejected since CTAP2 never uses them.
+
+const maxCBORDepth = 16
+
+var errCBORTruncated = errors.New("cbor data is truncated")
+
+// decodeCBOR decodes the first item in b and returns whatever comes after it
+func decodeCBOR(b []byte) (any, []byte, error) {
+	return decodeCBORItem(b, 0)
+}
+
+func decodeCBORItem(b []byte, depth int) (any, []byte, error) {
+	if depth > maxCBORDepth {
+		return nil, nil, errors.New("cbor data is nested too deep")
+	}
+
+	if len(b) == 0 {
+		return nil, nil, errCBORTruncated
+	}
+
+	var (
+		major = b[0] >> 5
+		info  = b[0] & 0x1f
+	)
+
+	// Floats keep their bits in the argument, everything else is a length or a value
+	if major == 7 {
+		return decodeCBORSimple(b)
+	}
+
+	arg, b, err := decodeCBORArgument(b)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if info == 31 {
+		return nil, nil, errors.New("indefinite length cbor items are not supported")
+	}
+
+	switch major {
+	case 0:
+		if arg > math.MaxInt64 {
+			return nil, nil, errors.New("cbor integer overflows int64")
+		}
+		return int64(arg), b, nil
+	case 1:
+		if arg > math.MaxInt64 {
+			return nil, nil, errors.New("cbor integer overflows int64")
+		}
+		return -1 - int64(arg), b, nil
+	case 2, 3:
+		if arg > uint64(len(b)) {
+			return nil, nil, errCBORTruncated
+		}
+		v := b[:arg]
+		if major == 3 {
+			return string(v), b[arg:], nil
+		}
+		return append([]byte(nil), v...), b[arg:], nil
+	case 4:
+		// Every item takes at least a byte, so this also bounds the allocation
+		if arg > uint64(len(b)) {
+			return nil, nil, errCBORTruncated
+		}
+		arr := make([]any, 0, arg)
+		for i := uint64(0); i < arg; i++ {
+			var v any
+			v, b, err = decodeCBORItem(b, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			arr = append(arr, v)
+		}
+		return arr, b, nil
+	case 5:
+		if arg > uint64(len(b)) {
+			return nil, nil, errCBORTruncated
+		}
+		m := make(map[any]any, arg)
+		for i := uint64(0); i < arg; i++ {
+			var k, v any
+			k, b, err = decodeCBORItem(b, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			switch k.(type) {
+			case int64, string:
+			default:
+				return nil, nil, fmt.Errorf("unsupported cbor map key type %T", k)
+			}
+
+			if _, ok := m[k]; ok {
+				return nil, nil, fmt.Errorf("duplicate cbor map key %v", k)
+			}
+
+			v, b, err = decodeCBORItem(b, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			m[k] = v
+		}
+		return m, b, nil
+	case 6:
+		// Tags don't change anything we care about
+		return decodeCBORItem(b, depth+1)
+	}
+
+	return nil, nil, fmt.Errorf("unknown cbor major type %d", major)
+}
+
+func decodeCBORArgument(b []byte) (uint64, []byte, error) {
+	info := b[0] & 0x1f
+	b = b[1:]
+
+	switch {
+	case info < 24:
+		return uint64(info), b, nil
+	case info == 24:
+		if len(b) < 1 {
+			return 0, nil, errCBORTruncated
+		}
+		return uint64(b[0]), b[1:], nil
+	case info == 25:
+		if len(b) < 2 {
+			return 0, nil, errCBORTruncated
+		}
+		return uint64(binary.BigEndian.Uint16(b)), b[2:], nil
+	case info == 26:
+		if len(b) < 4 {
+			return 0, nil, errCBORTruncated
+		}
+		return uint64(binary.BigEndian.Uint32(b)), b[4:], nil
+	case info == 27:
+		if len(b) < 8 {
+			return 0, nil, errCBORTruncated
+		}
+		return binary.BigEndian.Uint64(b), b[8:], nil
+	case info == 31:
+		return 0, b, nil
+	}
+
+	return 0, nil, fmt.Errorf("invalid cbor additional info %d", info)
+}
+
+func decodeCBORSimple(b []byte) (any, []byte, error) {
+	info := b[0] & 0x1f
+
+	switch info {
+	case 20:
+		return false, b[1:], nil
+	case 21:
+		return true, b[1:], nil
+	case 22, 23:
+		return nil, b[1:], nil
+	case 25, 26, 27:
+		arg, rest, err := decodeCBORArgument(b)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		switch info {
+		case 25:
+			return halfToFloat(uint16(arg)), rest, nil
+		case 26:
+			return float64(math.Float32frombits(uint32(arg))), rest, nil
+		default:
+			return math.Float64frombits(arg), rest, nil
+		}
+	}
+
+	return nil, nil, fmt.Errorf("unsupported cbor simple value %d", info)
+}
+
+func halfToFloat(h uint16) float64 {
+	var (
+		sign = 1.0
+		exp  = int(h>>10) & 0x1f
+		frac = float64(h & 0x3ff)
+	)
+
+	if h&0x8000 != 0 {
+		sign = -1
+	}
+
+	switch exp {
+	case 0:
+		return sign * math.Ldexp(frac, -24)
+	case 31:
+		if frac == 0 {
+			return sign * math.Inf(1)
+		}
+		return math.NaN()
+	}
+
+	return sign * math.Ldexp(frac+1024, exp-25)
+}
@@ -0,0 +1,133 @@
+package passkey
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// COSE algorithm identifiers, these are what we offer in pubKeyCredParams
+const (
+	AlgES256 = -7
+	AlgEdDSA = -8
+	AlgRS256 = -257
+)
+
+// Algorithms in order of preference
+var Algorithms = []int{AlgES256, AlgEdDSA, AlgRS256}
+
+// COSE key parameters from RFC 9053
+const (
+	coseKty = 1
+	coseAlg = 3
+	coseCrv = -1
+	coseX   = -2
+	coseY   = -3
+	coseN   = -1
+	coseE   = -2
+
+	ktyOKP = 1
+	ktyEC2 = 2
+	ktyRSA = 3
+
+	crvP256    = 1
+	crvEd25519 = 6
+
+	minRSABits = 2048
+)
+
+var errUnsupportedKey = errors.New("unsupported public key")
+
+type publicKey struct {
+	alg int
+	key crypto.PublicKey
+}
+
+// parsePublicKey parses a COSE_Key and makes sure we know how to verify its signatures
+func parsePublicKey(b []byte) (*publicKey, error) {
+	v, rest, err := decodeCBOR(b)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode public key: %w", err)
+	}
+
+	if len(rest) != 0 {
+		return nil, errors.New("public key has trailing data")
+	}
+
+	m, ok := v.(map[any]any)
+	if !ok {
+		return nil, errors.New("public key is not a map")
+	}
+
+	kty, _ := m[int64(coseKty)].(int64)
+	alg, _ := m[int64(coseAlg)].(int64)
+
+	switch {
+	case kty == ktyEC2 && alg == AlgES256:
+		crv, _ := m[int64(coseCrv)].(int64)
+		x, _ := m[int64(coseX)].([]byte)
+		y, _ := m[int64(coseY)].([]byte)
+
+		if crv != crvP256 || len(x) != 32 || len(y) != 32 {
+			return nil, errUnsupportedKey
+		}
+
+		pub := &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}
+
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, errors.New("public key is not on the curve")
+		}
+
+		return &publicKey{alg: AlgES256, key: pub}, nil
+	case kty == ktyOKP && alg == AlgEdDSA:
+		crv, _ := m[int64(coseCrv)].(int64)
+		x, _ := m[int64(coseX)].([]byte)
+
+		if crv != crvEd25519 || len(x) != ed25519.PublicKeySize {
+			return nil, errUnsupportedKey
+		}
+
+		return &publicKey{alg: AlgEdDSA, key: ed25519.PublicKey(x)}, nil
+	case kty == ktyRSA && alg == AlgRS256:
+		n, _ := m[int64(coseN)].([]byte)
+		e, _ := m[int64(coseE)].([]byte)
+
+		if len(n)*8 < minRSABits || len(e) == 0 || len(e) > 4 {
+			return nil, errUnsupportedKey
+		}
+
+		var exp int
+		for _, c := range e {
+			exp = exp<<8 | int(c)
+		}
+
+		return &publicKey{alg: AlgRS256, key: &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: exp}}, nil
+	}
+
+	return nil, errUnsupportedKey
+}
+
+func (k *publicKey) verify(data, sig []byte) bool {
+	switch key := k.key.(type) {
+	case *ecdsa.PublicKey:
+		h := sha256.Sum256(data)
+		return ecdsa.VerifyASN1(key, h[:], sig)
+	case ed25519.PublicKey:
+		return ed25519.Verify(key, data, sig)
+	case *rsa.PublicKey:
+		h := sha256.Sum256(data)
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, h[:], sig) == nil
+	}
+
+	return false
+}
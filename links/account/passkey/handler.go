@@ -0,0 +1,427 @@
+package passkey
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/derinil/links/links/cache"
+	"github.com/derinil/links/links/crypto"
+	"github.com/derinil/links/links/generic"
+	"github.com/google/uuid"
+)
+
+type (
+	Handler interface {
+		List(ctx context.Context, cmd *ListCmd) ([]Credential, error)
+		Rename(ctx context.Context, cmd *RenameCmd) error
+		Delete(ctx context.Context, cmd *DeleteCmd) error
+
+		BeginRegistration(ctx context.Context, cmd *BeginRegistrationCmd) (*CreationOptions, error)
+		FinishRegistration(ctx context.Context, cmd *FinishRegistrationCmd) (*Credential, error)
+		BeginLogin(ctx context.Context) (*RequestOptions, error)
+		// FinishLogin returns the credential that signed the challenge
+		FinishLogin(ctx context.Context, cmd *FinishLoginCmd) (*Credential, error)
+	}
+
+	HandlerImpl struct {
+		reader Reader
+		writer Writer
+		cache  cache.Cache
+		config Config
+	}
+
+	// Config is who we are to authenticators. Credentials are bound to the RP ID,
+	// so changing it later means everyone has to register their passkeys again.
+	Config struct {
+		RPID   string
+		RPName string
+		// Origin is the scheme, host and port the site is served from
+		Origin string
+	}
+
+	Reader interface {
+		ListCredentialsByAccountID(ctx context.Context, accountID uuid.UUID) ([]Credential, error)
+		// GetCredentialByCredentialID returns nil if there is no such credential
+		GetCredentialByCredentialID(ctx context.Context, credentialID []byte) (*Credential, error)
+	}
+
+	Writer interface {
+		SaveCredential(ctx context.Context, c *Credential) error
+		// RenameCredential and DeleteCredential report whether the account had a passkey with the ID
+		RenameCredential(ctx context.Context, accountID, id uuid.UUID, name string) (bool, error)
+		DeleteCredential(ctx context.Context, accountID, id uuid.UUID) (bool, error)
+		TouchCredential(ctx context.Context, id uuid.UUID, signCount int64, at time.Time) error
+	}
+
+	ListCmd struct {
+		AccountID uuid.UUID
+	}
+
+	RenameCmd struct {
+		AccountID uuid.UUID
+		ID        uuid.UUID
+		Name      string
+	}
+
+	DeleteCmd struct {
+		AccountID uuid.UUID
+		ID        uuid.UUID
+	}
+
+	BeginRegistrationCmd struct {
+		AccountID uuid.UUID
+		Handle    string
+		Name      string
+	}
+
+	FinishRegistrationCmd struct {
+		AccountID uuid.UUID
+		// What the user calls the passkey, like "Phone"
+		Name string
+		// The JSON encoded CreationResponse
+		Response []byte
+	}
+
+	FinishLoginCmd struct {
+		// The JSON encoded AssertionResponse
+		Response []byte
+	}
+
+	challenge struct {
+		Ceremony string
+		// Only set for registrations
+		AccountID uuid.UUID
+	}
+)
+
+const (
+	ChallengeLifetime = 5 * time.Minute
+	challengeLength   = 32
+)
+
+var (
+	ErrInvalidResponse = generic.NewWebError(http.StatusBadRequest, "passkey_response_invalid", "Passkey response is invalid")
+	ErrInvalidPasskey  = generic.NewWebError(http.StatusUnauthorized, "passkey_invalid", "Passkey isn't recognized")
+	ErrChallengeGone   = generic.NewWebError(http.StatusBadRequest, "passkey_challenge_expired", "Passkey request expired, try again")
+	ErrPasskeyNotFound = generic.NewWebError(http.StatusNotFound, "passkey_not_found", "Passkey not found")
+	ErrPasskeyExists   = generic.NewWebError(http.StatusBadRequest, "passkey_exists", "This passkey is already registered")
+)
+
+var _ Handler = (*HandlerImpl)(nil)
+
+func NewHandler(reader Reader, writer Writer, cache cache.Cache, config Config) *HandlerImpl {
+	return &HandlerImpl{
+		reader: reader,
+		writer: writer,
+		cache:  cache,
+		config: config,
+	}
+}
+
+func (s *HandlerImpl) List(ctx context.Context, cmd *ListCmd) ([]Credential, error) {
+	cs, err := s.reader.ListCredentialsByAccountID(ctx, cmd.AccountID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list passkeys: %w", err)
+	}
+
+	return cs, nil
+}
+
+func (s *HandlerImpl) Rename(ctx context.Context, cmd *RenameCmd) error {
+	c := &Credential{Name: cmd.Name}
+
+	c.Sanitize()
+	if err := generic.Validator.StructPartial(c, "Name"); err != nil {
+		return fmt.Errorf("failed to validate passkey: %w", err)
+	}
+
+	ok, err := s.writer.RenameCredential(ctx, cmd.AccountID, cmd.ID, c.Name)
+	if err != nil {
+		return fmt.Errorf("failed to rename passkey: %w", err)
+	}
+
+	if !ok {
+		return ErrPasskeyNotFound
+	}
+
+	return nil
+}
+
+func (s *HandlerImpl) Delete(ctx context.Context, cmd *DeleteCmd) error {
+	ok, err := s.writer.DeleteCredential(ctx, cmd.AccountID, cmd.ID)
+	if err != nil {
+		return fmt.Errorf("failed to delete passkey: %w", err)
+	}
+
+	if !ok {
+		return ErrPasskeyNotFound
+	}
+
+	return nil
+}
+
+func (s *HandlerImpl) BeginRegistration(ctx context.Context, cmd *BeginRegistrationCmd) (*CreationOptions, error) {
+	cs, err := s.List(ctx, &ListCmd{AccountID: cmd.AccountID})
+	if err != nil {
+		return nil, err
+	}
+
+	ch, err := s.newChallenge(ctx, &challenge{Ceremony: ceremonyCreate, AccountID: cmd.AccountID})
+	if err != nil {
+		return nil, err
+	}
+
+	// The user handle is what logins use to find the account, it mustn't be anything personal
+	id := cmd.AccountID
+
+	opts := &CreationOptions{
+		Challenge: ch,
+		RP: RelyingParty{
+			ID:   s.config.RPID,
+			Name: s.config.RPName,
+		},
+		User: User{
+			ID:          id[:],
+			Name:        cmd.Handle,
+			DisplayName: cmd.Name,
+		},
+		Timeout: ChallengeLifetime.Milliseconds(),
+		// Stops the same authenticator from being registered twice
+		ExcludeCredentials: make([]CredentialDescriptor, len(cs)),
+		AuthenticatorSelection: AuthenticatorSelection{
+			ResidentKey:        "required",
+			RequireResidentKey: true,
+			UserVerification:   "required",
+		},
+		Attestation: "none",
+	}
+
+	for _, alg := range Algorithms {
+		opts.PubKeyCredParams = append(opts.PubKeyCredParams, CredentialParameter{Type: credentialType, Alg: alg})
+	}
+
+	for i := range cs {
+		opts.ExcludeCredentials[i] = CredentialDescriptor{Type: credentialType, ID: cs[i].CredentialID}
+	}
+
+	return opts, nil
+}
+
+func (s *HandlerImpl) FinishRegistration(ctx context.Context, cmd *FinishRegistrationCmd) (*Credential, error) {
+	var res CreationResponse
+	if err := json.Unmarshal(cmd.Response, &res); err != nil || res.Type != credentialType {
+		return nil, ErrInvalidResponse
+	}
+
+	cd, err := verifyClientData(res.Response.ClientDataJSON, ceremonyCreate, s.config.Origin)
+	if err != nil {
+		log.Println("invalid passkey registration:", err)
+		return nil, ErrInvalidResponse
+	}
+
+	ch, err := s.useChallenge(ctx, cd.Challenge)
+	if err != nil {
+		return nil, err
+	}
+
+	if ch.Ceremony != ceremonyCreate || ch.AccountID != cmd.AccountID {
+		return nil, ErrChallengeGone
+	}
+
+	raw, err := parseAttestationObject(res.Response.AttestationObject)
+	if err != nil {
+		log.Println("invalid passkey registration:", err)
+		return nil, ErrInvalidResponse
+	}
+
+	ad, err := parseAuthenticatorData(raw)
+	if err != nil {
+		log.Println("invalid passkey registration:", err)
+		return nil, ErrInvalidResponse
+	}
+
+	if err := ad.verify(s.config.RPID); err != nil {
+		log.Println("invalid passkey registration:", err)
+		return nil, ErrInvalidResponse
+	}
+
+	if ad.credentialID == nil || !bytes.Equal(ad.credentialID, res.RawID) {
+		return nil, ErrInvalidResponse
+	}
+
+	if _, err := parsePublicKey(ad.publicKey); err != nil {
+		log.Println("invalid passkey registration:", err)
+		return nil, ErrInvalidResponse
+	}
+
+	existing, err := s.reader.GetCredentialByCredentialID(ctx, ad.credentialID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get passkey: %w", err)
+	}
+
+	if existing != nil {
+		return nil, ErrPasskeyExists
+	}
+
+	c := New(cmd.AccountID, cmd.Name, ad.credentialID, ad.publicKey, ad.signCount)
+
+	c.Sanitize()
+	if err := c.Validate(); err != nil {
+		return nil, fmt.Errorf("failed to validate passkey: %w", err)
+	}
+
+	if err := s.writer.SaveCredential(ctx, c); err != nil {
+		return nil, fmt.Errorf("failed to save passkey: %w", err)
+	}
+
+	return c, nil
+}
+
+func (s *HandlerImpl) BeginLogin(ctx context.Context) (*RequestOptions, error) {
+	ch, err := s.newChallenge(ctx, &challenge{Ceremony: ceremonyGet})
+	if err != nil {
+		return nil, err
+	}
+
+	return &RequestOptions{
+		Challenge:        ch,
+		Timeout:          ChallengeLifetime.Milliseconds(),
+		RPID:             s.config.RPID,
+		UserVerification: "required",
+	}, nil
+}
+
+func (s *HandlerImpl) FinishLogin(ctx context.Context, cmd *FinishLoginCmd) (*Credential, error) {
+	var res AssertionResponse
+	if err := json.Unmarshal(cmd.Response, &res); err != nil || res.Type != credentialType {
+		return nil, ErrInvalidResponse
+	}
+
+	cd, err := verifyClientData(res.Response.ClientDataJSON, ceremonyGet, s.config.Origin)
+	if err != nil {
+		log.Println("invalid passkey login:", err)
+		return nil, ErrInvalidResponse
+	}
+
+	ch, err := s.useChallenge(ctx, cd.Challenge)
+	if err != nil {
+		return nil, err
+	}
+
+	if ch.Ceremony != ceremonyGet {
+		return nil, ErrChallengeGone
+	}
+
+	c, err := s.reader.GetCredentialByCredentialID(ctx, res.RawID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get passkey: %w", err)
+	}
+
+	if c == nil {
+		return nil, ErrInvalidPasskey
+	}
+
+	if len(res.Response.UserHandle) > 0 && subtle.ConstantTimeCompare(res.Response.UserHandle, c.AccountID[:]) != 1 {
+		return nil, ErrInvalidPasskey
+	}
+
+	ad, err := parseAuthenticatorData(res.Response.AuthenticatorData)
+	if err != nil {
+		log.Println("invalid passkey login:", err)
+		return nil, ErrInvalidResponse
+	}
+
+	if err := ad.verify(s.config.RPID); err != nil {
+		log.Println("invalid passkey login:", err)
+		return nil, ErrInvalidResponse
+	}
+
+	pub, err := parsePublicKey(c.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse stored public key: %w", err)
+	}
+
+	h := hashClientData(res.Response.ClientDataJSON)
+	if !pub.verify(append(append([]byte(nil), res.Response.AuthenticatorData...), h...), res.Response.Signature) {
+		return nil, ErrInvalidPasskey
+	}
+
+	// Authenticators that count signatures only ever count up, going back means the
+	// key was copied somewhere. Synced passkeys always send 0, which is fine.
+	if (ad.signCount != 0 || c.SignCount != 0) && int64(ad.signCount) <= c.SignCount {
+		log.Println("passkey sign count went backwards", c.ID, c.SignCount, ad.signCount)
+		return nil, ErrInvalidPasskey
+	}
+
+	now := time.Now().UTC()
+	c.SignCount = int64(ad.signCount)
+	c.LastUsedAt = &now
+
+	if err := s.writer.TouchCredential(ctx, c.ID, c.SignCount, now); err != nil {
+		return nil, fmt.Errorf("failed to update passkey: %w", err)
+	}
+
+	return c, nil
+}
+
+func (s *HandlerImpl) newChallenge(ctx context.Context, ch *challenge) (Base64URL, error) {
+	b, err := crypto.ReadBytes(challengeLength)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read challenge: %w", err)
+	}
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(ch); err != nil {
+		return nil, fmt.Errorf("failed to encode challenge: %w", err)
+	}
+
+	key := challengeCacheKey(base64.RawURLEncoding.EncodeToString(b))
+	if err := s.cache.PutWithTTL(ctx, key, buf.Bytes(), ChallengeLifetime); err != nil {
+		return nil, fmt.Errorf("failed to cache challenge: %w", err)
+	}
+
+	return b, nil
+}
+
+// useChallenge looks up the challenge the client signed and deletes it, so it only works once
+func (s *HandlerImpl) useChallenge(ctx context.Context, encoded string) (*challenge, error) {
+	if len(encoded) == 0 || len(encoded) > 2*challengeLength {
+		return nil, ErrChallengeGone
+	}
+
+	key := challengeCacheKey(encoded)
+
+	b, err := s.cache.Get(ctx, key)
+	if err != nil || len(b) == 0 {
+		return nil, ErrChallengeGone
+	}
+
+	ok, err := s.cache.Invalidate(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to invalidate challenge: %w", err)
+	}
+
+	// Someone else used it first
+	if !ok {
+		return nil, ErrChallengeGone
+	}
+
+	var ch challenge
+	if err := gob.NewDecoder(bytes.NewReader(b)).Decode(&ch); err != nil {
+		return nil, fmt.Errorf("failed to decode challenge: %w", err)
+	}
+
+	return &ch, nil
+}
+
+func challengeCacheKey(encoded string) string {
+	return "passkey-challenge-" + encoded
+}
@@ -0,0 +1,712 @@
+package passkey_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/derinil/links/links/account/passkey"
+	"github.com/derinil/links/links/cache"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type (
+	MockReader struct{ mock.Mock }
+	MockWriter struct{ mock.Mock }
+
+	// authenticator is a software passkey that signs whatever the tests ask it to
+	authenticator struct {
+		credentialID []byte
+		ecdsa        *ecdsa.PrivateKey
+		ed25519      ed25519.PrivateKey
+		signCount    uint32
+	}
+
+	// ceremony is what ends up in the client and authenticator data, tests
+	// change it to act like a broken or malicious client
+	ceremony struct {
+		typ       string
+		origin    string
+		rpID      string
+		challenge []byte
+		flags     byte
+	}
+)
+
+const (
+	rpID   = "links.test"
+	origin = "https://links.test"
+
+	flagsUPUV = 0x01 | 0x04
+	flagAT    = 0x40
+)
+
+var config = passkey.Config{RPID: rpID, RPName: "Links", Origin: origin}
+
+func (m *MockReader) ListCredentialsByAccountID(ctx context.Context, accountID uuid.UUID) ([]passkey.Credential, error) {
+	args := m.Called(ctx, accountID)
+	return args.Get(0).([]passkey.Credential), args.Error(1)
+}
+
+func (m *MockReader) GetCredentialByCredentialID(ctx context.Context, credentialID []byte) (*passkey.Credential, error) {
+	args := m.Called(ctx, credentialID)
+	return args.Get(0).(*passkey.Credential), args.Error(1)
+}
+
+func (m *MockWriter) SaveCredential(ctx context.Context, c *passkey.Credential) error {
+	args := m.Called(ctx, c)
+	return args.Error(0)
+}
+
+func (m *MockWriter) RenameCredential(ctx context.Context, accountID, id uuid.UUID, name string) (bool, error) {
+	args := m.Called(ctx, accountID, id, name)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockWriter) DeleteCredential(ctx context.Context, accountID, id uuid.UUID) (bool, error) {
+	args := m.Called(ctx, accountID, id)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockWriter) TouchCredential(ctx context.Context, id uuid.UUID, signCount int64, at time.Time) error {
+	args := m.Called(ctx, id, signCount, at)
+	return args.Error(0)
+}
+
+func newHandler(reader *MockReader, writer *MockWriter) *passkey.HandlerImpl {
+	return passkey.NewHandler(reader, writer, cache.NewMemory(cache.MemoryConfig{}), config)
+}
+
+func newES256(t *testing.T) *authenticator {
+	k, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	return &authenticator{credentialID: randomBytes(t, 16), ecdsa: k}
+}
+
+func newEd25519(t *testing.T) *authenticator {
+	_, k, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	return &authenticator{credentialID: randomBytes(t, 16), ed25519: k}
+}
+
+func randomBytes(t *testing.T, n int) []byte {
+	b := make([]byte, n)
+	_, err := rand.Read(b)
+	require.NoError(t, err)
+	return b
+}
+
+// coseKey is the public key the way authenticators encode it
+func (a *authenticator) coseKey() []byte {
+	if a.ed25519 != nil {
+		return encodeCBOR(map[any]any{1: 1, 3: passkey.AlgEdDSA, -1: 6, -2: []byte(a.ed25519.Public().(ed25519.PublicKey))})
+	}
+
+	x, y := make([]byte, 32), make([]byte, 32)
+	a.ecdsa.X.FillBytes(x)
+	a.ecdsa.Y.FillBytes(y)
+
+	return encodeCBOR(map[any]any{1: 2, 3: passkey.AlgES256, -1: 1, -2: x, -3: y})
+}
+
+func (a *authenticator) sign(t *testing.T, data []byte) []byte {
+	if a.ed25519 != nil {
+		return ed25519.Sign(a.ed25519, data)
+	}
+
+	h := sha256.Sum256(data)
+	sig, err := ecdsa.SignASN1(rand.Reader, a.ecdsa, h[:])
+	require.NoError(t, err)
+	return sig
+}
+
+func (a *authenticator) authenticatorData(c *ceremony, attested bool) []byte {
+	var (
+		h     = sha256.Sum256([]byte(c.rpID))
+		flags = c.flags
+		b     = append([]byte(nil), h[:]...)
+	)
+
+	if attested {
+		flags |= flagAT
+	}
+
+	b = append(b, flags)
+	b = binary.BigEndian.AppendUint32(b, a.signCount)
+
+	if attested {
+		// An all zero aaguid, like most authenticators send without attestation
+		b = append(b, make([]byte, 16)...)
+		b = binary.BigEndian.AppendUint16(b, uint16(len(a.credentialID)))
+		b = append(b, a.credentialID...)
+		b = append(b, a.coseKey()...)
+	}
+
+	return b
+}
+
+func clientDataJSON(t *testing.T, c *ceremony) []byte {
+	b, err := json.Marshal(map[string]any{
+		"type":      c.typ,
+		"challenge": base64.RawURLEncoding.EncodeToString(c.challenge),
+		"origin":    c.origin,
+	})
+	require.NoError(t, err)
+	return b
+}
+
+// create answers navigator.credentials.create
+func (a *authenticator) create(t *testing.T, c *ceremony) []byte {
+	res := passkey.CreationResponse{
+		ID:    base64.RawURLEncoding.EncodeToString(a.credentialID),
+		RawID: a.credentialID,
+		Type:  "public-key",
+	}
+
+	res.Response.ClientDataJSON = clientDataJSON(t, c)
+	res.Response.AttestationObject = encodeCBOR(map[any]any{
+		"fmt":      "none",
+		"attStmt":  map[any]any{},
+		"authData": a.authenticatorData(c, true),
+	})
+
+	b, err := json.Marshal(&res)
+	require.NoError(t, err)
+	return b
+}
+
+// get answers navigator.credentials.get
+func (a *authenticator) get(t *testing.T, c *ceremony, userHandle []byte) []byte {
+	res := passkey.AssertionResponse{
+		ID:    base64.RawURLEncoding.EncodeToString(a.credentialID),
+		RawID: a.credentialID,
+		Type:  "public-key",
+	}
+
+	res.Response.ClientDataJSON = clientDataJSON(t, c)
+	res.Response.AuthenticatorData = a.authenticatorData(c, false)
+	res.Response.UserHandle = userHandle
+
+	h := sha256.Sum256(res.Response.ClientDataJSON)
+	res.Response.Signature = a.sign(t, append(append([]byte(nil), res.Response.AuthenticatorData...), h[:]...))
+
+	b, err := json.Marshal(&res)
+	require.NoError(t, err)
+	return b
+}
+
+// encodeCBOR only knows the types the fixtures use
+func encodeCBOR(v any) []byte {
+	head := func(major byte, n uint64) []byte {
+		switch {
+		case n < 24:
+			return []byte{major<<5 | byte(n)}
+		case n <= 0xff:
+			return []byte{major<<5 | 24, byte(n)}
+		case n <= 0xffff:
+			return binary.BigEndian.AppendUint16([]byte{major<<5 | 25}, uint16(n))
+		}
+		return binary.BigEndian.AppendUint32([]byte{major<<5 | 26}, uint32(n))
+	}
+
+	switch v := v.(type) {
+	case int:
+		if v < 0 {
+			return head(1, uint64(-1-v))
+		}
+		return head(0, uint64(v))
+	case []byte:
+		return append(head(2, uint64(len(v))), v...)
+	case string:
+		return append(head(3, uint64(len(v))), v...)
+	case map[any]any:
+		// Sorted by encoded key like CTAP2 canonical CBOR, so the same key always encodes the same
+		pairs := make([][2][]byte, 0, len(v))
+		for k, e := range v {
+			pairs = append(pairs, [2][]byte{encodeCBOR(k), encodeCBOR(e)})
+		}
+
+		sort.Slice(pairs, func(i, j int) bool {
+			if len(pairs[i][0]) != len(pairs[j][0]) {
+				return len(pairs[i][0]) < len(pairs[j][0])
+			}
+			return bytes.Compare(pairs[i][0], pairs[j][0]) < 0
+		})
+
+		b := head(5, uint64(len(v)))
+		for _, p := range pairs {
+			b = append(b, p[0]...)
+			b = append(b, p[1]...)
+		}
+		return b
+	}
+
+	panic("unsupported cbor type")
+}
+
+func TestFinishRegistration(t *testing.T) {
+	accountID := uuid.New()
+
+	testCases := []struct {
+		name   string
+		auth   *authenticator
+		modify func(c *ceremony)
+		// Ask for the challenge as this account instead
+		beginAs  uuid.UUID
+		existing *passkey.Credential
+		// Send the response a second time
+		replay bool
+		err    error
+	}{
+		{
+			name: "es256",
+			auth: newES256(t),
+		},
+		{
+			name: "ed25519",
+			auth: newEd25519(t),
+		},
+		{
+			name:   "replayed",
+			auth:   newES256(t),
+			replay: true,
+			err:    passkey.ErrChallengeGone,
+		},
+		{
+			name:   "wrong origin",
+			auth:   newES256(t),
+			modify: func(c *ceremony) { c.origin = "https://evil.test" },
+			err:    passkey.ErrInvalidResponse,
+		},
+		{
+			name:   "wrong type",
+			auth:   newES256(t),
+			modify: func(c *ceremony) { c.typ = "webauthn.get" },
+			err:    passkey.ErrInvalidResponse,
+		},
+		{
+			name:   "wrong rp id",
+			auth:   newES256(t),
+			modify: func(c *ceremony) { c.rpID = "evil.test" },
+			err:    passkey.ErrInvalidResponse,
+		},
+		{
+			name:   "user not verified",
+			auth:   newES256(t),
+			modify: func(c *ceremony) { c.flags = 0x01 },
+			err:    passkey.ErrInvalidResponse,
+		},
+		{
+			name:   "unknown challenge",
+			auth:   newES256(t),
+			modify: func(c *ceremony) { c.challenge = []byte("made up") },
+			err:    passkey.ErrChallengeGone,
+		},
+		{
+			name:    "challenge for another account",
+			auth:    newES256(t),
+			beginAs: uuid.New(),
+			err:     passkey.ErrChallengeGone,
+		},
+		{
+			name:     "already registered",
+			auth:     newES256(t),
+			existing: &passkey.Credential{AccountID: accountID},
+			err:      passkey.ErrPasskeyExists,
+		},
+	}
+
+	for _, c := range testCases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			var (
+				ctx    = context.Background()
+				reader = &MockReader{}
+				writer = &MockWriter{}
+				h      = newHandler(reader, writer)
+				begin  = accountID
+			)
+
+			if c.beginAs != uuid.Nil {
+				begin = c.beginAs
+			}
+
+			reader.On("ListCredentialsByAccountID", ctx, begin).Return([]passkey.Credential(nil), nil)
+			reader.On("GetCredentialByCredentialID", ctx, c.auth.credentialID).Return(c.existing, nil)
+			writer.On("SaveCredential", ctx, mock.Anything).Return(nil)
+
+			opts, err := h.BeginRegistration(ctx, &passkey.BeginRegistrationCmd{AccountID: begin, Handle: "links"})
+			require.NoError(t, err)
+			require.Equal(t, rpID, opts.RP.ID)
+			require.Equal(t, begin[:], []byte(opts.User.ID))
+
+			cer := &ceremony{typ: "webauthn.create", origin: origin, rpID: rpID, challenge: opts.Challenge, flags: flagsUPUV}
+			if c.modify != nil {
+				c.modify(cer)
+			}
+
+			res := c.auth.create(t, cer)
+
+			cred, err := h.FinishRegistration(ctx, &passkey.FinishRegistrationCmd{
+				AccountID: accountID,
+				Name:      " Phone ",
+				Response:  res,
+			})
+			if c.replay {
+				require.NoError(t, err)
+				_, err = h.FinishRegistration(ctx, &passkey.FinishRegistrationCmd{
+					AccountID: accountID,
+					Name:      "Phone",
+					Response:  res,
+				})
+			}
+			if c.err != nil || err != nil {
+				require.ErrorIs(t, err, c.err)
+				if !c.replay {
+					writer.AssertNotCalled(t, "SaveCredential", mock.Anything, mock.Anything)
+				}
+				return
+			}
+
+			require.Equal(t, accountID, cred.AccountID)
+			require.Equal(t, "Phone", cred.Name)
+			require.Equal(t, c.auth.credentialID, cred.CredentialID)
+			require.Equal(t, c.auth.coseKey(), cred.PublicKey)
+			writer.AssertCalled(t, "SaveCredential", ctx, cred)
+		})
+	}
+}
+
+func TestFinishRegistrationMalformed(t *testing.T) {
+	testCases := []struct {
+		name     string
+		response string
+	}{
+		{
+			name:     "not json",
+			response: "{",
+		},
+		{
+			name:     "wrong credential type",
+			response: `{"type":"password"}`,
+		},
+		{
+			name:     "bad base64",
+			response: `{"type":"public-key","rawId":"!!"}`,
+		},
+	}
+
+	for _, c := range testCases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			h := newHandler(&MockReader{}, &MockWriter{})
+
+			_, err := h.FinishRegistration(context.Background(), &passkey.FinishRegistrationCmd{
+				AccountID: uuid.New(),
+				Name:      "Phone",
+				Response:  []byte(c.response),
+			})
+			require.ErrorIs(t, err, passkey.ErrInvalidResponse)
+		})
+	}
+}
+
+func TestFinishRegistrationAttestationObject(t *testing.T) {
+	var (
+		ctx       = context.Background()
+		accountID = uuid.New()
+		a         = newES256(t)
+		reader    = &MockReader{}
+		h         = newHandler(reader, &MockWriter{})
+	)
+
+	reader.On("ListCredentialsByAccountID", ctx, accountID).Return([]passkey.Credential(nil), nil)
+
+	testCases := []struct {
+		name        string
+		attestation func(authData []byte) []byte
+	}{
+		{
+			name:        "not cbor",
+			attestation: func(authData []byte) []byte { return []byte{0xff} },
+		},
+		{
+			name:        "not a map",
+			attestation: func(authData []byte) []byte { return encodeCBOR(authData) },
+		},
+		{
+			name: "truncated authenticator data",
+			attestation: func(authData []byte) []byte {
+				return encodeCBOR(map[any]any{"fmt": "none", "authData": authData[:len(authData)-1]})
+			},
+		},
+		{
+			name: "trailing bytes",
+			attestation: func(authData []byte) []byte {
+				return encodeCBOR(map[any]any{"fmt": "none", "authData": append(authData, 0)})
+			},
+		},
+	}
+
+	for _, c := range testCases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			opts, err := h.BeginRegistration(ctx, &passkey.BeginRegistrationCmd{AccountID: accountID})
+			require.NoError(t, err)
+
+			cer := &ceremony{typ: "webauthn.create", origin: origin, rpID: rpID, challenge: opts.Challenge, flags: flagsUPUV}
+
+			res := passkey.CreationResponse{RawID: a.credentialID, Type: "public-key"}
+			res.Response.ClientDataJSON = clientDataJSON(t, cer)
+			res.Response.AttestationObject = c.attestation(a.authenticatorData(cer, true))
+
+			b, err := json.Marshal(&res)
+			require.NoError(t, err)
+
+			_, err = h.FinishRegistration(ctx, &passkey.FinishRegistrationCmd{
+				AccountID: accountID,
+				Name:      "Phone",
+				Response:  b,
+			})
+			require.ErrorIs(t, err, passkey.ErrInvalidResponse)
+		})
+	}
+}
+
+func TestFinishLogin(t *testing.T) {
+	var (
+		accountID = uuid.New()
+		otherID   = uuid.New()
+	)
+
+	testCases := []struct {
+		name string
+		auth *authenticator
+		// Another key signs instead of the registered one
+		signer     *authenticator
+		stored     int64
+		signCount  uint32
+		userHandle []byte
+		modify     func(c *ceremony)
+		unknown    bool
+		replay     bool
+		err        error
+	}{
+		{
+			name:       "es256",
+			auth:       newES256(t),
+			stored:     4,
+			signCount:  5,
+			userHandle: accountID[:],
+		},
+		{
+			name: "ed25519 without user handle",
+			auth: newEd25519(t),
+		},
+		{
+			name:   "replayed",
+			auth:   newES256(t),
+			replay: true,
+			err:    passkey.ErrChallengeGone,
+		},
+		{
+			name:    "unknown passkey",
+			auth:    newES256(t),
+			unknown: true,
+			err:     passkey.ErrInvalidPasskey,
+		},
+		{
+			name:   "signed by another key",
+			auth:   newES256(t),
+			signer: newES256(t),
+			err:    passkey.ErrInvalidPasskey,
+		},
+		{
+			name:      "sign count went backwards",
+			auth:      newES256(t),
+			stored:    5,
+			signCount: 5,
+			err:       passkey.ErrInvalidPasskey,
+		},
+		{
+			name:       "user handle of another account",
+			auth:       newES256(t),
+			userHandle: otherID[:],
+			err:        passkey.ErrInvalidPasskey,
+		},
+		{
+			name:   "wrong origin",
+			auth:   newES256(t),
+			modify: func(c *ceremony) { c.origin = "http://links.test" },
+			err:    passkey.ErrInvalidResponse,
+		},
+		{
+			name:   "user not verified",
+			auth:   newES256(t),
+			modify: func(c *ceremony) { c.flags = 0x01 },
+			err:    passkey.ErrInvalidResponse,
+		},
+		{
+			name:   "unknown challenge",
+			auth:   newES256(t),
+			modify: func(c *ceremony) { c.challenge = []byte("made up") },
+			err:    passkey.ErrChallengeGone,
+		},
+	}
+
+	for _, c := range testCases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			var (
+				ctx    = context.Background()
+				reader = &MockReader{}
+				writer = &MockWriter{}
+				h      = newHandler(reader, writer)
+				stored = &passkey.Credential{
+					AccountID:    accountID,
+					CredentialID: c.auth.credentialID,
+					PublicKey:    c.auth.coseKey(),
+					SignCount:    c.stored,
+				}
+				signer = c.auth
+			)
+
+			if c.unknown {
+				stored = nil
+			}
+
+			if c.signer != nil {
+				c.signer.credentialID = c.auth.credentialID
+				signer = c.signer
+			}
+
+			signer.signCount = c.signCount
+
+			reader.On("GetCredentialByCredentialID", ctx, c.auth.credentialID).Return(stored, nil)
+			writer.On("TouchCredential", ctx, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+
+			opts, err := h.BeginLogin(ctx)
+			require.NoError(t, err)
+			require.Equal(t, rpID, opts.RPID)
+
+			cer := &ceremony{typ: "webauthn.get", origin: origin, rpID: rpID, challenge: opts.Challenge, flags: flagsUPUV}
+			if c.modify != nil {
+				c.modify(cer)
+			}
+
+			res := signer.get(t, cer, c.userHandle)
+
+			cred, err := h.FinishLogin(ctx, &passkey.FinishLoginCmd{Response: res})
+			if c.replay {
+				require.NoError(t, err)
+				_, err = h.FinishLogin(ctx, &passkey.FinishLoginCmd{Response: res})
+			}
+			if c.err != nil || err != nil {
+				require.ErrorIs(t, err, c.err)
+				if !c.replay {
+					writer.AssertNotCalled(t, "TouchCredential", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+				}
+				return
+			}
+
+			require.Equal(t, accountID, cred.AccountID)
+			require.Equal(t, int64(c.signCount), cred.SignCount)
+			require.NotNil(t, cred.LastUsedAt)
+			writer.AssertCalled(t, "TouchCredential", ctx, cred.ID, int64(c.signCount), *cred.LastUsedAt)
+		})
+	}
+}
+
+func TestFinishLoginRegistrationChallenge(t *testing.T) {
+	var (
+		ctx       = context.Background()
+		accountID = uuid.New()
+		a         = newES256(t)
+		reader    = &MockReader{}
+		h         = newHandler(reader, &MockWriter{})
+	)
+
+	reader.On("ListCredentialsByAccountID", ctx, accountID).Return([]passkey.Credential(nil), nil)
+
+	// A challenge handed out for registration can't be used to log in
+	opts, err := h.BeginRegistration(ctx, &passkey.BeginRegistrationCmd{AccountID: accountID})
+	require.NoError(t, err)
+
+	res := a.get(t, &ceremony{typ: "webauthn.get", origin: origin, rpID: rpID, challenge: opts.Challenge, flags: flagsUPUV}, nil)
+
+	_, err = h.FinishLogin(ctx, &passkey.FinishLoginCmd{Response: res})
+	require.ErrorIs(t, err, passkey.ErrChallengeGone)
+}
+
+func TestRename(t *testing.T) {
+	var (
+		accountID = uuid.New()
+		id        = uuid.New()
+	)
+
+	testCases := []struct {
+		name   string
+		input  string
+		stored string
+		found  bool
+		err    error
+		errStr string
+	}{
+		{
+			name:   "trims",
+			input:  "  Laptop ",
+			stored: "Laptop",
+			found:  true,
+		},
+		{
+			name:   "not found",
+			input:  "Laptop",
+			stored: "Laptop",
+			err:    passkey.ErrPasskeyNotFound,
+		},
+		{
+			name:   "empty",
+			input:  "   ",
+			errStr: "failed to validate passkey",
+		},
+	}
+
+	for _, c := range testCases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			var (
+				ctx    = context.Background()
+				writer = &MockWriter{}
+				h      = newHandler(&MockReader{}, writer)
+			)
+
+			writer.On("RenameCredential", ctx, accountID, id, c.stored).Return(c.found, nil)
+
+			err := h.Rename(ctx, &passkey.RenameCmd{AccountID: accountID, ID: id, Name: c.input})
+			if c.err != nil || c.errStr != "" || err != nil {
+				if c.err != nil {
+					require.ErrorIs(t, err, c.err)
+				} else {
+					require.Contains(t, err.Error(), c.errStr)
+				}
+				return
+			}
+
+			writer.AssertCalled(t, "RenameCredential", ctx, accountID, id, c.stored)
+		})
+	}
+}
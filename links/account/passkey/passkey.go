@@ -0,0 +1,74 @@
+package passkey
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/derinil/links/links/generic"
+	"github.com/google/uuid"
+)
+
+/*
+	Passkeys:
+		- Registration and login are the WebAuthn create and get ceremonies.
+			The options go out as JSON, the browser hands them to the
+			authenticator and the response comes back in a form post.
+		- Challenges are random, kept in the cache for ChallengeLifetime and
+			deleted the first time they're used. Registration challenges are
+			tied to the account that asked for them.
+		- We ask for no attestation and ignore whatever statement comes along,
+			we only care that the same key signs in later, not who made it.
+		- Logins don't list any credentials, the authenticator picks a
+			discoverable one and tells us whose it is with the user handle.
+		- User verification is required both times, so a passkey is a factor
+			of its own plus a PIN or biometric and logging in with one skips
+			two factor authentication.
+		- Only ES256, EdDSA and RS256 keys are accepted, see cose.go. The
+			CBOR decoder in cbor.go only knows as much as WebAuthn needs.
+*/
+
+type Credential struct {
+	generic.DBStruct
+	AccountID    uuid.UUID `db:"account_id"`
+	Name         string    `validate:"min=1,max=64" db:"name"`
+	CredentialID []byte    `db:"credential_id"`
+	// COSE encoded, exactly as the authenticator sent it
+	PublicKey  []byte     `db:"public_key"`
+	SignCount  int64      `db:"sign_count"`
+	LastUsedAt *time.Time `db:"last_used_at"`
+}
+
+func New(accountID uuid.UUID, name string, credentialID, publicKey []byte, signCount uint32) *Credential {
+	return &Credential{
+		DBStruct:     generic.NewDBStruct(),
+		AccountID:    accountID,
+		Name:         name,
+		CredentialID: credentialID,
+		PublicKey:    publicKey,
+		SignCount:    int64(signCount),
+	}
+}
+
+func (c *Credential) Sanitize() {
+	c.Name = strings.TrimSpace(c.Name)
+}
+
+func (c *Credential) Validate() error {
+	if err := generic.Validator.Struct(c); err != nil {
+		return fmt.Errorf("failed to validate passkey: %w", err)
+	}
+
+	return nil
+}
+
+func (c *Credential) BeforeSave() error {
+	c.Sanitize()
+	if err := c.Validate(); err != nil {
+		return fmt.Errorf("failed to validate passkey: %w", err)
+	}
+
+	c.SetUpdatedAt()
+
+	return nil
+}
@@ -0,0 +1,269 @@
+package passkey
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Base64URL is how binary fields travel between the browser and us
+type Base64URL []byte
+
+type (
+	// CreationOptions is the publicKey member of navigator.credentials.create
+	CreationOptions struct {
+		Challenge              Base64URL              `json:"challenge"`
+		RP                     RelyingParty           `json:"rp"`
+		User                   User                   `json:"user"`
+		PubKeyCredParams       []CredentialParameter  `json:"pubKeyCredParams"`
+		Timeout                int64                  `json:"timeout"`
+		ExcludeCredentials     []CredentialDescriptor `json:"excludeCredentials"`
+		AuthenticatorSelection AuthenticatorSelection `json:"authenticatorSelection"`
+		Attestation            string                 `json:"attestation"`
+	}
+
+	// RequestOptions is the publicKey member of navigator.credentials.get. It has no
+	// allowCredentials, the authenticator tells us who it is with the user handle.
+	RequestOptions struct {
+		Challenge        Base64URL `json:"challenge"`
+		Timeout          int64     `json:"timeout"`
+		RPID             string    `json:"rpId"`
+		UserVerification string    `json:"userVerification"`
+	}
+
+	RelyingParty struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	}
+
+	User struct {
+		ID          Base64URL `json:"id"`
+		Name        string    `json:"name"`
+		DisplayName string    `json:"displayName"`
+	}
+
+	CredentialParameter struct {
+		Type string `json:"type"`
+		Alg  int    `json:"alg"`
+	}
+
+	CredentialDescriptor struct {
+		Type string    `json:"type"`
+		ID   Base64URL `json:"id"`
+	}
+
+	AuthenticatorSelection struct {
+		ResidentKey        string `json:"residentKey"`
+		RequireResidentKey bool   `json:"requireResidentKey"`
+		UserVerification   string `json:"userVerification"`
+	}
+
+	// CreationResponse is the PublicKeyCredential from navigator.credentials.create
+	CreationResponse struct {
+		ID       string    `json:"id"`
+		RawID    Base64URL `json:"rawId"`
+		Type     string    `json:"type"`
+		Response struct {
+			ClientDataJSON    Base64URL `json:"clientDataJSON"`
+			AttestationObject Base64URL `json:"attestationObject"`
+		} `json:"response"`
+	}
+
+	// AssertionResponse is the PublicKeyCredential from navigator.credentials.get
+	AssertionResponse struct {
+		ID       string    `json:"id"`
+		RawID    Base64URL `json:"rawId"`
+		Type     string    `json:"type"`
+		Response struct {
+			ClientDataJSON    Base64URL `json:"clientDataJSON"`
+			AuthenticatorData Base64URL `json:"authenticatorData"`
+			Signature         Base64URL `json:"signature"`
+			UserHandle        Base64URL `json:"userHandle"`
+		} `json:"response"`
+	}
+
+	clientData struct {
+		Type        string `json:"type"`
+		Challenge   string `json:"challenge"`
+		Origin      string `json:"origin"`
+		CrossOrigin bool   `json:"crossOrigin"`
+	}
+
+	authenticatorData struct {
+		rpIDHash  []byte
+		flags     byte
+		signCount uint32
+		// Only there when flagAttestedData is set
+		credentialID []byte
+		publicKey    []byte
+	}
+)
+
+const (
+	credentialType = "public-key"
+
+	ceremonyCreate = "webauthn.create"
+	ceremonyGet    = "webauthn.get"
+
+	flagUserPresent  = 0x01
+	flagUserVerified = 0x04
+	flagAttestedData = 0x40
+	flagExtensions   = 0x80
+
+	// WebAuthn caps credential IDs at this many bytes
+	maxCredentialIDLength = 1023
+)
+
+func (b Base64URL) MarshalJSON() ([]byte, error) {
+	return json.Marshal(base64.RawURLEncoding.EncodeToString(b))
+}
+
+func (b *Base64URL) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+
+	// Some browsers pad and some don't
+	v, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return fmt.Errorf("failed to decode base64url: %w", err)
+	}
+
+	*b = v
+	return nil
+}
+
+// verifyClientData checks everything in the client data except the challenge,
+// which the caller has to look up itself
+func verifyClientData(raw []byte, ceremony, origin string) (*clientData, error) {
+	var cd clientData
+	if err := json.Unmarshal(raw, &cd); err != nil {
+		return nil, fmt.Errorf("failed to decode client data: %w", err)
+	}
+
+	if cd.Type != ceremony {
+		return nil, fmt.Errorf("client data is for %q instead of %q", cd.Type, ceremony)
+	}
+
+	if cd.Origin != origin {
+		return nil, fmt.Errorf("client data origin %q doesn't match %q", cd.Origin, origin)
+	}
+
+	if cd.CrossOrigin {
+		return nil, errors.New("client data is cross origin")
+	}
+
+	return &cd, nil
+}
+
+func parseAuthenticatorData(b []byte) (*authenticatorData, error) {
+	// rpIdHash, flags and signCount
+	const headerLength = 32 + 1 + 4
+
+	if len(b) < headerLength {
+		return nil, errors.New("authenticator data is too short")
+	}
+
+	ad := &authenticatorData{
+		rpIDHash:  b[:32],
+		flags:     b[32],
+		signCount: binary.BigEndian.Uint32(b[33:37]),
+	}
+
+	rest := b[headerLength:]
+
+	if ad.flags&flagAttestedData != 0 {
+		// aaguid and the credential ID length
+		if len(rest) < 16+2 {
+			return nil, errors.New("attested credential data is too short")
+		}
+
+		n := int(binary.BigEndian.Uint16(rest[16:18]))
+		rest = rest[18:]
+
+		if n > maxCredentialIDLength || n > len(rest) {
+			return nil, errors.New("credential ID is too long")
+		}
+
+		ad.credentialID = rest[:n]
+		rest = rest[n:]
+
+		// The public key is a single CBOR item, anything after it is extensions
+		_, after, err := decodeCBOR(rest)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode credential public key: %w", err)
+		}
+
+		ad.publicKey = rest[:len(rest)-len(after)]
+		rest = after
+	}
+
+	if ad.flags&flagExtensions != 0 {
+		_, after, err := decodeCBOR(rest)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode extensions: %w", err)
+		}
+		rest = after
+	}
+
+	if len(rest) != 0 {
+		return nil, errors.New("authenticator data has trailing bytes")
+	}
+
+	return ad, nil
+}
+
+// verify checks the flags we insist on and that the data is for our RP ID
+func (ad *authenticatorData) verify(rpID string) error {
+	h := sha256.Sum256([]byte(rpID))
+	if !bytes.Equal(ad.rpIDHash, h[:]) {
+		return errors.New("authenticator data is for another relying party")
+	}
+
+	if ad.flags&flagUserPresent == 0 {
+		return errors.New("user wasn't present")
+	}
+
+	if ad.flags&flagUserVerified == 0 {
+		return errors.New("user wasn't verified")
+	}
+
+	return nil
+}
+
+// parseAttestationObject returns the authenticator data out of an attestation object.
+// We ask for no attestation, so whatever statement came along with it is ignored.
+func parseAttestationObject(b []byte) ([]byte, error) {
+	v, rest, err := decodeCBOR(b)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode attestation object: %w", err)
+	}
+
+	if len(rest) != 0 {
+		return nil, errors.New("attestation object has trailing data")
+	}
+
+	m, ok := v.(map[any]any)
+	if !ok {
+		return nil, errors.New("attestation object is not a map")
+	}
+
+	authData, ok := m["authData"].([]byte)
+	if !ok {
+		return nil, errors.New("attestation object has no authenticator data")
+	}
+
+	return authData, nil
+}
+
+// hashClientData is what assertion signatures cover along with the authenticator data
+func hashClientData(raw []byte) []byte {
+	h := sha256.Sum256(raw)
+	return h[:]
+}
@@ -0,0 +1,106 @@
+package account
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+type (
+	// Purger deletes accounts for good once their grace period is over
+	Purger struct {
+		deleter Deleter
+		config  PurgerConfig
+	}
+
+	// PurgerConfig is filled with the defaults where it's zero
+	PurgerConfig struct {
+		// How long a deletion can be cancelled by logging back in
+		GracePeriod time.Duration
+		// How often accounts are checked
+		Interval time.Duration
+	}
+
+	Deleter interface {
+		// DeleteAccounts returns the IDs and handles of the accounts it deleted
+		DeleteAccounts(ctx context.Context, cmd *DeleteAccountsCmd) ([]Account, error)
+	}
+
+	DeleteAccountsCmd struct {
+		RequestedBefore time.Time
+		Limit           int
+	}
+)
+
+const (
+	DefaultDeletionGracePeriod = 30 * 24 * time.Hour
+	DefaultPurgeInterval       = time.Hour
+
+	// Accounts are deleted in batches so one purge doesn't hold
+	// locks on a lot of rows at once
+	purgeBatchSize = 100
+	purgeTimeout   = time.Minute
+)
+
+func NewPurger(deleter Deleter, config PurgerConfig) *Purger {
+	if config.GracePeriod <= 0 {
+		config.GracePeriod = DefaultDeletionGracePeriod
+	}
+	if config.Interval <= 0 {
+		config.Interval = DefaultPurgeInterval
+	}
+
+	return &Purger{
+		deleter: deleter,
+		config:  config,
+	}
+}
+
+// Run purges once right away and then every interval until the context is cancelled
+func (s *Purger) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		pctx, cancel := context.WithTimeout(ctx, purgeTimeout)
+		n, err := s.Purge(pctx)
+		cancel()
+
+		if err != nil {
+			log.Println("failed to purge accounts", err)
+		} else if n > 0 {
+			log.Println("purged", n, "accounts")
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Purge deletes every account whose grace period is over and returns how many it deleted
+func (s *Purger) Purge(ctx context.Context) (int, error) {
+	var (
+		total  int
+		before = time.Now().UTC().Add(-s.config.GracePeriod)
+	)
+
+	for {
+		as, err := s.deleter.DeleteAccounts(ctx, &DeleteAccountsCmd{
+			RequestedBefore: before,
+			Limit:           purgeBatchSize,
+		})
+		if err != nil {
+			return total, fmt.Errorf("failed to delete accounts: %w", err)
+		}
+
+		total += len(as)
+
+		if len(as) < purgeBatchSize {
+			return total, nil
+		}
+	}
+}
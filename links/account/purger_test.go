@@ -0,0 +1,89 @@
+package account_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/derinil/links/links/account"
+	"github.com/stretchr/testify/require"
+)
+
+// FakeDeleter deletes from a list of accounts that asked to be deleted
+type FakeDeleter struct {
+	accounts []account.Account
+	calls    int
+	err      error
+}
+
+func (d *FakeDeleter) DeleteAccounts(ctx context.Context, cmd *account.DeleteAccountsCmd) ([]account.Account, error) {
+	d.calls++
+	if d.err != nil {
+		return nil, d.err
+	}
+
+	var deleted, kept []account.Account
+	for _, a := range d.accounts {
+		if a.DeletionRequestedAt.Before(cmd.RequestedBefore) && len(deleted) < cmd.Limit {
+			deleted = append(deleted, a)
+		} else {
+			kept = append(kept, a)
+		}
+	}
+
+	d.accounts = kept
+	return deleted, nil
+}
+
+func pendingSince(d time.Duration) account.Account {
+	at := time.Now().UTC().Add(-d)
+
+	a := account.New("name", "handle", "password")
+	a.DeletionRequestedAt = &at
+
+	return *a
+}
+
+func TestPurge(t *testing.T) {
+	ctx := context.Background()
+
+	deleter := &FakeDeleter{}
+	for i := 0; i < 250; i++ {
+		deleter.accounts = append(deleter.accounts, pendingSince(48*time.Hour))
+	}
+	deleter.accounts = append(deleter.accounts, pendingSince(time.Hour))
+
+	purger := account.NewPurger(deleter, account.PurgerConfig{GracePeriod: 24 * time.Hour})
+
+	// Batches of 100 until one comes back short
+	n, err := purger.Purge(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 250, n)
+	require.Equal(t, 3, deleter.calls)
+	require.Len(t, deleter.accounts, 1)
+
+	n, err = purger.Purge(ctx)
+	require.NoError(t, err)
+	require.Zero(t, n)
+
+	deleter.err = errors.New("haha")
+	_, err = purger.Purge(ctx)
+	require.ErrorIs(t, err, deleter.err)
+}
+
+func TestPurgerRun(t *testing.T) {
+	var (
+		ctx, cancel = context.WithCancel(context.Background())
+		deleter     = &FakeDeleter{accounts: []account.Account{pendingSince(48 * time.Hour)}}
+		purger      = account.NewPurger(deleter, account.PurgerConfig{GracePeriod: 24 * time.Hour})
+	)
+
+	// The first purge doesn't wait for the interval, so it
+	// happens even though Run returns right after it
+	cancel()
+	purger.Run(ctx)
+
+	require.Equal(t, 1, deleter.calls)
+	require.Empty(t, deleter.accounts)
+}
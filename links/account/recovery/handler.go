@@ -0,0 +1,404 @@
+package recovery
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/derinil/links/links/account"
+	"github.com/derinil/links/links/account/session"
+	"github.com/derinil/links/links/cache"
+	"github.com/derinil/links/links/crypto"
+	"github.com/derinil/links/links/generic"
+	"github.com/derinil/links/links/mail"
+	"github.com/google/uuid"
+)
+
+/*
+	Recovery:
+		- Emails are verified by mailing a link with a token in it. Only
+			verified emails can be used to reset a password, otherwise anyone
+			could add someone else's email to their account and wait.
+		- Tokens are random, only their Sha256 hashes are used as cache keys,
+			and they only work once. Verification tokens are also tied to the
+			email they were sent to, reset tokens to the password hash at the
+			time, so changing either kills the links that are still out there.
+		- Asking for a reset never says whether an account has the email, and
+			the mail goes out in the background so the time it takes doesn't
+			say either.
+		- Each account gets at most one mail of each kind per ResendInterval.
+		- Resetting the password logs the account out everywhere.
+*/
+
+type (
+	Handler interface {
+		// SendVerification mails a verification link to the account's email
+		SendVerification(ctx context.Context, cmd *SendVerificationCmd) error
+		Verify(ctx context.Context, cmd *VerifyCmd) (*account.Account, error)
+		// RequestReset mails a reset link in the background if an account has
+		// the email verified, and quietly does nothing otherwise
+		RequestReset(ctx context.Context, cmd *RequestResetCmd) error
+		// CheckReset is for showing the form, it doesn't use the token up
+		CheckReset(ctx context.Context, cmd *ResetCmd) error
+		Reset(ctx context.Context, cmd *ResetCmd) (*account.Account, error)
+	}
+
+	HandlerImpl struct {
+		cache          cache.Cache
+		accountHandler account.Handler
+		sessionHandler session.Handler
+		sender         mail.Sender
+		renderer       mail.Renderer
+		baseURL        string
+		// Reset mails still being sent, see Wait
+		pending sync.WaitGroup
+	}
+
+	Config struct {
+		// BaseURL is where links in mails point to, like https://links.example.com
+		BaseURL string
+	}
+
+	SendVerificationCmd struct {
+		AccountID uuid.UUID
+	}
+
+	VerifyCmd struct {
+		Token string
+	}
+
+	RequestResetCmd struct {
+		Email string
+	}
+
+	// Password is in plaintext, Reset hashes it
+	ResetCmd struct {
+		Token    string
+		Password string
+	}
+
+	// MailData is what the mail templates get
+	MailData struct {
+		Handle  string
+		Link    string
+		Expires string
+	}
+
+	// pendingToken is kept in the cache until the link is used
+	pendingToken struct {
+		AccountID uuid.UUID
+		// Email the verification was sent to
+		Email string
+		// Sha256 of the password hash the reset was asked with
+		Password []byte
+	}
+)
+
+const (
+	VerificationLifetime = 24 * time.Hour
+	ResetLifetime        = time.Hour
+	ResendInterval       = time.Minute
+	// How long a reset mail sent in the background can take
+	MailTimeout = 30 * time.Second
+
+	VerifyEmailMail   = "verify_email"
+	ResetPasswordMail = "reset_password"
+
+	tokenLength = 32
+)
+
+var (
+	ErrTokenInvalid    = generic.NewWebError(http.StatusBadRequest, "recovery_token_invalid", "This link expired or was already used")
+	ErrNoEmail         = generic.NewWebError(http.StatusBadRequest, "email_missing", "Add an email first")
+	ErrAlreadyVerified = generic.NewWebError(http.StatusBadRequest, "email_already_verified", "Email is already verified")
+	ErrTooSoon         = generic.NewWebError(http.StatusTooManyRequests, "email_too_soon", "An email was just sent, check your inbox or try again in a minute")
+	ErrPasswordEmpty   = generic.NewWebError(http.StatusBadRequest, "password_empty", "Password can't be empty")
+	ErrMailFailed      = generic.NewWebError(http.StatusBadGateway, "email_failed", "Couldn't send the email, try again later")
+)
+
+var _ Handler = (*HandlerImpl)(nil)
+
+func NewHandler(
+	cache cache.Cache,
+	accountHandler account.Handler,
+	sessionHandler session.Handler,
+	sender mail.Sender,
+	renderer mail.Renderer,
+	config Config,
+) *HandlerImpl {
+	return &HandlerImpl{
+		cache:          cache,
+		accountHandler: accountHandler,
+		sessionHandler: sessionHandler,
+		sender:         sender,
+		renderer:       renderer,
+		baseURL:        config.BaseURL,
+	}
+}
+
+func (s *HandlerImpl) SendVerification(ctx context.Context, cmd *SendVerificationCmd) error {
+	a, err := s.accountHandler.Get(ctx, &account.GetCmd{ID: cmd.AccountID, Shallow: true})
+	if err != nil {
+		return fmt.Errorf("failed to get account: %w", err)
+	}
+
+	if a.Email == "" {
+		return ErrNoEmail
+	}
+
+	if a.EmailVerified() {
+		return ErrAlreadyVerified
+	}
+
+	sent, err := s.throttle(ctx, VerifyEmailMail, a.ID)
+	if err != nil {
+		return err
+	}
+
+	if sent {
+		return ErrTooSoon
+	}
+
+	return s.send(ctx, a, VerifyEmailMail, "/email/verify", VerificationLifetime, &pendingToken{
+		AccountID: a.ID,
+		Email:     a.Email,
+	})
+}
+
+func (s *HandlerImpl) Verify(ctx context.Context, cmd *VerifyCmd) (*account.Account, error) {
+	var pt pendingToken
+	if err := s.take(ctx, tokenCacheKey(VerifyEmailMail, cmd.Token), &pt); err != nil {
+		return nil, err
+	}
+
+	a, err := s.accountHandler.VerifyEmail(ctx, &account.VerifyEmailCmd{
+		AccountID: pt.AccountID,
+		Email:     pt.Email,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify email: %w", err)
+	}
+
+	return a, nil
+}
+
+func (s *HandlerImpl) RequestReset(ctx context.Context, cmd *RequestResetCmd) error {
+	if cmd.Email == "" {
+		return nil
+	}
+
+	// The request is over before the mail is sent, so it can't use its context
+	email := cmd.Email
+	s.pending.Add(1)
+	go func() {
+		defer s.pending.Done()
+
+		ctx, cancel := context.WithTimeout(context.Background(), MailTimeout)
+		defer cancel()
+
+		if err := s.requestReset(ctx, email); err != nil {
+			log.Println("failed to request password reset", err)
+		}
+	}()
+
+	return nil
+}
+
+// Wait returns once the reset mails that are being sent are out
+func (s *HandlerImpl) Wait() {
+	s.pending.Wait()
+}
+
+func (s *HandlerImpl) requestReset(ctx context.Context, email string) error {
+	a, err := s.accountHandler.Get(ctx, &account.GetCmd{Email: email, Shallow: true})
+	if errors.Is(err, account.ErrAccountNotFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get account by email: %w", err)
+	}
+
+	sent, err := s.throttle(ctx, ResetPasswordMail, a.ID)
+	if err != nil {
+		return err
+	}
+
+	// Saying it's too soon would give away that the account exists
+	if sent {
+		return nil
+	}
+
+	return s.send(ctx, a, ResetPasswordMail, "/login/reset", ResetLifetime, &pendingToken{
+		AccountID: a.ID,
+		Password:  fingerprint(a.Password),
+	})
+}
+
+func (s *HandlerImpl) CheckReset(ctx context.Context, cmd *ResetCmd) error {
+	if cmd.Token == "" {
+		return ErrTokenInvalid
+	}
+
+	b, err := s.cache.Get(ctx, tokenCacheKey(ResetPasswordMail, cmd.Token))
+	if err != nil || len(b) == 0 {
+		return ErrTokenInvalid
+	}
+
+	return nil
+}
+
+func (s *HandlerImpl) Reset(ctx context.Context, cmd *ResetCmd) (*account.Account, error) {
+	if cmd.Password == "" {
+		return nil, ErrPasswordEmpty
+	}
+
+	var pt pendingToken
+	if err := s.take(ctx, tokenCacheKey(ResetPasswordMail, cmd.Token), &pt); err != nil {
+		return nil, err
+	}
+
+	a, err := s.accountHandler.Get(ctx, &account.GetCmd{ID: pt.AccountID, Shallow: true})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get account: %w", err)
+	}
+
+	// The password changed since the link was sent, most likely through another link
+	if !bytes.Equal(fingerprint(a.Password), pt.Password) {
+		return nil, ErrTokenInvalid
+	}
+
+	pw, err := crypto.HashPassword(cmd.Password)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	if err := s.accountHandler.UpdatePassword(ctx, &account.UpdatePasswordCmd{
+		AccountID: a.ID,
+		Password:  pw,
+	}); err != nil {
+		return nil, fmt.Errorf("failed to update password: %w", err)
+	}
+
+	// Whoever knew the old password might still be logged in
+	if err := s.sessionHandler.RevokeAll(ctx, a.ID, uuid.Nil); err != nil {
+		return nil, fmt.Errorf("failed to revoke sessions: %w", err)
+	}
+
+	a.Password = pw
+
+	return a, nil
+}
+
+// send stores the token and mails the link with it
+func (s *HandlerImpl) send(
+	ctx context.Context,
+	a *account.Account,
+	name, path string,
+	lifetime time.Duration,
+	pt *pendingToken,
+) error {
+	token, err := crypto.ReadHex(tokenLength)
+	if err != nil {
+		return fmt.Errorf("failed to read token: %w", err)
+	}
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(pt); err != nil {
+		return fmt.Errorf("failed to encode token: %w", err)
+	}
+
+	if err := s.cache.PutWithTTL(ctx, tokenCacheKey(name, token), buf.Bytes(), lifetime); err != nil {
+		return fmt.Errorf("failed to store token: %w", err)
+	}
+
+	m, err := s.renderer.Render(name, &MailData{
+		Handle:  a.Handle,
+		Link:    s.baseURL + path + "?" + url.Values{"token": {token}}.Encode(),
+		Expires: humanize(lifetime),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to render mail: %w", err)
+	}
+
+	m.To = a.Email
+
+	if err := s.sender.Send(ctx, m); err != nil {
+		// The details are for us, the user can only try again
+		log.Println("failed to send mail", name, err)
+		return ErrMailFailed
+	}
+
+	return nil
+}
+
+// throttle reports whether a mail of the kind went to the account within
+// ResendInterval, and counts this one if not
+func (s *HandlerImpl) throttle(ctx context.Context, name string, accountID uuid.UUID) (bool, error) {
+	key := "recovery-sent-" + name + "-" + accountID.String()
+
+	if b, err := s.cache.Get(ctx, key); err == nil && len(b) > 0 {
+		return true, nil
+	}
+
+	if err := s.cache.PutWithTTL(ctx, key, []byte{1}, ResendInterval); err != nil {
+		return false, fmt.Errorf("failed to store throttle: %w", err)
+	}
+
+	return false, nil
+}
+
+// take gets and deletes the token, only the first caller gets it
+func (s *HandlerImpl) take(ctx context.Context, key string, pt *pendingToken) error {
+	b, err := s.cache.Get(ctx, key)
+	if err != nil || len(b) == 0 {
+		return ErrTokenInvalid
+	}
+
+	ok, err := s.cache.Invalidate(ctx, key)
+	if err != nil {
+		return fmt.Errorf("failed to invalidate token: %w", err)
+	}
+
+	if !ok {
+		return ErrTokenInvalid
+	}
+
+	if err := gob.NewDecoder(bytes.NewReader(b)).Decode(pt); err != nil {
+		return fmt.Errorf("failed to decode token: %w", err)
+	}
+
+	return nil
+}
+
+// Tokens are secrets, so only their hashes go in cache keys
+func tokenCacheKey(name, token string) string {
+	h := sha256.Sum256([]byte(token))
+	return "recovery-" + name + "-" + hex.EncodeToString(h[:])
+}
+
+// fingerprint keeps password hashes out of the cache
+func fingerprint(password string) []byte {
+	h := sha256.Sum256([]byte(password))
+	return h[:]
+}
+
+func humanize(d time.Duration) string {
+	if h := int(d / time.Hour); h > 1 {
+		return fmt.Sprintf("%d hours", h)
+	}
+
+	if d == time.Hour {
+		return "an hour"
+	}
+
+	return fmt.Sprintf("%d minutes", int(d/time.Minute))
+}
@@ -0,0 +1,392 @@
+package recovery_test
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/derinil/links/links/account"
+	"github.com/derinil/links/links/account/recovery"
+	"github.com/derinil/links/links/account/session"
+	"github.com/derinil/links/links/cache"
+	"github.com/derinil/links/links/crypto"
+	"github.com/derinil/links/links/mail"
+	"github.com/derinil/links/links/views"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+type (
+	// FakeStore is an account reader and writer backed by a map
+	FakeStore struct {
+		sync.Mutex
+		accounts map[uuid.UUID]account.Account
+	}
+
+	// FakeSender keeps the mails instead of sending them
+	FakeSender struct {
+		sync.Mutex
+		sent []mail.Message
+		err  error
+		// Sends wait for this to close if it's set, like a slow SMTP server
+		wait chan struct{}
+	}
+)
+
+var tokenRegexp = regexp.MustCompile(`token=([0-9a-f]+)`)
+
+func (s *FakeStore) Get(ctx context.Context, cmd *account.GetCmd) (*account.Account, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	for _, a := range s.accounts {
+		if a.ID == cmd.ID || (cmd.Email != "" && a.EmailVerified() && strings.EqualFold(a.Email, cmd.Email)) {
+			return &a, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func (s *FakeStore) GetLink(ctx context.Context, cmd *account.GetLinkCmd) (*account.Link, error) {
+	return nil, nil
+}
+
+func (s *FakeStore) SaveAccount(ctx context.Context, a *account.Account) error {
+	s.Lock()
+	defer s.Unlock()
+
+	s.accounts[a.ID] = *a
+	return nil
+}
+
+func (s *FakeStore) get(id uuid.UUID) account.Account {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.accounts[id]
+}
+
+func (f *FakeSender) Send(ctx context.Context, m *mail.Message) error {
+	if f.wait != nil {
+		<-f.wait
+	}
+
+	f.Lock()
+	defer f.Unlock()
+
+	if f.err != nil {
+		return f.err
+	}
+
+	f.sent = append(f.sent, *m)
+	return nil
+}
+
+// last returns the last mail and the token in its link
+func (f *FakeSender) last(t *testing.T) (*mail.Message, string) {
+	f.Lock()
+	defer f.Unlock()
+
+	require.NotEmpty(t, f.sent)
+
+	m := f.sent[len(f.sent)-1]
+	match := tokenRegexp.FindStringSubmatch(m.Text)
+	require.Len(t, match, 2)
+	require.Contains(t, m.HTML, match[0])
+
+	return &m, match[1]
+}
+
+func (f *FakeSender) count() int {
+	f.Lock()
+	defer f.Unlock()
+
+	return len(f.sent)
+}
+
+func newHandler(accounts ...*account.Account) (*recovery.HandlerImpl, *FakeStore, *cache.Memory, *FakeSender) {
+	h, store, mem, sender, _ := newHandlerWithSessions(accounts...)
+	return h, store, mem, sender
+}
+
+func newHandlerWithSessions(accounts ...*account.Account) (*recovery.HandlerImpl, *FakeStore, *cache.Memory, *FakeSender, *session.HandlerImpl) {
+	var (
+		store  = &FakeStore{accounts: make(map[uuid.UUID]account.Account)}
+		mem    = cache.NewMemory(cache.MemoryConfig{})
+		sender = &FakeSender{}
+	)
+
+	for _, a := range accounts {
+		store.accounts[a.ID] = *a
+	}
+
+	sessions := session.NewHandler(mem, session.Config{})
+
+	h := recovery.NewHandler(
+		mem,
+		account.NewHandler(store, store, time.Hour),
+		sessions,
+		sender,
+		views.NewMailRenderer(),
+		recovery.Config{BaseURL: "https://links.test"},
+	)
+
+	return h, store, mem, sender, sessions
+}
+
+func newAccount(handle, email string, verified bool) *account.Account {
+	a := account.New(handle, handle, "")
+	a.Email = email
+
+	if verified {
+		now := time.Now()
+		a.EmailVerifiedAt = &now
+	}
+
+	return a
+}
+
+func TestVerification(t *testing.T) {
+	t.Run("verify", func(t *testing.T) {
+		var (
+			ctx                 = context.Background()
+			a                   = newAccount("jane", "jane@example.com", false)
+			h, store, _, sender = newHandler(a)
+			cmd                 = &recovery.SendVerificationCmd{AccountID: a.ID}
+		)
+
+		require.NoError(t, h.SendVerification(ctx, cmd))
+
+		m, token := sender.last(t)
+		require.Equal(t, "jane@example.com", m.To)
+		require.Equal(t, "Verify your email for Links", m.Subject)
+		require.Contains(t, m.Text, "https://links.test/email/verify?token=")
+
+		require.ErrorIs(t, h.SendVerification(ctx, cmd), recovery.ErrTooSoon)
+
+		va, err := h.Verify(ctx, &recovery.VerifyCmd{Token: token})
+		require.NoError(t, err)
+		require.True(t, va.EmailVerified())
+
+		sa := store.get(a.ID)
+		require.True(t, sa.EmailVerified())
+
+		_, err = h.Verify(ctx, &recovery.VerifyCmd{Token: token})
+		require.ErrorIs(t, err, recovery.ErrTokenInvalid)
+
+		require.ErrorIs(t, h.SendVerification(ctx, cmd), recovery.ErrAlreadyVerified)
+	})
+
+	t.Run("no email", func(t *testing.T) {
+		var (
+			a          = newAccount("jane", "", false)
+			h, _, _, _ = newHandler(a)
+		)
+
+		err := h.SendVerification(context.Background(), &recovery.SendVerificationCmd{AccountID: a.ID})
+		require.ErrorIs(t, err, recovery.ErrNoEmail)
+	})
+
+	t.Run("email changed after sending", func(t *testing.T) {
+		var (
+			ctx                 = context.Background()
+			a                   = newAccount("jane", "jane@example.com", false)
+			h, store, _, sender = newHandler(a)
+		)
+
+		require.NoError(t, h.SendVerification(ctx, &recovery.SendVerificationCmd{AccountID: a.ID}))
+		_, token := sender.last(t)
+
+		sa := store.get(a.ID)
+		sa.Email = "mallory@example.com"
+		require.NoError(t, store.SaveAccount(ctx, &sa))
+
+		_, err := h.Verify(ctx, &recovery.VerifyCmd{Token: token})
+		require.ErrorIs(t, err, account.ErrEmailChanged)
+
+		sa = store.get(a.ID)
+		require.False(t, sa.EmailVerified())
+	})
+
+	t.Run("verified by another account first", func(t *testing.T) {
+		var (
+			ctx             = context.Background()
+			a               = newAccount("jane", "jane@example.com", false)
+			other           = newAccount("mallory", "JANE@example.com", true)
+			h, _, _, sender = newHandler(a, other)
+		)
+
+		require.NoError(t, h.SendVerification(ctx, &recovery.SendVerificationCmd{AccountID: a.ID}))
+		_, token := sender.last(t)
+
+		_, err := h.Verify(ctx, &recovery.VerifyCmd{Token: token})
+		require.ErrorIs(t, err, account.ErrEmailTaken)
+	})
+
+	t.Run("sender fails", func(t *testing.T) {
+		var (
+			a               = newAccount("jane", "jane@example.com", false)
+			h, _, _, sender = newHandler(a)
+		)
+
+		sender.err = errors.New("connection refused")
+
+		err := h.SendVerification(context.Background(), &recovery.SendVerificationCmd{AccountID: a.ID})
+		require.ErrorIs(t, err, recovery.ErrMailFailed)
+	})
+}
+
+func TestReset(t *testing.T) {
+	oldHash, err := crypto.HashPassword("old password")
+	require.NoError(t, err)
+
+	verified := newAccount("jane", "jane@example.com", true)
+	verified.Password = oldHash
+
+	unverified := newAccount("john", "john@example.com", false)
+
+	testCases := []struct {
+		name  string
+		email string
+		// Whether a mail should go out
+		sent bool
+	}{
+		{
+			name:  "verified email",
+			email: "jane@example.com",
+			sent:  true,
+		},
+		{
+			name:  "different case",
+			email: "Jane@Example.com",
+			sent:  true,
+		},
+		{
+			name:  "unverified email",
+			email: "john@example.com",
+		},
+		{
+			name:  "unknown email",
+			email: "nobody@example.com",
+		},
+		{
+			name: "no email",
+		},
+	}
+
+	for _, c := range testCases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			var (
+				ctx                           = context.Background()
+				h, store, _, sender, sessions = newHandlerWithSessions(verified, unverified)
+			)
+
+			_, st, err := sessions.Issue(ctx, verified.ID, verified.Handle, false)
+			require.NoError(t, err)
+
+			require.NoError(t, h.RequestReset(ctx, &recovery.RequestResetCmd{Email: c.email}))
+			h.Wait()
+
+			if !c.sent {
+				require.Zero(t, sender.count())
+				return
+			}
+
+			m, token := sender.last(t)
+			require.Equal(t, "jane@example.com", m.To)
+			require.Contains(t, m.Text, "https://links.test/login/reset?token=")
+
+			require.NoError(t, h.CheckReset(ctx, &recovery.ResetCmd{Token: token}))
+
+			// An empty password doesn't use up the token
+			_, err = h.Reset(ctx, &recovery.ResetCmd{Token: token})
+			require.ErrorIs(t, err, recovery.ErrPasswordEmpty)
+
+			// Sessions from before the reset are logged out
+			_, err = sessions.Get(ctx, st)
+			require.NoError(t, err)
+
+			time.Sleep(time.Millisecond)
+
+			_, err = h.Reset(ctx, &recovery.ResetCmd{Token: token, Password: "new password"})
+			require.NoError(t, err)
+
+			_, err = sessions.Get(ctx, st)
+			require.ErrorIs(t, err, session.ErrSessionNotFound)
+
+			ok, err := crypto.ComparePassword("new password", "", store.get(verified.ID).Password)
+			require.NoError(t, err)
+			require.True(t, ok)
+
+			_, err = h.Reset(ctx, &recovery.ResetCmd{Token: token, Password: "newer password"})
+			require.ErrorIs(t, err, recovery.ErrTokenInvalid)
+			require.ErrorIs(t, h.CheckReset(ctx, &recovery.ResetCmd{Token: token}), recovery.ErrTokenInvalid)
+		})
+	}
+
+	t.Run("throttled", func(t *testing.T) {
+		var (
+			ctx             = context.Background()
+			h, _, _, sender = newHandler(verified)
+			cmd             = &recovery.RequestResetCmd{Email: "jane@example.com"}
+		)
+
+		require.NoError(t, h.RequestReset(ctx, cmd))
+		h.Wait()
+		// Quietly, so it doesn't give away that the account exists
+		require.NoError(t, h.RequestReset(ctx, cmd))
+		h.Wait()
+		require.Equal(t, 1, sender.count())
+	})
+
+	t.Run("slow mail", func(t *testing.T) {
+		var (
+			ctx             = context.Background()
+			h, _, _, sender = newHandler(verified)
+		)
+
+		// Answering only after the mail is out would say the account exists
+		sender.wait = make(chan struct{})
+		require.NoError(t, h.RequestReset(ctx, &recovery.RequestResetCmd{Email: "jane@example.com"}))
+		require.Zero(t, sender.count())
+
+		close(sender.wait)
+		h.Wait()
+		require.Equal(t, 1, sender.count())
+	})
+
+	t.Run("password changed since", func(t *testing.T) {
+		var (
+			ctx                   = context.Background()
+			h, store, mem, sender = newHandler(verified)
+			cmd                   = &recovery.RequestResetCmd{Email: "jane@example.com"}
+		)
+
+		require.NoError(t, h.RequestReset(ctx, cmd))
+		h.Wait()
+		_, first := sender.last(t)
+
+		_, err := mem.Invalidate(ctx, "recovery-sent-"+recovery.ResetPasswordMail+"-"+verified.ID.String())
+		require.NoError(t, err)
+
+		require.NoError(t, h.RequestReset(ctx, cmd))
+		h.Wait()
+		_, second := sender.last(t)
+
+		_, err = h.Reset(ctx, &recovery.ResetCmd{Token: second, Password: "new password"})
+		require.NoError(t, err)
+
+		_, err = h.Reset(ctx, &recovery.ResetCmd{Token: first, Password: "evil password"})
+		require.ErrorIs(t, err, recovery.ErrTokenInvalid)
+
+		ok, err := crypto.ComparePassword("new password", "", store.get(verified.ID).Password)
+		require.NoError(t, err)
+		require.True(t, ok)
+	})
+}
@@ -3,13 +3,15 @@ package session
 import (
 	"bytes"
 	"context"
-	"crypto/sha256"
 	"encoding/gob"
-	"encoding/hex"
+	"errors"
 	"fmt"
 	"net/http"
+	"sort"
+	"time"
 
 	"github.com/derinil/links/links/cache"
+	"github.com/derinil/links/links/crypto"
 	"github.com/derinil/links/links/generic"
 	"github.com/google/uuid"
 )
@@ -18,30 +20,99 @@ type (
 	Handler interface {
 		Destroy(ctx context.Context, token string) error
 		Get(ctx context.Context, token string) (*Session, error)
-		Issue(ctx context.Context, accountID uuid.UUID, handle string) (*Session, string, error)
+		// Issue records the client from the context if there is one,
+		// remembered sessions get the longer idle timeout
+		Issue(ctx context.Context, accountID uuid.UUID, handle string, remember bool) (*Session, string, error)
+		// Touch records that the session was just used by the client and
+		// extends it once it's past the refresh threshold
+		Touch(ctx context.Context, token string, se *Session, client *Client) error
+		// List returns the account's sessions, most recently used first
+		List(ctx context.Context, accountID uuid.UUID) ([]Session, error)
+		Revoke(ctx context.Context, accountID, id uuid.UUID) error
+		// RevokeAll ends every session of the account except the one with
+		// the ID except, which can be uuid.Nil to end all of them
+		RevokeAll(ctx context.Context, accountID, except uuid.UUID) error
 	}
 
 	HandlerImpl struct {
-		cache cache.Cache
+		cache  cache.Cache
+		config Config
+	}
+
+	// Config is filled with the defaults where it's zero
+	Config struct {
+		// Sessions that go unused for this long expire
+		IdleTimeout time.Duration
+		// IdleTimeout for sessions that were logged in with "remember me"
+		RememberIdleTimeout time.Duration
+		// No session lives longer than this, however much it's used
+		MaxLifetime time.Duration
 	}
 
 	CtxKey string
+
+	// indexEntry points from the account's index to one of its sessions
+	indexEntry struct {
+		ID    uuid.UUID
+		Token string
+		// The session's MaxExpiresAt, it might expire sooner if it's left idle
+		ExpiresAt time.Time
+	}
+
+	// revocation ends every session issued before Before, other than Except
+	revocation struct {
+		Before time.Time
+		Except uuid.UUID
+	}
 )
 
+/*
+	Sessions:
+		- A session lives under its token, and every account has an index of
+			its sessions so they can be listed and revoked one by one.
+		- The cache can't update the index atomically, so two logins at the
+			same time might lose an entry. Revoking all sessions doesn't rely
+			on the index for that reason: it also stores a revocation, and Get
+			turns down sessions that were issued before it.
+		- LastSeenAt is written back at most once per LastSeenInterval.
+		- Sessions expire after the idle timeout, and using a session that is
+			past half of it extends it by the idle timeout again, up until
+			MaxExpiresAt. The index and revocations live for MaxLifetime so
+			they outlast every session they cover.
+*/
+
 const (
 	CookieName string = "session"
+
+	tokenLength = 32
 )
 
+// errNotCached covers cache errors as well, the cache doesn't tell them apart from misses
+var errNotCached = errors.New("not cached")
+
 var (
 	ErrInvalidToken     = generic.NewWebError(http.StatusBadRequest, "token_invalid", "Session token is invalid")
 	ErrSessionNotFound  = generic.NewWebError(http.StatusUnauthorized, "session_not_found", "Session is invalid")
 	ErrNotAuthenticated = generic.NewWebError(http.StatusUnauthorized, "not_authorized", "You are not logged in")
+	ErrUnknownSession   = generic.NewWebError(http.StatusNotFound, "session_unknown", "Session not found")
 )
 
 var _ Handler = (*HandlerImpl)(nil)
 
-func NewHandler(cache cache.Cache) *HandlerImpl {
-	return &HandlerImpl{cache: cache}
+func NewHandler(cache cache.Cache, config Config) *HandlerImpl {
+	if config.IdleTimeout <= 0 {
+		config.IdleTimeout = DefaultIdleTimeout
+	}
+
+	if config.RememberIdleTimeout <= 0 {
+		config.RememberIdleTimeout = DefaultRememberIdleTimeout
+	}
+
+	if config.MaxLifetime <= 0 {
+		config.MaxLifetime = DefaultMaxLifetime
+	}
+
+	return &HandlerImpl{cache: cache, config: config}
 }
 
 func (s *HandlerImpl) Get(ctx context.Context, token string) (*Session, error) {
@@ -63,6 +134,22 @@ func (s *HandlerImpl) Get(ctx context.Context, token string) (*Session, error) {
 		return nil, fmt.Errorf("failed to decode session: %w", err)
 	}
 
+	// The cache should have dropped it already, but its TTL isn't exact
+	if !time.Now().Before(se.ExpiresAt) {
+		return nil, ErrSessionNotFound
+	}
+
+	var rev revocation
+	if err := s.get(ctx, revocationKey(se.AccountID), &rev); err == nil &&
+		se.InsertedAt.Before(rev.Before) && se.ID != rev.Except {
+		return nil, ErrSessionNotFound
+	}
+
+	// A Touch that raced the logout might have written the session back
+	if b, err := s.cache.Get(ctx, endedKey(token)); err == nil && len(b) != 0 {
+		return nil, ErrSessionNotFound
+	}
+
 	return &se, nil
 }
 
@@ -71,45 +158,272 @@ func (s *HandlerImpl) Destroy(ctx context.Context, token string) error {
 		return ErrInvalidToken
 	}
 
-	_, err := s.cache.Invalidate(ctx, cacheKey(token))
-	if err != nil {
-		return fmt.Errorf("failed to invalidate session: %w", err)
-	}
-
-	return nil
+	return s.end(ctx, token)
 }
 
-func (s *HandlerImpl) Issue(ctx context.Context, accountID uuid.UUID, handle string) (*Session, string, error) {
+func (s *HandlerImpl) Issue(ctx context.Context, accountID uuid.UUID, handle string, remember bool) (*Session, string, error) {
 	se := New(accountID, handle)
+	se.Remember = remember
+	se.MaxExpiresAt = se.InsertedAt.Add(s.config.MaxLifetime)
+	se.ExpiresAt = s.expiry(se, se.InsertedAt)
+
+	if c, ok := ctx.Value(ClientKey).(*Client); ok {
+		se.UserAgent = c.UserAgent
+		se.IP = c.IP
+	}
 
-	t, err := s.createToken(se.ID[:])
+	t, err := s.createToken()
 	if err != nil {
 		return nil, "", fmt.Errorf("failed to create session token: %w", err)
 	}
 
-	var b bytes.Buffer
-	if err := gob.NewEncoder(&b).Encode(se); err != nil {
-		return nil, "", fmt.Errorf("failed to encode session: %w", err)
+	if err = s.put(ctx, cacheKey(t), se, time.Until(se.ExpiresAt)); err != nil {
+		return nil, "", fmt.Errorf("failed to cache session: %w", err)
 	}
 
-	if err = s.cache.PutWithTTL(ctx, cacheKey(t), b.Bytes(), Lifetime); err != nil {
-		return nil, "", fmt.Errorf("failed to cache session: %w", err)
+	entries, err := s.index(ctx, accountID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	entries = append(entries, indexEntry{ID: se.ID, Token: t, ExpiresAt: se.MaxExpiresAt})
+
+	if err := s.put(ctx, indexKey(accountID), entries, s.config.MaxLifetime); err != nil {
+		return nil, "", fmt.Errorf("failed to save session index: %w", err)
 	}
 
 	return se, t, nil
 }
 
-func (s *HandlerImpl) createToken(id []byte) (string, error) {
-	h := sha256.New()
+func (s *HandlerImpl) Touch(ctx context.Context, token string, se *Session, client *Client) error {
+	var (
+		now = time.Now().UTC()
+		// Extending on every request would mean a write on every request,
+		// so it waits until half of the idle timeout is used up
+		renew = se.ExpiresAt.Sub(now) < s.idleTimeout(se)/2
+	)
+
+	if !renew && now.Sub(se.LastSeenAt) < LastSeenInterval {
+		return nil
+	}
+
+	if renew {
+		se.ExpiresAt = s.expiry(se, now)
+	}
+
+	se.LastSeenAt = now
+	if client != nil {
+		se.UserAgent = client.UserAgent
+		se.IP = client.IP
+	}
+
+	ttl := se.ExpiresAt.Sub(now)
+	if ttl <= 0 {
+		return nil
+	}
+
+	if err := s.put(ctx, cacheKey(token), se, ttl); err != nil {
+		return fmt.Errorf("failed to cache session: %w", err)
+	}
+
+	return nil
+}
+
+func (s *HandlerImpl) List(ctx context.Context, accountID uuid.UUID) ([]Session, error) {
+	entries, err := s.index(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		ses  = make([]Session, 0, len(entries))
+		live = make([]indexEntry, 0, len(entries))
+	)
+
+	for _, e := range entries {
+		se, err := s.Get(ctx, e.Token)
+		if err != nil {
+			continue
+		}
+
+		ses = append(ses, *se)
+		live = append(live, e)
+	}
+
+	// Logged out and revoked sessions are dropped from the index on the way
+	if len(live) != len(entries) {
+		if err := s.put(ctx, indexKey(accountID), live, s.config.MaxLifetime); err != nil {
+			return nil, fmt.Errorf("failed to save session index: %w", err)
+		}
+	}
+
+	sort.Slice(ses, func(i, j int) bool {
+		return ses[i].LastSeenAt.After(ses[j].LastSeenAt)
+	})
+
+	return ses, nil
+}
+
+func (s *HandlerImpl) Revoke(ctx context.Context, accountID, id uuid.UUID) error {
+	entries, err := s.index(ctx, accountID)
+	if err != nil {
+		return err
+	}
+
+	for i, e := range entries {
+		if e.ID != id {
+			continue
+		}
+
+		if err := s.end(ctx, e.Token); err != nil {
+			return err
+		}
+
+		entries = append(entries[:i], entries[i+1:]...)
+		if err := s.put(ctx, indexKey(accountID), entries, s.config.MaxLifetime); err != nil {
+			return fmt.Errorf("failed to save session index: %w", err)
+		}
+
+		return nil
+	}
+
+	return ErrUnknownSession
+}
+
+func (s *HandlerImpl) RevokeAll(ctx context.Context, accountID, except uuid.UUID) error {
+	rev := &revocation{Before: time.Now().UTC(), Except: except}
+	if err := s.put(ctx, revocationKey(accountID), rev, s.config.MaxLifetime); err != nil {
+		return fmt.Errorf("failed to save revocation: %w", err)
+	}
 
-	_, err := h.Write(id)
+	entries, err := s.index(ctx, accountID)
 	if err != nil {
-		return "", fmt.Errorf("failed to write session id: %w", err)
+		return err
+	}
+
+	kept := make([]indexEntry, 0, 1)
+	for _, e := range entries {
+		if e.ID == except {
+			kept = append(kept, e)
+			continue
+		}
+
+		if _, err := s.cache.Invalidate(ctx, cacheKey(e.Token)); err != nil {
+			return fmt.Errorf("failed to invalidate session: %w", err)
+		}
+	}
+
+	if err := s.put(ctx, indexKey(accountID), kept, s.config.MaxLifetime); err != nil {
+		return fmt.Errorf("failed to save session index: %w", err)
+	}
+
+	return nil
+}
+
+// end leaves a marker that Get checks before dropping the session, a Touch
+// that already read the session would write it back otherwise
+func (s *HandlerImpl) end(ctx context.Context, token string) error {
+	if err := s.cache.PutWithTTL(ctx, endedKey(token), []byte{1}, s.config.MaxLifetime); err != nil {
+		return fmt.Errorf("failed to mark session as ended: %w", err)
+	}
+
+	if _, err := s.cache.Invalidate(ctx, cacheKey(token)); err != nil {
+		return fmt.Errorf("failed to invalidate session: %w", err)
+	}
+
+	return nil
+}
+
+func (s *HandlerImpl) idleTimeout(se *Session) time.Duration {
+	if se.Remember {
+		return s.config.RememberIdleTimeout
+	}
+
+	return s.config.IdleTimeout
+}
+
+// expiry is when the session expires if it's left idle from now on
+func (s *HandlerImpl) expiry(se *Session, now time.Time) time.Time {
+	exp := now.Add(s.idleTimeout(se))
+
+	// Sessions from before MaxExpiresAt existed can't be extended
+	if se.MaxExpiresAt.IsZero() {
+		return se.ExpiresAt
+	}
+
+	if exp.After(se.MaxExpiresAt) {
+		return se.MaxExpiresAt
+	}
+
+	return exp
+}
+
+// index returns the account's index without the entries that expired
+func (s *HandlerImpl) index(ctx context.Context, accountID uuid.UUID) ([]indexEntry, error) {
+	var entries []indexEntry
+	if err := s.get(ctx, indexKey(accountID), &entries); err != nil {
+		if errors.Is(err, errNotCached) {
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("failed to get session index: %w", err)
+	}
+
+	var (
+		now  = time.Now()
+		live = entries[:0]
+	)
+
+	for _, e := range entries {
+		if e.ExpiresAt.After(now) {
+			live = append(live, e)
+		}
+	}
+
+	return live, nil
+}
+
+func (s *HandlerImpl) put(ctx context.Context, key string, v any, ttl time.Duration) error {
+	var b bytes.Buffer
+	if err := gob.NewEncoder(&b).Encode(v); err != nil {
+		return fmt.Errorf("failed to encode: %w", err)
+	}
+
+	return s.cache.PutWithTTL(ctx, key, b.Bytes(), ttl)
+}
+
+// get returns errNotCached when there is nothing under the key
+func (s *HandlerImpl) get(ctx context.Context, key string, v any) error {
+	b, err := s.cache.Get(ctx, key)
+	if err != nil || len(b) == 0 {
+		return errNotCached
+	}
+
+	if err := gob.NewDecoder(bytes.NewReader(b)).Decode(v); err != nil {
+		return fmt.Errorf("failed to decode: %w", err)
 	}
 
-	return hex.EncodeToString(h.Sum(nil)), nil
+	return nil
+}
+
+// Tokens used to be the Sha256 of the session ID, but IDs show up on the
+// account page now, so tokens are random and have nothing to do with them
+func (s *HandlerImpl) createToken() (string, error) {
+	return crypto.ReadHex(tokenLength)
 }
 
 func cacheKey(t string) string {
 	return "session-token-" + t
 }
+
+func indexKey(accountID uuid.UUID) string {
+	return "session-index-" + accountID.String()
+}
+
+func endedKey(t string) string {
+	return "session-ended-" + t
+}
+
+func revocationKey(accountID uuid.UUID) string {
+	return "session-revoked-" + accountID.String()
+}
@@ -5,11 +5,13 @@ import (
 	"context"
 	"encoding/gob"
 	"errors"
+	"fmt"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/derinil/links/links/account/session"
+	"github.com/derinil/links/links/cache"
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
@@ -36,6 +38,11 @@ func (m *MockCache) PutWithTTL(ctx context.Context, key string, val []byte, ttl
 	return args.Error(0)
 }
 
+func (m *MockCache) Increment(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	args := m.Called(ctx, key, ttl)
+	return args.Get(0).(int64), args.Error(1)
+}
+
 func TestDestroy(t *testing.T) {
 	testCases := []struct {
 		name      string
@@ -64,10 +71,12 @@ func TestDestroy(t *testing.T) {
 			var (
 				ctx            = context.Background()
 				mockCache      = new(MockCache)
-				sessionHandler = session.NewHandler(mockCache)
+				sessionHandler = session.NewHandler(mockCache, session.Config{})
 			)
 
 			if !c.skipCache {
+				mockCache.On("PutWithTTL", ctx, "session-ended-"+c.token, mock.Anything, session.DefaultMaxLifetime).
+					Return(nil).Once()
 				mockCache.On("Invalidate", ctx, "session-token-"+c.token).Return(false, c.err).Once()
 			}
 
@@ -122,12 +131,16 @@ func TestGet(t *testing.T) {
 			var (
 				ctx            = context.Background()
 				mockCache      = new(MockCache)
-				sessionHandler = session.NewHandler(mockCache)
+				sessionHandler = session.NewHandler(mockCache, session.Config{})
 			)
 
 			if !c.skipCache {
 				mockCache.On("Get", ctx, "session-token-"+c.token).
 					Return(c.encoded, c.cacheErr).Once()
+				mockCache.On("Get", ctx, "session-revoked-"+defaultSession.AccountID.String()).
+					Return([]byte(nil), errors.New("not found")).Maybe()
+				mockCache.On("Get", ctx, "session-ended-"+c.token).
+					Return([]byte(nil), errors.New("not found")).Maybe()
 			}
 
 			s, err := sessionHandler.Get(ctx, c.token)
@@ -177,7 +190,7 @@ func TestIssue(t *testing.T) {
 			var (
 				ctx            = context.Background()
 				mockCache      = new(MockCache)
-				sessionHandler = session.NewHandler(mockCache)
+				sessionHandler = session.NewHandler(mockCache, session.Config{})
 			)
 
 			var bs []byte
@@ -189,10 +202,18 @@ func TestIssue(t *testing.T) {
 				mock.MatchedBy(func(b []byte) bool {
 					bs = b
 					return true
-				}), session.Lifetime,
+				}),
+				mock.MatchedBy(func(ttl time.Duration) bool {
+					return ttl > session.DefaultIdleTimeout-time.Minute && ttl <= session.DefaultIdleTimeout
+				}),
 			).Return(nil).Once()
 
-			s, _, err := sessionHandler.Issue(ctx, c.id, c.handle)
+			mockCache.On("Get", ctx, "session-index-"+c.id.String()).
+				Return([]byte(nil), errors.New("not found")).Once()
+			mockCache.On("PutWithTTL", ctx, "session-index-"+c.id.String(), mock.Anything, session.DefaultMaxLifetime).
+				Return(nil).Once()
+
+			s, _, err := sessionHandler.Issue(ctx, c.id, c.handle, false)
 			require.Nil(t, err)
 
 			mockCache.AssertExpectations(t)
@@ -207,3 +228,331 @@ func TestIssue(t *testing.T) {
 		})
 	}
 }
+
+// issue logs in count times from different browsers
+func issue(t *testing.T, h *session.HandlerImpl, accountID uuid.UUID, count int) ([]*session.Session, []string) {
+	var (
+		ses    []*session.Session
+		tokens []string
+	)
+
+	for i := 0; i < count; i++ {
+		ctx := context.WithValue(context.Background(), session.ClientKey, &session.Client{
+			UserAgent: fmt.Sprintf("Browser %d", i),
+			IP:        "192.0.2.1",
+		})
+
+		se, token, err := h.Issue(ctx, accountID, "handle", false)
+		require.NoError(t, err)
+		require.Equal(t, fmt.Sprintf("Browser %d", i), se.UserAgent)
+		require.Equal(t, "192.0.2.1", se.IP)
+
+		ses = append(ses, se)
+		tokens = append(tokens, token)
+	}
+
+	return ses, tokens
+}
+
+func TestList(t *testing.T) {
+	var (
+		ctx       = context.Background()
+		h         = session.NewHandler(cache.NewMemory(cache.MemoryConfig{}), session.Config{})
+		accountID = uuid.New()
+		ses, toks = issue(t, h, accountID, 3)
+		_, others = issue(t, h, uuid.New(), 1)
+	)
+
+	// Tokens don't give away anything about the session
+	for i := range ses {
+		require.NotContains(t, toks[i], strings.ReplaceAll(ses[i].ID.String(), "-", ""))
+	}
+
+	require.NoError(t, h.Destroy(ctx, toks[1]))
+
+	// The oldest session was used last
+	ses[0].LastSeenAt = time.Now().Add(-time.Hour)
+	require.NoError(t, h.Touch(ctx, toks[0], ses[0], &session.Client{UserAgent: "Browser 0", IP: "192.0.2.2"}))
+
+	list, err := h.List(ctx, accountID)
+	require.NoError(t, err)
+	require.Len(t, list, 2)
+	require.Equal(t, ses[0].ID, list[0].ID)
+	require.Equal(t, "192.0.2.2", list[0].IP)
+	require.Equal(t, ses[2].ID, list[1].ID)
+
+	_, err = h.Get(ctx, others[0])
+	require.NoError(t, err)
+}
+
+func TestRevoke(t *testing.T) {
+	var (
+		ctx       = context.Background()
+		h         = session.NewHandler(cache.NewMemory(cache.MemoryConfig{}), session.Config{})
+		accountID = uuid.New()
+		ses, toks = issue(t, h, accountID, 2)
+	)
+
+	// Only sessions of the same account can be revoked
+	require.ErrorIs(t, h.Revoke(ctx, uuid.New(), ses[0].ID), session.ErrUnknownSession)
+
+	require.NoError(t, h.Revoke(ctx, accountID, ses[0].ID))
+	require.ErrorIs(t, h.Revoke(ctx, accountID, ses[0].ID), session.ErrUnknownSession)
+
+	_, err := h.Get(ctx, toks[0])
+	require.ErrorIs(t, err, session.ErrSessionNotFound)
+
+	_, err = h.Get(ctx, toks[1])
+	require.NoError(t, err)
+}
+
+func TestRevokeAll(t *testing.T) {
+	testCases := []struct {
+		name string
+		// Index of the session to keep, -1 for none
+		except int
+		// Lose the index first, like two logins racing would
+		loseIndex bool
+	}{
+		{
+			name:   "all",
+			except: -1,
+		},
+		{
+			name:   "everywhere else",
+			except: 1,
+		},
+		{
+			name:      "all without the index",
+			except:    -1,
+			loseIndex: true,
+		},
+		{
+			name:      "everywhere else without the index",
+			except:    2,
+			loseIndex: true,
+		},
+	}
+
+	for _, c := range testCases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			var (
+				ctx       = context.Background()
+				mem       = cache.NewMemory(cache.MemoryConfig{})
+				h         = session.NewHandler(mem, session.Config{})
+				accountID = uuid.New()
+				ses, toks = issue(t, h, accountID, 3)
+				_, others = issue(t, h, uuid.New(), 1)
+				except    = uuid.Nil
+			)
+
+			if c.except >= 0 {
+				except = ses[c.except].ID
+			}
+
+			if c.loseIndex {
+				_, err := mem.Invalidate(ctx, "session-index-"+accountID.String())
+				require.NoError(t, err)
+			}
+
+			// Sessions are issued within the same clock tick on some platforms
+			time.Sleep(time.Millisecond)
+
+			require.NoError(t, h.RevokeAll(ctx, accountID, except))
+
+			for i := range toks {
+				_, err := h.Get(ctx, toks[i])
+				if i == c.except {
+					require.NoError(t, err)
+				} else {
+					require.ErrorIs(t, err, session.ErrSessionNotFound)
+				}
+			}
+
+			_, err := h.Get(ctx, others[0])
+			require.NoError(t, err)
+
+			// Logging in again works
+			_, fresh := issue(t, h, accountID, 1)
+			_, err = h.Get(ctx, fresh[0])
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestTouch(t *testing.T) {
+	config := session.Config{
+		IdleTimeout:         time.Hour,
+		RememberIdleTimeout: 10 * time.Hour,
+		MaxLifetime:         24 * time.Hour,
+	}
+
+	testCases := []struct {
+		name     string
+		remember bool
+		// How long ago the session was issued and last used
+		age      time.Duration
+		lastSeen time.Duration
+		// How long is left until it expires before and after touching it
+		left    time.Duration
+		renewed time.Duration
+	}{
+		{
+			name:     "before the threshold",
+			age:      10 * time.Minute,
+			lastSeen: 10 * time.Minute,
+			left:     50 * time.Minute,
+			renewed:  50 * time.Minute,
+		},
+		{
+			name:     "past the threshold",
+			age:      40 * time.Minute,
+			lastSeen: 40 * time.Minute,
+			left:     20 * time.Minute,
+			renewed:  time.Hour,
+		},
+		{
+			name:     "remembered",
+			remember: true,
+			age:      6 * time.Hour,
+			lastSeen: 6 * time.Hour,
+			left:     4 * time.Hour,
+			renewed:  10 * time.Hour,
+		},
+		{
+			name:     "near the max lifetime",
+			remember: true,
+			age:      20 * time.Hour,
+			lastSeen: 6 * time.Hour,
+			left:     2 * time.Hour,
+			renewed:  4 * time.Hour,
+		},
+	}
+
+	for _, c := range testCases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			var (
+				ctx       = context.Background()
+				h         = session.NewHandler(cache.NewMemory(cache.MemoryConfig{}), config)
+				now       = time.Now().UTC()
+				se, token = issueWith(t, h, c.remember)
+			)
+
+			// Pretend the session was issued a while ago
+			se.InsertedAt = now.Add(-c.age)
+			se.MaxExpiresAt = se.InsertedAt.Add(config.MaxLifetime)
+			se.LastSeenAt = now.Add(-c.lastSeen)
+			se.ExpiresAt = now.Add(c.left)
+
+			require.NoError(t, h.Touch(ctx, token, se, nil))
+			require.WithinDuration(t, now.Add(c.renewed), se.ExpiresAt, time.Second)
+
+			got, err := h.Get(ctx, token)
+			require.NoError(t, err)
+			require.WithinDuration(t, now.Add(c.renewed), got.ExpiresAt, time.Second)
+			require.WithinDuration(t, now, got.LastSeenAt, time.Second)
+		})
+	}
+
+	t.Run("expired", func(t *testing.T) {
+		se := session.New(uuid.New(), "handle")
+		se.ExpiresAt = time.Now().Add(-time.Second)
+
+		var b bytes.Buffer
+		require.NoError(t, gob.NewEncoder(&b).Encode(se))
+
+		// The cache is late to drop it
+		mem := cache.NewMemory(cache.MemoryConfig{})
+		require.NoError(t, mem.Put(context.Background(), "session-token-token", b.Bytes()))
+
+		_, err := session.NewHandler(mem, config).Get(context.Background(), "token")
+		require.ErrorIs(t, err, session.ErrSessionNotFound)
+	})
+
+	t.Run("ended", func(t *testing.T) {
+		var (
+			ctx       = context.Background()
+			h         = session.NewHandler(cache.NewMemory(cache.MemoryConfig{}), config)
+			se, token = issueWith(t, h, false)
+			ses, toks = issue(t, h, se.AccountID, 1)
+		)
+
+		// Both were read before they were ended and are touched after
+		require.NoError(t, h.Destroy(ctx, token))
+		require.NoError(t, h.Revoke(ctx, se.AccountID, ses[0].ID))
+
+		se.LastSeenAt = time.Now().Add(-time.Hour)
+		require.NoError(t, h.Touch(ctx, token, se, nil))
+		ses[0].LastSeenAt = time.Now().Add(-time.Hour)
+		require.NoError(t, h.Touch(ctx, toks[0], ses[0], nil))
+
+		_, err := h.Get(ctx, token)
+		require.ErrorIs(t, err, session.ErrSessionNotFound)
+		_, err = h.Get(ctx, toks[0])
+		require.ErrorIs(t, err, session.ErrSessionNotFound)
+	})
+}
+
+func issueWith(t *testing.T, h *session.HandlerImpl, remember bool) (*session.Session, string) {
+	se, token, err := h.Issue(context.Background(), uuid.New(), "handle", remember)
+	require.NoError(t, err)
+	require.Equal(t, remember, se.Remember)
+
+	return se, token
+}
+
+func TestCookie(t *testing.T) {
+	se := session.New(uuid.New(), "handle")
+
+	// Forgotten when the browser closes
+	c := session.Cookie("token", se)
+	require.True(t, c.Expires.IsZero())
+	require.Zero(t, c.MaxAge)
+
+	se.Remember = true
+
+	c = session.Cookie("token", se)
+	require.Equal(t, se.MaxExpiresAt, c.Expires)
+	require.InDelta(t, session.DefaultMaxLifetime.Seconds(), float64(c.MaxAge), 5)
+}
+
+func TestDevice(t *testing.T) {
+	testCases := []struct {
+		userAgent string
+		device    string
+	}{
+		{
+			userAgent: "Mozilla/5.0 (X11; Linux x86_64; rv:121.0) Gecko/20100101 Firefox/121.0",
+			device:    "Firefox on desktop",
+		},
+		{
+			userAgent: "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36 Edg/120.0.0.0",
+			device:    "Edge on desktop",
+		},
+		{
+			userAgent: "Mozilla/5.0 (iPhone; CPU iPhone OS 17_2 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.2 Mobile/15E148 Safari/604.1",
+			device:    "Safari on mobile",
+		},
+		{
+			userAgent: "Mozilla/5.0 (Linux; Android 14) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Mobile Safari/537.36",
+			device:    "Chrome on mobile",
+		},
+		{
+			userAgent: "curl/8.5.0",
+			device:    "curl/8.5.0",
+		},
+		{
+			device: "Unknown device",
+		},
+	}
+
+	for _, c := range testCases {
+		c := c
+		t.Run(c.device, func(t *testing.T) {
+			s := &session.Session{UserAgent: c.userAgent}
+			require.Equal(t, c.device, s.Device())
+		})
+	}
+}
@@ -2,6 +2,7 @@ package session
 
 import (
 	"context"
+	"log"
 	"net/http"
 
 	"github.com/derinil/links/links/web/responder"
@@ -10,11 +11,16 @@ import (
 const (
 	SessionTokenKey  CtxKey = "session_token"
 	SessionObjectKey CtxKey = "session_object"
+	ClientKey        CtxKey = "session_client"
 )
 
 func ParseSession(sessionHandler Handler) func(h http.Handler) http.Handler {
 	return func(h http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// Sessions issued during the request get to know where they come from
+			client := NewClient(r)
+			r = r.WithContext(context.WithValue(r.Context(), ClientKey, client))
+
 			c, err := r.Cookie(CookieName)
 			if err != nil {
 				h.ServeHTTP(w, r)
@@ -38,6 +44,10 @@ func ParseSession(sessionHandler Handler) func(h http.Handler) http.Handler {
 				return
 			}
 
+			if err := sessionHandler.Touch(r.Context(), t, se, client); err != nil {
+				log.Println("failed to touch session", err)
+			}
+
 			r = r.WithContext(context.WithValue(r.Context(), SessionTokenKey, t))
 			r = r.WithContext(context.WithValue(r.Context(), SessionObjectKey, se))
 
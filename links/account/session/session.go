@@ -1,10 +1,13 @@
 package session
 
 import (
+	"net"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/derinil/links/links/generic"
+	"github.com/derinil/links/links/tracking"
 	"github.com/google/uuid"
 )
 
@@ -14,28 +17,112 @@ type (
 		Handle    string
 		AccountID uuid.UUID
 		ExpiresAt time.Time
+		// Where the session was last used from, so the account page can tell sessions apart
+		UserAgent  string
+		IP         string
+		LastSeenAt time.Time
+		// Remembered sessions idle for longer and outlive the browser
+		Remember bool
+		// ExpiresAt slides forward as the session is used, but never past MaxExpiresAt
+		MaxExpiresAt time.Time
+	}
+
+	// Client is the browser a request came from
+	Client struct {
+		UserAgent string
+		IP        string
 	}
 )
 
-const Lifetime = 24 * time.Hour
+const (
+	// Defaults for Config
+	DefaultIdleTimeout         = 24 * time.Hour
+	DefaultRememberIdleTimeout = 30 * 24 * time.Hour
+	DefaultMaxLifetime         = 90 * 24 * time.Hour
+	// LastSeenInterval is how stale LastSeenAt can get before a request updates it,
+	// so not every request has to write the session back
+	LastSeenInterval = time.Minute
+
+	maxUserAgentLength = 256
+)
 
-// Creates a session that expires in now + session.Lifetime
+// Creates a session that expires in now + DefaultIdleTimeout,
+// the handler sets the expiry from its config when issuing
 func New(accountID uuid.UUID, handle string) *Session {
+	d := generic.NewDBStruct()
+
 	return &Session{
-		DBStruct:  generic.NewDBStruct(),
-		AccountID: accountID,
-		Handle:    handle,
-		ExpiresAt: time.Now().Add(Lifetime).UTC(),
+		DBStruct:     d,
+		AccountID:    accountID,
+		Handle:       handle,
+		ExpiresAt:    d.InsertedAt.Add(DefaultIdleTimeout),
+		LastSeenAt:   d.InsertedAt,
+		MaxExpiresAt: d.InsertedAt.Add(DefaultMaxLifetime),
 	}
 }
 
-func Cookie(token string) *http.Cookie {
-	return &http.Cookie{
-		Name:    CookieName,
-		Value:   token,
-		Expires: time.Now().Add(Lifetime),
-		MaxAge:  int(Lifetime.Seconds()),
+// NewClient reads the client off the request, RemoteAddr is expected
+// to be set to the real IP by generic.RealIP when behind a proxy
+func NewClient(r *http.Request) *Client {
+	ip := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+
+	ua := r.UserAgent()
+	if len(ua) > maxUserAgentLength {
+		ua = ua[:maxUserAgentLength]
+	}
+
+	return &Client{UserAgent: ua, IP: ip}
+}
+
+// Device makes a short description like "Firefox on desktop" out of the user agent.
+// It only knows the common browsers, anything else is shown as it is.
+func (s *Session) Device() string {
+	var (
+		ua      = s.UserAgent
+		class   = tracking.ClassifyUserAgent(ua)
+		browser string
+	)
+
+	switch {
+	case strings.Contains(ua, "Edg/"):
+		browser = "Edge"
+	case strings.Contains(ua, "OPR/"):
+		browser = "Opera"
+	case strings.Contains(ua, "Firefox/"):
+		browser = "Firefox"
+	case strings.Contains(ua, "Chrome/"):
+		browser = "Chrome"
+	case strings.Contains(ua, "Safari/"):
+		browser = "Safari"
 	}
+
+	switch {
+	case class == tracking.UserAgentUnknown:
+		return "Unknown device"
+	case browser != "" && class != tracking.UserAgentBot:
+		return browser + " on " + string(class)
+	default:
+		return ua
+	}
+}
+
+// Cookie only outlives the browser if the session is remembered. It lasts until
+// MaxExpiresAt then, since the handler extends the session without touching the cookie.
+func Cookie(token string, se *Session) *http.Cookie {
+	c := &http.Cookie{
+		Name:  CookieName,
+		Value: token,
+	}
+
+	if se.Remember {
+		c.Expires = se.MaxExpiresAt
+		c.MaxAge = int(time.Until(se.MaxExpiresAt).Seconds())
+	}
+
+	return c
 }
 
 func RemoveCookie() *http.Cookie {
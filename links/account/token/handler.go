@@ -0,0 +1,177 @@
+package token
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/derinil/links/links/crypto"
+	"github.com/derinil/links/links/generic"
+	"github.com/google/uuid"
+)
+
+type (
+	Handler interface {
+		// Create returns the plaintext token along with the token, it can't be recovered later
+		Create(ctx context.Context, cmd *CreateCmd) (*Token, string, error)
+		List(ctx context.Context, cmd *ListCmd) ([]Token, error)
+		Revoke(ctx context.Context, cmd *RevokeCmd) error
+		Authenticate(ctx context.Context, plaintext string) (*Token, error)
+	}
+
+	HandlerImpl struct {
+		reader Reader
+		writer Writer
+	}
+
+	// Both return nil if there are no tokens
+	Reader interface {
+		GetTokenByHash(ctx context.Context, hash []byte) (*Token, error)
+		ListTokensByAccountID(ctx context.Context, accountID uuid.UUID) ([]Token, error)
+	}
+
+	Writer interface {
+		SaveToken(ctx context.Context, t *Token) error
+		// DeleteToken reports whether the account had a token with the ID
+		DeleteToken(ctx context.Context, accountID, id uuid.UUID) (bool, error)
+		TouchToken(ctx context.Context, id uuid.UUID, at time.Time) error
+	}
+
+	CreateCmd struct {
+		AccountID uuid.UUID
+		Name      string
+		Scopes    []Scope
+		ExpiresIn time.Duration
+	}
+
+	ListCmd struct {
+		AccountID uuid.UUID
+	}
+
+	RevokeCmd struct {
+		AccountID uuid.UUID
+		ID        uuid.UUID
+	}
+)
+
+const (
+	Prefix      = "lnk_"
+	tokenLength = 32
+	// LastUsedAt is only updated when it's older than this,
+	// so scripts hammering the API don't write on every request
+	touchInterval = time.Minute
+)
+
+// Lifetimes people can pick from, the last one is the longest allowed
+var Lifetimes = []time.Duration{
+	7 * 24 * time.Hour,
+	30 * 24 * time.Hour,
+	90 * 24 * time.Hour,
+	365 * 24 * time.Hour,
+}
+
+var (
+	ErrInvalidToken  = generic.NewWebError(http.StatusUnauthorized, "access_token_invalid", "Access token is invalid")
+	ErrTokenExpired  = generic.NewWebError(http.StatusUnauthorized, "access_token_expired", "Access token has expired")
+	ErrTokenNotFound = generic.NewWebError(http.StatusNotFound, "access_token_not_found", "Access token not found")
+	ErrInvalidExpiry = generic.NewWebError(http.StatusBadRequest, "access_token_expiry_invalid", "Access tokens must expire within a year")
+	ErrMissingScope  = generic.NewWebError(http.StatusForbidden, "access_token_scope_missing", "Access token doesn't have the scope for this")
+)
+
+var _ Handler = (*HandlerImpl)(nil)
+
+func NewHandler(reader Reader, writer Writer) *HandlerImpl {
+	return &HandlerImpl{
+		reader: reader,
+		writer: writer,
+	}
+}
+
+func (s *HandlerImpl) Create(ctx context.Context, cmd *CreateCmd) (*Token, string, error) {
+	if cmd.ExpiresIn <= 0 || cmd.ExpiresIn > Lifetimes[len(Lifetimes)-1] {
+		return nil, "", ErrInvalidExpiry
+	}
+
+	b, err := crypto.ReadBytes(tokenLength)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read token: %w", err)
+	}
+
+	plaintext := Prefix + base64.RawURLEncoding.EncodeToString(b)
+
+	t := New(cmd.AccountID, cmd.Name, cmd.Scopes, hash(plaintext), time.Now().Add(cmd.ExpiresIn))
+
+	t.Sanitize()
+	if err := t.Validate(); err != nil {
+		return nil, "", fmt.Errorf("failed to validate token: %w", err)
+	}
+
+	if err := s.writer.SaveToken(ctx, t); err != nil {
+		return nil, "", fmt.Errorf("failed to save token: %w", err)
+	}
+
+	return t, plaintext, nil
+}
+
+func (s *HandlerImpl) List(ctx context.Context, cmd *ListCmd) ([]Token, error) {
+	ts, err := s.reader.ListTokensByAccountID(ctx, cmd.AccountID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tokens: %w", err)
+	}
+
+	return ts, nil
+}
+
+func (s *HandlerImpl) Revoke(ctx context.Context, cmd *RevokeCmd) error {
+	ok, err := s.writer.DeleteToken(ctx, cmd.AccountID, cmd.ID)
+	if err != nil {
+		return fmt.Errorf("failed to delete token: %w", err)
+	}
+
+	if !ok {
+		return ErrTokenNotFound
+	}
+
+	return nil
+}
+
+func (s *HandlerImpl) Authenticate(ctx context.Context, plaintext string) (*Token, error) {
+	if !strings.HasPrefix(plaintext, Prefix) {
+		return nil, ErrInvalidToken
+	}
+
+	t, err := s.reader.GetTokenByHash(ctx, hash(plaintext))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get token: %w", err)
+	}
+
+	if t == nil {
+		return nil, ErrInvalidToken
+	}
+
+	if t.Expired() {
+		return nil, ErrTokenExpired
+	}
+
+	now := time.Now().UTC()
+	if t.LastUsedAt == nil || now.Sub(*t.LastUsedAt) > touchInterval {
+		// Not being able to track usage is no reason to fail the request
+		if err := s.writer.TouchToken(ctx, t.ID, now); err != nil {
+			log.Println("failed to update last used at of token", t.ID, err)
+		} else {
+			t.LastUsedAt = &now
+		}
+	}
+
+	return t, nil
+}
+
+func hash(plaintext string) []byte {
+	h := sha256.Sum256([]byte(plaintext))
+	return h[:]
+}
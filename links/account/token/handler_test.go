@@ -0,0 +1,301 @@
+package token_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/derinil/links/links/account/token"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type (
+	MockReader struct{ mock.Mock }
+	MockWriter struct{ mock.Mock }
+)
+
+func (m *MockReader) GetTokenByHash(ctx context.Context, hash []byte) (*token.Token, error) {
+	args := m.Called(ctx, hash)
+	return args.Get(0).(*token.Token), args.Error(1)
+}
+
+func (m *MockReader) ListTokensByAccountID(ctx context.Context, accountID uuid.UUID) ([]token.Token, error) {
+	args := m.Called(ctx, accountID)
+	return args.Get(0).([]token.Token), args.Error(1)
+}
+
+func (m *MockWriter) SaveToken(ctx context.Context, t *token.Token) error {
+	args := m.Called(ctx, t)
+	return args.Error(0)
+}
+
+func (m *MockWriter) DeleteToken(ctx context.Context, accountID, id uuid.UUID) (bool, error) {
+	args := m.Called(ctx, accountID, id)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockWriter) TouchToken(ctx context.Context, id uuid.UUID, at time.Time) error {
+	args := m.Called(ctx, id, at)
+	return args.Error(0)
+}
+
+func TestCreate(t *testing.T) {
+	testCases := []struct {
+		name      string
+		tokenName string
+		scopes    []token.Scope
+		expiresIn time.Duration
+		saveErr   error
+		err       error
+		errStr    string
+		skipSave  bool
+	}{
+		{
+			name:      "valid token",
+			tokenName: "  script ",
+			scopes:    []token.Scope{token.LinksRead, token.AccountWrite},
+			expiresIn: token.Lifetimes[0],
+		},
+		{
+			name:      "no expiry",
+			tokenName: "script",
+			scopes:    []token.Scope{token.LinksRead},
+			err:       token.ErrInvalidExpiry,
+			skipSave:  true,
+		},
+		{
+			name:      "expiry too long",
+			tokenName: "script",
+			scopes:    []token.Scope{token.LinksRead},
+			expiresIn: token.Lifetimes[len(token.Lifetimes)-1] + time.Hour,
+			err:       token.ErrInvalidExpiry,
+			skipSave:  true,
+		},
+		{
+			name:      "no scopes",
+			tokenName: "script",
+			expiresIn: token.Lifetimes[0],
+			errStr:    "failed to validate token",
+			skipSave:  true,
+		},
+		{
+			name:      "unknown scope",
+			tokenName: "script",
+			scopes:    []token.Scope{"everything"},
+			expiresIn: token.Lifetimes[0],
+			errStr:    "failed to validate token",
+			skipSave:  true,
+		},
+		{
+			name:      "empty name",
+			tokenName: "   ",
+			scopes:    []token.Scope{token.LinksRead},
+			expiresIn: token.Lifetimes[0],
+			errStr:    "failed to validate token",
+			skipSave:  true,
+		},
+		{
+			name:      "save error",
+			tokenName: "script",
+			scopes:    []token.Scope{token.LinksRead},
+			expiresIn: token.Lifetimes[0],
+			saveErr:   errors.New("haha"),
+			errStr:    "failed to save token",
+		},
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			var (
+				ctx          = context.Background()
+				reader       = new(MockReader)
+				writer       = new(MockWriter)
+				tokenHandler = token.NewHandler(reader, writer)
+				accountID    = uuid.New()
+			)
+
+			if !c.skipSave {
+				writer.On("SaveToken", ctx, mock.AnythingOfType("*token.Token")).Return(c.saveErr).Once()
+			}
+
+			tk, plaintext, err := tokenHandler.Create(ctx, &token.CreateCmd{
+				AccountID: accountID,
+				Name:      c.tokenName,
+				Scopes:    c.scopes,
+				ExpiresIn: c.expiresIn,
+			})
+
+			writer.AssertExpectations(t)
+
+			if c.err != nil || c.errStr != "" || err != nil {
+				if c.err != nil {
+					require.ErrorIs(t, err, c.err)
+				} else {
+					require.Contains(t, err.Error(), c.errStr)
+				}
+				return
+			}
+
+			require.Nil(t, err)
+			require.True(t, strings.HasPrefix(plaintext, token.Prefix))
+
+			h := sha256.Sum256([]byte(plaintext))
+			require.Equal(t, h[:], tk.Hash)
+			require.Equal(t, accountID, tk.AccountID)
+			require.Equal(t, strings.TrimSpace(c.tokenName), tk.Name)
+			require.WithinDuration(t, time.Now().Add(c.expiresIn), tk.ExpiresAt, time.Minute)
+		})
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	var (
+		plaintext = token.Prefix + "abc"
+		h         = sha256.Sum256([]byte(plaintext))
+		recently  = time.Now().UTC().Add(-time.Second)
+		longAgo   = time.Now().UTC().Add(-time.Hour)
+	)
+
+	testCases := []struct {
+		name      string
+		plaintext string
+		stored    *token.Token
+		skipGet   bool
+		touch     bool
+		touchErr  error
+		err       error
+	}{
+		{
+			name:      "missing prefix",
+			plaintext: "abc",
+			skipGet:   true,
+			err:       token.ErrInvalidToken,
+		},
+		{
+			name:      "unknown token",
+			plaintext: plaintext,
+			err:       token.ErrInvalidToken,
+		},
+		{
+			name:      "expired token",
+			plaintext: plaintext,
+			stored:    &token.Token{ExpiresAt: time.Now().Add(-time.Second)},
+			err:       token.ErrTokenExpired,
+		},
+		{
+			name:      "first use",
+			plaintext: plaintext,
+			stored:    &token.Token{ExpiresAt: time.Now().Add(time.Hour)},
+			touch:     true,
+		},
+		{
+			name:      "used recently",
+			plaintext: plaintext,
+			stored:    &token.Token{ExpiresAt: time.Now().Add(time.Hour), LastUsedAt: &recently},
+		},
+		{
+			name:      "used long ago",
+			plaintext: plaintext,
+			stored:    &token.Token{ExpiresAt: time.Now().Add(time.Hour), LastUsedAt: &longAgo},
+			touch:     true,
+		},
+		{
+			name:      "touch error is ignored",
+			plaintext: plaintext,
+			stored:    &token.Token{ExpiresAt: time.Now().Add(time.Hour)},
+			touch:     true,
+			touchErr:  errors.New("haha"),
+		},
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			var (
+				ctx          = context.Background()
+				reader       = new(MockReader)
+				writer       = new(MockWriter)
+				tokenHandler = token.NewHandler(reader, writer)
+			)
+
+			if !c.skipGet {
+				reader.On("GetTokenByHash", ctx, h[:]).Return(c.stored, nil).Once()
+			}
+
+			if c.touch {
+				writer.On("TouchToken", ctx, c.stored.ID, mock.AnythingOfType("time.Time")).Return(c.touchErr).Once()
+			}
+
+			tk, err := tokenHandler.Authenticate(ctx, c.plaintext)
+
+			reader.AssertExpectations(t)
+			writer.AssertExpectations(t)
+
+			require.ErrorIs(t, err, c.err)
+			if c.err != nil {
+				return
+			}
+
+			require.Equal(t, c.stored.ID, tk.ID)
+			if c.touch && c.touchErr == nil {
+				require.WithinDuration(t, time.Now(), *tk.LastUsedAt, time.Minute)
+			}
+		})
+	}
+}
+
+func TestRevoke(t *testing.T) {
+	testCases := []struct {
+		name    string
+		deleted bool
+		dbErr   error
+		err     error
+		errStr  string
+	}{
+		{
+			name:    "revoked",
+			deleted: true,
+		},
+		{
+			name: "someone else's token",
+			err:  token.ErrTokenNotFound,
+		},
+		{
+			name:   "delete error",
+			dbErr:  errors.New("haha"),
+			errStr: "failed to delete token",
+		},
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			var (
+				ctx          = context.Background()
+				reader       = new(MockReader)
+				writer       = new(MockWriter)
+				tokenHandler = token.NewHandler(reader, writer)
+				accountID    = uuid.New()
+				id           = uuid.New()
+			)
+
+			writer.On("DeleteToken", ctx, accountID, id).Return(c.deleted, c.dbErr).Once()
+
+			err := tokenHandler.Revoke(ctx, &token.RevokeCmd{AccountID: accountID, ID: id})
+
+			writer.AssertExpectations(t)
+
+			switch {
+			case c.err != nil:
+				require.ErrorIs(t, err, c.err)
+			case c.errStr != "":
+				require.Contains(t, err.Error(), c.errStr)
+			default:
+				require.Nil(t, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,144 @@
+package token
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/derinil/links/links/generic"
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+)
+
+/*
+	Personal Access Tokens:
+		- Tokens look like lnk_<43 url safe base64 characters> so they are easy
+			to spot in logs and secret scanners.
+		- They are random enough that a plain Sha256 is all the hashing they
+			need, so we can look them up by their hash directly.
+		- Only the hash is stored, the plaintext is returned once when the
+			token is created.
+*/
+
+type (
+	Token struct {
+		generic.DBStruct
+		AccountID uuid.UUID `db:"account_id"`
+		Name      string    `validate:"min=1,max=64" db:"name"`
+		Hash      []byte    `db:"hash"`
+		// Space separated, filled in from Scopes before saving
+		RawScopes  string     `db:"scopes"`
+		Scopes     []Scope    `validate:"min=1,dive,scope" db:"-"`
+		ExpiresAt  time.Time  `db:"expires_at"`
+		LastUsedAt *time.Time `db:"last_used_at"`
+	}
+
+	Scope string
+
+	CtxKey string
+)
+
+// The authenticated token is stored under this key next to session.SessionObjectKey
+const TokenObjectKey CtxKey = "token_object"
+
+const (
+	AccountRead  Scope = "account:read"
+	AccountWrite Scope = "account:write"
+	LinksRead    Scope = "links:read"
+	LinksWrite   Scope = "links:write"
+)
+
+// Scopes in the order they are shown in
+var Scopes = []Scope{AccountRead, AccountWrite, LinksRead, LinksWrite}
+
+func init() {
+	if err := generic.Validator.RegisterValidation("scope", func(field validator.FieldLevel) bool {
+		i := field.Field().Interface()
+		s, ok := i.(Scope)
+		if !ok {
+			return false
+		}
+
+		return s.Valid()
+	}); err != nil {
+		panic(err)
+	}
+}
+
+func New(accountID uuid.UUID, name string, scopes []Scope, hash []byte, expiresAt time.Time) *Token {
+	return &Token{
+		DBStruct:  generic.NewDBStruct(),
+		AccountID: accountID,
+		Name:      name,
+		Hash:      hash,
+		Scopes:    scopes,
+		ExpiresAt: expiresAt.UTC(),
+	}
+}
+
+// HasScope reports whether the token was given the scope, write scopes include reading
+func (t *Token) HasScope(scope Scope) bool {
+	for _, s := range t.Scopes {
+		if s == scope || s == scope.write() {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (t *Token) Expired() bool {
+	return !time.Now().Before(t.ExpiresAt)
+}
+
+func (t *Token) Sanitize() {
+	t.Name = strings.TrimSpace(t.Name)
+}
+
+func (t *Token) Validate() error {
+	if err := generic.Validator.Struct(t); err != nil {
+		return fmt.Errorf("failed to validate token: %w", err)
+	}
+
+	return nil
+}
+
+func (t *Token) BeforeSave() error {
+	t.Sanitize()
+	if err := t.Validate(); err != nil {
+		return fmt.Errorf("failed to validate token: %w", err)
+	}
+
+	ss := make([]string, len(t.Scopes))
+	for i := range t.Scopes {
+		ss[i] = string(t.Scopes[i])
+	}
+
+	t.RawScopes = strings.Join(ss, " ")
+	t.SetUpdatedAt()
+
+	return nil
+}
+
+func (t *Token) AfterLoad() error {
+	t.Scopes = nil
+	for _, s := range strings.Fields(t.RawScopes) {
+		t.Scopes = append(t.Scopes, Scope(s))
+	}
+
+	return nil
+}
+
+func (s Scope) Valid() bool {
+	for _, sc := range Scopes {
+		if s == sc {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s Scope) write() Scope {
+	return Scope(strings.TrimSuffix(string(s), ":read") + ":write")
+}
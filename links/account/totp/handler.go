@@ -0,0 +1,434 @@
+package totp
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/derinil/links/links/account"
+	"github.com/derinil/links/links/cache"
+	"github.com/derinil/links/links/crypto"
+	"github.com/derinil/links/links/generic"
+	"github.com/google/uuid"
+)
+
+type (
+	Handler interface {
+		// Get returns nil if the account never started setting up two factor authentication
+		Get(ctx context.Context, cmd *GetCmd) (*TOTP, error)
+		RecoveryCodesLeft(ctx context.Context, cmd *GetCmd) (int, error)
+		// Enroll creates a new unconfirmed secret, replacing any earlier unconfirmed one
+		Enroll(ctx context.Context, cmd *EnrollCmd) (*TOTP, error)
+		// Confirm turns two factor authentication on and returns the recovery codes to show once
+		Confirm(ctx context.Context, cmd *ConfirmCmd) ([]string, error)
+		// Verify accepts either a code from the app or one of the recovery codes
+		Verify(ctx context.Context, cmd *VerifyCmd) error
+		Disable(ctx context.Context, cmd *DisableCmd) error
+
+		// BeginLogin stores a pending login for an account that got its password right
+		BeginLogin(ctx context.Context, cmd *BeginLoginCmd) (string, error)
+		// GetLogin returns the pending login without verifying or using it up
+		GetLogin(ctx context.Context, token string) (*PendingLogin, error)
+		// FinishLogin verifies the code for a pending login and returns who it was for
+		FinishLogin(ctx context.Context, cmd *FinishLoginCmd) (*PendingLogin, error)
+	}
+
+	HandlerImpl struct {
+		reader         Reader
+		writer         Writer
+		cache          cache.Cache
+		accountHandler account.Handler
+	}
+
+	Reader interface {
+		// GetTOTP returns nil if the account has no secret
+		GetTOTP(ctx context.Context, accountID uuid.UUID) (*TOTP, error)
+		CountUnusedRecoveryCodes(ctx context.Context, accountID uuid.UUID) (int, error)
+	}
+
+	Writer interface {
+		SaveTOTP(ctx context.Context, t *TOTP) error
+		// ConfirmTOTP saves the confirmed secret and replaces the account's recovery codes
+		ConfirmTOTP(ctx context.Context, t *TOTP, codes []RecoveryCode) error
+		// UseStep reports whether step was later than the last accepted step, and stores it if so
+		UseStep(ctx context.Context, accountID uuid.UUID, step int64) (bool, error)
+		// UseRecoveryCode reports whether an unused code with the hash existed, and marks it used if so
+		UseRecoveryCode(ctx context.Context, accountID uuid.UUID, hash []byte, at time.Time) (bool, error)
+		// DeleteTOTP deletes the secret along with the recovery codes
+		DeleteTOTP(ctx context.Context, accountID uuid.UUID) error
+	}
+
+	PendingLogin struct {
+		AccountID uuid.UUID
+		Handle    string
+		Attempts  int
+		ExpiresAt time.Time
+		// Carried over from the login form to the session
+		Remember bool
+	}
+
+	GetCmd struct {
+		AccountID uuid.UUID
+	}
+
+	EnrollCmd struct {
+		AccountID uuid.UUID
+	}
+
+	ConfirmCmd struct {
+		AccountID uuid.UUID
+		Code      string
+	}
+
+	VerifyCmd struct {
+		AccountID uuid.UUID
+		Code      string
+	}
+
+	// Turning two factor authentication off needs both the password and a code,
+	// so a session left open somewhere isn't enough to do it
+	DisableCmd struct {
+		AccountID uuid.UUID
+		Password  string
+		Code      string
+	}
+
+	BeginLoginCmd struct {
+		AccountID uuid.UUID
+		Handle    string
+		Remember  bool
+	}
+
+	FinishLoginCmd struct {
+		Token string
+		Code  string
+	}
+)
+
+const (
+	// Holds the pending login token between the password and the code
+	CookieName = "login_2fa"
+
+	PendingLoginLifetime = 5 * time.Minute
+	// Wrong codes allowed for one pending login before it has to start over with the password
+	MaxAttempts = 5
+
+	pendingTokenLength = 32
+)
+
+var (
+	ErrInvalidCode      = generic.NewWebError(http.StatusBadRequest, "totp_code_invalid", "Code is invalid")
+	ErrAlreadyEnabled   = generic.NewWebError(http.StatusBadRequest, "totp_already_enabled", "Two factor authentication is already on")
+	ErrNotEnrolled      = generic.NewWebError(http.StatusBadRequest, "totp_not_enrolled", "Two factor authentication isn't set up")
+	ErrReauthInvalid    = generic.NewWebError(http.StatusBadRequest, "totp_reauth_invalid", "Password or code is wrong")
+	ErrPendingLoginGone = generic.NewWebError(http.StatusUnauthorized, "totp_login_expired", "Your login has expired, log in again")
+)
+
+var _ Handler = (*HandlerImpl)(nil)
+
+func NewHandler(reader Reader, writer Writer, cache cache.Cache, accountHandler account.Handler) *HandlerImpl {
+	return &HandlerImpl{
+		reader:         reader,
+		writer:         writer,
+		cache:          cache,
+		accountHandler: accountHandler,
+	}
+}
+
+func (s *HandlerImpl) Get(ctx context.Context, cmd *GetCmd) (*TOTP, error) {
+	t, err := s.reader.GetTOTP(ctx, cmd.AccountID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get totp: %w", err)
+	}
+
+	return t, nil
+}
+
+func (s *HandlerImpl) RecoveryCodesLeft(ctx context.Context, cmd *GetCmd) (int, error) {
+	n, err := s.reader.CountUnusedRecoveryCodes(ctx, cmd.AccountID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count recovery codes: %w", err)
+	}
+
+	return n, nil
+}
+
+func (s *HandlerImpl) Enroll(ctx context.Context, cmd *EnrollCmd) (*TOTP, error) {
+	t, err := s.Get(ctx, &GetCmd{AccountID: cmd.AccountID})
+	if err != nil {
+		return nil, err
+	}
+
+	if t != nil && t.Confirmed() {
+		return nil, ErrAlreadyEnabled
+	}
+
+	t, err = New(cmd.AccountID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create totp: %w", err)
+	}
+
+	if err := s.writer.SaveTOTP(ctx, t); err != nil {
+		return nil, fmt.Errorf("failed to save totp: %w", err)
+	}
+
+	return t, nil
+}
+
+func (s *HandlerImpl) Confirm(ctx context.Context, cmd *ConfirmCmd) ([]string, error) {
+	t, err := s.Get(ctx, &GetCmd{AccountID: cmd.AccountID})
+	if err != nil {
+		return nil, err
+	}
+
+	if t == nil {
+		return nil, ErrNotEnrolled
+	}
+
+	if t.Confirmed() {
+		return nil, ErrAlreadyEnabled
+	}
+
+	step, ok := t.Verify(cmd.Code, time.Now())
+	if !ok {
+		return nil, ErrInvalidCode
+	}
+
+	plain, codes, err := NewRecoveryCodes(t.AccountID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create recovery codes: %w", err)
+	}
+
+	now := time.Now().UTC()
+	t.ConfirmedAt = &now
+	t.LastStep = step
+	t.UpdatedAt = now
+
+	if err := s.writer.ConfirmTOTP(ctx, t, codes); err != nil {
+		return nil, fmt.Errorf("failed to confirm totp: %w", err)
+	}
+
+	return plain, nil
+}
+
+func (s *HandlerImpl) Verify(ctx context.Context, cmd *VerifyCmd) error {
+	t, err := s.Get(ctx, &GetCmd{AccountID: cmd.AccountID})
+	if err != nil {
+		return err
+	}
+
+	if t == nil || !t.Confirmed() {
+		return ErrNotEnrolled
+	}
+
+	code := strings.TrimSpace(cmd.Code)
+
+	// Anything that isn't a code from the app is tried as a recovery code
+	if step, ok := t.Verify(code, time.Now()); ok {
+		// Someone else may have used the same code in the meantime
+		ok, err := s.writer.UseStep(ctx, t.AccountID, step)
+		if err != nil {
+			return fmt.Errorf("failed to use step: %w", err)
+		}
+
+		if !ok {
+			return ErrInvalidCode
+		}
+
+		return nil
+	}
+
+	if len(code) <= Digits {
+		return ErrInvalidCode
+	}
+
+	ok, err := s.writer.UseRecoveryCode(ctx, t.AccountID, HashRecoveryCode(code), time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("failed to use recovery code: %w", err)
+	}
+
+	if !ok {
+		return ErrInvalidCode
+	}
+
+	return nil
+}
+
+func (s *HandlerImpl) Disable(ctx context.Context, cmd *DisableCmd) error {
+	t, err := s.Get(ctx, &GetCmd{AccountID: cmd.AccountID})
+	if err != nil {
+		return err
+	}
+
+	if t == nil {
+		return ErrNotEnrolled
+	}
+
+	// An unconfirmed secret isn't protecting anything yet, so cancelling the setup is free
+	if t.Confirmed() {
+		a, err := s.accountHandler.Get(ctx, &account.GetCmd{ID: cmd.AccountID, Shallow: true})
+		if err != nil {
+			return fmt.Errorf("failed to get account: %w", err)
+		}
+
+		if a == nil {
+			return ErrNotEnrolled
+		}
+
+		ok, err := crypto.ComparePassword(cmd.Password, a.Handle, a.Password)
+		if err != nil {
+			return fmt.Errorf("failed to compare passwords: %w", err)
+		}
+
+		if !ok {
+			return ErrReauthInvalid
+		}
+
+		if err := s.Verify(ctx, &VerifyCmd{AccountID: cmd.AccountID, Code: cmd.Code}); err != nil {
+			if errors.Is(err, ErrInvalidCode) {
+				return ErrReauthInvalid
+			}
+			return err
+		}
+	}
+
+	if err := s.writer.DeleteTOTP(ctx, cmd.AccountID); err != nil {
+		return fmt.Errorf("failed to delete totp: %w", err)
+	}
+
+	return nil
+}
+
+func (s *HandlerImpl) BeginLogin(ctx context.Context, cmd *BeginLoginCmd) (string, error) {
+	token, err := crypto.ReadHex(pendingTokenLength)
+	if err != nil {
+		return "", fmt.Errorf("failed to read pending login token: %w", err)
+	}
+
+	pl := &PendingLogin{
+		AccountID: cmd.AccountID,
+		Handle:    cmd.Handle,
+		ExpiresAt: time.Now().Add(PendingLoginLifetime).UTC(),
+		Remember:  cmd.Remember,
+	}
+
+	if err := s.putPendingLogin(ctx, token, pl); err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+func (s *HandlerImpl) GetLogin(ctx context.Context, token string) (*PendingLogin, error) {
+	if token == "" {
+		return nil, ErrPendingLoginGone
+	}
+
+	b, err := s.cache.Get(ctx, pendingCacheKey(token))
+	if err != nil || len(b) == 0 {
+		return nil, ErrPendingLoginGone
+	}
+
+	var pl PendingLogin
+	if err := gob.NewDecoder(bytes.NewReader(b)).Decode(&pl); err != nil {
+		return nil, fmt.Errorf("failed to decode pending login: %w", err)
+	}
+
+	if !time.Now().Before(pl.ExpiresAt) {
+		return nil, ErrPendingLoginGone
+	}
+
+	return &pl, nil
+}
+
+func (s *HandlerImpl) FinishLogin(ctx context.Context, cmd *FinishLoginCmd) (*PendingLogin, error) {
+	pl, err := s.GetLogin(ctx, cmd.Token)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.Verify(ctx, &VerifyCmd{AccountID: pl.AccountID, Code: cmd.Code}); err != nil {
+		if !errors.Is(err, ErrInvalidCode) {
+			return nil, err
+		}
+
+		pl.Attempts++
+		if pl.Attempts >= MaxAttempts {
+			if _, err := s.cache.Invalidate(ctx, pendingCacheKey(cmd.Token)); err != nil {
+				return nil, fmt.Errorf("failed to invalidate pending login: %w", err)
+			}
+			return nil, ErrPendingLoginGone
+		}
+
+		if err := s.putPendingLogin(ctx, cmd.Token, pl); err != nil {
+			return nil, err
+		}
+
+		return nil, ErrInvalidCode
+	}
+
+	// Only one request gets to turn a pending login into a session
+	ok, err := s.cache.Invalidate(ctx, pendingCacheKey(cmd.Token))
+	if err != nil {
+		return nil, fmt.Errorf("failed to invalidate pending login: %w", err)
+	}
+
+	if !ok {
+		return nil, ErrPendingLoginGone
+	}
+
+	return pl, nil
+}
+
+func (s *HandlerImpl) putPendingLogin(ctx context.Context, token string, pl *PendingLogin) error {
+	var b bytes.Buffer
+	if err := gob.NewEncoder(&b).Encode(pl); err != nil {
+		return fmt.Errorf("failed to encode pending login: %w", err)
+	}
+
+	ttl := time.Until(pl.ExpiresAt)
+	if ttl <= 0 {
+		return ErrPendingLoginGone
+	}
+
+	if err := s.cache.PutWithTTL(ctx, pendingCacheKey(token), b.Bytes(), ttl); err != nil {
+		return fmt.Errorf("failed to cache pending login: %w", err)
+	}
+
+	return nil
+}
+
+// The token is only ever handed to the browser, the cache gets its hash
+func pendingCacheKey(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return "login-2fa-" + hex.EncodeToString(h[:])
+}
+
+func Cookie(token string) *http.Cookie {
+	return &http.Cookie{
+		Name:     CookieName,
+		Value:    token,
+		Path:     "/login",
+		Expires:  time.Now().Add(PendingLoginLifetime),
+		MaxAge:   int(PendingLoginLifetime.Seconds()),
+		HttpOnly: true,
+	}
+}
+
+func RemoveCookie() *http.Cookie {
+	return &http.Cookie{
+		Name:     CookieName,
+		Value:    "",
+		Path:     "/login",
+		Expires:  time.Now().Add(-time.Hour),
+		MaxAge:   -1,
+		HttpOnly: true,
+	}
+}
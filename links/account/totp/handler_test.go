@@ -0,0 +1,396 @@
+package totp_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/derinil/links/links/account"
+	"github.com/derinil/links/links/account/totp"
+	"github.com/derinil/links/links/cache"
+	"github.com/derinil/links/links/crypto"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type (
+	MockReader struct{ mock.Mock }
+	MockWriter struct{ mock.Mock }
+
+	// FakeAccounts always returns the same account
+	FakeAccounts struct {
+		account *account.Account
+	}
+)
+
+func (m *MockReader) GetTOTP(ctx context.Context, accountID uuid.UUID) (*totp.TOTP, error) {
+	args := m.Called(ctx, accountID)
+	return args.Get(0).(*totp.TOTP), args.Error(1)
+}
+
+func (m *MockReader) CountUnusedRecoveryCodes(ctx context.Context, accountID uuid.UUID) (int, error) {
+	args := m.Called(ctx, accountID)
+	return args.Int(0), args.Error(1)
+}
+
+func (m *MockWriter) SaveTOTP(ctx context.Context, t *totp.TOTP) error {
+	args := m.Called(ctx, t)
+	return args.Error(0)
+}
+
+func (m *MockWriter) ConfirmTOTP(ctx context.Context, t *totp.TOTP, codes []totp.RecoveryCode) error {
+	args := m.Called(ctx, t, codes)
+	return args.Error(0)
+}
+
+func (m *MockWriter) UseStep(ctx context.Context, accountID uuid.UUID, step int64) (bool, error) {
+	args := m.Called(ctx, accountID, step)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockWriter) UseRecoveryCode(ctx context.Context, accountID uuid.UUID, hash []byte, at time.Time) (bool, error) {
+	args := m.Called(ctx, accountID, hash, at)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockWriter) DeleteTOTP(ctx context.Context, accountID uuid.UUID) error {
+	args := m.Called(ctx, accountID)
+	return args.Error(0)
+}
+
+func (f *FakeAccounts) Get(ctx context.Context, cmd *account.GetCmd) (*account.Account, error) {
+	return f.account, nil
+}
+
+func (f *FakeAccounts) GetLink(ctx context.Context, cmd *account.GetLinkCmd) (*account.Link, error) {
+	return nil, nil
+}
+
+func newHandler(reader *MockReader, writer *MockWriter, a *account.Account) *totp.HandlerImpl {
+	return totp.NewHandler(
+		reader,
+		writer,
+		cache.NewMemory(cache.MemoryConfig{}),
+		account.NewHandler(&FakeAccounts{account: a}, nil, time.Hour),
+	)
+}
+
+func confirmed(accountID uuid.UUID) *totp.TOTP {
+	now := time.Now().UTC()
+	return &totp.TOTP{
+		AccountID:   accountID,
+		Secret:      []byte("12345678901234567890"),
+		ConfirmedAt: &now,
+	}
+}
+
+func TestConfirm(t *testing.T) {
+	var (
+		accountID   = uuid.New()
+		unconfirmed = &totp.TOTP{AccountID: accountID, Secret: []byte("12345678901234567890")}
+		step        = totp.Step(time.Now())
+		code        = totp.Code(unconfirmed.Secret, step)
+	)
+
+	testCases := []struct {
+		name    string
+		stored  *totp.TOTP
+		code    string
+		confirm bool
+		err     error
+	}{
+		{
+			name: "not enrolled",
+			code: code,
+			err:  totp.ErrNotEnrolled,
+		},
+		{
+			name:   "already on",
+			stored: confirmed(accountID),
+			code:   code,
+			err:    totp.ErrAlreadyEnabled,
+		},
+		{
+			name:   "wrong code",
+			stored: unconfirmed,
+			code:   "000000",
+			err:    totp.ErrInvalidCode,
+		},
+		{
+			name:    "confirmed",
+			stored:  unconfirmed,
+			code:    code,
+			confirm: true,
+		},
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			var (
+				ctx         = context.Background()
+				reader      = new(MockReader)
+				writer      = new(MockWriter)
+				totpHandler = newHandler(reader, writer, nil)
+			)
+
+			var stored *totp.TOTP
+			if c.stored != nil {
+				cp := *c.stored
+				stored = &cp
+			}
+
+			reader.On("GetTOTP", ctx, accountID).Return(stored, nil).Once()
+
+			if c.confirm {
+				writer.On("ConfirmTOTP", ctx, mock.MatchedBy(func(t *totp.TOTP) bool {
+					return t.Confirmed() && t.LastStep == step
+				}), mock.MatchedBy(func(codes []totp.RecoveryCode) bool {
+					return len(codes) == totp.RecoveryCodeCount
+				})).Return(nil).Once()
+			}
+
+			codes, err := totpHandler.Confirm(ctx, &totp.ConfirmCmd{AccountID: accountID, Code: c.code})
+
+			reader.AssertExpectations(t)
+			writer.AssertExpectations(t)
+
+			require.ErrorIs(t, err, c.err)
+			if c.err == nil {
+				require.Len(t, codes, totp.RecoveryCodeCount)
+			}
+		})
+	}
+}
+
+func TestVerifyCmd(t *testing.T) {
+	var (
+		accountID = uuid.New()
+		stored    = confirmed(accountID)
+		step      = totp.Step(time.Now())
+		code      = totp.Code(stored.Secret, step)
+		recovery  = "abcdefgh-ijklmnop"
+	)
+
+	testCases := []struct {
+		name        string
+		stored      *totp.TOTP
+		code        string
+		useStep     *bool
+		useRecovery *bool
+		err         error
+	}{
+		{
+			name: "not enrolled",
+			code: code,
+			err:  totp.ErrNotEnrolled,
+		},
+		{
+			name:   "not confirmed yet",
+			stored: &totp.TOTP{AccountID: accountID, Secret: stored.Secret},
+			code:   code,
+			err:    totp.ErrNotEnrolled,
+		},
+		{
+			name:    "app code",
+			stored:  stored,
+			code:    code,
+			useStep: ptr(true),
+		},
+		{
+			name:    "app code used by someone else first",
+			stored:  stored,
+			code:    code,
+			useStep: ptr(false),
+			err:     totp.ErrInvalidCode,
+		},
+		{
+			name:   "wrong app code",
+			stored: stored,
+			code:   "000000",
+			err:    totp.ErrInvalidCode,
+		},
+		{
+			name:        "recovery code",
+			stored:      stored,
+			code:        recovery,
+			useRecovery: ptr(true),
+		},
+		{
+			name:        "used recovery code",
+			stored:      stored,
+			code:        recovery,
+			useRecovery: ptr(false),
+			err:         totp.ErrInvalidCode,
+		},
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			var (
+				ctx         = context.Background()
+				reader      = new(MockReader)
+				writer      = new(MockWriter)
+				totpHandler = newHandler(reader, writer, nil)
+			)
+
+			reader.On("GetTOTP", ctx, accountID).Return(c.stored, nil).Once()
+
+			if c.useStep != nil {
+				writer.On("UseStep", ctx, accountID, step).Return(*c.useStep, nil).Once()
+			}
+
+			if c.useRecovery != nil {
+				writer.On("UseRecoveryCode", ctx, accountID, totp.HashRecoveryCode(recovery), mock.AnythingOfType("time.Time")).
+					Return(*c.useRecovery, nil).Once()
+			}
+
+			err := totpHandler.Verify(ctx, &totp.VerifyCmd{AccountID: accountID, Code: c.code})
+
+			reader.AssertExpectations(t)
+			writer.AssertExpectations(t)
+			require.ErrorIs(t, err, c.err)
+		})
+	}
+}
+
+func TestDisable(t *testing.T) {
+	pw, err := crypto.HashPassword("password")
+	require.Nil(t, err)
+
+	var (
+		a      = account.New("name", "handle", pw)
+		stored = confirmed(a.ID)
+		step   = totp.Step(time.Now())
+		code   = totp.Code(stored.Secret, step)
+	)
+
+	testCases := []struct {
+		name     string
+		stored   *totp.TOTP
+		password string
+		code     string
+		verify   bool
+		delete   bool
+		err      error
+	}{
+		{
+			name: "not enrolled",
+			err:  totp.ErrNotEnrolled,
+		},
+		{
+			name:   "cancel setup",
+			stored: &totp.TOTP{AccountID: a.ID, Secret: stored.Secret},
+			delete: true,
+		},
+		{
+			name:     "wrong password",
+			stored:   stored,
+			password: "nope",
+			code:     code,
+			err:      totp.ErrReauthInvalid,
+		},
+		{
+			name:     "wrong code",
+			stored:   stored,
+			password: "password",
+			code:     "000000",
+			verify:   true,
+			err:      totp.ErrReauthInvalid,
+		},
+		{
+			name:     "turned off",
+			stored:   stored,
+			password: "password",
+			code:     code,
+			verify:   true,
+			delete:   true,
+		},
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			var (
+				ctx         = context.Background()
+				reader      = new(MockReader)
+				writer      = new(MockWriter)
+				totpHandler = newHandler(reader, writer, a)
+			)
+
+			reader.On("GetTOTP", ctx, a.ID).Return(c.stored, nil).Once()
+
+			if c.verify {
+				reader.On("GetTOTP", ctx, a.ID).Return(c.stored, nil).Once()
+			}
+
+			if c.verify && c.err == nil {
+				writer.On("UseStep", ctx, a.ID, step).Return(true, nil).Once()
+			}
+
+			if c.delete {
+				writer.On("DeleteTOTP", ctx, a.ID).Return(nil).Once()
+			}
+
+			err := totpHandler.Disable(ctx, &totp.DisableCmd{
+				AccountID: a.ID,
+				Password:  c.password,
+				Code:      c.code,
+			})
+
+			reader.AssertExpectations(t)
+			writer.AssertExpectations(t)
+			require.ErrorIs(t, err, c.err)
+		})
+	}
+}
+
+func TestLogin(t *testing.T) {
+	var (
+		ctx         = context.Background()
+		reader      = new(MockReader)
+		writer      = new(MockWriter)
+		totpHandler = newHandler(reader, writer, nil)
+		accountID   = uuid.New()
+		stored      = confirmed(accountID)
+	)
+
+	reader.On("GetTOTP", ctx, accountID).Return(stored, nil)
+	writer.On("UseStep", ctx, accountID, mock.AnythingOfType("int64")).Return(true, nil)
+
+	_, err := totpHandler.FinishLogin(ctx, &totp.FinishLoginCmd{Token: "nope", Code: "000000"})
+	require.ErrorIs(t, err, totp.ErrPendingLoginGone)
+
+	pt, err := totpHandler.BeginLogin(ctx, &totp.BeginLoginCmd{AccountID: accountID, Handle: "handle"})
+	require.Nil(t, err)
+
+	// Wrong codes count against the pending login until it's gone
+	for i := 1; i < totp.MaxAttempts; i++ {
+		_, err := totpHandler.FinishLogin(ctx, &totp.FinishLoginCmd{Token: pt, Code: "000000"})
+		require.ErrorIs(t, err, totp.ErrInvalidCode)
+	}
+
+	_, err = totpHandler.FinishLogin(ctx, &totp.FinishLoginCmd{Token: pt, Code: "000000"})
+	require.ErrorIs(t, err, totp.ErrPendingLoginGone)
+
+	pt, err = totpHandler.BeginLogin(ctx, &totp.BeginLoginCmd{AccountID: accountID, Handle: "handle"})
+	require.Nil(t, err)
+
+	pl, err := totpHandler.FinishLogin(ctx, &totp.FinishLoginCmd{
+		Token: pt,
+		Code:  totp.Code(stored.Secret, totp.Step(time.Now())),
+	})
+	require.Nil(t, err)
+	require.Equal(t, accountID, pl.AccountID)
+	require.Equal(t, "handle", pl.Handle)
+
+	// Pending logins can only be finished once
+	_, err = totpHandler.FinishLogin(ctx, &totp.FinishLoginCmd{
+		Token: pt,
+		Code:  totp.Code(stored.Secret, totp.Step(time.Now())),
+	})
+	require.ErrorIs(t, err, totp.ErrPendingLoginGone)
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
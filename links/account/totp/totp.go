@@ -0,0 +1,198 @@
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/derinil/links/links/crypto"
+	"github.com/google/uuid"
+)
+
+/*
+	Two Factor Authentication:
+		- Codes are RFC 6238 TOTP codes, 6 digits from HMAC-SHA1 over 30 second
+			steps. That's what every authenticator app defaults to, so we don't
+			let anyone pick anything else.
+		- Enrolling creates an unconfirmed secret. It only starts being asked for
+			on login once the first code from the app is confirmed, so a half
+			finished setup can't lock anyone out.
+		- The last accepted step is stored and only later steps are accepted,
+			so a code can't be used twice even within its window.
+		- Confirming generates a batch of recovery codes. They are random enough
+			that a plain Sha256 is all the hashing they need, like access tokens,
+			and each one can only be used once.
+		- Logging in with a password only gets you a short lived pending login
+			in the cache, the session is issued once the code checks out.
+*/
+
+type (
+	TOTP struct {
+		AccountID uuid.UUID `db:"account_id"`
+		Secret    []byte    `db:"secret"`
+		// Nil until the first code is confirmed
+		ConfirmedAt *time.Time `db:"confirmed_at"`
+		LastStep    int64      `db:"last_step"`
+		InsertedAt  time.Time  `db:"inserted_at"`
+		UpdatedAt   time.Time  `db:"updated_at"`
+	}
+
+	RecoveryCode struct {
+		ID        uuid.UUID  `db:"id"`
+		AccountID uuid.UUID  `db:"account_id"`
+		Hash      []byte     `db:"hash"`
+		UsedAt    *time.Time `db:"used_at"`
+		// Recovery codes are never updated other than being used, so there's no updated_at
+		InsertedAt time.Time `db:"inserted_at"`
+	}
+)
+
+const (
+	Issuer = "Links"
+
+	Digits       = 6
+	Period       = 30 * time.Second
+	SecretLength = 20
+	// Steps before and after the current one that are still accepted, for clocks that drift
+	Skew = 1
+
+	RecoveryCodeCount  = 10
+	recoveryCodeLength = 10
+)
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+func New(accountID uuid.UUID) (*TOTP, error) {
+	secret, err := crypto.ReadBytes(SecretLength)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read secret: %w", err)
+	}
+
+	now := time.Now().UTC()
+
+	return &TOTP{
+		AccountID:  accountID,
+		Secret:     secret,
+		InsertedAt: now,
+		UpdatedAt:  now,
+	}, nil
+}
+
+func (t *TOTP) Confirmed() bool {
+	return t.ConfirmedAt != nil
+}
+
+// Key is the secret the way authenticator apps want it typed in
+func (t *TOTP) Key() string {
+	return encoding.EncodeToString(t.Secret)
+}
+
+// URI is the otpauth:// provisioning URI, usually shown as a QR code
+func (t *TOTP) URI(handle string) string {
+	v := url.Values{}
+	v.Set("secret", t.Key())
+	v.Set("issuer", Issuer)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprint(Digits))
+	v.Set("period", fmt.Sprint(int(Period.Seconds())))
+
+	u := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + Issuer + ":" + handle,
+		RawQuery: v.Encode(),
+	}
+
+	return u.String()
+}
+
+// Verify checks the code against the steps around at and returns the step it
+// matched. Steps up to and including the last accepted step are rejected.
+func (t *TOTP) Verify(code string, at time.Time) (int64, bool) {
+	code = strings.ReplaceAll(code, " ", "")
+	if len(code) != Digits {
+		return 0, false
+	}
+
+	step := Step(at)
+	for i := step - Skew; i <= step+Skew; i++ {
+		if i <= t.LastStep {
+			continue
+		}
+
+		if subtle.ConstantTimeCompare([]byte(Code(t.Secret, i)), []byte(code)) == 1 {
+			return i, true
+		}
+	}
+
+	return 0, false
+}
+
+func Step(at time.Time) int64 {
+	return at.Unix() / int64(Period.Seconds())
+}
+
+// Code is the RFC 4226 HOTP value of the secret for the counter
+func Code(secret []byte, counter int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(counter))
+
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < Digits; i++ {
+		mod *= 10
+	}
+
+	return fmt.Sprintf("%0*d", Digits, value%mod)
+}
+
+// NewRecoveryCodes returns the codes to show once along with what to store for them
+func NewRecoveryCodes(accountID uuid.UUID) ([]string, []RecoveryCode, error) {
+	var (
+		now   = time.Now().UTC()
+		plain = make([]string, RecoveryCodeCount)
+		codes = make([]RecoveryCode, RecoveryCodeCount)
+	)
+
+	for i := range plain {
+		b, err := crypto.ReadBytes(recoveryCodeLength)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read recovery code: %w", err)
+		}
+
+		// 16 lowercase base32 characters, split in two so they're easier to copy by hand
+		s := strings.ToLower(encoding.EncodeToString(b))
+		plain[i] = s[:8] + "-" + s[8:]
+
+		codes[i] = RecoveryCode{
+			ID:         uuid.New(),
+			AccountID:  accountID,
+			Hash:       HashRecoveryCode(plain[i]),
+			InsertedAt: now,
+		}
+	}
+
+	return plain, codes, nil
+}
+
+// HashRecoveryCode ignores case, spaces and dashes so codes can be typed in loosely
+func HashRecoveryCode(code string) []byte {
+	code = strings.ToLower(code)
+	code = strings.NewReplacer(" ", "", "-", "").Replace(code)
+
+	h := sha256.Sum256([]byte(code))
+	return h[:]
+}
@@ -0,0 +1,130 @@
+package totp_test
+
+import (
+	"bytes"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/derinil/links/links/account/totp"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+// The SHA1 vectors from RFC 6238 appendix B, cut down to 6 digits
+func TestCode(t *testing.T) {
+	secret := []byte("12345678901234567890")
+
+	testCases := []struct {
+		unix int64
+		code string
+	}{
+		{unix: 59, code: "287082"},
+		{unix: 1111111109, code: "081804"},
+		{unix: 1111111111, code: "050471"},
+		{unix: 1234567890, code: "005924"},
+		{unix: 2000000000, code: "279037"},
+		{unix: 20000000000, code: "353130"},
+	}
+
+	for _, c := range testCases {
+		require.Equal(t, c.code, totp.Code(secret, totp.Step(time.Unix(c.unix, 0))), c.unix)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	var (
+		secret = []byte("12345678901234567890")
+		now    = time.Unix(1111111111, 0)
+		step   = totp.Step(now)
+	)
+
+	testCases := []struct {
+		name     string
+		code     string
+		lastStep int64
+		step     int64
+		ok       bool
+	}{
+		{
+			name: "current step",
+			code: totp.Code(secret, step),
+			step: step,
+			ok:   true,
+		},
+		{
+			name: "with spaces",
+			code: "050 471",
+			step: step,
+			ok:   true,
+		},
+		{
+			name: "previous step",
+			code: totp.Code(secret, step-1),
+			step: step - 1,
+			ok:   true,
+		},
+		{
+			name: "next step",
+			code: totp.Code(secret, step+1),
+			step: step + 1,
+			ok:   true,
+		},
+		{
+			name: "too old",
+			code: totp.Code(secret, step-2),
+		},
+		{
+			name:     "already used",
+			code:     totp.Code(secret, step),
+			lastStep: step,
+		},
+		{
+			name: "wrong length",
+			code: "12345",
+		},
+		{
+			name: "wrong code",
+			code: "000000",
+		},
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			tp := &totp.TOTP{Secret: secret, LastStep: c.lastStep}
+
+			step, ok := tp.Verify(c.code, now)
+			require.Equal(t, c.ok, ok)
+			require.Equal(t, c.step, step)
+		})
+	}
+}
+
+func TestURI(t *testing.T) {
+	tp := &totp.TOTP{Secret: []byte("12345678901234567890")}
+
+	u, err := url.Parse(tp.URI("handle"))
+	require.Nil(t, err)
+	require.Equal(t, "otpauth", u.Scheme)
+	require.Equal(t, "totp", u.Host)
+	require.Equal(t, "/Links:handle", u.Path)
+	require.Equal(t, "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ", u.Query().Get("secret"))
+	require.Equal(t, "Links", u.Query().Get("issuer"))
+}
+
+func TestNewRecoveryCodes(t *testing.T) {
+	accountID := uuid.New()
+
+	plain, codes, err := totp.NewRecoveryCodes(accountID)
+	require.Nil(t, err)
+	require.Len(t, plain, totp.RecoveryCodeCount)
+	require.Len(t, codes, totp.RecoveryCodeCount)
+
+	for i := range plain {
+		require.Len(t, plain[i], 17)
+		require.Equal(t, accountID, codes[i].AccountID)
+		require.True(t, bytes.Equal(codes[i].Hash, totp.HashRecoveryCode(plain[i])))
+	}
+
+	require.Equal(t, totp.HashRecoveryCode("abcdefgh-ijklmnop"), totp.HashRecoveryCode(" ABCDEFGH IJKLMNOP"))
+}
@@ -0,0 +1,224 @@
+package analytics
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/derinil/links/links/generic"
+	"github.com/google/uuid"
+)
+
+type (
+	Handler interface {
+		Summarize(ctx context.Context, cmd *SummarizeCmd) (*Summary, error)
+	}
+
+	HandlerImpl struct {
+		reader Reader
+	}
+
+	// Reader reads from daily rollups, From and To are days in UTC
+	// and both of them are inclusive
+	Reader interface {
+		ListDailyViews(ctx context.Context, cmd *RangeCmd) ([]DailyViews, error)
+		ListLinkClicks(ctx context.Context, cmd *RangeCmd) ([]LinkClicks, error)
+		ListReferrers(ctx context.Context, cmd *RangeCmd) ([]ReferrerViews, error)
+	}
+
+	RangeCmd struct {
+		AccountID uuid.UUID
+		From      time.Time
+		To        time.Time
+	}
+
+	SummarizeCmd struct {
+		AccountID uuid.UUID
+		Window    Window
+	}
+
+	// Window is the number of days a summary covers, today included
+	Window int
+
+	DailyViews struct {
+		Day   time.Time `db:"day"`
+		Views int       `db:"views"`
+	}
+
+	// Reader should return every link of the account, even the ones without clicks
+	LinkClicks struct {
+		LinkID uuid.UUID `db:"link_id"`
+		Title  string    `db:"title"`
+		Link   string    `db:"link"`
+		Clicks int       `db:"clicks"`
+	}
+
+	// Referrer is empty for direct visits
+	ReferrerViews struct {
+		Referrer string `db:"referrer"`
+		Views    int    `db:"views"`
+	}
+
+	LinkStats struct {
+		LinkClicks
+		ClickThroughRate float64
+	}
+
+	Summary struct {
+		Window Window
+		From   time.Time
+		To     time.Time
+		// One entry per day of the window, days without views included
+		Days             []DailyViews
+		MaxDailyViews    int
+		TotalViews       int
+		TotalClicks      int
+		ClickThroughRate float64
+		// Sorted by clicks, most clicked first
+		Links []LinkStats
+		// Sorted by views, at most TopReferrers of them
+		Referrers []ReferrerViews
+	}
+)
+
+const (
+	Week    Window = 7
+	Month   Window = 30
+	Quarter Window = 90
+
+	TopReferrers = 10
+)
+
+var Windows = [...]Window{Week, Month, Quarter}
+
+var ErrInvalidWindow = generic.NewWebError(http.StatusBadRequest, "invalid_window", "Analytics can only be shown for 7, 30 or 90 days")
+
+var _ Handler = (*HandlerImpl)(nil)
+
+func NewHandler(reader Reader) *HandlerImpl {
+	return &HandlerImpl{reader: reader}
+}
+
+// ParseWindow parses the number of days, an empty string is a week
+func ParseWindow(s string) (Window, error) {
+	if s == "" {
+		return Week, nil
+	}
+
+	d, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, ErrInvalidWindow
+	}
+
+	w := Window(d)
+	if !w.Valid() {
+		return 0, ErrInvalidWindow
+	}
+
+	return w, nil
+}
+
+func (w Window) Valid() bool {
+	for _, vw := range Windows {
+		if w == vw {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *HandlerImpl) Summarize(ctx context.Context, cmd *SummarizeCmd) (*Summary, error) {
+	if !cmd.Window.Valid() {
+		return nil, ErrInvalidWindow
+	}
+
+	var (
+		to = truncateDay(time.Now())
+		rc = &RangeCmd{
+			AccountID: cmd.AccountID,
+			From:      to.AddDate(0, 0, -int(cmd.Window)+1),
+			To:        to,
+		}
+		sm = &Summary{
+			Window: cmd.Window,
+			From:   rc.From,
+			To:     rc.To,
+		}
+	)
+
+	dvs, err := s.reader.ListDailyViews(ctx, rc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list daily views: %w", err)
+	}
+
+	lcs, err := s.reader.ListLinkClicks(ctx, rc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list link clicks: %w", err)
+	}
+
+	rvs, err := s.reader.ListReferrers(ctx, rc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list referrers: %w", err)
+	}
+
+	views := make(map[time.Time]int, len(dvs))
+	for _, dv := range dvs {
+		views[truncateDay(dv.Day)] += dv.Views
+	}
+
+	sm.Days = make([]DailyViews, 0, cmd.Window)
+	for d := rc.From; !d.After(rc.To); d = d.AddDate(0, 0, 1) {
+		v := views[d]
+
+		sm.Days = append(sm.Days, DailyViews{Day: d, Views: v})
+		sm.TotalViews += v
+
+		if v > sm.MaxDailyViews {
+			sm.MaxDailyViews = v
+		}
+	}
+
+	sm.Links = make([]LinkStats, 0, len(lcs))
+	for _, lc := range lcs {
+		sm.TotalClicks += lc.Clicks
+		sm.Links = append(sm.Links, LinkStats{
+			LinkClicks:       lc,
+			ClickThroughRate: rate(lc.Clicks, sm.TotalViews),
+		})
+	}
+
+	sort.SliceStable(sm.Links, func(i, j int) bool {
+		return sm.Links[i].Clicks > sm.Links[j].Clicks
+	})
+
+	sm.ClickThroughRate = rate(sm.TotalClicks, sm.TotalViews)
+
+	sort.SliceStable(rvs, func(i, j int) bool {
+		return rvs[i].Views > rvs[j].Views
+	})
+
+	if len(rvs) > TopReferrers {
+		rvs = rvs[:TopReferrers]
+	}
+
+	sm.Referrers = rvs
+
+	return sm, nil
+}
+
+func truncateDay(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+func rate(x, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+
+	return float64(x) / float64(total)
+}
@@ -0,0 +1,205 @@
+package analytics_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/derinil/links/links/analytics"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+// FakeReader serves canned rollups and remembers the ranges it was asked for
+type FakeReader struct {
+	views     []analytics.DailyViews
+	clicks    []analytics.LinkClicks
+	referrers []analytics.ReferrerViews
+	err       error
+	ranges    []analytics.RangeCmd
+}
+
+func (r *FakeReader) ListDailyViews(ctx context.Context, cmd *analytics.RangeCmd) ([]analytics.DailyViews, error) {
+	r.ranges = append(r.ranges, *cmd)
+	return r.views, r.err
+}
+
+func (r *FakeReader) ListLinkClicks(ctx context.Context, cmd *analytics.RangeCmd) ([]analytics.LinkClicks, error) {
+	r.ranges = append(r.ranges, *cmd)
+	return r.clicks, r.err
+}
+
+func (r *FakeReader) ListReferrers(ctx context.Context, cmd *analytics.RangeCmd) ([]analytics.ReferrerViews, error) {
+	r.ranges = append(r.ranges, *cmd)
+	return r.referrers, r.err
+}
+
+func today() time.Time {
+	n := time.Now().UTC()
+	return time.Date(n.Year(), n.Month(), n.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+func TestParseWindow(t *testing.T) {
+	testCases := []struct {
+		name   string
+		s      string
+		window analytics.Window
+		err    error
+	}{
+		{name: "empty is a week", s: "", window: analytics.Week},
+		{name: "week", s: "7", window: analytics.Week},
+		{name: "month", s: "30", window: analytics.Month},
+		{name: "quarter", s: "90", window: analytics.Quarter},
+		{name: "unsupported number", s: "14", err: analytics.ErrInvalidWindow},
+		{name: "garbage", s: "forever", err: analytics.ErrInvalidWindow},
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			w, err := analytics.ParseWindow(c.s)
+			require.ErrorIs(t, err, c.err)
+			require.Equal(t, c.window, w)
+		})
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	var (
+		ctx       = context.Background()
+		accountID = uuid.New()
+		day       = today()
+		first     = uuid.New()
+		second    = uuid.New()
+		reader    = &FakeReader{
+			views: []analytics.DailyViews{
+				{Day: day.AddDate(0, 0, -6), Views: 10},
+				{Day: day.AddDate(0, 0, -2), Views: 30},
+				{Day: day, Views: 60},
+			},
+			clicks: []analytics.LinkClicks{
+				{LinkID: first, Title: "First", Clicks: 5},
+				{LinkID: second, Title: "Second", Clicks: 20},
+			},
+			referrers: []analytics.ReferrerViews{
+				{Referrer: "", Views: 40},
+				{Referrer: "twitter.com", Views: 50},
+				{Referrer: "example.com", Views: 10},
+			},
+		}
+		analyticsHandler = analytics.NewHandler(reader)
+	)
+
+	sm, err := analyticsHandler.Summarize(ctx, &analytics.SummarizeCmd{
+		AccountID: accountID,
+		Window:    analytics.Week,
+	})
+	require.Nil(t, err)
+
+	for _, rc := range reader.ranges {
+		require.Equal(t, accountID, rc.AccountID)
+		require.Equal(t, day.AddDate(0, 0, -6), rc.From)
+		require.Equal(t, day, rc.To)
+	}
+
+	require.Len(t, sm.Days, 7)
+	require.Equal(t, []int{10, 0, 0, 0, 30, 0, 60}, func() []int {
+		vs := make([]int, len(sm.Days))
+		for i := range sm.Days {
+			vs[i] = sm.Days[i].Views
+		}
+		return vs
+	}())
+	require.Equal(t, 60, sm.MaxDailyViews)
+	require.Equal(t, 100, sm.TotalViews)
+	require.Equal(t, 25, sm.TotalClicks)
+	require.InDelta(t, 0.25, sm.ClickThroughRate, 0.0001)
+
+	require.Len(t, sm.Links, 2)
+	require.Equal(t, second, sm.Links[0].LinkID)
+	require.InDelta(t, 0.2, sm.Links[0].ClickThroughRate, 0.0001)
+	require.Equal(t, first, sm.Links[1].LinkID)
+	require.InDelta(t, 0.05, sm.Links[1].ClickThroughRate, 0.0001)
+
+	require.Equal(t, []analytics.ReferrerViews{
+		{Referrer: "twitter.com", Views: 50},
+		{Referrer: "", Views: 40},
+		{Referrer: "example.com", Views: 10},
+	}, sm.Referrers)
+}
+
+func TestSummarizeWithoutViews(t *testing.T) {
+	var (
+		reader = &FakeReader{
+			clicks: []analytics.LinkClicks{
+				{LinkID: uuid.New(), Title: "Clicked from a cached page", Clicks: 3},
+			},
+		}
+		analyticsHandler = analytics.NewHandler(reader)
+	)
+
+	sm, err := analyticsHandler.Summarize(context.Background(), &analytics.SummarizeCmd{
+		AccountID: uuid.New(),
+		Window:    analytics.Quarter,
+	})
+	require.Nil(t, err)
+
+	require.Len(t, sm.Days, 90)
+	require.Equal(t, today(), sm.Days[89].Day)
+	require.Equal(t, 0, sm.TotalViews)
+	require.Equal(t, 3, sm.TotalClicks)
+	require.Zero(t, sm.ClickThroughRate)
+	require.Zero(t, sm.Links[0].ClickThroughRate)
+}
+
+func TestSummarizeTopReferrers(t *testing.T) {
+	reader := new(FakeReader)
+	for i := 0; i < analytics.TopReferrers+5; i++ {
+		reader.referrers = append(reader.referrers, analytics.ReferrerViews{
+			Referrer: string(rune('a' + i)),
+			Views:    i,
+		})
+	}
+
+	sm, err := analytics.NewHandler(reader).Summarize(context.Background(), &analytics.SummarizeCmd{
+		AccountID: uuid.New(),
+		Window:    analytics.Month,
+	})
+	require.Nil(t, err)
+
+	require.Len(t, sm.Referrers, analytics.TopReferrers)
+	require.Equal(t, analytics.TopReferrers+4, sm.Referrers[0].Views)
+}
+
+func TestSummarizeErrors(t *testing.T) {
+	funnyErr := errors.New("haha")
+
+	testCases := []struct {
+		name   string
+		window analytics.Window
+		err    error
+	}{
+		{
+			name:   "invalid window",
+			window: 12,
+			err:    analytics.ErrInvalidWindow,
+		},
+		{
+			name:   "reader error out",
+			window: analytics.Week,
+			err:    funnyErr,
+		},
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			analyticsHandler := analytics.NewHandler(&FakeReader{err: funnyErr})
+
+			_, err := analyticsHandler.Summarize(context.Background(), &analytics.SummarizeCmd{
+				AccountID: uuid.New(),
+				Window:    c.window,
+			})
+			require.ErrorIs(t, err, c.err)
+		})
+	}
+}
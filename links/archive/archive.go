@@ -0,0 +1,504 @@
+package archive
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/derinil/links/links/account"
+	"github.com/derinil/links/links/analytics"
+	"github.com/derinil/links/links/favicon"
+	"github.com/derinil/links/links/generic"
+	"github.com/derinil/links/links/imaging"
+	"github.com/derinil/links/links/theme"
+	"github.com/google/uuid"
+)
+
+/*
+	Data Archives:
+		- Users can take their profile, links, CSS and analytics summaries out
+			as a versioned JSON document, or as a zip that has the same document
+			next to the avatar and the favicons.
+		- Importing restores an archive into an existing account, so the
+			handle, email and password always stay the ones of the account on
+			this instance. Links are replaced in the archive's order, links
+			with the same URL keep their IDs and their clicks.
+		- Everything in an archive goes through the same validation as the
+			account page, images are decoded and encoded again like uploads,
+			and themes this instance doesn't have fall back to the default.
+		- Analytics are exported for reference only, they aren't imported.
+*/
+
+type (
+	Handler interface {
+		Export(ctx context.Context, cmd *ExportCmd) ([]byte, error)
+		// Import returns the account as it was saved
+		Import(ctx context.Context, cmd *ImportCmd) (*account.Account, error)
+	}
+
+	HandlerImpl struct {
+		accountHandler   account.Handler
+		writer           account.Writer
+		analyticsHandler analytics.Handler
+		themes           *theme.Registry
+	}
+
+	Format string
+
+	ExportCmd struct {
+		AccountID uuid.UUID
+		Format    Format
+	}
+
+	// Data is either format, it's sniffed from the first bytes
+	ImportCmd struct {
+		AccountID uuid.UUID
+		Data      []byte
+	}
+
+	Archive struct {
+		Version    int       `json:"version"`
+		ExportedAt time.Time `json:"exported_at"`
+		Profile    Profile   `json:"profile"`
+		// In the order they're shown on the profile
+		Links     []Link     `json:"links"`
+		Analytics *Analytics `json:"analytics,omitempty"`
+	}
+
+	Profile struct {
+		Name   string `json:"name"`
+		Handle string `json:"handle"`
+		Email  string `json:"email,omitempty"`
+		CSS    string `json:"css"`
+		Theme  string `json:"theme,omitempty"`
+		// Name of the file in the zip, empty without an avatar or in JSON
+		Avatar    string    `json:"avatar,omitempty"`
+		CreatedAt time.Time `json:"created_at"`
+	}
+
+	Link struct {
+		Title string `json:"title"`
+		URL   string `json:"url"`
+		// Name of the file in the zip, empty without a favicon or in JSON
+		Favicon   string    `json:"favicon,omitempty"`
+		CreatedAt time.Time `json:"created_at"`
+	}
+
+	// Analytics is the summary of the longest window analytics are kept for
+	Analytics struct {
+		Days        int             `json:"days"`
+		From        time.Time       `json:"from"`
+		To          time.Time       `json:"to"`
+		TotalViews  int             `json:"total_views"`
+		TotalClicks int             `json:"total_clicks"`
+		DailyViews  []DailyViews    `json:"daily_views"`
+		LinkClicks  []LinkClicks    `json:"link_clicks"`
+		Referrers   []ReferrerViews `json:"referrers"`
+	}
+
+	DailyViews struct {
+		Day   time.Time `json:"day"`
+		Views int       `json:"views"`
+	}
+
+	LinkClicks struct {
+		Title  string `json:"title"`
+		URL    string `json:"url"`
+		Clicks int    `json:"clicks"`
+	}
+
+	// Referrer is empty for direct visits
+	ReferrerViews struct {
+		Referrer string `json:"referrer"`
+		Views    int    `json:"views"`
+	}
+)
+
+const (
+	JSON Format = "json"
+	Zip  Format = "zip"
+)
+
+// Version is bumped whenever the archive changes in a way older
+// instances can't read
+const Version = 1
+
+const (
+	// Name of the document inside zips
+	DocumentName = "archive.json"
+	// The document and every file in a zip are at most this large
+	MaxFileSize = 8 << 20
+	// All files in a zip together, so a small upload can't unpack into gigabytes
+	MaxSize = 64 << 20
+)
+
+var (
+	ErrInvalidFormat      = generic.NewWebError(http.StatusBadRequest, "archive_invalid_format", "Archives can only be exported as json or zip")
+	ErrInvalidArchive     = generic.NewWebError(http.StatusBadRequest, "archive_invalid", "File is not a links archive")
+	ErrUnsupportedVersion = generic.NewWebError(http.StatusBadRequest, "archive_unsupported_version", fmt.Sprintf("Archive version is not supported, this instance reads version %d", Version))
+	ErrFileTooLarge       = generic.NewWebError(http.StatusBadRequest, "archive_file_too_large", "Archive has a file that is too large")
+)
+
+var _ Handler = (*HandlerImpl)(nil)
+
+func NewHandler(
+	accountHandler account.Handler,
+	writer account.Writer,
+	analyticsHandler analytics.Handler,
+	themes *theme.Registry,
+) *HandlerImpl {
+	return &HandlerImpl{
+		accountHandler:   accountHandler,
+		writer:           writer,
+		analyticsHandler: analyticsHandler,
+		themes:           themes,
+	}
+}
+
+// ParseFormat reads json or zip, an empty string is json
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(s); f {
+	case "":
+		return JSON, nil
+	case JSON, Zip:
+		return f, nil
+	default:
+		return "", ErrInvalidFormat
+	}
+}
+
+// ContentType is what the archive is served as
+func (f Format) ContentType() string {
+	if f == Zip {
+		return "application/zip"
+	}
+
+	return "application/json"
+}
+
+func (s *HandlerImpl) Export(ctx context.Context, cmd *ExportCmd) ([]byte, error) {
+	if cmd.Format != JSON && cmd.Format != Zip {
+		return nil, ErrInvalidFormat
+	}
+
+	a, err := s.getAccount(ctx, cmd.AccountID)
+	if err != nil {
+		return nil, err
+	}
+
+	sum, err := s.analyticsHandler.Summarize(ctx, &analytics.SummarizeCmd{
+		AccountID: a.ID,
+		Window:    analytics.Quarter,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to summarize analytics: %w", err)
+	}
+
+	ar := &Archive{
+		Version:    Version,
+		ExportedAt: time.Now().UTC(),
+		Profile: Profile{
+			Name:      a.Name,
+			Handle:    a.Handle,
+			Email:     a.Email,
+			CSS:       a.CSS,
+			Theme:     a.Theme,
+			CreatedAt: a.InsertedAt,
+		},
+		Links:     make([]Link, len(a.Links)),
+		Analytics: newAnalytics(sum),
+	}
+
+	for i := range a.Links {
+		ar.Links[i] = Link{
+			Title:     a.Links[i].Title,
+			URL:       a.Links[i].Link,
+			CreatedAt: a.Links[i].InsertedAt,
+		}
+	}
+
+	if cmd.Format == JSON {
+		b, err := json.MarshalIndent(ar, "", "  ")
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode archive: %w", err)
+		}
+
+		return b, nil
+	}
+
+	return writeZip(a, ar)
+}
+
+func (s *HandlerImpl) Import(ctx context.Context, cmd *ImportCmd) (*account.Account, error) {
+	ar, files, err := read(cmd.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	a, err := s.getAccount(ctx, cmd.AccountID)
+	if err != nil {
+		return nil, err
+	}
+
+	a.Name = ar.Profile.Name
+	a.CSS = ar.Profile.CSS
+	a.Theme = ""
+	if s.themes.Has(ar.Profile.Theme) {
+		a.Theme = ar.Profile.Theme
+	}
+
+	a.Sanitize()
+	if err := a.Validate(); err != nil {
+		return nil, fmt.Errorf("failed to validate account: %w", err)
+	}
+
+	scaffolds := make([]account.LinkScaffold, len(ar.Links))
+	for i := range ar.Links {
+		scaffolds[i] = account.LinkScaffold{
+			Title: ar.Links[i].Title,
+			Link:  ar.Links[i].URL,
+		}
+	}
+
+	if err := a.SetLinks(scaffolds); err != nil {
+		return nil, fmt.Errorf("failed to set links: %w", err)
+	}
+
+	// An image that doesn't decode is left out rather than failing the
+	// whole import, favicons are fetched again and avatars uploaded again
+	for i := range ar.Links {
+		if b, ok := files[ar.Links[i].Favicon]; ok {
+			if icon, err := favicon.Normalize(b); err == nil {
+				a.Links[i].Favicon = icon
+			}
+		}
+	}
+
+	if b, ok := files[ar.Profile.Avatar]; ok {
+		if avi, thumbnail, err := account.EncodeAvatar(b); err == nil {
+			a.Avi = avi
+			a.AviThumbnail = thumbnail
+		}
+	}
+
+	if err := s.writer.SaveAccount(ctx, a); err != nil {
+		return nil, fmt.Errorf("failed to save account: %w", err)
+	}
+
+	return a, nil
+}
+
+func (s *HandlerImpl) getAccount(ctx context.Context, id uuid.UUID) (*account.Account, error) {
+	a, err := s.accountHandler.Get(ctx, &account.GetCmd{ID: id})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get account by id: %w", err)
+	}
+
+	if a == nil {
+		return nil, account.ErrAccountNotFound
+	}
+
+	return a, nil
+}
+
+func newAnalytics(sum *analytics.Summary) *Analytics {
+	an := &Analytics{
+		Days:        int(sum.Window),
+		From:        sum.From,
+		To:          sum.To,
+		TotalViews:  sum.TotalViews,
+		TotalClicks: sum.TotalClicks,
+		DailyViews:  make([]DailyViews, len(sum.Days)),
+		LinkClicks:  make([]LinkClicks, len(sum.Links)),
+		Referrers:   make([]ReferrerViews, len(sum.Referrers)),
+	}
+
+	for i, d := range sum.Days {
+		an.DailyViews[i] = DailyViews{Day: d.Day, Views: d.Views}
+	}
+
+	for i, l := range sum.Links {
+		an.LinkClicks[i] = LinkClicks{Title: l.Title, URL: l.Link, Clicks: l.Clicks}
+	}
+
+	for i, r := range sum.Referrers {
+		an.Referrers[i] = ReferrerViews{Referrer: r.Referrer, Views: r.Views}
+	}
+
+	return an
+}
+
+// writeZip names the images in the archive and puts them next to it
+func writeZip(a *account.Account, ar *Archive) ([]byte, error) {
+	var (
+		b  bytes.Buffer
+		zw = zip.NewWriter(&b)
+	)
+
+	if len(a.Avi) > 0 {
+		if f, err := imaging.Sniff(a.Avi); err == nil {
+			ar.Profile.Avatar = "avatar" + extension(f)
+		}
+	}
+
+	for i := range a.Links {
+		if len(a.Links[i].Favicon) > 0 {
+			ar.Links[i].Favicon = fmt.Sprintf("favicons/%d.png", i)
+		}
+	}
+
+	doc, err := json.MarshalIndent(ar, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode archive: %w", err)
+	}
+
+	if err := writeFile(zw, DocumentName, doc, ar.ExportedAt); err != nil {
+		return nil, err
+	}
+
+	if ar.Profile.Avatar != "" {
+		if err := writeFile(zw, ar.Profile.Avatar, a.Avi, ar.ExportedAt); err != nil {
+			return nil, err
+		}
+	}
+
+	for i := range ar.Links {
+		if ar.Links[i].Favicon != "" {
+			if err := writeFile(zw, ar.Links[i].Favicon, a.Links[i].Favicon, ar.ExportedAt); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		return nil, fmt.Errorf("failed to close zip: %w", err)
+	}
+
+	return b.Bytes(), nil
+}
+
+// writeFile only compresses the document, images are already compressed
+func writeFile(zw *zip.Writer, name string, b []byte, modified time.Time) error {
+	method := zip.Store
+	if name == DocumentName {
+		method = zip.Deflate
+	}
+
+	w, err := zw.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   method,
+		Modified: modified,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create %s in zip: %w", name, err)
+	}
+
+	if _, err := w.Write(b); err != nil {
+		return fmt.Errorf("failed to write %s to zip: %w", name, err)
+	}
+
+	return nil
+}
+
+// read decodes either format, files are empty for JSON
+func read(data []byte) (*Archive, map[string][]byte, error) {
+	var (
+		doc   = data
+		files = make(map[string][]byte)
+	)
+
+	if http.DetectContentType(data) == "application/zip" {
+		zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return nil, nil, ErrInvalidArchive
+		}
+
+		left := MaxSize
+		for _, f := range zr.File {
+			b, err := readFile(f, left)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			files[f.Name] = b
+			left -= len(b)
+		}
+
+		var ok bool
+		if doc, ok = files[DocumentName]; !ok {
+			return nil, nil, ErrInvalidArchive
+		}
+		delete(files, DocumentName)
+	}
+
+	var ar Archive
+	if err := json.Unmarshal(doc, &ar); err != nil {
+		return nil, nil, ErrInvalidArchive
+	}
+
+	if ar.Version == 0 {
+		return nil, nil, ErrInvalidArchive
+	}
+
+	if ar.Version != Version {
+		return nil, nil, ErrUnsupportedVersion
+	}
+
+	return &ar, files, nil
+}
+
+// readFile reads at most limit bytes, or MaxFileSize if that's less. It doesn't
+// trust the sizes in the zip's headers.
+func readFile(f *zip.File, limit int) ([]byte, error) {
+	if f.FileInfo().IsDir() {
+		return nil, nil
+	}
+
+	if limit > MaxFileSize {
+		limit = MaxFileSize
+	}
+
+	if f.UncompressedSize64 > uint64(limit) {
+		return nil, ErrFileTooLarge
+	}
+
+	rc, err := f.Open()
+	if err != nil {
+		return nil, ErrInvalidArchive
+	}
+	defer rc.Close()
+
+	b, err := io.ReadAll(io.LimitReader(rc, int64(limit)+1))
+	if err != nil {
+		if errors.Is(err, zip.ErrChecksum) || errors.Is(err, zip.ErrFormat) {
+			return nil, ErrInvalidArchive
+		}
+
+		return nil, fmt.Errorf("failed to read %s from zip: %w", f.Name, err)
+	}
+
+	if len(b) > limit {
+		return nil, ErrFileTooLarge
+	}
+
+	return b, nil
+}
+
+func extension(f imaging.Format) string {
+	switch f {
+	case imaging.JPEG:
+		return ".jpg"
+	case imaging.GIF:
+		return ".gif"
+	case imaging.WebP:
+		return ".webp"
+	default:
+		return ".png"
+	}
+}
@@ -0,0 +1,338 @@
+package archive_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+	"time"
+
+	"github.com/derinil/links/links/account"
+	"github.com/derinil/links/links/analytics"
+	"github.com/derinil/links/links/archive"
+	"github.com/derinil/links/links/theme"
+	"github.com/derinil/links/links/views"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+// FakeStore is an account reader and writer backed by a map
+type FakeStore struct {
+	accounts map[uuid.UUID]account.Account
+}
+
+func (s *FakeStore) Get(ctx context.Context, cmd *account.GetCmd) (*account.Account, error) {
+	if a, ok := s.accounts[cmd.ID]; ok {
+		a.Links = append([]account.Link(nil), a.Links...)
+		return &a, nil
+	}
+
+	return nil, nil
+}
+
+func (s *FakeStore) GetLink(ctx context.Context, cmd *account.GetLinkCmd) (*account.Link, error) {
+	return nil, nil
+}
+
+func (s *FakeStore) SaveAccount(ctx context.Context, a *account.Account) error {
+	s.accounts[a.ID] = *a
+	return nil
+}
+
+type FakeAnalytics struct{}
+
+func (FakeAnalytics) Summarize(ctx context.Context, cmd *analytics.SummarizeCmd) (*analytics.Summary, error) {
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	return &analytics.Summary{
+		Window:      cmd.Window,
+		From:        day,
+		To:          day,
+		Days:        []analytics.DailyViews{{Day: day, Views: 10}},
+		TotalViews:  10,
+		TotalClicks: 4,
+		Links: []analytics.LinkStats{{
+			LinkClicks: analytics.LinkClicks{Title: "Blog", Link: "https://blog.example.com", Clicks: 4},
+		}},
+		Referrers: []analytics.ReferrerViews{{Referrer: "https://news.example.com", Views: 6}},
+	}, nil
+}
+
+func icon(t *testing.T, c color.Color) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, 32, 32))
+	for y := 0; y < 32; y++ {
+		for x := 0; x < 32; x++ {
+			img.Set(x, y, c)
+		}
+	}
+
+	var b bytes.Buffer
+	require.NoError(t, png.Encode(&b, img))
+
+	return b.Bytes()
+}
+
+func newHandler(t *testing.T, accounts ...*account.Account) (*archive.HandlerImpl, *FakeStore) {
+	themes, err := theme.Load(views.ThemeFiles, "themes")
+	require.NoError(t, err)
+
+	store := &FakeStore{accounts: make(map[uuid.UUID]account.Account)}
+	for _, a := range accounts {
+		store.accounts[a.ID] = *a
+	}
+
+	accountHandler := account.NewHandler(store, store, time.Hour)
+
+	return archive.NewHandler(accountHandler, store, FakeAnalytics{}, themes), store
+}
+
+func source(t *testing.T) *account.Account {
+	a := account.New("Jane", "jane", "hash")
+	a.Email = "jane@example.com"
+	a.CSS = "a { color: red; }"
+	a.Theme = "midnight"
+
+	var err error
+	a.Avi, a.AviThumbnail, err = account.EncodeAvatar(icon(t, color.RGBA{0, 0, 255, 255}))
+	require.NoError(t, err)
+
+	require.NoError(t, a.SetLinks([]account.LinkScaffold{
+		{Title: "Blog", Link: "https://blog.example.com"},
+		{Title: "Code", Link: "https://code.example.com"},
+	}))
+	a.Links[0].Favicon = icon(t, color.RGBA{255, 0, 0, 255})
+
+	return a
+}
+
+func TestExport(t *testing.T) {
+	a := source(t)
+	archiveHandler, _ := newHandler(t, a)
+	ctx := context.Background()
+
+	b, err := archiveHandler.Export(ctx, &archive.ExportCmd{AccountID: a.ID, Format: archive.JSON})
+	require.NoError(t, err)
+
+	var ar archive.Archive
+	require.NoError(t, json.Unmarshal(b, &ar))
+	require.Equal(t, archive.Version, ar.Version)
+	require.Equal(t, "jane", ar.Profile.Handle)
+	require.Equal(t, "jane@example.com", ar.Profile.Email)
+	require.Equal(t, "midnight", ar.Profile.Theme)
+	require.Empty(t, ar.Profile.Avatar)
+	require.Len(t, ar.Links, 2)
+	require.Equal(t, "https://code.example.com", ar.Links[1].URL)
+	require.Empty(t, ar.Links[0].Favicon)
+	require.NotNil(t, ar.Analytics)
+	require.Equal(t, int(analytics.Quarter), ar.Analytics.Days)
+	require.Equal(t, 4, ar.Analytics.LinkClicks[0].Clicks)
+
+	b, err = archiveHandler.Export(ctx, &archive.ExportCmd{AccountID: a.ID, Format: archive.Zip})
+	require.NoError(t, err)
+
+	zr, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+	require.NoError(t, err)
+
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	// Opaque avatars are stored as JPEG
+	require.Equal(t, []string{archive.DocumentName, "avatar.jpg", "favicons/0.png"}, names)
+
+	_, err = archiveHandler.Export(ctx, &archive.ExportCmd{AccountID: a.ID, Format: "tar"})
+	require.ErrorIs(t, err, archive.ErrInvalidFormat)
+
+	_, err = archiveHandler.Export(ctx, &archive.ExportCmd{AccountID: uuid.New(), Format: archive.JSON})
+	require.ErrorIs(t, err, account.ErrAccountNotFound)
+}
+
+func TestImport(t *testing.T) {
+	var (
+		ctx = context.Background()
+		src = source(t)
+		dst = account.New("Jane Doe", "janedoe", "other")
+	)
+
+	dst.Email = "doe@example.com"
+	require.NoError(t, dst.SetLinks([]account.LinkScaffold{
+		{Title: "Old", Link: "https://old.example.com"},
+		{Title: "Code", Link: "https://code.example.com"},
+	}))
+	codeID := dst.Links[1].ID
+
+	archiveHandler, store := newHandler(t, src, dst)
+
+	for _, f := range []archive.Format{archive.JSON, archive.Zip} {
+		f := f
+		t.Run(string(f), func(t *testing.T) {
+			b, err := archiveHandler.Export(ctx, &archive.ExportCmd{AccountID: src.ID, Format: f})
+			require.NoError(t, err)
+
+			a, err := archiveHandler.Import(ctx, &archive.ImportCmd{AccountID: dst.ID, Data: b})
+			require.NoError(t, err)
+
+			saved := store.accounts[dst.ID]
+			require.Equal(t, a.Name, saved.Name)
+
+			// The account keeps what identifies it on this instance
+			require.Equal(t, "Jane", saved.Name)
+			require.Equal(t, "janedoe", saved.Handle)
+			require.Equal(t, "doe@example.com", saved.Email)
+			require.Equal(t, "other", saved.Password)
+			require.Equal(t, "#profile a {\n  color: red;\n}", saved.CSS)
+			require.Equal(t, "midnight", saved.Theme)
+
+			require.Len(t, saved.Links, 2)
+			require.Equal(t, "https://blog.example.com", saved.Links[0].Link)
+			require.Equal(t, "https://code.example.com", saved.Links[1].Link)
+			require.Equal(t, codeID, saved.Links[1].ID)
+			require.Equal(t, dst.ID, saved.Links[0].AccountID)
+			require.NotEmpty(t, saved.RemovedLinks)
+
+			if f == archive.Zip {
+				require.NotEmpty(t, saved.Avi)
+				require.NotEmpty(t, saved.AviThumbnail)
+				require.NotEmpty(t, saved.Links[0].Favicon)
+			} else {
+				require.Empty(t, saved.Avi)
+				require.Empty(t, saved.Links[0].Favicon)
+			}
+		})
+	}
+}
+
+func TestImportInvalid(t *testing.T) {
+	var (
+		ctx = context.Background()
+		dst = account.New("Jane", "jane", "hash")
+	)
+
+	archiveHandler, store := newHandler(t, dst)
+
+	doc := func(f func(ar *archive.Archive)) []byte {
+		ar := &archive.Archive{
+			Version: archive.Version,
+			Profile: archive.Profile{Name: "Jane"},
+			Links:   []archive.Link{{Title: "Blog", URL: "https://blog.example.com"}},
+		}
+		f(ar)
+
+		b, err := json.Marshal(ar)
+		require.NoError(t, err)
+
+		return b
+	}
+
+	bomb := func() []byte {
+		var b bytes.Buffer
+		zw := zip.NewWriter(&b)
+
+		w, err := zw.Create(archive.DocumentName)
+		require.NoError(t, err)
+		_, err = w.Write(bytes.Repeat([]byte(" "), archive.MaxFileSize+1))
+		require.NoError(t, err)
+		require.NoError(t, zw.Close())
+
+		return b.Bytes()
+	}
+
+	testCases := []struct {
+		name   string
+		data   []byte
+		err    error
+		errStr string
+	}{
+		{
+			name: "not an archive",
+			data: []byte("hello"),
+			err:  archive.ErrInvalidArchive,
+		},
+		{
+			name: "no version",
+			data: []byte(`{"profile": {"name": "Jane"}}`),
+			err:  archive.ErrInvalidArchive,
+		},
+		{
+			name: "newer version",
+			data: doc(func(ar *archive.Archive) { ar.Version = archive.Version + 1 }),
+			err:  archive.ErrUnsupportedVersion,
+		},
+		{
+			name: "zip without document",
+			data: func() []byte {
+				var b bytes.Buffer
+				zw := zip.NewWriter(&b)
+				_, err := zw.Create("avatar.png")
+				require.NoError(t, err)
+				require.NoError(t, zw.Close())
+				return b.Bytes()
+			}(),
+			err: archive.ErrInvalidArchive,
+		},
+		{
+			name: "file too large",
+			data: bomb(),
+			err:  archive.ErrFileTooLarge,
+		},
+		{
+			name:   "invalid link",
+			data:   doc(func(ar *archive.Archive) { ar.Links[0].URL = "not a url" }),
+			errStr: "failed to validate link",
+		},
+		{
+			name: "duplicate link",
+			data: doc(func(ar *archive.Archive) { ar.Links = append(ar.Links, ar.Links[0]) }),
+			err:  account.ErrDuplicateLink,
+		},
+	}
+
+	for _, c := range testCases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			_, err := archiveHandler.Import(ctx, &archive.ImportCmd{AccountID: dst.ID, Data: c.data})
+			require.Error(t, err)
+			if c.err != nil {
+				require.ErrorIs(t, err, c.err)
+			}
+			if c.errStr != "" {
+				require.ErrorContains(t, err, c.errStr)
+			}
+
+			require.Empty(t, store.accounts[dst.ID].Links)
+		})
+	}
+
+	// Unknown themes fall back to the default, CSS the policy doesn't
+	// allow and broken images are left out
+	var b bytes.Buffer
+	zw := zip.NewWriter(&b)
+	for name, content := range map[string]string{
+		archive.DocumentName: string(doc(func(ar *archive.Archive) {
+			ar.Profile.Theme = "nope"
+			ar.Profile.CSS = "a { color: red; behavior: url(x.htc) }"
+			ar.Profile.Avatar = "avatar.png"
+			ar.Links[0].Favicon = "favicons/0.png"
+		})),
+		"avatar.png":     "not an image",
+		"favicons/0.png": "not an image",
+	} {
+		w, err := zw.Create(name)
+		require.NoError(t, err)
+		_, err = w.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+
+	a, err := archiveHandler.Import(ctx, &archive.ImportCmd{AccountID: dst.ID, Data: b.Bytes()})
+	require.NoError(t, err)
+	require.Empty(t, a.Theme)
+	require.Equal(t, "#profile a {\n  color: red;\n}", a.CSS)
+	require.Empty(t, a.Avi)
+	require.Len(t, a.Links, 1)
+	require.Empty(t, a.Links[0].Favicon)
+}
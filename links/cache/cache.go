@@ -0,0 +1,25 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Cache stores byte values under string keys. Values can be evicted
+// before they expire, so it's never the only copy of anything important.
+type Cache interface {
+	// Get returns ErrNotFound if there is nothing under the key
+	Get(ctx context.Context, key string) ([]byte, error)
+	// Put stores the value without an expiry, replacing any TTL the key had
+	Put(ctx context.Context, key string, val []byte) error
+	// Invalidate deletes the key and reports whether there was anything under it
+	Invalidate(ctx context.Context, key string) (bool, error)
+	PutWithTTL(ctx context.Context, key string, val []byte, ttl time.Duration) error
+	// Increment adds one to the counter under the key in a single step and
+	// returns the new count. A new counter expires after ttl, counting doesn't
+	// move the expiry. Counters are stored as decimal strings.
+	Increment(ctx context.Context, key string, ttl time.Duration) (int64, error)
+}
+
+var ErrNotFound = errors.New("not found in cache")
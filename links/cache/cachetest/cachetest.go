@@ -0,0 +1,254 @@
+// Package cachetest has the tests every cache.Cache has to pass, and a small
+// Redis stand-in so the Redis cache can run them without a real server.
+package cachetest
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/derinil/links/links/cache"
+	"github.com/stretchr/testify/require"
+)
+
+// Run runs the conformance tests, newCache is called for every test and
+// gets to clean up after itself with t.Cleanup
+func Run(t *testing.T, newCache func(t *testing.T) cache.Cache) {
+	t.Run("get missing", func(t *testing.T) {
+		c := newCache(t)
+
+		_, err := c.Get(context.Background(), "missing")
+		require.ErrorIs(t, err, cache.ErrNotFound)
+	})
+
+	t.Run("put and get", func(t *testing.T) {
+		var (
+			ctx = context.Background()
+			c   = newCache(t)
+		)
+
+		require.NoError(t, c.Put(ctx, "key", []byte("value")))
+
+		v, err := c.Get(ctx, "key")
+		require.NoError(t, err)
+		require.Equal(t, []byte("value"), v)
+
+		require.NoError(t, c.Put(ctx, "key", []byte("other value")))
+
+		v, err = c.Get(ctx, "key")
+		require.NoError(t, err)
+		require.Equal(t, []byte("other value"), v)
+	})
+
+	t.Run("binary and empty values", func(t *testing.T) {
+		var (
+			ctx = context.Background()
+			c   = newCache(t)
+			bin = []byte{0, 1, 2, '\r', '\n', 255}
+		)
+
+		require.NoError(t, c.Put(ctx, "binary", bin))
+		require.NoError(t, c.Put(ctx, "empty", []byte{}))
+
+		v, err := c.Get(ctx, "binary")
+		require.NoError(t, err)
+		require.Equal(t, bin, v)
+
+		v, err = c.Get(ctx, "empty")
+		require.NoError(t, err)
+		require.Empty(t, v)
+	})
+
+	t.Run("values are copied", func(t *testing.T) {
+		var (
+			ctx = context.Background()
+			c   = newCache(t)
+			val = []byte("value")
+		)
+
+		require.NoError(t, c.Put(ctx, "key", val))
+		val[0] = 'V'
+
+		v, err := c.Get(ctx, "key")
+		require.NoError(t, err)
+		require.Equal(t, []byte("value"), v)
+
+		v[0] = 'V'
+
+		v, err = c.Get(ctx, "key")
+		require.NoError(t, err)
+		require.Equal(t, []byte("value"), v)
+	})
+
+	t.Run("ttl", func(t *testing.T) {
+		var (
+			ctx = context.Background()
+			c   = newCache(t)
+		)
+
+		require.NoError(t, c.PutWithTTL(ctx, "short", []byte("value"), 50*time.Millisecond))
+		require.NoError(t, c.PutWithTTL(ctx, "long", []byte("value"), time.Hour))
+
+		_, err := c.Get(ctx, "short")
+		require.NoError(t, err)
+
+		time.Sleep(100 * time.Millisecond)
+
+		_, err = c.Get(ctx, "short")
+		require.ErrorIs(t, err, cache.ErrNotFound)
+
+		_, err = c.Get(ctx, "long")
+		require.NoError(t, err)
+
+		ok, err := c.Invalidate(ctx, "short")
+		require.NoError(t, err)
+		require.False(t, ok)
+	})
+
+	t.Run("put clears the ttl", func(t *testing.T) {
+		var (
+			ctx = context.Background()
+			c   = newCache(t)
+		)
+
+		require.NoError(t, c.PutWithTTL(ctx, "key", []byte("value"), 50*time.Millisecond))
+		require.NoError(t, c.Put(ctx, "key", []byte("forever")))
+
+		time.Sleep(100 * time.Millisecond)
+
+		v, err := c.Get(ctx, "key")
+		require.NoError(t, err)
+		require.Equal(t, []byte("forever"), v)
+	})
+
+	t.Run("invalidate", func(t *testing.T) {
+		var (
+			ctx = context.Background()
+			c   = newCache(t)
+		)
+
+		require.NoError(t, c.Put(ctx, "key", []byte("value")))
+		require.NoError(t, c.Put(ctx, "other", []byte("value")))
+
+		ok, err := c.Invalidate(ctx, "key")
+		require.NoError(t, err)
+		require.True(t, ok)
+
+		// Only the first caller gets true, single use tokens depend on it
+		ok, err = c.Invalidate(ctx, "key")
+		require.NoError(t, err)
+		require.False(t, ok)
+
+		_, err = c.Get(ctx, "key")
+		require.ErrorIs(t, err, cache.ErrNotFound)
+
+		_, err = c.Get(ctx, "other")
+		require.NoError(t, err)
+	})
+
+	t.Run("increment", func(t *testing.T) {
+		var (
+			ctx = context.Background()
+			c   = newCache(t)
+		)
+
+		for i := int64(1); i <= 3; i++ {
+			n, err := c.Increment(ctx, "counter", 50*time.Millisecond)
+			require.NoError(t, err)
+			require.Equal(t, i, n)
+		}
+
+		v, err := c.Get(ctx, "counter")
+		require.NoError(t, err)
+		require.Equal(t, []byte("3"), v)
+
+		// Counting doesn't move the expiry, the counter starts over after it
+		time.Sleep(100 * time.Millisecond)
+
+		n, err := c.Increment(ctx, "counter", time.Hour)
+		require.NoError(t, err)
+		require.Equal(t, int64(1), n)
+
+		require.NoError(t, c.Put(ctx, "text", []byte("value")))
+
+		_, err = c.Increment(ctx, "text", time.Hour)
+		require.Error(t, err)
+	})
+
+	t.Run("concurrent increments", func(t *testing.T) {
+		var (
+			ctx = context.Background()
+			c   = newCache(t)
+			wg  sync.WaitGroup
+		)
+
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				for j := 0; j < 10; j++ {
+					if _, err := c.Increment(ctx, "counter", time.Minute); err != nil {
+						t.Error(err)
+						return
+					}
+				}
+			}()
+		}
+
+		wg.Wait()
+
+		n, err := c.Increment(ctx, "counter", time.Minute)
+		require.NoError(t, err)
+		require.Equal(t, int64(101), n)
+	})
+
+	t.Run("concurrent", func(t *testing.T) {
+		var (
+			ctx = context.Background()
+			c   = newCache(t)
+			wg  sync.WaitGroup
+			won = make(chan string, 100)
+		)
+
+		require.NoError(t, c.Put(ctx, "once", []byte("value")))
+
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+
+				for j := 0; j < 10; j++ {
+					key := fmt.Sprintf("key-%d-%d", i, j)
+					if err := c.PutWithTTL(ctx, key, []byte(key), time.Minute); err != nil {
+						t.Error(err)
+						return
+					}
+
+					v, err := c.Get(ctx, key)
+					if err != nil || string(v) != key {
+						t.Error("got", string(v), err)
+						return
+					}
+				}
+
+				ok, err := c.Invalidate(ctx, "once")
+				if err != nil {
+					t.Error(err)
+					return
+				}
+
+				if ok {
+					won <- fmt.Sprint(i)
+				}
+			}(i)
+		}
+
+		wg.Wait()
+		close(won)
+
+		require.Len(t, won, 1)
+	})
+}
@@ -0,0 +1,287 @@
+package cachetest
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// RedisServer speaks just enough RESP2 for cache.Redis: PING, GET, SET
+	// with EX or PX, DEL, INCR and PEXPIRE. Everything else is an unknown command.
+	RedisServer struct {
+		Addr string
+
+		ln net.Listener
+		wg sync.WaitGroup
+
+		sync.Mutex
+		values map[string]redisValue
+		conns  map[net.Conn]struct{}
+		closed bool
+	}
+
+	redisValue struct {
+		val       []byte
+		expiresAt time.Time
+	}
+)
+
+// NewRedisServer listens on a random local port, call Close when done with it
+func NewRedisServer() (*RedisServer, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen: %w", err)
+	}
+
+	s := &RedisServer{
+		Addr:   ln.Addr().String(),
+		ln:     ln,
+		values: make(map[string]redisValue),
+		conns:  make(map[net.Conn]struct{}),
+	}
+
+	s.wg.Add(1)
+	go s.accept()
+
+	return s, nil
+}
+
+// Close closes the listener and every connection, and waits for them to finish
+func (s *RedisServer) Close() error {
+	err := s.ln.Close()
+
+	s.Lock()
+	s.closed = true
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.Unlock()
+
+	s.wg.Wait()
+	return err
+}
+
+func (s *RedisServer) accept() {
+	defer s.wg.Done()
+
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+
+		s.Lock()
+		if s.closed {
+			s.Unlock()
+			conn.Close()
+			return
+		}
+		s.conns[conn] = struct{}{}
+		s.Unlock()
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.serve(conn)
+		}()
+	}
+}
+
+func (s *RedisServer) serve(conn net.Conn) {
+	defer func() {
+		s.Lock()
+		delete(s.conns, conn)
+		s.Unlock()
+
+		conn.Close()
+	}()
+
+	var (
+		r = bufio.NewReader(conn)
+		w = bufio.NewWriter(conn)
+	)
+
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+
+		s.handle(w, args)
+
+		if err := w.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+func (s *RedisServer) handle(w *bufio.Writer, args []string) {
+	if len(args) == 0 {
+		fmt.Fprint(w, "-ERR empty command\r\n")
+		return
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	switch strings.ToUpper(args[0]) {
+	case "PING":
+		fmt.Fprint(w, "+PONG\r\n")
+	case "GET":
+		if len(args) != 2 {
+			fmt.Fprint(w, "-ERR wrong number of arguments for 'get' command\r\n")
+			return
+		}
+
+		v, ok := s.get(args[1])
+		if !ok {
+			fmt.Fprint(w, "$-1\r\n")
+			return
+		}
+
+		fmt.Fprintf(w, "$%d\r\n%s\r\n", len(v.val), v.val)
+	case "SET":
+		if len(args) != 3 && len(args) != 5 {
+			fmt.Fprint(w, "-ERR syntax error\r\n")
+			return
+		}
+
+		v := redisValue{val: []byte(args[2])}
+
+		if len(args) == 5 {
+			n, err := strconv.Atoi(args[4])
+			if err != nil || n <= 0 {
+				fmt.Fprint(w, "-ERR invalid expire time in 'set' command\r\n")
+				return
+			}
+
+			switch strings.ToUpper(args[3]) {
+			case "EX":
+				v.expiresAt = time.Now().Add(time.Duration(n) * time.Second)
+			case "PX":
+				v.expiresAt = time.Now().Add(time.Duration(n) * time.Millisecond)
+			default:
+				fmt.Fprint(w, "-ERR syntax error\r\n")
+				return
+			}
+		}
+
+		s.values[args[1]] = v
+		fmt.Fprint(w, "+OK\r\n")
+	case "DEL":
+		n := 0
+		for _, k := range args[1:] {
+			if _, ok := s.get(k); ok {
+				n++
+			}
+			delete(s.values, k)
+		}
+
+		fmt.Fprintf(w, ":%d\r\n", n)
+	case "INCR":
+		if len(args) != 2 {
+			fmt.Fprint(w, "-ERR wrong number of arguments for 'incr' command\r\n")
+			return
+		}
+
+		v, ok := s.get(args[1])
+
+		n := 0
+		if ok {
+			var err error
+			if n, err = strconv.Atoi(string(v.val)); err != nil {
+				fmt.Fprint(w, "-ERR value is not an integer or out of range\r\n")
+				return
+			}
+		}
+
+		n++
+		v.val = []byte(strconv.Itoa(n))
+		s.values[args[1]] = v
+		fmt.Fprintf(w, ":%d\r\n", n)
+	case "PEXPIRE":
+		if len(args) != 3 {
+			fmt.Fprint(w, "-ERR wrong number of arguments for 'pexpire' command\r\n")
+			return
+		}
+
+		ms, err := strconv.Atoi(args[2])
+		if err != nil {
+			fmt.Fprint(w, "-ERR value is not an integer or out of range\r\n")
+			return
+		}
+
+		v, ok := s.get(args[1])
+		if !ok {
+			fmt.Fprint(w, ":0\r\n")
+			return
+		}
+
+		v.expiresAt = time.Now().Add(time.Duration(ms) * time.Millisecond)
+		s.values[args[1]] = v
+		fmt.Fprint(w, ":1\r\n")
+	default:
+		fmt.Fprintf(w, "-ERR unknown command '%s'\r\n", args[0])
+	}
+}
+
+// get expects the lock to be held
+func (s *RedisServer) get(key string) (redisValue, bool) {
+	v, ok := s.values[key]
+	if !ok {
+		return v, false
+	}
+
+	if !v.expiresAt.IsZero() && !time.Now().Before(v.expiresAt) {
+		delete(s.values, key)
+		return v, false
+	}
+
+	return v, true
+}
+
+// readCommand reads an array of bulk strings, which is all clients send
+func readCommand(r *bufio.Reader) ([]string, error) {
+	n, err := readLength(r, '*')
+	if err != nil {
+		return nil, err
+	}
+
+	args := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		l, err := readLength(r, '$')
+		if err != nil {
+			return nil, err
+		}
+
+		b := make([]byte, l+2)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+
+		args = append(args, string(b[:l]))
+	}
+
+	return args, nil
+}
+
+func readLength(r *bufio.Reader, prefix byte) (int, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return 0, err
+	}
+
+	line = strings.TrimSuffix(line, "\r\n")
+	if len(line) < 2 || line[0] != prefix {
+		return 0, errors.New("unexpected line: " + line)
+	}
+
+	return strconv.Atoi(line[1:])
+}
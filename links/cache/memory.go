@@ -0,0 +1,260 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type (
+	// Memory keeps everything in the process, for deployments with a single
+	// instance and for tests. It forgets everything on restart, so sessions
+	// and pending logins don't survive a deploy.
+	Memory struct {
+		mu    sync.Mutex
+		items map[string]*list.Element
+		// Most recently used at the front
+		lru        *list.List
+		size       int64
+		maxEntries int
+		maxBytes   int64
+
+		stop chan struct{}
+		done chan struct{}
+	}
+
+	// MemoryConfig bounds the cache, zero values mean no bound
+	MemoryConfig struct {
+		// Least recently used entries are evicted to stay under these
+		MaxEntries int
+		// Counts the keys and the values
+		MaxBytes int64
+		// How often expired entries are cleaned up, they're never
+		// returned after they expire either way
+		JanitorInterval time.Duration
+	}
+
+	memoryEntry struct {
+		key string
+		val []byte
+		// Zero if the entry doesn't expire
+		expiresAt time.Time
+	}
+)
+
+const DefaultJanitorInterval = time.Minute
+
+var _ Cache = (*Memory)(nil)
+
+// NewMemory starts the janitor, Close stops it
+func NewMemory(config MemoryConfig) *Memory {
+	if config.JanitorInterval <= 0 {
+		config.JanitorInterval = DefaultJanitorInterval
+	}
+
+	m := &Memory{
+		items:      make(map[string]*list.Element),
+		lru:        list.New(),
+		maxEntries: config.MaxEntries,
+		maxBytes:   config.MaxBytes,
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+
+	go m.janitor(config.JanitorInterval)
+
+	return m
+}
+
+func (s *Memory) Get(ctx context.Context, key string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	el, ok := s.items[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	e := el.Value.(*memoryEntry)
+	if e.expired(time.Now()) {
+		s.remove(el)
+		return nil, ErrNotFound
+	}
+
+	s.lru.MoveToFront(el)
+
+	// Callers own what they get back, same as with Redis
+	return append([]byte{}, e.val...), nil
+}
+
+func (s *Memory) Put(ctx context.Context, key string, val []byte) error {
+	s.put(key, val, time.Time{})
+	return nil
+}
+
+func (s *Memory) PutWithTTL(ctx context.Context, key string, val []byte, ttl time.Duration) error {
+	var expiresAt time.Time
+	if ttl > 0 {
+		expiresAt = time.Now().Add(ttl)
+	}
+
+	s.put(key, val, expiresAt)
+	return nil
+}
+
+func (s *Memory) Increment(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var (
+		n         int64
+		now       = time.Now()
+		expiresAt time.Time
+	)
+
+	if ttl > 0 {
+		expiresAt = now.Add(ttl)
+	}
+
+	if el, ok := s.items[key]; ok {
+		if e := el.Value.(*memoryEntry); !e.expired(now) {
+			v, err := strconv.ParseInt(string(e.val), 10, 64)
+			if err != nil {
+				return 0, fmt.Errorf("failed to increment %s: value is not an integer", key)
+			}
+
+			n, expiresAt = v, e.expiresAt
+		}
+	}
+
+	n++
+	s.putLocked(&memoryEntry{
+		key:       key,
+		val:       []byte(strconv.FormatInt(n, 10)),
+		expiresAt: expiresAt,
+	})
+
+	return n, nil
+}
+
+func (s *Memory) Invalidate(ctx context.Context, key string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	el, ok := s.items[key]
+	if !ok {
+		return false, nil
+	}
+
+	expired := el.Value.(*memoryEntry).expired(time.Now())
+	s.remove(el)
+
+	return !expired, nil
+}
+
+// Len returns how many entries there are, including expired ones the janitor hasn't got to yet
+func (s *Memory) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.lru.Len()
+}
+
+// Close stops the janitor, the cache keeps working without it
+func (s *Memory) Close() error {
+	select {
+	case <-s.stop:
+	default:
+		close(s.stop)
+	}
+
+	<-s.done
+
+	return nil
+}
+
+func (s *Memory) put(key string, val []byte, expiresAt time.Time) {
+	e := &memoryEntry{
+		key:       key,
+		val:       append([]byte{}, val...),
+		expiresAt: expiresAt,
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.putLocked(e)
+}
+
+// putLocked expects the lock to be held
+func (s *Memory) putLocked(e *memoryEntry) {
+	key := e.key
+
+	if el, ok := s.items[key]; ok {
+		s.remove(el)
+	}
+
+	// A single entry over the bound would evict everything else and then itself
+	if s.maxBytes > 0 && e.size() > s.maxBytes {
+		return
+	}
+
+	s.items[key] = s.lru.PushFront(e)
+	s.size += e.size()
+
+	for s.overBounds() {
+		s.remove(s.lru.Back())
+	}
+}
+
+func (s *Memory) overBounds() bool {
+	return (s.maxEntries > 0 && s.lru.Len() > s.maxEntries) ||
+		(s.maxBytes > 0 && s.size > s.maxBytes)
+}
+
+// remove expects the lock to be held
+func (s *Memory) remove(el *list.Element) {
+	e := s.lru.Remove(el).(*memoryEntry)
+	delete(s.items, e.key)
+	s.size -= e.size()
+}
+
+func (s *Memory) janitor(interval time.Duration) {
+	defer close(s.done)
+
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case now := <-t.C:
+			s.removeExpired(now)
+		}
+	}
+}
+
+func (s *Memory) removeExpired(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for el := s.lru.Back(); el != nil; {
+		prev := el.Prev()
+		if el.Value.(*memoryEntry).expired(now) {
+			s.remove(el)
+		}
+		el = prev
+	}
+}
+
+func (e *memoryEntry) expired(now time.Time) bool {
+	return !e.expiresAt.IsZero() && !now.Before(e.expiresAt)
+}
+
+func (e *memoryEntry) size() int64 {
+	return int64(len(e.key) + len(e.val))
+}
@@ -0,0 +1,140 @@
+package cache_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/derinil/links/links/cache"
+	"github.com/derinil/links/links/cache/cachetest"
+	"github.com/stretchr/testify/require"
+)
+
+func newMemory(t *testing.T, config cache.MemoryConfig) *cache.Memory {
+	m := cache.NewMemory(config)
+	t.Cleanup(func() {
+		require.NoError(t, m.Close())
+	})
+
+	return m
+}
+
+func TestMemoryConformance(t *testing.T) {
+	cachetest.Run(t, func(t *testing.T) cache.Cache {
+		return newMemory(t, cache.MemoryConfig{})
+	})
+}
+
+func TestMemoryMaxEntries(t *testing.T) {
+	var (
+		ctx = context.Background()
+		m   = newMemory(t, cache.MemoryConfig{MaxEntries: 3})
+	)
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, m.Put(ctx, fmt.Sprint(i), []byte("value")))
+	}
+
+	// Using 0 makes 1 the least recently used
+	_, err := m.Get(ctx, "0")
+	require.NoError(t, err)
+
+	require.NoError(t, m.Put(ctx, "3", []byte("value")))
+	require.Equal(t, 3, m.Len())
+
+	_, err = m.Get(ctx, "1")
+	require.ErrorIs(t, err, cache.ErrNotFound)
+
+	for _, k := range []string{"0", "2", "3"} {
+		_, err := m.Get(ctx, k)
+		require.NoError(t, err, k)
+	}
+
+	// Replacing a value doesn't evict anything
+	require.NoError(t, m.Put(ctx, "3", []byte("other value")))
+	require.Equal(t, 3, m.Len())
+}
+
+func TestMemoryMaxBytes(t *testing.T) {
+	var (
+		ctx = context.Background()
+		// Each entry below is 10 bytes with its key
+		m = newMemory(t, cache.MemoryConfig{MaxBytes: 25})
+	)
+
+	require.NoError(t, m.Put(ctx, "a", []byte("123456789")))
+	require.NoError(t, m.Put(ctx, "b", []byte("123456789")))
+	require.NoError(t, m.Put(ctx, "c", []byte("123456789")))
+	require.Equal(t, 2, m.Len())
+
+	_, err := m.Get(ctx, "a")
+	require.ErrorIs(t, err, cache.ErrNotFound)
+
+	// Too large to ever fit, so it's not stored and nothing else is evicted
+	require.NoError(t, m.Put(ctx, "big", make([]byte, 30)))
+	require.Equal(t, 2, m.Len())
+
+	_, err = m.Get(ctx, "big")
+	require.ErrorIs(t, err, cache.ErrNotFound)
+
+	// Shrinking a value frees up room
+	require.NoError(t, m.Put(ctx, "b", []byte("1")))
+	require.NoError(t, m.Put(ctx, "d", []byte("123456789")))
+	require.Equal(t, 3, m.Len())
+}
+
+func TestMemoryJanitor(t *testing.T) {
+	var (
+		ctx = context.Background()
+		m   = newMemory(t, cache.MemoryConfig{JanitorInterval: 10 * time.Millisecond})
+	)
+
+	require.NoError(t, m.PutWithTTL(ctx, "short", []byte("value"), 20*time.Millisecond))
+	require.NoError(t, m.PutWithTTL(ctx, "long", []byte("value"), time.Hour))
+	require.NoError(t, m.Put(ctx, "forever", []byte("value")))
+
+	// Expired entries go away without anyone asking for them
+	require.Eventually(t, func() bool {
+		return m.Len() == 2
+	}, time.Second, 10*time.Millisecond)
+
+	require.NoError(t, m.Close())
+	require.NoError(t, m.Close())
+
+	// The cache still works without the janitor
+	_, err := m.Get(ctx, "long")
+	require.NoError(t, err)
+}
+
+func TestMemoryExpiredEntries(t *testing.T) {
+	var (
+		ctx = context.Background()
+		// The janitor never gets to run here
+		m = newMemory(t, cache.MemoryConfig{JanitorInterval: time.Hour})
+	)
+
+	require.NoError(t, m.PutWithTTL(ctx, "a", []byte("value"), 10*time.Millisecond))
+	require.NoError(t, m.PutWithTTL(ctx, "b", []byte("value"), 10*time.Millisecond))
+	require.NoError(t, m.PutWithTTL(ctx, "c", []byte("value"), time.Hour))
+	time.Sleep(20 * time.Millisecond)
+
+	require.Equal(t, 3, m.Len())
+
+	// Reading an expired entry drops it
+	_, err := m.Get(ctx, "a")
+	require.ErrorIs(t, err, cache.ErrNotFound)
+	require.Equal(t, 2, m.Len())
+
+	// Expired entries don't count as there, but they're gone after
+	ok, err := m.Invalidate(ctx, "b")
+	require.NoError(t, err)
+	require.False(t, ok)
+	require.Equal(t, 1, m.Len())
+
+	// Putting again brings the key back with the new TTL
+	require.NoError(t, m.PutWithTTL(ctx, "a", []byte("other"), time.Hour))
+	v, err := m.Get(ctx, "a")
+	require.NoError(t, err)
+	require.Equal(t, []byte("other"), v)
+}
@@ -2,24 +2,16 @@ package cache
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
 	"github.com/redis/go-redis/v9"
 )
 
-type (
-	Cache interface {
-		Get(ctx context.Context, key string) ([]byte, error)
-		Put(ctx context.Context, key string, val []byte) error
-		Invalidate(ctx context.Context, key string) (bool, error)
-		PutWithTTL(ctx context.Context, key string, val []byte, ttl time.Duration) error
-	}
-
-	Redis struct {
-		r *redis.Client
-	}
-)
+type Redis struct {
+	r *redis.Client
+}
 
 var _ Cache = (*Redis)(nil)
 
@@ -38,6 +30,10 @@ func NewRedis(ctx context.Context, addr, password string) (*Redis, error) {
 
 func (s *Redis) Get(ctx context.Context, key string) ([]byte, error) {
 	sc := s.r.Get(ctx, key)
+	if errors.Is(sc.Err(), redis.Nil) {
+		return nil, ErrNotFound
+	}
+
 	if sc.Err() != nil {
 		return nil, sc.Err()
 	}
@@ -55,6 +51,23 @@ func (s *Redis) PutWithTTL(ctx context.Context, key string, val []byte, ttl time
 	return sc.Err()
 }
 
+// Increment sets the TTL right after the first INCR, the counter only
+// outlives it if the process stops in between
+func (s *Redis) Increment(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	n, err := s.r.Incr(ctx, key).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	if n == 1 && ttl > 0 {
+		if err := s.r.PExpire(ctx, key, ttl).Err(); err != nil {
+			return 0, err
+		}
+	}
+
+	return n, nil
+}
+
 func (s *Redis) Invalidate(ctx context.Context, key string) (bool, error) {
 	sc := s.r.Del(ctx, key)
 	return sc.Val() > 0, sc.Err()
@@ -0,0 +1,27 @@
+package cache_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/derinil/links/links/cache"
+	"github.com/derinil/links/links/cache/cachetest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedisConformance(t *testing.T) {
+	cachetest.Run(t, func(t *testing.T) cache.Cache {
+		srv, err := cachetest.NewRedisServer()
+		require.NoError(t, err)
+
+		r, err := cache.NewRedis(context.Background(), srv.Addr, "")
+		require.NoError(t, err)
+
+		t.Cleanup(func() {
+			require.NoError(t, r.Close())
+			require.NoError(t, srv.Close())
+		})
+
+		return r
+	})
+}
@@ -0,0 +1,180 @@
+package crypto
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+type PasswordParams struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+/*
+	Password Hashing:
+		- Passwords are hashed with Argon2id using a random salt per password.
+		- The result is stored in the PHC string format so the parameters travel
+			with the hash and can be tuned later without breaking old hashes:
+			$argon2id$v=19$m=<memory>,t=<iterations>,p=<parallelism>$<b64 salt>$<b64 key>
+		- Hashes created before Argon2id are hex encoded salted Sha256 hashes
+			seeded with the handle. They are still accepted by ComparePassword
+			and reported by NeedsRehash so they can be upgraded on login.
+		- When the handle of an account with a legacy hash changes, the old handle
+			is pinned into the hash with PinLegacySeed so the hash stays valid:
+			$sha256$s=<seed>$<hex hash>
+*/
+
+const (
+	argon2idPrefix     = "$argon2id$"
+	pinnedSha256Prefix = "$sha256$"
+)
+
+// RFC 9106 recommends at least 64 MiB of memory when 2 GiB is not feasible
+var DefaultPasswordParams = &PasswordParams{
+	Memory:      64 * 1024,
+	Iterations:  3,
+	Parallelism: 2,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+var ErrInvalidPasswordHash = errors.New("invalid password hash")
+
+func HashPassword(password string) (string, error) {
+	return HashPasswordWithParams(password, DefaultPasswordParams)
+}
+
+func HashPasswordWithParams(password string, p *PasswordParams) (string, error) {
+	salt, err := ReadBytes(int(p.SaltLength))
+	if err != nil {
+		return "", fmt.Errorf("failed to read salt: %w", err)
+	}
+
+	key := argon2.IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Parallelism, p.KeyLength)
+
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2idPrefix,
+		argon2.Version,
+		p.Memory,
+		p.Iterations,
+		p.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// ComparePassword checks the password against an Argon2id PHC string, or
+// against a legacy Sha256 hash in which case seed is used as the salt.
+func ComparePassword(password, seed, hash string) (bool, error) {
+	if strings.HasPrefix(hash, pinnedSha256Prefix) {
+		// "", "sha256", "s=<seed>", hash
+		args := strings.Split(hash, "$")
+		if len(args) != 4 || !strings.HasPrefix(args[2], "s=") {
+			return false, ErrInvalidPasswordHash
+		}
+
+		return CompareSha256(password, strings.TrimPrefix(args[2], "s="), args[3])
+	}
+
+	if !strings.HasPrefix(hash, argon2idPrefix) {
+		return CompareSha256(password, seed, hash)
+	}
+
+	p, salt, key, err := decodePasswordHash(hash)
+	if err != nil {
+		return false, fmt.Errorf("failed to decode password hash: %w", err)
+	}
+
+	nk := argon2.IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Parallelism, p.KeyLength)
+
+	return subtle.ConstantTimeCompare(nk, key) == 1, nil
+}
+
+// PinLegacySeed stores the seed alongside a legacy Sha256 hash so it no longer
+// depends on the seed passed to ComparePassword. Other hashes are returned as is.
+func PinLegacySeed(hash, seed string) string {
+	if strings.HasPrefix(hash, "$") {
+		return hash
+	}
+
+	return fmt.Sprintf("%ss=%s$%s", pinnedSha256Prefix, seed, hash)
+}
+
+// NeedsRehash reports whether the hash was created with anything other
+// than Argon2id and the default parameters.
+func NeedsRehash(hash string) bool {
+	if !strings.HasPrefix(hash, argon2idPrefix) {
+		return true
+	}
+
+	p, salt, _, err := decodePasswordHash(hash)
+	if err != nil {
+		return true
+	}
+
+	return p.Memory != DefaultPasswordParams.Memory ||
+		p.Iterations != DefaultPasswordParams.Iterations ||
+		p.Parallelism != DefaultPasswordParams.Parallelism ||
+		p.KeyLength != DefaultPasswordParams.KeyLength ||
+		uint32(len(salt)) != DefaultPasswordParams.SaltLength
+}
+
+func decodePasswordHash(hash string) (*PasswordParams, []byte, []byte, error) {
+	// "", "argon2id", "v=19", "m=..,t=..,p=..", salt, key
+	args := strings.Split(hash, "$")
+	if len(args) != 6 {
+		return nil, nil, nil, ErrInvalidPasswordHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(args[2], "v=%d", &version); err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to parse version: %w", err)
+	}
+
+	if version != argon2.Version {
+		return nil, nil, nil, fmt.Errorf("%w: unsupported version %d", ErrInvalidPasswordHash, version)
+	}
+
+	var p PasswordParams
+	if _, err := fmt.Sscanf(args[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Iterations, &p.Parallelism); err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to parse params: %w", err)
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(args[4])
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to decode salt: %w", err)
+	}
+
+	key, err := base64.RawStdEncoding.DecodeString(args[5])
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to decode key: %w", err)
+	}
+
+	// argon2.IDKey panics on zero iterations or parallelism, and an
+	// empty salt or key would make any password compare equal or not at all
+	if p.Iterations == 0 || p.Parallelism == 0 {
+		return nil, nil, nil, fmt.Errorf("%w: iterations and parallelism must be at least 1", ErrInvalidPasswordHash)
+	}
+
+	if len(salt) == 0 || len(key) == 0 {
+		return nil, nil, nil, fmt.Errorf("%w: empty salt or key", ErrInvalidPasswordHash)
+	}
+
+	p.SaltLength = uint32(len(salt))
+	p.KeyLength = uint32(len(key))
+
+	// The key is derived with KeyLength so it has to describe the stored key
+	if int(p.KeyLength) != len(key) {
+		return nil, nil, nil, fmt.Errorf("%w: key length %d does not match key", ErrInvalidPasswordHash, p.KeyLength)
+	}
+
+	return &p, salt, key, nil
+}
@@ -0,0 +1,209 @@
+package crypto_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/derinil/links/links/crypto"
+	"github.com/stretchr/testify/require"
+)
+
+func TestComparePassword(t *testing.T) {
+	var (
+		weakParams = &crypto.PasswordParams{
+			Memory:      1024,
+			Iterations:  1,
+			Parallelism: 1,
+			SaltLength:  16,
+			KeyLength:   32,
+		}
+		argonHash = must(crypto.HashPasswordWithParams("test", weakParams))
+		// crypto.Sha256("test", "hello")
+		legacyHash = "491738c463d855d98ecb21a025e06b093fa08d13b382d17b93db9cd0c40318fd"
+	)
+
+	testCases := []struct {
+		name, password, seed string
+		hash                 string
+		same                 bool
+		err                  string
+	}{
+		{
+			name:     "good argon2id hash",
+			password: "test",
+			hash:     argonHash,
+			same:     true,
+		},
+		{
+			name:     "seed is ignored for argon2id",
+			password: "test",
+			seed:     "whatever",
+			hash:     argonHash,
+			same:     true,
+		},
+		{
+			name:     "bad password",
+			password: "hello",
+			hash:     argonHash,
+		},
+		{
+			name:     "empty password",
+			password: "",
+			hash:     argonHash,
+		},
+		{
+			name:     "good legacy hash",
+			password: "test",
+			seed:     "hello",
+			hash:     legacyHash,
+			same:     true,
+		},
+		{
+			name:     "legacy hash with bad seed",
+			password: "test",
+			seed:     "test",
+			hash:     legacyHash,
+		},
+		{
+			name:     "pinned legacy hash ignores new seed",
+			password: "test",
+			seed:     "newhandle",
+			hash:     crypto.PinLegacySeed(legacyHash, "hello"),
+			same:     true,
+		},
+		{
+			name:     "pinned legacy hash with bad password",
+			password: "hello",
+			seed:     "hello",
+			hash:     crypto.PinLegacySeed(legacyHash, "hello"),
+		},
+		{
+			name:     "pinning leaves argon2id alone",
+			password: "test",
+			hash:     crypto.PinLegacySeed(argonHash, "hello"),
+			same:     true,
+		},
+		{
+			name:     "malformed pinned legacy hash",
+			password: "test",
+			hash:     "$sha256$hello$" + legacyHash,
+			err:      "invalid password hash",
+		},
+		{
+			name:     "truncated argon2id hash",
+			password: "test",
+			hash:     "$argon2id$v=19$m=1024,t=1,p=1$c2FsdA",
+			err:      "invalid password hash",
+		},
+		{
+			name:     "unsupported version",
+			password: "test",
+			hash:     strings.Replace(argonHash, "v=19", "v=16", 1),
+			err:      "unsupported version",
+		},
+		{
+			name:     "garbage params",
+			password: "test",
+			hash:     strings.Replace(argonHash, "m=1024", "m=lots", 1),
+			err:      "failed to parse params",
+		},
+		{
+			name:     "zero parallelism",
+			password: "test",
+			hash:     strings.Replace(argonHash, "p=1", "p=0", 1),
+			err:      "invalid password hash",
+		},
+		{
+			name:     "zero iterations",
+			password: "test",
+			hash:     strings.Replace(argonHash, "t=1", "t=0", 1),
+			err:      "invalid password hash",
+		},
+		{
+			name:     "empty salt",
+			password: "test",
+			hash:     "$argon2id$v=19$m=1024,t=1,p=1$$" + argonHash[strings.LastIndex(argonHash, "$")+1:],
+			err:      "invalid password hash",
+		},
+		{
+			name:     "empty key",
+			password: "test",
+			hash:     argonHash[:strings.LastIndex(argonHash, "$")+1],
+			err:      "invalid password hash",
+		},
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			ok, err := crypto.ComparePassword(c.password, c.seed, c.hash)
+			if c.err != "" {
+				require.ErrorContains(t, err, c.err)
+				return
+			}
+
+			require.Nil(t, err)
+			require.Equal(t, c.same, ok)
+		})
+	}
+}
+
+func TestHashPasswordUniqueSalt(t *testing.T) {
+	h1 := must(crypto.HashPassword("password"))
+	h2 := must(crypto.HashPassword("password"))
+
+	require.NotEqual(t, h1, h2)
+	require.True(t, strings.HasPrefix(h1, "$argon2id$v=19$m=65536,t=3,p=2$"))
+}
+
+func TestNeedsRehash(t *testing.T) {
+	testCases := []struct {
+		name   string
+		hash   string
+		rehash bool
+	}{
+		{
+			name: "default params",
+			hash: must(crypto.HashPassword("password")),
+		},
+		{
+			name: "weaker params",
+			hash: must(crypto.HashPasswordWithParams("password", &crypto.PasswordParams{
+				Memory:      1024,
+				Iterations:  1,
+				Parallelism: 1,
+				SaltLength:  16,
+				KeyLength:   32,
+			})),
+			rehash: true,
+		},
+		{
+			name:   "legacy sha256",
+			hash:   must(crypto.Sha256("password", "handle")),
+			rehash: true,
+		},
+		{
+			name:   "pinned legacy sha256",
+			hash:   crypto.PinLegacySeed(must(crypto.Sha256("password", "handle")), "handle"),
+			rehash: true,
+		},
+		{
+			name:   "garbage",
+			hash:   "$argon2id$garbage",
+			rehash: true,
+		},
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			require.Equal(t, c.rehash, crypto.NeedsRehash(c.hash))
+		})
+	}
+}
+
+func must(s string, err error) string {
+	if err != nil {
+		panic(err)
+	}
+
+	return s
+}
@@ -0,0 +1,327 @@
+package css
+
+import (
+	"fmt"
+	"strings"
+)
+
+type (
+	// Rule is a style rule, or an at-rule if Name is set. Blocks are kept
+	// as tokens since what's in them depends on the rule.
+	Rule struct {
+		Name     string
+		Prelude  []Token
+		Block    []Token
+		HasBlock bool
+		Pos      Pos
+	}
+
+	Declaration struct {
+		Name      string
+		Value     []Token
+		Important bool
+		Pos       Pos
+	}
+
+	Error struct {
+		Pos
+		Msg string
+	}
+
+	// parser follows https://www.w3.org/TR/css-syntax-3/#parsing, except that
+	// it reports everything it skips so users know why their CSS is missing
+	parser struct {
+		tokens []Token
+		i      int
+		errors []*Error
+	}
+)
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("line %d, column %d: %s", e.Line, e.Column, e.Msg)
+}
+
+// ParseRules parses a stylesheet, or the block of an at-rule that has rules in it
+func ParseRules(tokens []Token) ([]*Rule, []*Error) {
+	p := newParser(tokens)
+	return p.rules(), p.errors
+}
+
+// ParseDeclarations parses the block of a style rule or an at-rule like @font-face
+func ParseDeclarations(tokens []Token) ([]*Declaration, []*Error) {
+	p := newParser(tokens)
+	return p.declarations(), p.errors
+}
+
+func newParser(tokens []Token) *parser {
+	// Blocks don't end with EOF, but it's easier if every list does
+	if len(tokens) == 0 || tokens[len(tokens)-1].Kind != EOF {
+		end := Token{Kind: EOF}
+		if len(tokens) > 0 {
+			end.Pos = tokens[len(tokens)-1].Pos
+		}
+
+		tokens = append(tokens[:len(tokens):len(tokens)], end)
+	}
+
+	return &parser{tokens: tokens}
+}
+
+func (p *parser) peek() Token {
+	return p.tokens[p.i]
+}
+
+func (p *parser) advance() Token {
+	tok := p.tokens[p.i]
+	if tok.Kind != EOF {
+		p.i++
+	}
+
+	return tok
+}
+
+func (p *parser) error(pos Pos, format string, args ...any) {
+	p.errors = append(p.errors, &Error{Pos: pos, Msg: fmt.Sprintf(format, args...)})
+}
+
+func (p *parser) rules() []*Rule {
+	var rules []*Rule
+
+	for {
+		switch tok := p.peek(); tok.Kind {
+		case EOF:
+			return rules
+		case Whitespace, CDO, CDC:
+			p.advance()
+		case RightBrace:
+			p.advance()
+			p.error(tok.Pos, "unexpected }")
+		case AtKeyword:
+			if r := p.atRule(); r != nil {
+				rules = append(rules, r)
+			}
+		default:
+			if r := p.qualifiedRule(); r != nil {
+				rules = append(rules, r)
+			}
+		}
+	}
+}
+
+func (p *parser) atRule() *Rule {
+	tok := p.advance()
+	r := &Rule{Name: tok.Value, Pos: tok.Pos}
+
+	for {
+		switch p.peek().Kind {
+		case Semicolon:
+			p.advance()
+			return r
+		case EOF:
+			p.error(r.Pos, "@%s is missing a ; or a block", r.Name)
+			return nil
+		case LeftBrace:
+			r.Block, r.HasBlock = p.block()
+			if !r.HasBlock {
+				return nil
+			}
+			return r
+		default:
+			r.Prelude = append(r.Prelude, p.component()...)
+		}
+	}
+}
+
+func (p *parser) qualifiedRule() *Rule {
+	r := &Rule{Pos: p.peek().Pos}
+
+	for {
+		switch p.peek().Kind {
+		case EOF:
+			p.error(r.Pos, "rule is missing a block")
+			return nil
+		case LeftBrace:
+			r.Block, r.HasBlock = p.block()
+			if !r.HasBlock {
+				return nil
+			}
+			return r
+		default:
+			r.Prelude = append(r.Prelude, p.component()...)
+		}
+	}
+}
+
+// block returns what's between the braces, and false if it never closes
+func (p *parser) block() ([]Token, bool) {
+	open := p.advance()
+
+	var tokens []Token
+	for {
+		switch p.peek().Kind {
+		case EOF:
+			p.error(open.Pos, "block is never closed")
+			return nil, false
+		case RightBrace:
+			p.advance()
+			return tokens, true
+		default:
+			tokens = append(tokens, p.component()...)
+		}
+	}
+}
+
+// component returns the next token, or everything up to the matching
+// closing token if it opens a block or a function
+func (p *parser) component() []Token {
+	open := p.advance()
+
+	var closing Kind
+	switch open.Kind {
+	case LeftBrace:
+		closing = RightBrace
+	case LeftBracket:
+		closing = RightBracket
+	case LeftParen, Function:
+		closing = RightParen
+	default:
+		return []Token{open}
+	}
+
+	tokens := []Token{open}
+	for {
+		switch tok := p.peek(); tok.Kind {
+		case EOF:
+			p.error(open.Pos, "%s is never closed", describe(open))
+			return tokens
+		case closing:
+			return append(tokens, p.advance())
+		default:
+			tokens = append(tokens, p.component()...)
+		}
+	}
+}
+
+func (p *parser) declarations() []*Declaration {
+	var decls []*Declaration
+
+	for {
+		switch tok := p.peek(); tok.Kind {
+		case EOF:
+			return decls
+		case Whitespace, Semicolon:
+			p.advance()
+		case AtKeyword:
+			p.atRule()
+			p.error(tok.Pos, "@%s can't be used inside a rule", tok.Value)
+		case Ident:
+			if d := p.declaration(p.untilSemicolon()); d != nil {
+				decls = append(decls, d)
+			}
+		default:
+			p.untilSemicolon()
+			p.error(tok.Pos, "expected a property, got %s", describe(tok))
+		}
+	}
+}
+
+func (p *parser) untilSemicolon() []Token {
+	var tokens []Token
+	for k := p.peek().Kind; k != Semicolon && k != EOF; k = p.peek().Kind {
+		tokens = append(tokens, p.component()...)
+	}
+
+	return tokens
+}
+
+func (p *parser) declaration(tokens []Token) *Declaration {
+	d := &Declaration{Name: tokens[0].Value, Pos: tokens[0].Pos}
+
+	rest := trim(tokens[1:])
+	if len(rest) == 0 || rest[0].Kind != Colon {
+		p.error(d.Pos, "expected : after %s", d.Name)
+		return nil
+	}
+
+	d.Value = trim(rest[1:])
+
+	// !important is the last two tokens, maybe with whitespace between them
+	if n := len(d.Value); n >= 2 {
+		last := d.Value[n-1]
+		bang := trim(d.Value[:n-1])
+
+		if last.Kind == Ident && strings.EqualFold(last.Value, "important") &&
+			len(bang) > 0 && bang[len(bang)-1].Kind == Delim && bang[len(bang)-1].Value == "!" {
+			d.Important = true
+			d.Value = trim(bang[:len(bang)-1])
+		}
+	}
+
+	if len(d.Value) == 0 {
+		p.error(d.Pos, "%s is missing a value", d.Name)
+		return nil
+	}
+
+	return d
+}
+
+// trim drops whitespace from both ends
+func trim(tokens []Token) []Token {
+	for len(tokens) > 0 && tokens[0].Kind == Whitespace {
+		tokens = tokens[1:]
+	}
+
+	for len(tokens) > 0 && tokens[len(tokens)-1].Kind == Whitespace {
+		tokens = tokens[:len(tokens)-1]
+	}
+
+	return tokens
+}
+
+// describe names the token for error messages
+func describe(tok Token) string {
+	switch tok.Kind {
+	case EOF:
+		return "end of stylesheet"
+	case Ident:
+		return fmt.Sprintf("%q", tok.Value)
+	case Function:
+		return tok.Value + "()"
+	case AtKeyword:
+		return "@" + tok.Value
+	case Hash:
+		return "#" + tok.Value
+	case String, BadString:
+		return "string"
+	case URL, BadURL:
+		return "url"
+	case Number, Percentage, Dimension:
+		return "number"
+	case Whitespace:
+		return "whitespace"
+	case CDO:
+		return "<!--"
+	case CDC:
+		return "-->"
+	case Delim:
+		return tok.Value
+	case Colon:
+		return ":"
+	case Semicolon:
+		return ";"
+	case Comma:
+		return ","
+	case LeftBracket:
+		return "["
+	case RightBracket:
+		return "]"
+	case LeftParen:
+		return "("
+	case RightParen:
+		return ")"
+	case LeftBrace:
+		return "{"
+	default:
+		return "}"
+	}
+}
@@ -0,0 +1,713 @@
+package css
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+/*
+	Sanitizing:
+		- User CSS is parsed and written back out token by token, so whatever
+			the browser reads is exactly what we checked. Comments are dropped
+			and escapes are decoded before anything is looked up, which is what
+			made substring matching easy to get around.
+		- Properties, functions and at-rules have to be on the policy's
+			allowlist. Everything else is left out and reported with its line
+			and column, @import included.
+		- url() can only point to our own host with a relative address, or to
+			one of the policy's schemes. Data URLs have to be images.
+		- Every selector is put under the policy's scope so profiles can't
+			restyle the rest of the page, html, body and :root are replaced with
+			the scope itself so the usual page wide rules still work.
+		- The output never has a < in it, so it can't close the style element.
+*/
+
+type (
+	Policy struct {
+		// Selector every rule is scoped under
+		Scope string
+		// Vendor prefixes are dropped before properties are looked up,
+		// custom properties are always allowed
+		Properties map[string]bool
+		Functions  map[string]bool
+		AtRules    map[string]Block
+		// Schemes absolute URLs can use
+		Schemes map[string]bool
+		// Media types data URLs can have
+		DataTypes map[string]bool
+	}
+
+	// Block is what an allowed at-rule has in its block
+	Block int
+
+	sanitizer struct {
+		policy *Policy
+		errors []*Error
+	}
+)
+
+const (
+	// Rules are sanitized like the rest of the stylesheet, like in @media
+	Rules Block = iota
+	// Declarations are sanitized like the block of a style rule, like in @font-face
+	Declarations
+	// Keyframes are rules with keyframe selectors that aren't scoped
+	Keyframes
+)
+
+var vendorPrefixes = [...]string{"-webkit-", "-moz-", "-ms-", "-o-"}
+
+// DefaultPolicy is for the public links page, where the profile is in #profile
+var DefaultPolicy = &Policy{
+	Scope: "#profile",
+	Properties: set(
+		"color", "opacity", "visibility", "cursor", "content", "quotes",
+		"background", "background-color", "background-image", "background-position",
+		"background-position-x", "background-position-y", "background-repeat",
+		"background-size", "background-attachment", "background-clip", "background-origin",
+		"background-blend-mode", "mix-blend-mode",
+		"border", "border-color", "border-style", "border-width", "border-collapse", "border-spacing",
+		"border-top", "border-right", "border-bottom", "border-left",
+		"border-top-color", "border-right-color", "border-bottom-color", "border-left-color",
+		"border-top-style", "border-right-style", "border-bottom-style", "border-left-style",
+		"border-top-width", "border-right-width", "border-bottom-width", "border-left-width",
+		"border-radius", "border-top-left-radius", "border-top-right-radius",
+		"border-bottom-left-radius", "border-bottom-right-radius",
+		"border-image", "border-image-source", "border-image-slice", "border-image-width",
+		"border-image-outset", "border-image-repeat",
+		"outline", "outline-color", "outline-style", "outline-width", "outline-offset",
+		"box-shadow", "box-sizing",
+		"margin", "margin-top", "margin-right", "margin-bottom", "margin-left",
+		"padding", "padding-top", "padding-right", "padding-bottom", "padding-left",
+		"width", "height", "min-width", "min-height", "max-width", "max-height", "aspect-ratio",
+		"display", "position", "top", "right", "bottom", "left", "z-index", "float", "clear",
+		"overflow", "overflow-x", "overflow-y", "object-fit", "object-position",
+		"flex", "flex-basis", "flex-direction", "flex-flow", "flex-grow", "flex-shrink", "flex-wrap",
+		"grid", "grid-area", "grid-auto-columns", "grid-auto-flow", "grid-auto-rows",
+		"grid-column", "grid-column-end", "grid-column-start", "grid-row", "grid-row-end",
+		"grid-row-start", "grid-template", "grid-template-areas", "grid-template-columns",
+		"grid-template-rows", "gap", "row-gap", "column-gap", "order",
+		"align-content", "align-items", "align-self", "justify-content", "justify-items",
+		"justify-self", "place-content", "place-items", "place-self",
+		"font", "font-family", "font-size", "font-style", "font-variant", "font-weight",
+		"font-stretch", "font-display", "src", "unicode-range",
+		"letter-spacing", "line-height", "word-spacing", "white-space", "word-break",
+		"overflow-wrap", "hyphens", "tab-size",
+		"text-align", "text-indent", "text-transform", "text-shadow", "text-overflow",
+		"text-decoration", "text-decoration-color", "text-decoration-line",
+		"text-decoration-style", "text-decoration-thickness", "text-underline-offset",
+		"vertical-align", "direction",
+		"list-style", "list-style-image", "list-style-position", "list-style-type",
+		"transform", "transform-origin", "perspective", "perspective-origin", "backface-visibility",
+		"transition", "transition-delay", "transition-duration", "transition-property",
+		"transition-timing-function",
+		"animation", "animation-delay", "animation-direction", "animation-duration",
+		"animation-fill-mode", "animation-iteration-count", "animation-name",
+		"animation-play-state", "animation-timing-function",
+		"filter", "backdrop-filter", "clip-path", "image-rendering",
+	),
+	Functions: set(
+		// values
+		"rgb", "rgba", "hsl", "hsla", "hwb", "lab", "lch", "color-mix",
+		"calc", "min", "max", "clamp", "var", "attr", "counter", "counters",
+		"linear-gradient", "radial-gradient", "conic-gradient",
+		"repeating-linear-gradient", "repeating-radial-gradient", "repeating-conic-gradient",
+		"translate", "translatex", "translatey", "translate3d", "rotate", "rotatex", "rotatey",
+		"rotatez", "rotate3d", "scale", "scalex", "scaley", "scale3d", "skew", "skewx", "skewy",
+		"matrix", "matrix3d", "perspective",
+		"blur", "brightness", "contrast", "drop-shadow", "grayscale", "hue-rotate", "invert",
+		"saturate", "sepia",
+		"cubic-bezier", "steps", "repeat", "minmax", "fit-content",
+		"circle", "ellipse", "inset", "polygon", "format", "local",
+		// selectors
+		"not", "is", "where", "has", "nth-child", "nth-last-child", "nth-of-type",
+		"nth-last-of-type", "lang", "dir",
+	),
+	AtRules: map[string]Block{
+		"media":     Rules,
+		"supports":  Rules,
+		"font-face": Declarations,
+		"keyframes": Keyframes,
+	},
+	Schemes:   set("https"),
+	DataTypes: set("image/png", "image/gif", "image/jpeg", "image/webp", "image/avif"),
+}
+
+// Sanitize returns the parts of the stylesheet the policy allows, scoped and
+// written out again. Everything that was left out is in the errors, in order.
+func (p *Policy) Sanitize(src string) (string, []*Error) {
+	s := &sanitizer{policy: p}
+
+	tokens, errs := Tokenize(src)
+	s.errors = append(s.errors, errs...)
+
+	out := s.rules(tokens, "", false)
+
+	sort.SliceStable(s.errors, func(i, j int) bool {
+		a, b := s.errors[i].Pos, s.errors[j].Pos
+		return a.Line < b.Line || (a.Line == b.Line && a.Column < b.Column)
+	})
+
+	return out, s.errors
+}
+
+// Validate returns the first thing Sanitize would leave out, as an *Error
+func (p *Policy) Validate(src string) error {
+	if _, errs := p.Sanitize(src); len(errs) > 0 {
+		return errs[0]
+	}
+
+	return nil
+}
+
+func (s *sanitizer) error(pos Pos, format string, args ...any) {
+	s.errors = append(s.errors, &Error{Pos: pos, Msg: fmt.Sprintf(format, args...)})
+}
+
+// rules leaves out rules that end up empty
+func (s *sanitizer) rules(tokens []Token, indent string, keyframes bool) string {
+	rules, errs := ParseRules(tokens)
+	s.errors = append(s.errors, errs...)
+
+	var b strings.Builder
+	for _, r := range rules {
+		if r.Name != "" {
+			b.WriteString(s.atRule(r, indent))
+			continue
+		}
+
+		var (
+			selectors string
+			ok        bool
+		)
+
+		if keyframes {
+			selectors, ok = s.keyframeSelectors(r)
+		} else {
+			selectors, ok = s.selectors(r)
+		}
+
+		if !ok {
+			continue
+		}
+
+		if decls := s.declarations(r.Block, indent+"  "); decls != "" {
+			b.WriteString(indent + selectors + " {\n" + decls + indent + "}\n")
+		}
+	}
+
+	return b.String()
+}
+
+func (s *sanitizer) atRule(r *Rule, indent string) string {
+	name := strings.ToLower(r.Name)
+
+	block, ok := s.policy.AtRules[unprefix(name)]
+	if !ok {
+		s.error(r.Pos, "@%s is not allowed", r.Name)
+		return ""
+	}
+
+	if !r.HasBlock {
+		s.error(r.Pos, "@%s needs a block", r.Name)
+		return ""
+	}
+
+	var prelude strings.Builder
+	if !s.tokens(&prelude, trim(r.Prelude), false) {
+		return ""
+	}
+
+	var content string
+	switch block {
+	case Declarations:
+		content = s.declarations(r.Block, indent+"  ")
+	case Keyframes:
+		content = s.rules(r.Block, indent+"  ", true)
+	default:
+		content = s.rules(r.Block, indent+"  ", false)
+	}
+
+	if content == "" {
+		return ""
+	}
+
+	head := indent + "@" + serializeIdent(name)
+	if prelude.Len() > 0 {
+		head += " " + prelude.String()
+	}
+
+	return head + " {\n" + content + indent + "}\n"
+}
+
+func (s *sanitizer) declarations(tokens []Token, indent string) string {
+	decls, errs := ParseDeclarations(tokens)
+	s.errors = append(s.errors, errs...)
+
+	var b strings.Builder
+	for _, d := range decls {
+		name := d.Name
+		if !strings.HasPrefix(name, "--") {
+			name = strings.ToLower(name)
+
+			if !s.policy.Properties[unprefix(name)] {
+				s.error(d.Pos, "property %s is not allowed", d.Name)
+				continue
+			}
+		}
+
+		var value strings.Builder
+		if !s.tokens(&value, d.Value, true) {
+			continue
+		}
+
+		b.WriteString(indent + serializeIdent(name) + ": " + value.String())
+		if d.Important {
+			b.WriteString(" !important")
+		}
+		b.WriteString(";\n")
+	}
+
+	return b.String()
+}
+
+// selectors scopes every selector in the list
+func (s *sanitizer) selectors(r *Rule) (string, bool) {
+	var (
+		prelude = r.Prelude
+		out     []string
+		start   = 0
+		depth   = 0
+	)
+
+	for i := 0; i <= len(prelude); i++ {
+		if i < len(prelude) {
+			switch prelude[i].Kind {
+			case LeftParen, LeftBracket, Function:
+				depth++
+				continue
+			case RightParen, RightBracket:
+				depth--
+				continue
+			case Comma:
+				if depth > 0 {
+					continue
+				}
+			default:
+				continue
+			}
+		}
+
+		sel := trim(prelude[start:i])
+		if len(sel) == 0 {
+			pos := r.Pos
+			if i < len(prelude) {
+				pos = prelude[i].Pos
+			}
+
+			s.error(pos, "empty selector")
+			return "", false
+		}
+
+		var b strings.Builder
+		b.WriteString(s.policy.Scope)
+
+		rest := s.unscope(sel)
+		if len(rest) == len(sel) {
+			rest = unroot(sel)
+		}
+		if len(rest) == len(sel) {
+			b.WriteString(" ")
+		}
+
+		if !s.tokens(&b, rest, false) {
+			return "", false
+		}
+
+		out = append(out, b.String())
+		start = i + 1
+	}
+
+	return strings.Join(out, ", "), true
+}
+
+// unscope drops the scope from the start of the selector, so sanitizing
+// what Sanitize wrote out doesn't scope it twice
+func (s *sanitizer) unscope(sel []Token) []Token {
+	scope, _ := Tokenize(s.policy.Scope)
+	for len(scope) > 0 && scope[len(scope)-1].Kind == EOF {
+		scope = scope[:len(scope)-1]
+	}
+
+	if len(scope) == 0 || len(sel) < len(scope) {
+		return sel
+	}
+
+	for i := range scope {
+		if sel[i].Kind != scope[i].Kind || sel[i].Value != scope[i].Value {
+			return sel
+		}
+	}
+
+	if rest := sel[len(scope):]; inScope(rest) {
+		return rest
+	}
+
+	return sel
+}
+
+// inScope reports whether what comes after the scope in a selector only
+// reaches the scope itself or elements inside it
+func inScope(rest []Token) bool {
+	if len(rest) == 0 {
+		return true
+	}
+
+	if rest[0].Kind != Whitespace && !(rest[0].Kind == Delim && rest[0].Value == ">") {
+		return false
+	}
+
+	for _, tok := range rest {
+		if tok.Kind == Whitespace {
+			continue
+		}
+
+		// Siblings of the scope are outside of it
+		return !(tok.Kind == Delim && (tok.Value == "+" || tok.Value == "~"))
+	}
+
+	return true
+}
+
+// unroot drops html, body and :root from the start of the selector, what's
+// left starts with the combinator that came after them
+func unroot(sel []Token) []Token {
+	for {
+		n := 0
+		switch {
+		case len(sel) > 0 && sel[0].Kind == Ident &&
+			(strings.EqualFold(sel[0].Value, "html") || strings.EqualFold(sel[0].Value, "body")):
+			n = 1
+		case len(sel) > 1 && sel[0].Kind == Colon && sel[1].Kind == Ident && strings.EqualFold(sel[1].Value, "root"):
+			n = 2
+		default:
+			return sel
+		}
+
+		// body.dark is a different element than the scope and body ~ a
+		// is outside of it, leave them be
+		if !inScope(sel[n:]) {
+			return sel
+		}
+
+		next := sel[n:]
+
+		// Keep going for html > body, but only if body comes next
+		i := 0
+		for i < len(next) && (next[i].Kind == Whitespace || (next[i].Kind == Delim && next[i].Value == ">")) {
+			i++
+		}
+
+		if len(unroot(next[i:])) == len(next[i:]) {
+			return next
+		}
+
+		sel = next[i:]
+	}
+}
+
+// keyframeSelectors checks for from, to and percentages
+func (s *sanitizer) keyframeSelectors(r *Rule) (string, bool) {
+	var b strings.Builder
+
+	for _, tok := range trim(r.Prelude) {
+		switch {
+		case tok.Kind == Ident && (strings.EqualFold(tok.Value, "from") || strings.EqualFold(tok.Value, "to")):
+			b.WriteString(strings.ToLower(tok.Value))
+		case tok.Kind == Percentage:
+			b.WriteString(tok.Value + "%")
+		case tok.Kind == Comma:
+			b.WriteString(", ")
+		case tok.Kind == Whitespace:
+		default:
+			s.error(tok.Pos, "keyframe selectors can only be from, to or percentages")
+			return "", false
+		}
+	}
+
+	if b.Len() == 0 {
+		s.error(r.Pos, "empty selector")
+		return "", false
+	}
+
+	return b.String(), true
+}
+
+// tokens writes the tokens out again, or reports the first one that isn't
+// allowed and returns false. Whitespace is collapsed.
+func (s *sanitizer) tokens(b *strings.Builder, tokens []Token, urls bool) bool {
+	for i := 0; i < len(tokens); i++ {
+		tok := tokens[i]
+
+		if i > 0 && needsSeparator(tokens[i-1], tok) {
+			b.WriteString("/**/")
+		}
+
+		switch tok.Kind {
+		case Whitespace:
+			b.WriteString(" ")
+		case Ident:
+			b.WriteString(serializeIdent(tok.Value))
+		case Hash:
+			b.WriteString("#" + serializeName(tok.Value))
+		case String:
+			b.WriteString(serializeString(tok.Value))
+		case Number:
+			b.WriteString(tok.Value)
+		case Percentage:
+			b.WriteString(tok.Value + "%")
+		case Dimension:
+			b.WriteString(tok.Value + serializeName(tok.Unit))
+		case Colon:
+			b.WriteString(":")
+		case Comma:
+			b.WriteString(",")
+		case LeftParen:
+			b.WriteString("(")
+		case RightParen:
+			b.WriteString(")")
+		case LeftBracket:
+			b.WriteString("[")
+		case RightBracket:
+			b.WriteString("]")
+		case Delim:
+			// < could close the style element and the rest have no use here
+			if tok.Value == "<" || tok.Value == "@" || tok.Value == "\\" {
+				s.error(tok.Pos, "%s is not allowed", tok.Value)
+				return false
+			}
+			b.WriteString(tok.Value)
+		case URL:
+			if !urls {
+				s.error(tok.Pos, "url() is not allowed here")
+				return false
+			}
+
+			if !s.url(tok) {
+				return false
+			}
+
+			b.WriteString("url(" + serializeString(tok.Value) + ")")
+		case Function:
+			name := strings.ToLower(tok.Value)
+
+			if name == "url" {
+				// The tokenizer only makes a url function if there's a string in it
+				arg := trim(tokens[i+1 : matching(tokens, i)])
+				if !urls || len(arg) != 1 || arg[0].Kind != String {
+					s.error(tok.Pos, "url() needs a single address")
+					return false
+				}
+
+				if !s.url(Token{Kind: URL, Value: arg[0].Value, Pos: tok.Pos}) {
+					return false
+				}
+
+				b.WriteString("url(" + serializeString(arg[0].Value) + ")")
+				i = matching(tokens, i)
+				continue
+			}
+
+			if !s.policy.Functions[unprefix(name)] {
+				s.error(tok.Pos, "%s() is not allowed", tok.Value)
+				return false
+			}
+
+			b.WriteString(serializeIdent(name) + "(")
+		case BadString, BadURL:
+			// The tokenizer already said what's wrong with it
+			return false
+		default:
+			s.error(tok.Pos, "%s is not allowed here", describe(tok))
+			return false
+		}
+	}
+
+	return true
+}
+
+// url checks the address of a url token
+func (s *sanitizer) url(tok Token) bool {
+	raw := strings.TrimSpace(tok.Value)
+
+	// Browsers read backslashes as slashes, /\example.com is another host
+	for _, r := range raw {
+		if r < 0x20 || r == 0x7F || r == '\\' {
+			s.error(tok.Pos, "url has characters that aren't allowed")
+			return false
+		}
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		s.error(tok.Pos, "url can't be read")
+		return false
+	}
+
+	switch scheme := strings.ToLower(u.Scheme); {
+	case scheme == "" && !strings.HasPrefix(raw, "//"):
+		return true
+	case scheme == "data":
+		mediaType, _, _ := strings.Cut(u.Opaque, ",")
+		mediaType, _, _ = strings.Cut(mediaType, ";")
+
+		if !s.policy.DataTypes[strings.ToLower(mediaType)] {
+			s.error(tok.Pos, "data urls can only be images")
+			return false
+		}
+
+		return true
+	case s.policy.Schemes[scheme] && u.Host != "":
+		return true
+	default:
+		s.error(tok.Pos, "url has to be relative or use %s", strings.Join(keys(s.policy.Schemes), " or "))
+		return false
+	}
+}
+
+// needsSeparator reports whether the two tokens would be read as something
+// else if they were written next to each other. They were apart in the input
+// because of a comment, and url/**/( can't be allowed to become url(.
+// The table is from https://www.w3.org/TR/css-syntax-3/#serialization.
+func needsSeparator(a, b Token) bool {
+	var (
+		word    = b.Kind == Ident || b.Kind == Function || b.Kind == URL || b.Kind == BadURL
+		number  = b.Kind == Number || b.Kind == Percentage || b.Kind == Dimension
+		dash    = b.Kind == Delim && b.Value == "-"
+		percent = b.Kind == Delim && b.Value == "%"
+	)
+
+	switch {
+	case a.Kind == Ident:
+		return word || number || dash || b.Kind == CDC || b.Kind == LeftParen
+	case a.Kind == AtKeyword || a.Kind == Hash || a.Kind == Dimension:
+		return word || number || dash || b.Kind == CDC
+	case a.Kind == Number:
+		return word || number || percent
+	case a.Kind == Delim && (a.Value == "#" || a.Value == "-"):
+		return word || number || dash
+	case a.Kind == Delim && a.Value == "@":
+		return word || dash
+	case a.Kind == Delim && (a.Value == "." || a.Value == "+"):
+		return number
+	case a.Kind == Delim && a.Value == "/":
+		return b.Kind == Delim && b.Value == "*"
+	}
+
+	return false
+}
+
+// matching returns the index of the token that closes the function at i
+func matching(tokens []Token, i int) int {
+	depth := 0
+	for j := i; j < len(tokens); j++ {
+		switch tokens[j].Kind {
+		case LeftParen, Function:
+			depth++
+		case RightParen:
+			depth--
+			if depth == 0 {
+				return j
+			}
+		}
+	}
+
+	return len(tokens)
+}
+
+func unprefix(name string) string {
+	for _, p := range vendorPrefixes {
+		if strings.HasPrefix(name, p) {
+			return name[len(p):]
+		}
+	}
+
+	return name
+}
+
+func serializeIdent(s string) string {
+	var b strings.Builder
+
+	for i, r := range s {
+		// Idents can't start with a digit, or a dash and a digit
+		if isDigit(r) && (i == 0 || (i == 1 && s[0] == '-')) {
+			fmt.Fprintf(&b, "\\%x ", r)
+			continue
+		}
+
+		writeNameRune(&b, r)
+	}
+
+	return b.String()
+}
+
+// serializeName is for hashes and units, which can start with anything
+func serializeName(s string) string {
+	var b strings.Builder
+
+	for _, r := range s {
+		writeNameRune(&b, r)
+	}
+
+	return b.String()
+}
+
+func writeNameRune(b *strings.Builder, r rune) {
+	if isName(r) {
+		b.WriteRune(r)
+		return
+	}
+
+	fmt.Fprintf(b, "\\%x ", r)
+}
+
+func serializeString(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+
+	for _, r := range s {
+		switch {
+		case r == '"' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r == '<' || r < 0x20 || r == 0x7F:
+			fmt.Fprintf(&b, "\\%x ", r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+
+	b.WriteByte('"')
+	return b.String()
+}
+
+func set(values ...string) map[string]bool {
+	m := make(map[string]bool, len(values))
+	for _, v := range values {
+		m[v] = true
+	}
+
+	return m
+}
+
+func keys(m map[string]bool) []string {
+	ks := make([]string, 0, len(m))
+	for k := range m {
+		ks = append(ks, k)
+	}
+
+	sort.Strings(ks)
+	return ks
+}
@@ -0,0 +1,150 @@
+package css_test
+
+import (
+	"testing"
+
+	"github.com/derinil/links/links/css"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSanitize(t *testing.T) {
+	testCases := []struct {
+		name     string
+		src      string
+		expected string
+		errors   []string
+	}{
+		{
+			name:     "scoped",
+			src:      ".link-entry a:hover, .x:not(.y) > b { color: red }",
+			expected: "#profile .link-entry a:hover, #profile .x:not(.y) > b {\n  color: red;\n}\n",
+		},
+		{
+			name:     "page wide rules",
+			src:      "body { color: red } html > body > div { margin: 0 } :root { --accent: #fff } body.dark a { color: blue }",
+			expected: "#profile {\n  color: red;\n}\n#profile > div {\n  margin: 0;\n}\n#profile {\n  --accent: #fff;\n}\n#profile body.dark a {\n  color: blue;\n}\n",
+		},
+		{
+			name:     "siblings of the scope",
+			src:      "body ~ a { color: red } :root + div { color: blue } body > p ~ a { color: green }",
+			expected: "#profile body ~ a {\n  color: red;\n}\n#profile :root + div {\n  color: blue;\n}\n#profile > p ~ a {\n  color: green;\n}\n",
+		},
+		{
+			name:     "harmless words",
+			src:      ".description { background: url(/static/unico.jpg) no-repeat; color: #ABC !important }",
+			expected: "#profile .description {\n  background: url(\"/static/unico.jpg\") no-repeat;\n  color: #ABC !important;\n}\n",
+		},
+		{
+			name:     "properties",
+			src:      "a {\n  behavior: url(x.htc);\n  -moz-binding: url(x.xml);\n  -webkit-transform: rotate(1deg);\n}",
+			expected: "#profile a {\n  -webkit-transform: rotate(1deg);\n}\n",
+			errors: []string{
+				"line 2, column 3: property behavior is not allowed",
+				"line 3, column 3: property -moz-binding is not allowed",
+			},
+		},
+		{
+			name: "escapes and comments",
+			src:  "a { width: ex\\70 ression(alert(1)); color: r/**/ed; w\\idth: 1px; background: url/**/(javascript:x) }",
+			// The comments have to stay, or the browser would read r/**/ed as red and url/**/( as url(
+			expected: "#profile a {\n  color: r/**/ed;\n  width: 1px;\n  background: url/**/(javascript:x);\n}\n",
+			errors:   []string{"line 1, column 12: expression() is not allowed"},
+		},
+		{
+			name:     "at-rules",
+			src:      "@import url(https://evil.com/x.css);\n@media (max-width: 600px) { a { color: red } }\n@page { margin: 0 }",
+			expected: "@media (max-width: 600px) {\n  #profile a {\n    color: red;\n  }\n}\n",
+			errors: []string{
+				"line 1, column 1: @import is not allowed",
+				"line 3, column 1: @page is not allowed",
+			},
+		},
+		{
+			name:     "keyframes",
+			src:      "@-webkit-keyframes spin { from { opacity: 0 } 50%, to { opacity: 1 } }",
+			expected: "@-webkit-keyframes spin {\n  from {\n    opacity: 0;\n  }\n  50%, to {\n    opacity: 1;\n  }\n}\n",
+		},
+		{
+			name: "urls",
+			src: "a {\n" +
+				"  background: url(javascript:alert(1));\n" +
+				"  background: url('//evil.com/x.png');\n" +
+				"  background: url(/\\\\evil.com/x.png);\n" +
+				"  background: url(http://evil.com/x.png);\n" +
+				"  background: url(data:text/html,x);\n" +
+				"  background: url(https://example.com/x.png);\n" +
+				"  background: url(\"data:image/png;base64,AAAA\");\n" +
+				"}",
+			expected: "#profile a {\n  background: url(\"https://example.com/x.png\");\n  background: url(\"data:image/png;base64,AAAA\");\n}\n",
+			errors: []string{
+				"line 2, column 15: invalid url",
+				"line 3, column 15: url has to be relative or use https",
+				"line 4, column 15: url has characters that aren't allowed",
+				"line 5, column 15: url has to be relative or use https",
+				"line 6, column 15: data urls can only be images",
+			},
+		},
+		{
+			name:     "closing the style element",
+			src:      "a::after { content: \"</style><script>alert(1)</script>\" } a { color: red } </style>",
+			expected: "#profile a::after {\n  content: \"\\3c /style>\\3c script>alert(1)\\3c /script>\";\n}\n#profile a {\n  color: red;\n}\n",
+			errors:   []string{"line 1, column 76: rule is missing a block"},
+		},
+		{
+			name:     "syntax errors",
+			src:      "a { color red; width: }\n, b { color: red }\nc { color: blue",
+			expected: "",
+			errors: []string{
+				"line 1, column 5: expected : after color",
+				"line 1, column 16: width is missing a value",
+				"line 2, column 1: empty selector",
+				"line 3, column 3: block is never closed",
+			},
+		},
+	}
+
+	for _, c := range testCases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			out, errs := css.DefaultPolicy.Sanitize(c.src)
+			require.Equal(t, c.expected, out)
+
+			var msgs []string
+			for _, e := range errs {
+				msgs = append(msgs, e.Error())
+			}
+			require.Equal(t, c.errors, msgs)
+
+			require.NotContains(t, out, "<")
+		})
+	}
+}
+
+func TestSanitizeTwice(t *testing.T) {
+	src := `@import url("https://evil.example.com/x.css");
+body { color: red } html > body > div { margin: 0 } .a, .b:hover { color: blue }
+@media (max-width: 600px) { body ~ a { display: none } }
+@keyframes spin { from { transform: rotate(0deg) } to { transform: rotate(360deg) } }`
+
+	once, errs := css.DefaultPolicy.Sanitize(src)
+	require.NotEmpty(t, errs)
+
+	twice, errs := css.DefaultPolicy.Sanitize(once)
+	require.Empty(t, errs)
+	require.Equal(t, once, twice)
+
+	// Only what Sanitize would write is left alone
+	out, _ := css.DefaultPolicy.Sanitize("#profile ~ a { color: red } #profile.dark { color: red }")
+	require.Equal(t, "#profile #profile ~ a {\n  color: red;\n}\n#profile #profile.dark {\n  color: red;\n}\n", out)
+}
+
+func TestValidate(t *testing.T) {
+	require.NoError(t, css.DefaultPolicy.Validate("a { color: red }"))
+
+	err := css.DefaultPolicy.Validate("a {\n  color: red;\n  position: fixed;\n  zoom: 2;\n}")
+
+	var ce *css.Error
+	require.ErrorAs(t, err, &ce)
+	require.Equal(t, css.Pos{Line: 4, Column: 3}, ce.Pos)
+	require.Equal(t, "line 4, column 3: property zoom is not allowed", err.Error())
+}
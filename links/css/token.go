@@ -0,0 +1,511 @@
+package css
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+type (
+	Kind int
+
+	// Pos is where a token starts, both are counted from 1 and
+	// columns are counted in characters, not bytes
+	Pos struct {
+		Line   int
+		Column int
+	}
+
+	Token struct {
+		Kind Kind
+		// Unescaped name of idents, functions, at-keywords and hashes, contents
+		// of strings and URLs, the character of delims, and the number as
+		// written for numbers, percentages and dimensions
+		Value string
+		// Unit of dimensions
+		Unit string
+		Pos  Pos
+	}
+
+	// tokenizer follows https://www.w3.org/TR/css-syntax-3/#tokenization,
+	// minus the parts we never look at like number values and hash types
+	tokenizer struct {
+		src    []rune
+		i      int
+		pos    Pos
+		errors []*Error
+	}
+)
+
+const (
+	EOF Kind = iota
+	Ident
+	Function
+	AtKeyword
+	Hash
+	String
+	BadString
+	URL
+	BadURL
+	Delim
+	Number
+	Percentage
+	Dimension
+	Whitespace
+	CDO
+	CDC
+	Colon
+	Semicolon
+	Comma
+	LeftBracket
+	RightBracket
+	LeftParen
+	RightParen
+	LeftBrace
+	RightBrace
+)
+
+const eof = -1
+
+// Tokenize splits the stylesheet into tokens, the last one is always EOF.
+// Comments are dropped, the errors are the parts the tokenizer had to guess.
+func Tokenize(src string) ([]Token, []*Error) {
+	// Preprocessing from the spec, columns still line up since
+	// only line breaks change length
+	src = strings.NewReplacer("\r\n", "\n", "\r", "\n", "\f", "\n", "\x00", "�").Replace(src)
+
+	t := &tokenizer{
+		src: []rune(src),
+		pos: Pos{Line: 1, Column: 1},
+	}
+
+	var tokens []Token
+	for {
+		tok := t.next()
+		tokens = append(tokens, tok)
+
+		if tok.Kind == EOF {
+			return tokens, t.errors
+		}
+	}
+}
+
+func (t *tokenizer) peek(n int) rune {
+	if t.i+n >= len(t.src) {
+		return eof
+	}
+
+	return t.src[t.i+n]
+}
+
+func (t *tokenizer) advance() rune {
+	r := t.peek(0)
+	if r == eof {
+		return eof
+	}
+
+	t.i++
+	if r == '\n' {
+		t.pos.Line++
+		t.pos.Column = 1
+	} else {
+		t.pos.Column++
+	}
+
+	return r
+}
+
+func (t *tokenizer) error(pos Pos, msg string) {
+	t.errors = append(t.errors, &Error{Pos: pos, Msg: msg})
+}
+
+func (t *tokenizer) next() Token {
+	t.skipComments()
+
+	var (
+		pos = t.pos
+		r   = t.advance()
+		tok = Token{Pos: pos}
+	)
+
+	switch {
+	case r == eof:
+		tok.Kind = EOF
+	case isWhitespace(r):
+		for isWhitespace(t.peek(0)) {
+			t.advance()
+		}
+		tok.Kind = Whitespace
+	case r == '"' || r == '\'':
+		return t.string(r, pos)
+	case r == '#':
+		if isName(t.peek(0)) || isEscape(t.peek(0), t.peek(1)) {
+			tok.Kind = Hash
+			tok.Value = t.name()
+		} else {
+			tok.Kind = Delim
+			tok.Value = "#"
+		}
+	case r == '(':
+		tok.Kind = LeftParen
+	case r == ')':
+		tok.Kind = RightParen
+	case r == '[':
+		tok.Kind = LeftBracket
+	case r == ']':
+		tok.Kind = RightBracket
+	case r == '{':
+		tok.Kind = LeftBrace
+	case r == '}':
+		tok.Kind = RightBrace
+	case r == ',':
+		tok.Kind = Comma
+	case r == ':':
+		tok.Kind = Colon
+	case r == ';':
+		tok.Kind = Semicolon
+	case r == '+' || r == '.':
+		if startsNumber(r, t.peek(0), t.peek(1)) {
+			return t.numeric(r, pos)
+		}
+		tok.Kind = Delim
+		tok.Value = string(r)
+	case r == '-':
+		switch {
+		case startsNumber(r, t.peek(0), t.peek(1)):
+			return t.numeric(r, pos)
+		case t.peek(0) == '-' && t.peek(1) == '>':
+			t.advance()
+			t.advance()
+			tok.Kind = CDC
+		case startsIdent(r, t.peek(0), t.peek(1)):
+			return t.identLike(pos)
+		default:
+			tok.Kind = Delim
+			tok.Value = "-"
+		}
+	case r == '<':
+		if t.peek(0) == '!' && t.peek(1) == '-' && t.peek(2) == '-' {
+			t.advance()
+			t.advance()
+			t.advance()
+			tok.Kind = CDO
+		} else {
+			tok.Kind = Delim
+			tok.Value = "<"
+		}
+	case r == '@':
+		if startsIdent(t.peek(0), t.peek(1), t.peek(2)) {
+			tok.Kind = AtKeyword
+			tok.Value = t.name()
+		} else {
+			tok.Kind = Delim
+			tok.Value = "@"
+		}
+	case r == '\\':
+		if isEscape(r, t.peek(0)) {
+			return t.identLike(pos)
+		}
+		t.error(pos, "invalid escape")
+		tok.Kind = Delim
+		tok.Value = "\\"
+	case isDigit(r):
+		return t.numeric(r, pos)
+	case isNameStart(r):
+		return t.identLike(pos)
+	default:
+		tok.Kind = Delim
+		tok.Value = string(r)
+	}
+
+	return tok
+}
+
+func (t *tokenizer) skipComments() {
+	for t.peek(0) == '/' && t.peek(1) == '*' {
+		pos := t.pos
+		t.advance()
+		t.advance()
+
+		for {
+			if t.peek(0) == eof {
+				t.error(pos, "unterminated comment")
+				return
+			}
+
+			if t.advance() == '*' && t.peek(0) == '/' {
+				t.advance()
+				break
+			}
+		}
+	}
+}
+
+func (t *tokenizer) string(quote rune, pos Pos) Token {
+	var b strings.Builder
+
+	for {
+		switch r := t.peek(0); {
+		case r == quote:
+			t.advance()
+			return Token{Kind: String, Value: b.String(), Pos: pos}
+		case r == eof:
+			t.error(pos, "unterminated string")
+			return Token{Kind: String, Value: b.String(), Pos: pos}
+		case r == '\n':
+			// The newline isn't part of the string, it's whitespace after it
+			t.error(pos, "line break in string")
+			return Token{Kind: BadString, Pos: pos}
+		case r == '\\':
+			t.advance()
+			switch t.peek(0) {
+			case eof:
+			case '\n':
+				t.advance()
+			default:
+				b.WriteRune(t.escape())
+			}
+		default:
+			b.WriteRune(t.advance())
+		}
+	}
+}
+
+// numeric is called with the first character of the number already consumed
+func (t *tokenizer) numeric(first rune, pos Pos) Token {
+	var b strings.Builder
+	b.WriteRune(first)
+
+	digits := func() {
+		for isDigit(t.peek(0)) {
+			b.WriteRune(t.advance())
+		}
+	}
+
+	digits()
+
+	// Numbers that start with the dot already had their fraction
+	if first != '.' && t.peek(0) == '.' && isDigit(t.peek(1)) {
+		b.WriteRune(t.advance())
+		digits()
+	}
+
+	if e := t.peek(0); e == 'e' || e == 'E' {
+		if isDigit(t.peek(1)) {
+			b.WriteRune(t.advance())
+			digits()
+		} else if s := t.peek(1); (s == '+' || s == '-') && isDigit(t.peek(2)) {
+			b.WriteRune(t.advance())
+			b.WriteRune(t.advance())
+			digits()
+		}
+	}
+
+	switch {
+	case startsIdent(t.peek(0), t.peek(1), t.peek(2)):
+		return Token{Kind: Dimension, Value: b.String(), Unit: t.name(), Pos: pos}
+	case t.peek(0) == '%':
+		t.advance()
+		return Token{Kind: Percentage, Value: b.String(), Pos: pos}
+	default:
+		return Token{Kind: Number, Value: b.String(), Pos: pos}
+	}
+}
+
+// identLike is called with the first character of the name already consumed,
+// it steps back over it since names are read with their escapes in one go
+func (t *tokenizer) identLike(pos Pos) Token {
+	t.i--
+	t.pos = pos
+	name := t.name()
+
+	if t.peek(0) != '(' {
+		return Token{Kind: Ident, Value: name, Pos: pos}
+	}
+
+	t.advance()
+
+	if !strings.EqualFold(name, "url") {
+		return Token{Kind: Function, Value: name, Pos: pos}
+	}
+
+	// url( followed by a quote is a function with a string in it,
+	// otherwise it's a url token with the address as is
+	n := 0
+	for isWhitespace(t.peek(n)) {
+		n++
+	}
+
+	if q := t.peek(n); q == '"' || q == '\'' {
+		return Token{Kind: Function, Value: name, Pos: pos}
+	}
+
+	return t.url(pos)
+}
+
+func (t *tokenizer) url(pos Pos) Token {
+	var b strings.Builder
+
+	for isWhitespace(t.peek(0)) {
+		t.advance()
+	}
+
+	for {
+		switch r := t.peek(0); {
+		case r == ')':
+			t.advance()
+			return Token{Kind: URL, Value: b.String(), Pos: pos}
+		case r == eof:
+			t.error(pos, "unterminated url")
+			return Token{Kind: URL, Value: b.String(), Pos: pos}
+		case isWhitespace(r):
+			for isWhitespace(t.peek(0)) {
+				t.advance()
+			}
+
+			if t.peek(0) == ')' || t.peek(0) == eof {
+				continue
+			}
+
+			return t.badURL(pos)
+		case r == '"' || r == '\'' || r == '(' || isNonPrintable(r):
+			return t.badURL(pos)
+		case r == '\\':
+			if !isEscape(r, t.peek(1)) {
+				return t.badURL(pos)
+			}
+
+			t.advance()
+			b.WriteRune(t.escape())
+		default:
+			b.WriteRune(t.advance())
+		}
+	}
+}
+
+// badURL skips the rest of a url that can't be read so the
+// tokens after it are still right
+func (t *tokenizer) badURL(pos Pos) Token {
+	t.error(pos, "invalid url")
+
+	for {
+		switch r := t.peek(0); {
+		case r == eof:
+			return Token{Kind: BadURL, Pos: pos}
+		case r == ')':
+			t.advance()
+			return Token{Kind: BadURL, Pos: pos}
+		case isEscape(r, t.peek(1)):
+			t.advance()
+			t.escape()
+		default:
+			t.advance()
+		}
+	}
+}
+
+func (t *tokenizer) name() string {
+	var b strings.Builder
+
+	for {
+		r := t.peek(0)
+
+		switch {
+		case isName(r):
+			b.WriteRune(t.advance())
+		case isEscape(r, t.peek(1)):
+			t.advance()
+			b.WriteRune(t.escape())
+		default:
+			return b.String()
+		}
+	}
+}
+
+// escape is called after the backslash
+func (t *tokenizer) escape() rune {
+	r := t.peek(0)
+
+	if r == eof {
+		return utf8.RuneError
+	}
+
+	if !isHex(r) {
+		return t.advance()
+	}
+
+	var v rune
+	for n := 0; n < 6 && isHex(t.peek(0)); n++ {
+		v = v*16 + hexValue(t.advance())
+	}
+
+	if isWhitespace(t.peek(0)) {
+		t.advance()
+	}
+
+	if v == 0 || (v >= 0xD800 && v <= 0xDFFF) || v > utf8.MaxRune {
+		return utf8.RuneError
+	}
+
+	return v
+}
+
+func isWhitespace(r rune) bool {
+	return r == ' ' || r == '\t' || r == '\n'
+}
+
+func isDigit(r rune) bool {
+	return r >= '0' && r <= '9'
+}
+
+func isHex(r rune) bool {
+	return isDigit(r) || (r >= 'a' && r <= 'f') || (r >= 'A' && r <= 'F')
+}
+
+func hexValue(r rune) rune {
+	switch {
+	case isDigit(r):
+		return r - '0'
+	case r >= 'a' && r <= 'f':
+		return r - 'a' + 10
+	default:
+		return r - 'A' + 10
+	}
+}
+
+func isNameStart(r rune) bool {
+	return (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || r == '_' || r >= 0x80
+}
+
+func isName(r rune) bool {
+	return isNameStart(r) || isDigit(r) || r == '-'
+}
+
+func isNonPrintable(r rune) bool {
+	return (r >= 0 && r <= 0x08) || r == 0x0B || (r >= 0x0E && r <= 0x1F) || r == 0x7F
+}
+
+func isEscape(a, b rune) bool {
+	return a == '\\' && b != '\n' && b != eof
+}
+
+func startsIdent(a, b, c rune) bool {
+	switch {
+	case a == '-':
+		return isNameStart(b) || b == '-' || isEscape(b, c)
+	case a == '\\':
+		return isEscape(a, b)
+	default:
+		return isNameStart(a)
+	}
+}
+
+func startsNumber(a, b, c rune) bool {
+	switch {
+	case a == '+' || a == '-':
+		return isDigit(b) || (b == '.' && isDigit(c))
+	case a == '.':
+		return isDigit(b)
+	default:
+		return isDigit(a)
+	}
+}
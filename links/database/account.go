@@ -27,7 +27,12 @@ func NewAccountReader(db *sqlx.DB) *AccountReader {
 func (s *AccountReader) Get(ctx context.Context, cmd *account.GetCmd) (*account.Account, error) {
 	b := builder.Select("*").From("accounts")
 
-	if cmd.Handle != "" {
+	if cmd.Handle != "" && cmd.PreviousHandles {
+		b = b.Where(squirrel.Or{
+			squirrel.Eq{"handle": cmd.Handle},
+			squirrel.Expr("id = (select account_id from handle_history where handle = ?)", cmd.Handle),
+		})
+	} else if cmd.Handle != "" {
 		b = b.Where(squirrel.Eq{"handle": cmd.Handle})
 	}
 
@@ -35,6 +40,10 @@ func (s *AccountReader) Get(ctx context.Context, cmd *account.GetCmd) (*account.
 		b = b.Where(squirrel.Eq{"id": cmd.ID})
 	}
 
+	if cmd.Email != "" {
+		b = b.Where("lower(email) = lower(?) and email_verified_at is not null", cmd.Email)
+	}
+
 	q, args, err := b.ToSql()
 	if err != nil {
 		return nil, fmt.Errorf("failed to build query: %w", err)
@@ -58,6 +67,15 @@ func (s *AccountReader) Get(ctx context.Context, cmd *account.GetCmd) (*account.
 		a.Links = ls
 	}
 
+	if cmd.PreviousHandles {
+		phs, err := s.listPreviousHandles(ctx, a.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list previous handles: %w", err)
+		}
+
+		a.PreviousHandles = phs
+	}
+
 	if err := a.AfterLoad(); err != nil {
 		return nil, fmt.Errorf("failed to run after load on account: %w", err)
 	}
@@ -65,6 +83,21 @@ func (s *AccountReader) Get(ctx context.Context, cmd *account.GetCmd) (*account.
 	return &a, nil
 }
 
+func (s *AccountReader) GetLink(ctx context.Context, cmd *account.GetLinkCmd) (*account.Link, error) {
+	return s.linkReader.GetLink(ctx, cmd)
+}
+
+func (s *AccountReader) listPreviousHandles(ctx context.Context, id uuid.UUID) ([]account.PreviousHandle, error) {
+	const query = `select * from handle_history where account_id = $1 order by changed_at desc`
+
+	var phs []account.PreviousHandle
+	if err := s.db.SelectContext(ctx, &phs, query, id); err != nil {
+		return nil, fmt.Errorf("failed to select handle history: %w", err)
+	}
+
+	return phs, nil
+}
+
 type AccountWriter struct {
 	db         *sqlx.DB
 	linkWriter *LinkWriter
@@ -79,13 +112,19 @@ func NewAccountWriter(db *sqlx.DB) *AccountWriter {
 
 func (s *AccountWriter) SaveAccount(ctx context.Context, a *account.Account) error {
 	const query = `insert into
-		accounts (id, name, handle, password, avi, css, inserted_at, updated_at)
-		values (:id, :name, :handle, :password, :avi, :css, :inserted_at, :updated_at)
+		accounts (id, name, handle, password, email, email_verified_at, avi, avi_thumbnail, css, theme, deletion_requested_at, inserted_at, updated_at)
+		values (:id, :name, :handle, :password, :email, :email_verified_at, :avi, :avi_thumbnail, :css, :theme, :deletion_requested_at, :inserted_at, :updated_at)
 	on conflict (id) do update set
 		name = :name,
 		handle = :handle,
+		password = :password,
+		email = :email,
+		email_verified_at = :email_verified_at,
 		avi = :avi,
+		avi_thumbnail = :avi_thumbnail,
 		css = :css,
+		theme = :theme,
+		deletion_requested_at = :deletion_requested_at,
 		updated_at = :updated_at`
 
 	if err := a.BeforeSave(); err != nil {
@@ -95,19 +134,130 @@ func (s *AccountWriter) SaveAccount(ctx context.Context, a *account.Account) err
 	tx := s.db.MustBeginTx(ctx, nil)
 	defer tx.Rollback()
 
-	for i := range a.Links {
-		if err := s.linkWriter.SaveLinkWithTx(ctx, tx, &a.Links[i]); err != nil {
-			return fmt.Errorf("failed to save link: %w", err)
-		}
+	oldHandle, err := s.recordHandleChangeWithTx(ctx, tx, a)
+	if err != nil {
+		return fmt.Errorf("failed to record handle change: %w", err)
 	}
 
+	// The account has to exist before its links can reference it
 	if _, err := tx.NamedExecContext(ctx, query, a); err != nil {
 		return fmt.Errorf("failed to insert account: %w", err)
 	}
 
+	// Deletions go first so a removed link's URL can be reused right away
+	if err := s.linkWriter.DeleteLinksWithTx(ctx, tx, a.ID, a.RemovedLinks); err != nil {
+		return fmt.Errorf("failed to delete removed links: %w", err)
+	}
+
+	for i := range a.Links {
+		if err := s.linkWriter.SaveLinkWithTx(ctx, tx, &a.Links[i]); err != nil {
+			return fmt.Errorf("failed to save link: %w", err)
+		}
+	}
+
 	if err := tx.Commit(); err != nil {
 		return fmt.Errorf("failed to commit transaction: %w", err)
 	}
 
+	a.RemovedLinks = nil
+
+	if oldHandle != "" {
+		a.PreviousHandles = append([]account.PreviousHandle{{
+			Handle:    oldHandle,
+			AccountID: a.ID,
+			ChangedAt: a.UpdatedAt,
+		}}, a.PreviousHandles...)
+	}
+
 	return nil
 }
+
+// recordHandleChangeWithTx moves the account's current handle into the handle
+// history if it is about to change, and takes the new handle out of the history
+// in case the account is reclaiming one of its previous handles. It returns
+// the old handle if it changed.
+func (s *AccountWriter) recordHandleChangeWithTx(ctx context.Context, tx *sqlx.Tx, a *account.Account) (string, error) {
+	const (
+		selectQuery = `select handle from accounts where id = $1 for update`
+		insertQuery = `insert into
+			handle_history (handle, account_id, changed_at)
+			values ($1, $2, $3)
+		on conflict (handle) do update set
+			changed_at = excluded.changed_at
+		where handle_history.account_id = excluded.account_id`
+		deleteQuery = `delete from handle_history where handle = $1 and account_id = $2`
+	)
+
+	var oldHandle string
+	if err := tx.GetContext(ctx, &oldHandle, selectQuery, a.ID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", nil
+		}
+
+		return "", fmt.Errorf("failed to get current handle: %w", err)
+	}
+
+	if oldHandle == a.Handle {
+		return "", nil
+	}
+
+	if _, err := tx.ExecContext(ctx, insertQuery, oldHandle, a.ID, a.UpdatedAt); err != nil {
+		return "", fmt.Errorf("failed to insert previous handle: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, deleteQuery, a.Handle, a.ID); err != nil {
+		return "", fmt.Errorf("failed to delete reclaimed handle: %w", err)
+	}
+
+	return oldHandle, nil
+}
+
+// SanitizeCSS runs the CSS of every account through the CSS policy again and
+// saves what changed, for CSS stored before the policy existed. Pages trust
+// stored CSS, so this runs before the server starts. It returns how many
+// accounts changed.
+func (s *AccountWriter) SanitizeCSS(ctx context.Context) (int, error) {
+	const (
+		selectQuery = `select id, css from accounts where css <> ''`
+		updateQuery = `update accounts set css = $1 where id = $2`
+	)
+
+	var as []account.Account
+	if err := s.db.SelectContext(ctx, &as, selectQuery); err != nil {
+		return 0, fmt.Errorf("failed to select account css: %w", err)
+	}
+
+	changed := 0
+	for _, a := range as {
+		out := account.SanitizeCSS(a.CSS)
+		if out == a.CSS {
+			continue
+		}
+
+		if _, err := s.db.ExecContext(ctx, updateQuery, out, a.ID); err != nil {
+			return changed, fmt.Errorf("failed to update account css: %w", err)
+		}
+
+		changed++
+	}
+
+	return changed, nil
+}
+
+// DeleteAccounts deletes up to Limit accounts that asked to be deleted before
+// RequestedBefore, everything else they own goes with them through the cascades
+func (s *AccountWriter) DeleteAccounts(ctx context.Context, cmd *account.DeleteAccountsCmd) ([]account.Account, error) {
+	const query = `delete from accounts where id in (
+		select id from accounts
+		where deletion_requested_at is not null and deletion_requested_at < $1
+		order by deletion_requested_at
+		limit $2
+	) returning id, handle`
+
+	var as []account.Account
+	if err := s.db.SelectContext(ctx, &as, query, cmd.RequestedBefore, cmd.Limit); err != nil {
+		return nil, fmt.Errorf("failed to delete accounts: %w", err)
+	}
+
+	return as, nil
+}
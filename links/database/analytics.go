@@ -0,0 +1,66 @@
+package database
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/derinil/links/links/analytics"
+	"github.com/jmoiron/sqlx"
+)
+
+type AnalyticsReader struct {
+	db *sqlx.DB
+}
+
+var _ analytics.Reader = (*AnalyticsReader)(nil)
+
+func NewAnalyticsReader(db *sqlx.DB) *AnalyticsReader {
+	return &AnalyticsReader{db: db}
+}
+
+func (s *AnalyticsReader) ListDailyViews(ctx context.Context, cmd *analytics.RangeCmd) ([]analytics.DailyViews, error) {
+	const query = `select day, sum(views) as views
+	from profile_view_rollups
+	where account_id = $1 and day between $2 and $3
+	group by day
+	order by day`
+
+	var dvs []analytics.DailyViews
+	if err := s.db.SelectContext(ctx, &dvs, query, cmd.AccountID, cmd.From, cmd.To); err != nil {
+		return nil, fmt.Errorf("failed to select daily views: %w", err)
+	}
+
+	return dvs, nil
+}
+
+func (s *AnalyticsReader) ListLinkClicks(ctx context.Context, cmd *analytics.RangeCmd) ([]analytics.LinkClicks, error) {
+	const query = `select l.id as link_id, l.title, l.link, coalesce(sum(r.clicks), 0) as clicks
+	from links l
+	left join link_click_rollups r on r.link_id = l.id and r.day between $2 and $3
+	where l.account_id = $1
+	group by l.id
+	order by l.index`
+
+	var lcs []analytics.LinkClicks
+	if err := s.db.SelectContext(ctx, &lcs, query, cmd.AccountID, cmd.From, cmd.To); err != nil {
+		return nil, fmt.Errorf("failed to select link clicks: %w", err)
+	}
+
+	return lcs, nil
+}
+
+func (s *AnalyticsReader) ListReferrers(ctx context.Context, cmd *analytics.RangeCmd) ([]analytics.ReferrerViews, error) {
+	const query = `select referrer, sum(views) as views
+	from profile_view_rollups
+	where account_id = $1 and day between $2 and $3
+	group by referrer
+	order by views desc
+	limit $4`
+
+	var rvs []analytics.ReferrerViews
+	if err := s.db.SelectContext(ctx, &rvs, query, cmd.AccountID, cmd.From, cmd.To, analytics.TopReferrers); err != nil {
+		return nil, fmt.Errorf("failed to select referrers: %w", err)
+	}
+
+	return rvs, nil
+}
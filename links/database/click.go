@@ -0,0 +1,73 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/derinil/links/links/tracking"
+	"github.com/jmoiron/sqlx"
+)
+
+type ClickWriter struct {
+	db *sqlx.DB
+}
+
+var _ tracking.Writer[tracking.Click] = (*ClickWriter)(nil)
+
+func NewClickWriter(db *sqlx.DB) *ClickWriter {
+	return &ClickWriter{db: db}
+}
+
+// Save inserts the whole batch and bumps the daily rollups with a single statement.
+// Clicks on links that were deleted since they were recorded are skipped instead
+// of failing the batch, and clicks by bots don't count towards the rollups.
+func (s *ClickWriter) Save(ctx context.Context, cs []tracking.Click) error {
+	const query = `with inserted as (
+		insert into
+			clicks (id, link_id, account_id, referrer, user_agent_class, clicked_at)
+		select c.id, c.link_id, c.account_id, c.referrer, c.user_agent_class, c.clicked_at
+		from unnest($1::uuid[], $2::uuid[], $3::uuid[], $4::text[], $5::text[], $6::timestamp[])
+			as c (id, link_id, account_id, referrer, user_agent_class, clicked_at)
+		join links l on l.id = c.link_id and l.account_id = c.account_id
+		on conflict (id) do nothing
+		returning account_id, link_id, user_agent_class, clicked_at
+	)
+	insert into
+		link_click_rollups (account_id, link_id, day, clicks)
+	select account_id, link_id, clicked_at::date, count(*)
+	from inserted
+	where user_agent_class <> $7
+	group by account_id, link_id, clicked_at::date
+	on conflict (link_id, day) do update set
+		clicks = link_click_rollups.clicks + excluded.clicks`
+
+	if len(cs) == 0 {
+		return nil
+	}
+
+	var (
+		ids        = make([]string, len(cs))
+		linkIDs    = make([]string, len(cs))
+		accountIDs = make([]string, len(cs))
+		referrers  = make([]string, len(cs))
+		classes    = make([]string, len(cs))
+		clickedAts = make([]time.Time, len(cs))
+	)
+
+	for i := range cs {
+		c := &cs[i]
+		ids[i] = c.ID.String()
+		linkIDs[i] = c.LinkID.String()
+		accountIDs[i] = c.AccountID.String()
+		referrers[i] = c.Referrer
+		classes[i] = string(c.UserAgentClass)
+		clickedAts[i] = c.ClickedAt
+	}
+
+	if _, err := s.db.ExecContext(ctx, query, ids, linkIDs, accountIDs, referrers, classes, clickedAts, string(tracking.UserAgentBot)); err != nil {
+		return fmt.Errorf("failed to insert clicks: %w", err)
+	}
+
+	return nil
+}
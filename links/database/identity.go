@@ -0,0 +1,93 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/derinil/links/links/account/oidc"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+type IdentityReader struct {
+	db *sqlx.DB
+}
+
+var _ oidc.Reader = (*IdentityReader)(nil)
+
+func NewIdentityReader(db *sqlx.DB) *IdentityReader {
+	return &IdentityReader{db: db}
+}
+
+func (s *IdentityReader) GetIdentity(ctx context.Context, provider, subject string) (*oidc.Identity, error) {
+	const query = `select * from identities where provider = $1 and subject = $2`
+
+	var i oidc.Identity
+	if err := s.db.GetContext(ctx, &i, query, provider, subject); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("failed to get identity: %w", err)
+	}
+
+	return &i, nil
+}
+
+func (s *IdentityReader) ListIdentitiesByAccountID(ctx context.Context, accountID uuid.UUID) ([]oidc.Identity, error) {
+	const query = `select * from identities where account_id = $1 order by inserted_at asc`
+
+	var is []oidc.Identity
+	if err := s.db.SelectContext(ctx, &is, query, accountID); err != nil {
+		return nil, fmt.Errorf("failed to select identities: %w", err)
+	}
+
+	return is, nil
+}
+
+type IdentityWriter struct {
+	db *sqlx.DB
+}
+
+var _ oidc.Writer = (*IdentityWriter)(nil)
+
+func NewIdentityWriter(db *sqlx.DB) *IdentityWriter {
+	return &IdentityWriter{db: db}
+}
+
+func (s *IdentityWriter) SaveIdentity(ctx context.Context, i *oidc.Identity) error {
+	const query = `insert into
+		identities (id, account_id, provider, subject, email, inserted_at, updated_at)
+		values (:id, :account_id, :provider, :subject, :email, :inserted_at, :updated_at)
+	on conflict (id) do update set
+		email = :email,
+		updated_at = :updated_at`
+
+	if err := i.BeforeSave(); err != nil {
+		return fmt.Errorf("failed to run before save on identity: %w", err)
+	}
+
+	if _, err := s.db.NamedExecContext(ctx, query, i); err != nil {
+		return fmt.Errorf("failed to insert identity: %w", err)
+	}
+
+	return nil
+}
+
+func (s *IdentityWriter) DeleteIdentity(ctx context.Context, accountID, id uuid.UUID) (bool, error) {
+	const query = `delete from identities where account_id = $1 and id = $2`
+
+	res, err := s.db.ExecContext(ctx, query, accountID, id)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete identity: %w", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	return n > 0, nil
+}
@@ -2,9 +2,13 @@ package database
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"fmt"
+	"time"
 
 	"github.com/derinil/links/links/account"
+	"github.com/derinil/links/links/favicon"
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
 )
@@ -18,7 +22,7 @@ func NewLinkReader(db *sqlx.DB) *LinkReader {
 }
 
 func (s *LinkReader) ListLinksByAccountID(ctx context.Context, id uuid.UUID) ([]account.Link, error) {
-	const query = `select * from links where account_id = $1`
+	const query = `select * from links where account_id = $1 order by index`
 
 	var ls []account.Link
 	if err := s.db.SelectContext(ctx, &ls, query, id); err != nil {
@@ -34,6 +38,30 @@ func (s *LinkReader) ListLinksByAccountID(ctx context.Context, id uuid.UUID) ([]
 	return ls, nil
 }
 
+func (s *LinkReader) GetLink(ctx context.Context, cmd *account.GetLinkCmd) (*account.Link, error) {
+	const query = `select l.* from links l
+		join accounts a on a.id = l.account_id
+	where l.id = $1 and a.deletion_requested_at is null and (
+		a.handle = $2 or
+		exists (select 1 from handle_history h where h.handle = $2 and h.account_id = a.id and h.changed_at > $3)
+	)`
+
+	var l account.Link
+	if err := s.db.GetContext(ctx, &l, query, cmd.ID, cmd.Handle, cmd.HandleChangedAfter); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("failed to get link: %w", err)
+	}
+
+	if err := l.AfterLoad(); err != nil {
+		return nil, fmt.Errorf("failed to run after load on link: %w", err)
+	}
+
+	return &l, nil
+}
+
 type LinkWriter struct {
 	db *sqlx.DB
 }
@@ -50,6 +78,7 @@ func (s *LinkWriter) SaveLinkWithTx(ctx context.Context, tx *sqlx.Tx, l *account
 		title = :title,
 		link = :link,
 		favicon = :favicon,
+		index = :index,
 		updated_at = :updated_at`
 
 	if err := l.BeforeSave(); err != nil {
@@ -62,3 +91,33 @@ func (s *LinkWriter) SaveLinkWithTx(ctx context.Context, tx *sqlx.Tx, l *account
 
 	return nil
 }
+
+func (s *LinkWriter) DeleteLinksWithTx(ctx context.Context, tx *sqlx.Tx, accountID uuid.UUID, ids []uuid.UUID) error {
+	const query = `delete from links where account_id = $1 and id = any($2::uuid[])`
+
+	if len(ids) == 0 {
+		return nil
+	}
+
+	strs := make([]string, len(ids))
+	for i := range ids {
+		strs[i] = ids[i].String()
+	}
+
+	if _, err := tx.ExecContext(ctx, query, accountID, strs); err != nil {
+		return fmt.Errorf("failed to delete links: %w", err)
+	}
+
+	return nil
+}
+
+// SaveFavicon only touches the link if it still points to the URL the favicon was fetched for
+func (s *LinkWriter) SaveFavicon(ctx context.Context, job *favicon.Job, b []byte) error {
+	const query = `update links set favicon = $1, updated_at = $2 where id = $3 and link = $4`
+
+	if _, err := s.db.ExecContext(ctx, query, b, time.Now().UTC(), job.LinkID, job.URL); err != nil {
+		return fmt.Errorf("failed to update favicon: %w", err)
+	}
+
+	return nil
+}
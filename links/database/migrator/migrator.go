@@ -89,7 +89,11 @@ func (m *Migrator) walkExec(filter func(string) bool) error {
 		return fmt.Errorf("failed to walk dir: %w", err)
 	}
 
+	// By number rather than name, otherwise 10_ would run before 2_
 	sort.Slice(migrations, func(i, j int) bool {
+		if migrations[i].PrefixNumber != migrations[j].PrefixNumber {
+			return migrations[i].PrefixNumber < migrations[j].PrefixNumber
+		}
 		return migrations[i].Name < migrations[j].Name
 	})
 
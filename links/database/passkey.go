@@ -0,0 +1,122 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/derinil/links/links/account/passkey"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+type PasskeyReader struct {
+	db *sqlx.DB
+}
+
+var _ passkey.Reader = (*PasskeyReader)(nil)
+
+func NewPasskeyReader(db *sqlx.DB) *PasskeyReader {
+	return &PasskeyReader{db: db}
+}
+
+func (s *PasskeyReader) ListCredentialsByAccountID(ctx context.Context, accountID uuid.UUID) ([]passkey.Credential, error) {
+	const query = `select * from passkeys where account_id = $1 order by inserted_at asc`
+
+	var cs []passkey.Credential
+	if err := s.db.SelectContext(ctx, &cs, query, accountID); err != nil {
+		return nil, fmt.Errorf("failed to select passkeys: %w", err)
+	}
+
+	return cs, nil
+}
+
+func (s *PasskeyReader) GetCredentialByCredentialID(ctx context.Context, credentialID []byte) (*passkey.Credential, error) {
+	const query = `select * from passkeys where credential_id = $1`
+
+	var c passkey.Credential
+	if err := s.db.GetContext(ctx, &c, query, credentialID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("failed to get passkey: %w", err)
+	}
+
+	return &c, nil
+}
+
+type PasskeyWriter struct {
+	db *sqlx.DB
+}
+
+var _ passkey.Writer = (*PasskeyWriter)(nil)
+
+func NewPasskeyWriter(db *sqlx.DB) *PasskeyWriter {
+	return &PasskeyWriter{db: db}
+}
+
+func (s *PasskeyWriter) SaveCredential(ctx context.Context, c *passkey.Credential) error {
+	const query = `insert into
+		passkeys (id, account_id, name, credential_id, public_key, sign_count, last_used_at, inserted_at, updated_at)
+		values (:id, :account_id, :name, :credential_id, :public_key, :sign_count, :last_used_at, :inserted_at, :updated_at)
+	on conflict (id) do update set
+		name = :name,
+		sign_count = :sign_count,
+		last_used_at = :last_used_at,
+		updated_at = :updated_at`
+
+	if err := c.BeforeSave(); err != nil {
+		return fmt.Errorf("failed to run before save on passkey: %w", err)
+	}
+
+	if _, err := s.db.NamedExecContext(ctx, query, c); err != nil {
+		return fmt.Errorf("failed to insert passkey: %w", err)
+	}
+
+	return nil
+}
+
+func (s *PasskeyWriter) RenameCredential(ctx context.Context, accountID, id uuid.UUID, name string) (bool, error) {
+	const query = `update passkeys set name = $1, updated_at = $2 where account_id = $3 and id = $4`
+
+	res, err := s.db.ExecContext(ctx, query, name, time.Now().UTC(), accountID, id)
+	if err != nil {
+		return false, fmt.Errorf("failed to rename passkey: %w", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	return n > 0, nil
+}
+
+func (s *PasskeyWriter) DeleteCredential(ctx context.Context, accountID, id uuid.UUID) (bool, error) {
+	const query = `delete from passkeys where account_id = $1 and id = $2`
+
+	res, err := s.db.ExecContext(ctx, query, accountID, id)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete passkey: %w", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	return n > 0, nil
+}
+
+func (s *PasskeyWriter) TouchCredential(ctx context.Context, id uuid.UUID, signCount int64, at time.Time) error {
+	const query = `update passkeys set sign_count = $1, last_used_at = $2 where id = $3`
+
+	if _, err := s.db.ExecContext(ctx, query, signCount, at, id); err != nil {
+		return fmt.Errorf("failed to update passkey: %w", err)
+	}
+
+	return nil
+}
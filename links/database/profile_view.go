@@ -0,0 +1,60 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/derinil/links/links/tracking"
+	"github.com/jmoiron/sqlx"
+)
+
+type ProfileViewWriter struct {
+	db *sqlx.DB
+}
+
+var _ tracking.Writer[tracking.ProfileView] = (*ProfileViewWriter)(nil)
+
+func NewProfileViewWriter(db *sqlx.DB) *ProfileViewWriter {
+	return &ProfileViewWriter{db: db}
+}
+
+// Save only bumps the daily rollups, we don't keep individual profile views around.
+// Views of accounts that were deleted since they were recorded are skipped.
+func (s *ProfileViewWriter) Save(ctx context.Context, vs []tracking.ProfileView) error {
+	const query = `insert into
+		profile_view_rollups (account_id, day, referrer, views)
+	select v.account_id, v.viewed_at::date, v.referrer, count(*)
+	from unnest($1::uuid[], $2::text[], $3::text[], $4::timestamp[])
+		as v (account_id, referrer, user_agent_class, viewed_at)
+	join accounts a on a.id = v.account_id
+	where v.user_agent_class <> $5
+	group by v.account_id, v.viewed_at::date, v.referrer
+	on conflict (account_id, day, referrer) do update set
+		views = profile_view_rollups.views + excluded.views`
+
+	if len(vs) == 0 {
+		return nil
+	}
+
+	var (
+		accountIDs = make([]string, len(vs))
+		referrers  = make([]string, len(vs))
+		classes    = make([]string, len(vs))
+		viewedAts  = make([]time.Time, len(vs))
+	)
+
+	for i := range vs {
+		v := &vs[i]
+		accountIDs[i] = v.AccountID.String()
+		referrers[i] = v.Referrer
+		classes[i] = string(v.UserAgentClass)
+		viewedAts[i] = v.ViewedAt
+	}
+
+	if _, err := s.db.ExecContext(ctx, query, accountIDs, referrers, classes, viewedAts, string(tracking.UserAgentBot)); err != nil {
+		return fmt.Errorf("failed to insert profile views: %w", err)
+	}
+
+	return nil
+}
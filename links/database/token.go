@@ -0,0 +1,117 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/derinil/links/links/account/token"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+type TokenReader struct {
+	db *sqlx.DB
+}
+
+var _ token.Reader = (*TokenReader)(nil)
+
+func NewTokenReader(db *sqlx.DB) *TokenReader {
+	return &TokenReader{db: db}
+}
+
+func (s *TokenReader) GetTokenByHash(ctx context.Context, hash []byte) (*token.Token, error) {
+	const query = `select * from access_tokens where hash = $1`
+
+	var t token.Token
+	if err := s.db.GetContext(ctx, &t, query, hash); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("failed to get token: %w", err)
+	}
+
+	if err := t.AfterLoad(); err != nil {
+		return nil, fmt.Errorf("failed to run after load on token: %w", err)
+	}
+
+	return &t, nil
+}
+
+func (s *TokenReader) ListTokensByAccountID(ctx context.Context, accountID uuid.UUID) ([]token.Token, error) {
+	const query = `select * from access_tokens where account_id = $1 order by inserted_at desc`
+
+	var ts []token.Token
+	if err := s.db.SelectContext(ctx, &ts, query, accountID); err != nil {
+		return nil, fmt.Errorf("failed to select tokens: %w", err)
+	}
+
+	for i := range ts {
+		if err := ts[i].AfterLoad(); err != nil {
+			return nil, fmt.Errorf("failed to run after load on token: %w", err)
+		}
+	}
+
+	return ts, nil
+}
+
+type TokenWriter struct {
+	db *sqlx.DB
+}
+
+var _ token.Writer = (*TokenWriter)(nil)
+
+func NewTokenWriter(db *sqlx.DB) *TokenWriter {
+	return &TokenWriter{db: db}
+}
+
+func (s *TokenWriter) SaveToken(ctx context.Context, t *token.Token) error {
+	const query = `insert into
+		access_tokens (id, account_id, name, hash, scopes, expires_at, last_used_at, inserted_at, updated_at)
+		values (:id, :account_id, :name, :hash, :scopes, :expires_at, :last_used_at, :inserted_at, :updated_at)
+	on conflict (id) do update set
+		name = :name,
+		scopes = :scopes,
+		expires_at = :expires_at,
+		last_used_at = :last_used_at,
+		updated_at = :updated_at`
+
+	if err := t.BeforeSave(); err != nil {
+		return fmt.Errorf("failed to run before save on token: %w", err)
+	}
+
+	if _, err := s.db.NamedExecContext(ctx, query, t); err != nil {
+		return fmt.Errorf("failed to insert token: %w", err)
+	}
+
+	return nil
+}
+
+func (s *TokenWriter) DeleteToken(ctx context.Context, accountID, id uuid.UUID) (bool, error) {
+	const query = `delete from access_tokens where account_id = $1 and id = $2`
+
+	res, err := s.db.ExecContext(ctx, query, accountID, id)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete token: %w", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	return n > 0, nil
+}
+
+func (s *TokenWriter) TouchToken(ctx context.Context, id uuid.UUID, at time.Time) error {
+	const query = `update access_tokens set last_used_at = $1 where id = $2`
+
+	if _, err := s.db.ExecContext(ctx, query, at, id); err != nil {
+		return fmt.Errorf("failed to update last used at: %w", err)
+	}
+
+	return nil
+}
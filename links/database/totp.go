@@ -0,0 +1,166 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/derinil/links/links/account/totp"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+type TOTPReader struct {
+	db *sqlx.DB
+}
+
+var _ totp.Reader = (*TOTPReader)(nil)
+
+func NewTOTPReader(db *sqlx.DB) *TOTPReader {
+	return &TOTPReader{db: db}
+}
+
+func (s *TOTPReader) GetTOTP(ctx context.Context, accountID uuid.UUID) (*totp.TOTP, error) {
+	const query = `select * from totp where account_id = $1`
+
+	var t totp.TOTP
+	if err := s.db.GetContext(ctx, &t, query, accountID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("failed to get totp: %w", err)
+	}
+
+	return &t, nil
+}
+
+func (s *TOTPReader) CountUnusedRecoveryCodes(ctx context.Context, accountID uuid.UUID) (int, error) {
+	const query = `select count(*) from recovery_codes where account_id = $1 and used_at is null`
+
+	var n int
+	if err := s.db.GetContext(ctx, &n, query, accountID); err != nil {
+		return 0, fmt.Errorf("failed to count recovery codes: %w", err)
+	}
+
+	return n, nil
+}
+
+type TOTPWriter struct {
+	db *sqlx.DB
+}
+
+var _ totp.Writer = (*TOTPWriter)(nil)
+
+func NewTOTPWriter(db *sqlx.DB) *TOTPWriter {
+	return &TOTPWriter{db: db}
+}
+
+const saveTOTPQuery = `insert into
+	totp (account_id, secret, confirmed_at, last_step, inserted_at, updated_at)
+	values (:account_id, :secret, :confirmed_at, :last_step, :inserted_at, :updated_at)
+on conflict (account_id) do update set
+	secret = :secret,
+	confirmed_at = :confirmed_at,
+	last_step = :last_step,
+	inserted_at = :inserted_at,
+	updated_at = :updated_at`
+
+func (s *TOTPWriter) SaveTOTP(ctx context.Context, t *totp.TOTP) error {
+	if _, err := s.db.NamedExecContext(ctx, saveTOTPQuery, t); err != nil {
+		return fmt.Errorf("failed to insert totp: %w", err)
+	}
+
+	return nil
+}
+
+func (s *TOTPWriter) ConfirmTOTP(ctx context.Context, t *totp.TOTP, codes []totp.RecoveryCode) error {
+	const (
+		deleteQuery = `delete from recovery_codes where account_id = $1`
+		insertQuery = `insert into
+			recovery_codes (id, account_id, hash, used_at, inserted_at)
+			values (:id, :account_id, :hash, :used_at, :inserted_at)`
+	)
+
+	tx := s.db.MustBeginTx(ctx, nil)
+	defer tx.Rollback()
+
+	if _, err := tx.NamedExecContext(ctx, saveTOTPQuery, t); err != nil {
+		return fmt.Errorf("failed to update totp: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, deleteQuery, t.AccountID); err != nil {
+		return fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+
+	if len(codes) > 0 {
+		if _, err := tx.NamedExecContext(ctx, insertQuery, codes); err != nil {
+			return fmt.Errorf("failed to insert recovery codes: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+func (s *TOTPWriter) UseStep(ctx context.Context, accountID uuid.UUID, step int64) (bool, error) {
+	// The comparison happens in the update so two requests with the same code can't both win
+	const query = `update totp set last_step = $1 where account_id = $2 and last_step < $1`
+
+	res, err := s.db.ExecContext(ctx, query, step, accountID)
+	if err != nil {
+		return false, fmt.Errorf("failed to update last step: %w", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	return n > 0, nil
+}
+
+func (s *TOTPWriter) UseRecoveryCode(ctx context.Context, accountID uuid.UUID, hash []byte, at time.Time) (bool, error) {
+	const query = `update recovery_codes set used_at = $1 where account_id = $2 and hash = $3 and used_at is null`
+
+	res, err := s.db.ExecContext(ctx, query, at, accountID, hash)
+	if err != nil {
+		return false, fmt.Errorf("failed to use recovery code: %w", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	return n > 0, nil
+}
+
+func (s *TOTPWriter) DeleteTOTP(ctx context.Context, accountID uuid.UUID) error {
+	const (
+		codesQuery = `delete from recovery_codes where account_id = $1`
+		totpQuery  = `delete from totp where account_id = $1`
+	)
+
+	tx := s.db.MustBeginTx(ctx, nil)
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, codesQuery, accountID); err != nil {
+		return fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, totpQuery, accountID); err != nil {
+		return fmt.Errorf("failed to delete totp: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
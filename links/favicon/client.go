@@ -0,0 +1,105 @@
+package favicon
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"syscall"
+	"time"
+)
+
+/*
+	SSRF Protection:
+		- Link URLs come from users, so fetching them is basically letting anyone
+			make requests from inside our network.
+		- The check happens in the dialer after the host has been resolved, which
+			covers redirects and DNS records that point at internal addresses
+			without us having to resolve anything ourselves.
+		- Proxies from the environment are ignored since they'd do the dialing.
+*/
+
+const (
+	// Timeout for a single request made by the client, Fetch has its own
+	// timeout for all requests it makes combined
+	ClientTimeout = 5 * time.Second
+	MaxRedirects  = 5
+)
+
+var ErrBlockedAddress = errors.New("address is not publicly routable")
+
+// Special purpose ranges net/netip doesn't have helpers for
+var blockedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+	netip.MustParsePrefix("64:ff9b:1::/48"),
+}
+
+// NewClient returns a client that refuses to connect to loopback,
+// private, link local and other addresses that aren't on the internet.
+func NewClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: ClientTimeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			ap, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return fmt.Errorf("failed to parse address: %w", err)
+			}
+
+			if !IsPublicAddr(ap.Addr()) {
+				return fmt.Errorf("%w: %s", ErrBlockedAddress, ap.Addr())
+			}
+
+			return nil
+		},
+	}
+
+	return &http.Client{
+		Timeout: ClientTimeout,
+		Transport: &http.Transport{
+			Proxy:                  nil,
+			DialContext:            dialer.DialContext,
+			TLSHandshakeTimeout:    ClientTimeout,
+			ResponseHeaderTimeout:  ClientTimeout,
+			MaxResponseHeaderBytes: 64 << 10,
+			MaxIdleConns:           10,
+			IdleConnTimeout:        30 * time.Second,
+		},
+		CheckRedirect: checkRedirect,
+	}
+}
+
+// IsPublicAddr reports whether the address is a global unicast address
+// outside of the private and special purpose ranges.
+func IsPublicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+
+	if !addr.IsGlobalUnicast() || addr.IsPrivate() {
+		return false
+	}
+
+	for _, p := range blockedPrefixes {
+		if p.Contains(addr) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= MaxRedirects {
+		return fmt.Errorf("stopped after %d redirects", MaxRedirects)
+	}
+
+	if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+		return fmt.Errorf("%w: redirect to %s", ErrInvalidURL, req.URL.Scheme)
+	}
+
+	return nil
+}
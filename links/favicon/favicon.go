@@ -0,0 +1,239 @@
+package favicon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"image"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/derinil/links/links/imaging"
+)
+
+/*
+	Favicons:
+		- The page the link points to is fetched and its <link rel=icon> tags are
+			tried in order, followed by /favicon.ico at the root of the site.
+		- Only the start of the page is read since the tags have to be in the head.
+		- Whatever we find is decoded, cropped to a square and stored as a Size by
+			Size PNG, so we never serve anything we got from the internet as is.
+*/
+
+const (
+	// Size of the stored favicons in pixels
+	Size = 32
+	// Time limit for everything a single Fetch does
+	FetchTimeout  = 15 * time.Second
+	MaxPageSize   = 512 << 10
+	MaxIconSize   = 256 << 10
+	MaxIconPixels = 1024 * 1024
+	// We give up on a page after this many icon links
+	MaxCandidates = 4
+
+	userAgent = "Mozilla/5.0 (compatible; links-favicon/1.0)"
+)
+
+var (
+	ErrInvalidURL   = errors.New("invalid url")
+	ErrIconTooLarge = errors.New("icon is too large")
+	ErrNotFound     = errors.New("no usable favicon found")
+)
+
+var (
+	linkTagRegex   = regexp.MustCompile(`(?is)<link\s[^>]*>`)
+	attributeRegex = regexp.MustCompile(`(?s)([a-zA-Z:-]+)\s*=\s*("[^"]*"|'[^']*'|[^\s"'>]+)`)
+	headEndRegex   = regexp.MustCompile(`(?i)</head\s*>|<body[\s>]`)
+)
+
+type Fetcher struct {
+	client *http.Client
+}
+
+// NewFetcher uses the client for all requests. Use NewClient
+// unless you really want to reach internal addresses.
+func NewFetcher(client *http.Client) *Fetcher {
+	return &Fetcher{client: client}
+}
+
+// Fetch finds the favicon of the site the URL points to and
+// returns it as a normalized PNG.
+func (s *Fetcher) Fetch(ctx context.Context, rawURL string) ([]byte, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, ErrInvalidURL
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, FetchTimeout)
+	defer cancel()
+
+	var candidates []string
+
+	// A page we can't read can still have a /favicon.ico
+	page, base, err := s.fetchPage(ctx, u.String())
+	if err == nil {
+		candidates = iconLinks(page, base)
+	} else {
+		base = u
+	}
+
+	candidates = append(candidates, (&url.URL{Scheme: base.Scheme, Host: base.Host, Path: "/favicon.ico"}).String())
+
+	lastErr := ErrNotFound
+	for _, c := range candidates {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("failed to fetch favicon: %w", ctx.Err())
+		}
+
+		b, err := s.fetchIcon(ctx, c)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		png, err := Normalize(b)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		return png, nil
+	}
+
+	return nil, fmt.Errorf("%w: %v", ErrNotFound, lastErr)
+}
+
+func (s *Fetcher) fetchPage(ctx context.Context, rawURL string) ([]byte, *url.URL, error) {
+	res, err := s.get(ctx, rawURL)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer res.Body.Close()
+
+	if ct := res.Header.Get("Content-Type"); ct != "" && !strings.Contains(ct, "html") {
+		return nil, nil, fmt.Errorf("page is %s, not html", ct)
+	}
+
+	// Cut off pages instead of failing, we only need the head
+	b, err := io.ReadAll(io.LimitReader(res.Body, MaxPageSize))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read page: %w", err)
+	}
+
+	// The final URL after redirects, relative icon links are relative to that
+	return b, res.Request.URL, nil
+}
+
+func (s *Fetcher) fetchIcon(ctx context.Context, rawURL string) ([]byte, error) {
+	res, err := s.get(ctx, rawURL)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.ContentLength > MaxIconSize {
+		return nil, ErrIconTooLarge
+	}
+
+	b, err := io.ReadAll(io.LimitReader(res.Body, MaxIconSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read icon: %w", err)
+	}
+
+	if len(b) > MaxIconSize {
+		return nil, ErrIconTooLarge
+	}
+
+	return b, nil
+}
+
+func (s *Fetcher) get(ctx context.Context, rawURL string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("User-Agent", userAgent)
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s: %w", rawURL, err)
+	}
+
+	if res.StatusCode != http.StatusOK {
+		res.Body.Close()
+		return nil, fmt.Errorf("failed to get %s: status %d", rawURL, res.StatusCode)
+	}
+
+	return res, nil
+}
+
+// iconLinks returns the absolute URLs of the icons linked in the head of the
+// page, regular icons first since apple touch icons tend to have backgrounds.
+func iconLinks(page []byte, base *url.URL) []string {
+	if loc := headEndRegex.FindIndex(page); loc != nil {
+		page = page[:loc[0]]
+	}
+
+	var icons, touchIcons []string
+	for _, tag := range linkTagRegex.FindAll(page, -1) {
+		attrs := make(map[string]string)
+		for _, m := range attributeRegex.FindAllSubmatch(tag, -1) {
+			attrs[strings.ToLower(string(m[1]))] = html.UnescapeString(strings.Trim(string(m[2]), `"'`))
+		}
+
+		href := strings.TrimSpace(attrs["href"])
+		if href == "" || strings.Contains(attrs["type"], "svg") {
+			continue
+		}
+
+		ref, err := base.Parse(href)
+		if err != nil || (ref.Scheme != "http" && ref.Scheme != "https") || strings.HasSuffix(ref.Path, ".svg") {
+			continue
+		}
+
+		for _, rel := range strings.Fields(strings.ToLower(attrs["rel"])) {
+			if rel == "icon" {
+				icons = append(icons, ref.String())
+				break
+			}
+
+			if rel == "apple-touch-icon" || rel == "apple-touch-icon-precomposed" {
+				touchIcons = append(touchIcons, ref.String())
+				break
+			}
+		}
+	}
+
+	icons = append(icons, touchIcons...)
+	if len(icons) > MaxCandidates {
+		icons = icons[:MaxCandidates]
+	}
+
+	return icons
+}
+
+// Normalize decodes an icon in any format we take and encodes it the way favicons are stored
+func Normalize(b []byte) ([]byte, error) {
+	var (
+		img image.Image
+		err error
+	)
+
+	if imaging.Format(http.DetectContentType(b)) == icoFormat {
+		img, err = decodeICO(b, MaxIconPixels)
+	} else {
+		img, _, err = imaging.Decode(b, MaxIconPixels)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode icon: %w", err)
+	}
+
+	return imaging.EncodePNG(imaging.Resize(imaging.CropSquare(img), Size, Size))
+}
@@ -0,0 +1,297 @@
+package favicon_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/derinil/links/links/favicon"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFetch(t *testing.T) {
+	var (
+		red   = color.NRGBA{R: 0xff, A: 0xff}
+		green = color.NRGBA{G: 0xff, A: 0xff}
+		blue  = color.NRGBA{B: 0xff, A: 0xff}
+	)
+
+	testCases := []struct {
+		name   string
+		routes map[string][]byte
+		path   string
+		color  color.NRGBA
+		err    error
+		errStr string
+	}{
+		{
+			name: "link rel icon",
+			routes: map[string][]byte{
+				"/":                []byte(`<html><head><link rel="icon" href="/static/icon.png"></head></html>`),
+				"/static/icon.png": encodePNG(64, 64, red),
+				"/favicon.ico":     icoWithPNG(encodePNG(16, 16, green)),
+			},
+			color: red,
+		},
+		{
+			name: "relative icon link on a sub page",
+			routes: map[string][]byte{
+				"/blog/post":     []byte(`<head><LINK REL='shortcut icon' HREF='icon.png'></head>`),
+				"/blog/icon.png": encodePNG(16, 16, red),
+			},
+			path:  "/blog/post",
+			color: red,
+		},
+		{
+			name: "icons outside the head are ignored",
+			routes: map[string][]byte{
+				"/":            []byte(`<head></head><body><link rel="icon" href="/icon.png"></body>`),
+				"/icon.png":    encodePNG(16, 16, red),
+				"/favicon.ico": icoWithPNG(encodePNG(16, 16, green)),
+			},
+			color: green,
+		},
+		{
+			name: "regular icons before apple touch icons",
+			routes: map[string][]byte{
+				"/": []byte(`<head>
+					<link rel="apple-touch-icon" href="/touch.png">
+					<link rel="icon" href="/icon.png">
+				</head>`),
+				"/touch.png": encodePNG(180, 180, blue),
+				"/icon.png":  encodePNG(32, 32, red),
+			},
+			color: red,
+		},
+		{
+			name: "broken icon falls through to the next one",
+			routes: map[string][]byte{
+				"/": []byte(`<head>
+					<link rel="icon" href="/broken.png">
+					<link rel="icon" href="/icon.svg">
+				</head>`),
+				"/broken.png":  []byte("not an image"),
+				"/favicon.ico": icoWithPNG(encodePNG(16, 16, green)),
+			},
+			color: green,
+		},
+		{
+			name: "favicon.ico with a png",
+			routes: map[string][]byte{
+				"/":            []byte(`<html></html>`),
+				"/favicon.ico": icoWithPNG(encodePNG(48, 48, green)),
+			},
+			color: green,
+		},
+		{
+			name: "favicon.ico with a bitmap",
+			routes: map[string][]byte{
+				"/favicon.ico": icoWithBitmap(16, blue),
+			},
+			color: blue,
+		},
+		{
+			name: "no favicon",
+			routes: map[string][]byte{
+				"/": []byte(`<html></html>`),
+			},
+			err: favicon.ErrNotFound,
+		},
+		{
+			name: "icon too large",
+			routes: map[string][]byte{
+				"/favicon.ico": make([]byte, favicon.MaxIconSize+1),
+			},
+			err:    favicon.ErrNotFound,
+			errStr: "icon is too large",
+		},
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			mux := http.NewServeMux()
+			for path, body := range c.routes {
+				path, body := path, body
+				mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+					if r.URL.Path != path {
+						http.NotFound(w, r)
+						return
+					}
+
+					if bytes.Contains(body, []byte("<")) {
+						w.Header().Set("Content-Type", "text/html")
+					}
+
+					w.Write(body)
+				})
+			}
+
+			srv := httptest.NewServer(mux)
+			defer srv.Close()
+
+			fetcher := favicon.NewFetcher(srv.Client())
+
+			b, err := fetcher.Fetch(context.Background(), srv.URL+c.path)
+			require.ErrorIs(t, err, c.err)
+
+			if c.err != nil {
+				require.ErrorContains(t, err, c.errStr)
+				return
+			}
+
+			img, err := png.Decode(bytes.NewReader(b))
+			require.Nil(t, err)
+			require.Equal(t, image.Rect(0, 0, favicon.Size, favicon.Size), img.Bounds())
+			require.Equal(t, c.color, color.NRGBAModel.Convert(img.At(favicon.Size/2, favicon.Size/2)))
+		})
+	}
+}
+
+func TestFetchInvalidURL(t *testing.T) {
+	fetcher := favicon.NewFetcher(http.DefaultClient)
+
+	for _, u := range []string{"", "ftp://example.com", "javascript:alert(1)", "https://"} {
+		_, err := fetcher.Fetch(context.Background(), u)
+		require.ErrorIs(t, err, favicon.ErrInvalidURL, u)
+	}
+}
+
+func TestFetchTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+
+	_, err := favicon.NewFetcher(srv.Client()).Fetch(ctx, srv.URL)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Less(t, time.Since(start), time.Second)
+}
+
+func TestClientBlocksInternalAddresses(t *testing.T) {
+	var hit bool
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hit = true
+	}))
+	defer srv.Close()
+
+	_, err := favicon.NewClient().Get(srv.URL)
+	require.ErrorIs(t, err, favicon.ErrBlockedAddress)
+	require.False(t, hit)
+}
+
+func TestIsPublicAddr(t *testing.T) {
+	testCases := []struct {
+		addr   string
+		public bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:2800:220:1:248:1893:25c8:1946", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"100.64.0.1", false},
+		{"0.0.0.0", false},
+		{"255.255.255.255", false},
+		{"224.0.0.1", false},
+		{"fc00::1", false},
+		{"fe80::1", false},
+		{"::ffff:127.0.0.1", false},
+		{"::ffff:10.0.0.1", false},
+		{"64:ff9b::a00:1", false},
+	}
+
+	for _, c := range testCases {
+		t.Run(c.addr, func(t *testing.T) {
+			require.Equal(t, c.public, favicon.IsPublicAddr(netip.MustParseAddr(c.addr)))
+		})
+	}
+}
+
+func encodePNG(w, h int, c color.NRGBA) []byte {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for i := 0; i < len(img.Pix); i += 4 {
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = c.R, c.G, c.B, c.A
+	}
+
+	var b bytes.Buffer
+	if err := png.Encode(&b, img); err != nil {
+		panic(err)
+	}
+
+	return b.Bytes()
+}
+
+func icoWithPNG(p []byte) []byte {
+	return ico(0, 0, 32, p)
+}
+
+// icoWithBitmap builds a 24 bit icon with an AND mask that makes the first row transparent
+func icoWithBitmap(size int, c color.NRGBA) []byte {
+	var (
+		b          bytes.Buffer
+		stride     = (size*24 + 31) / 32 * 4
+		maskStride = (size + 31) / 32 * 4
+	)
+
+	binary.Write(&b, binary.LittleEndian, struct {
+		Size                     uint32
+		Width, Height            int32
+		Planes, BitCount         uint16
+		Compression, ImageSize   uint32
+		XPerMeter, YPerMeter     int32
+		ColorsUsed, ColorsImport uint32
+	}{40, int32(size), int32(size * 2), 1, 24, 0, 0, 0, 0, 0, 0})
+
+	for y := 0; y < size; y++ {
+		row := make([]byte, stride)
+		for x := 0; x < size; x++ {
+			row[x*3], row[x*3+1], row[x*3+2] = c.B, c.G, c.R
+		}
+		b.Write(row)
+	}
+
+	for y := 0; y < size; y++ {
+		row := make([]byte, maskStride)
+		// Rows are bottom up so the last one is the top row
+		if y == size-1 {
+			for i := range row {
+				row[i] = 0xff
+			}
+		}
+		b.Write(row)
+	}
+
+	return ico(byte(size), byte(size), 24, b.Bytes())
+}
+
+func ico(w, h byte, bitCount uint16, data []byte) []byte {
+	var b bytes.Buffer
+
+	binary.Write(&b, binary.LittleEndian, []uint16{0, 1, 1})
+	b.Write([]byte{w, h, 0, 0})
+	binary.Write(&b, binary.LittleEndian, []uint16{1, bitCount})
+	binary.Write(&b, binary.LittleEndian, []uint32{uint32(len(data)), 22})
+	b.Write(data)
+
+	return b.Bytes()
+}
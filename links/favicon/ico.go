@@ -0,0 +1,215 @@
+package favicon
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+
+	"github.com/derinil/links/links/imaging"
+)
+
+/*
+	ICO Files:
+		- An ICO file is a directory of images, each entry is either a whole PNG
+			file or a BMP without its file header (a DIB).
+		- DIB entries store their height doubled since the color bitmap is followed
+			by a 1 bit AND mask which marks transparent pixels.
+		- We only decode the largest entry since we scale it down anyway.
+*/
+
+const icoFormat imaging.Format = "image/x-icon"
+
+var errInvalidICO = errors.New("invalid ico file")
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+type icoEntry struct {
+	width, height int
+	bitCount      int
+	size, offset  int
+}
+
+func decodeICO(b []byte, maxPixels int) (image.Image, error) {
+	// reserved, type, count
+	if len(b) < 6 || binary.LittleEndian.Uint16(b[2:]) != 1 {
+		return nil, errInvalidICO
+	}
+
+	count := int(binary.LittleEndian.Uint16(b[4:]))
+	if count == 0 || len(b) < 6+count*16 {
+		return nil, errInvalidICO
+	}
+
+	var best *icoEntry
+	for i := 0; i < count; i++ {
+		d := b[6+i*16:]
+
+		e := &icoEntry{
+			width:    int(d[0]),
+			height:   int(d[1]),
+			bitCount: int(binary.LittleEndian.Uint16(d[6:])),
+			size:     int(binary.LittleEndian.Uint32(d[8:])),
+			offset:   int(binary.LittleEndian.Uint32(d[12:])),
+		}
+
+		// 0 means 256
+		if e.width == 0 {
+			e.width = 256
+		}
+		if e.height == 0 {
+			e.height = 256
+		}
+
+		if best == nil || e.width > best.width || (e.width == best.width && e.bitCount > best.bitCount) {
+			best = e
+		}
+	}
+
+	if best.offset < 0 || best.size <= 0 || best.offset+best.size > len(b) || best.offset+best.size < best.offset {
+		return nil, errInvalidICO
+	}
+
+	data := b[best.offset : best.offset+best.size]
+
+	if bytes.HasPrefix(data, pngSignature) {
+		img, _, err := imaging.Decode(data, maxPixels)
+		return img, err
+	}
+
+	return decodeDIB(data, maxPixels)
+}
+
+func decodeDIB(b []byte, maxPixels int) (image.Image, error) {
+	if len(b) < 40 {
+		return nil, errInvalidICO
+	}
+
+	var (
+		headerSize  = int(binary.LittleEndian.Uint32(b[0:]))
+		width       = int(int32(binary.LittleEndian.Uint32(b[4:])))
+		height      = int(int32(binary.LittleEndian.Uint32(b[8:]))) / 2
+		bitCount    = int(binary.LittleEndian.Uint16(b[14:]))
+		compression = binary.LittleEndian.Uint32(b[16:])
+		colorsUsed  = int(binary.LittleEndian.Uint32(b[32:]))
+	)
+
+	if headerSize < 40 || headerSize > len(b) {
+		return nil, errInvalidICO
+	}
+
+	// Bitfields are only ever used with the default masks in icons
+	if compression != 0 && !(compression == 3 && bitCount == 32) {
+		return nil, fmt.Errorf("%w: unsupported compression %d", errInvalidICO, compression)
+	}
+
+	if width <= 0 || height <= 0 || width > 256 || height > 256 || width*height > maxPixels {
+		return nil, imaging.ErrImageTooLarge
+	}
+
+	var palette []color.NRGBA
+	switch bitCount {
+	case 1, 4, 8:
+		n := colorsUsed
+		if n == 0 || n > 1<<bitCount {
+			n = 1 << bitCount
+		}
+
+		p := b[headerSize:]
+		if len(p) < n*4 {
+			return nil, errInvalidICO
+		}
+
+		palette = make([]color.NRGBA, n)
+		for i := range palette {
+			palette[i] = color.NRGBA{R: p[i*4+2], G: p[i*4+1], B: p[i*4], A: 0xff}
+		}
+
+		headerSize += n * 4
+	case 24, 32:
+		if compression == 3 {
+			headerSize += 12
+		}
+	default:
+		return nil, fmt.Errorf("%w: unsupported bit count %d", errInvalidICO, bitCount)
+	}
+
+	if headerSize > len(b) {
+		return nil, errInvalidICO
+	}
+
+	var (
+		stride     = (width*bitCount + 31) / 32 * 4
+		maskStride = (width + 31) / 32 * 4
+		pixels     = b[headerSize:]
+	)
+
+	if len(pixels) < stride*height {
+		return nil, errInvalidICO
+	}
+
+	// Some icons leave out the mask, everything is opaque then
+	var mask []byte
+	if len(pixels) >= stride*height+maskStride*height {
+		mask = pixels[stride*height : stride*height+maskStride*height]
+	}
+
+	var (
+		img      = image.NewNRGBA(image.Rect(0, 0, width, height))
+		hasAlpha = false
+	)
+
+	for y := 0; y < height; y++ {
+		// Rows are stored bottom up
+		row := pixels[(height-1-y)*stride:]
+
+		for x := 0; x < width; x++ {
+			var c color.NRGBA
+
+			switch bitCount {
+			case 1, 4, 8:
+				var (
+					bit = x * bitCount
+					i   = int(row[bit/8]>>(8-bitCount-bit%8)) & (1<<bitCount - 1)
+				)
+
+				if i < len(palette) {
+					c = palette[i]
+				}
+			case 24:
+				c = color.NRGBA{R: row[x*3+2], G: row[x*3+1], B: row[x*3], A: 0xff}
+			case 32:
+				c = color.NRGBA{R: row[x*4+2], G: row[x*4+1], B: row[x*4], A: row[x*4+3]}
+				hasAlpha = hasAlpha || c.A != 0
+			}
+
+			img.SetNRGBA(x, y, c)
+		}
+	}
+
+	// 32 bit icons carry their own alpha, unless it's all zeroes in which
+	// case they were made for systems that only knew about the mask
+	if bitCount == 32 && hasAlpha {
+		return img, nil
+	}
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			c := img.NRGBAAt(x, y)
+			c.A = 0xff
+
+			if mask != nil {
+				row := mask[(height-1-y)*maskStride:]
+				if row[x/8]&(0x80>>(x%8)) != 0 {
+					c.A = 0
+				}
+			}
+
+			img.SetNRGBA(x, y, c)
+		}
+	}
+
+	return img, nil
+}
@@ -0,0 +1,98 @@
+package favicon
+
+import (
+	"context"
+	"log"
+	"sync"
+	"sync/atomic"
+
+	"github.com/google/uuid"
+)
+
+type (
+	Job struct {
+		LinkID    uuid.UUID
+		AccountID uuid.UUID
+		// The favicon is only saved if the link still points here
+		URL string
+	}
+
+	// Queue must never block the request that enqueues a job
+	Queue interface {
+		Enqueue(job *Job)
+	}
+
+	Writer interface {
+		SaveFavicon(ctx context.Context, job *Job, favicon []byte) error
+	}
+
+	// Worker fetches favicons in the background with a fixed number of goroutines.
+	// Jobs are dropped when the buffer is full, the link simply has no favicon
+	// until it's saved again.
+	Worker struct {
+		fetcher     *Fetcher
+		writer      Writer
+		jobs        chan Job
+		concurrency int
+		dropped     atomic.Uint64
+	}
+)
+
+func NewWorker(fetcher *Fetcher, writer Writer, bufferSize, concurrency int) *Worker {
+	return &Worker{
+		fetcher:     fetcher,
+		writer:      writer,
+		jobs:        make(chan Job, bufferSize),
+		concurrency: concurrency,
+	}
+}
+
+func (s *Worker) Enqueue(job *Job) {
+	select {
+	case s.jobs <- *job:
+	default:
+		s.dropped.Add(1)
+	}
+}
+
+// Dropped returns the number of jobs that didn't fit in the buffer
+func (s *Worker) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+// Run works on jobs until the context is cancelled and returns once the jobs
+// in progress are done. Jobs still in the buffer are dropped, there's no point
+// in holding up a shutdown for favicons.
+func (s *Worker) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+
+	wg.Add(s.concurrency)
+	for i := 0; i < s.concurrency; i++ {
+		go func() {
+			defer wg.Done()
+
+			for {
+				select {
+				case j := <-s.jobs:
+					s.work(ctx, &j)
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+}
+
+func (s *Worker) work(ctx context.Context, j *Job) {
+	// Plenty of sites don't have a favicon we can use, that's not worth logging
+	b, err := s.fetcher.Fetch(ctx, j.URL)
+	if err != nil {
+		return
+	}
+
+	if err := s.writer.SaveFavicon(ctx, j, b); err != nil {
+		log.Println("failed to save favicon for link", j.LinkID, err)
+	}
+}
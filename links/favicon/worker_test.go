@@ -0,0 +1,90 @@
+package favicon_test
+
+import (
+	"context"
+	"image/color"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/derinil/links/links/favicon"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+type FakeWriter struct {
+	sync.Mutex
+	saved map[uuid.UUID]favicon.Job
+}
+
+func (w *FakeWriter) SaveFavicon(ctx context.Context, job *favicon.Job, b []byte) error {
+	w.Lock()
+	defer w.Unlock()
+
+	w.saved[job.LinkID] = *job
+
+	return nil
+}
+
+func (w *FakeWriter) Saved() map[uuid.UUID]favicon.Job {
+	w.Lock()
+	defer w.Unlock()
+
+	m := make(map[uuid.UUID]favicon.Job, len(w.saved))
+	for k, v := range w.saved {
+		m[k] = v
+	}
+
+	return m
+}
+
+func TestWorker(t *testing.T) {
+	icon := icoWithPNG(encodePNG(16, 16, color.NRGBA{R: 0xff, A: 0xff}))
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/favicon.ico" {
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Write(icon)
+	}))
+	defer srv.Close()
+
+	var (
+		ctx, cancel = context.WithCancel(context.Background())
+		writer      = &FakeWriter{saved: make(map[uuid.UUID]favicon.Job)}
+		worker      = favicon.NewWorker(favicon.NewFetcher(srv.Client()), writer, 10, 2)
+		done        = make(chan struct{})
+		found       = favicon.Job{LinkID: uuid.New(), URL: srv.URL}
+		missing     = favicon.Job{LinkID: uuid.New(), URL: "ftp://nope"}
+	)
+
+	go func() {
+		worker.Run(ctx)
+		close(done)
+	}()
+
+	worker.Enqueue(&found)
+	worker.Enqueue(&missing)
+
+	require.Eventually(t, func() bool {
+		return len(writer.Saved()) == 1
+	}, 5*time.Second, time.Millisecond)
+
+	cancel()
+	<-done
+
+	require.Equal(t, map[uuid.UUID]favicon.Job{found.LinkID: found}, writer.Saved())
+}
+
+func TestWorkerDropsWhenFull(t *testing.T) {
+	worker := favicon.NewWorker(favicon.NewFetcher(http.DefaultClient), nil, 1, 1)
+
+	worker.Enqueue(&favicon.Job{LinkID: uuid.New()})
+	worker.Enqueue(&favicon.Job{LinkID: uuid.New()})
+
+	require.Equal(t, uint64(1), worker.Dropped())
+}
@@ -2,7 +2,9 @@ package generic
 
 import (
 	"context"
+	"net"
 	"net/http"
+	"strings"
 	"time"
 )
 
@@ -16,3 +18,61 @@ func RequestBeginTime(h http.Handler) http.Handler {
 		h.ServeHTTP(w, r)
 	})
 }
+
+// RealIP sets RemoteAddr to the client's IP from X-Forwarded-For or X-Real-IP,
+// but only for requests that come from one of the trusted proxies. Anyone else
+// could put anything in those headers, so their socket address is kept.
+func RealIP(trustedProxies []*net.IPNet) func(h http.Handler) http.Handler {
+	trusted := func(ip net.IP) bool {
+		for _, n := range trustedProxies {
+			if n.Contains(ip) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			host, _, err := net.SplitHostPort(r.RemoteAddr)
+			if err != nil {
+				host = r.RemoteAddr
+			}
+
+			if ip := net.ParseIP(host); ip != nil && trusted(ip) {
+				if client := forwardedFor(r, trusted); client != "" {
+					r.RemoteAddr = client
+				}
+			}
+
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+// forwardedFor returns the last address in X-Forwarded-For that isn't one of
+// our proxies, the ones before it were added by the client and can't be trusted
+func forwardedFor(r *http.Request, trusted func(ip net.IP) bool) string {
+	var hops []string
+	for _, v := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(v, ",")...)
+	}
+
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(hops[i]))
+		if ip == nil {
+			return ""
+		}
+
+		if i == 0 || !trusted(ip) {
+			return ip.String()
+		}
+	}
+
+	if ip := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); ip != nil {
+		return ip.String()
+	}
+
+	return ""
+}
@@ -0,0 +1,83 @@
+package generic_test
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/derinil/links/links/generic"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRealIP(t *testing.T) {
+	_, proxies, err := net.ParseCIDR("10.0.0.0/8")
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name       string
+		remoteAddr string
+		forwarded  []string
+		realIP     string
+		ip         string
+	}{
+		{
+			name:       "no proxy",
+			remoteAddr: "203.0.113.7:1234",
+			ip:         "203.0.113.7:1234",
+		},
+		{
+			name:       "headers from a client are ignored",
+			remoteAddr: "203.0.113.7:1234",
+			forwarded:  []string{"198.51.100.1"},
+			realIP:     "198.51.100.2",
+			ip:         "203.0.113.7:1234",
+		},
+		{
+			name:       "forwarded by a trusted proxy",
+			remoteAddr: "10.0.0.1:1234",
+			forwarded:  []string{"198.51.100.1"},
+			ip:         "198.51.100.1",
+		},
+		{
+			name:       "addresses the client made up are skipped",
+			remoteAddr: "10.0.0.1:1234",
+			forwarded:  []string{"1.2.3.4, 198.51.100.1", "10.0.0.2"},
+			ip:         "198.51.100.1",
+		},
+		{
+			name:       "garbage in the chain",
+			remoteAddr: "10.0.0.1:1234",
+			forwarded:  []string{"198.51.100.1, nonsense"},
+			ip:         "10.0.0.1:1234",
+		},
+		{
+			name:       "real ip from a trusted proxy",
+			remoteAddr: "10.0.0.1:1234",
+			realIP:     "198.51.100.2",
+			ip:         "198.51.100.2",
+		},
+	}
+
+	for _, c := range testCases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			var got string
+			h := generic.RealIP([]*net.IPNet{proxies})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = r.RemoteAddr
+			}))
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = c.remoteAddr
+			for _, f := range c.forwarded {
+				r.Header.Add("X-Forwarded-For", f)
+			}
+			if c.realIP != "" {
+				r.Header.Set("X-Real-IP", c.realIP)
+			}
+
+			h.ServeHTTP(httptest.NewRecorder(), r)
+			require.Equal(t, c.ip, got)
+		})
+	}
+}
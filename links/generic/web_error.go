@@ -1,11 +1,17 @@
 package generic
 
-import "errors"
+import (
+	"errors"
+	"time"
+)
 
 type WebError struct {
 	StatusCode int
 	ErrKey     string
 	ErrMsg     string
+	// RetryAfter is how long the client should wait before trying again,
+	// responses send it as the Retry-After header when it's set
+	RetryAfter time.Duration
 }
 
 func NewWebError(statusCode int, key, msg string) *WebError {
@@ -20,6 +26,18 @@ func (e *WebError) Error() string {
 	return e.ErrMsg
 }
 
+// Is matches web errors by their key, so copies with a different
+// message or RetryAfter still match the error they were made from
+func (e *WebError) Is(target error) bool {
+	t, ok := target.(*WebError)
+	return ok && t.ErrKey == e.ErrKey
+}
+
+// RetryAfterSeconds rounds RetryAfter up to whole seconds for the header
+func (e *WebError) RetryAfterSeconds() int {
+	return int((e.RetryAfter + time.Second - 1) / time.Second)
+}
+
 // Unwrap returns the unwrapped error and if that's nil,
 // it returns the error back
 func Unwrap(err error) error {
@@ -0,0 +1,182 @@
+package imaging
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+
+	"github.com/derinil/links/links/generic"
+	"golang.org/x/image/draw"
+	"golang.org/x/image/webp"
+)
+
+/*
+	Image Processing:
+		- We never trust the file name or the content type the client sent, the
+			format is sniffed from the first bytes and only PNG, JPEG, GIF and WebP
+			are decoded.
+		- The dimensions are checked before decoding the whole image so a tiny file
+			can't make us allocate gigabytes of pixels.
+		- Images are always re-encoded from their pixels, which also strips EXIF
+			and any other metadata that came with the original file. JPEGs are
+			turned upright from their EXIF orientation first, since that's lost
+			with it.
+*/
+
+type Format string
+
+const (
+	PNG  Format = "image/png"
+	JPEG Format = "image/jpeg"
+	GIF  Format = "image/gif"
+	WebP Format = "image/webp"
+)
+
+// 40 megapixels is already more than most phone cameras
+const DefaultMaxPixels = 40_000_000
+
+// GIFs only decode their first frame
+var decoders = map[Format]struct {
+	decode func(io.Reader) (image.Image, error)
+	config func(io.Reader) (image.Config, error)
+}{
+	PNG:  {png.Decode, png.DecodeConfig},
+	JPEG: {jpeg.Decode, jpeg.DecodeConfig},
+	GIF:  {gif.Decode, gif.DecodeConfig},
+	WebP: {webp.Decode, webp.DecodeConfig},
+}
+
+var (
+	ErrUnsupportedFormat = generic.NewWebError(http.StatusBadRequest, "image_unsupported", "Image must be a PNG, JPEG, GIF or WebP")
+	ErrImageTooLarge     = generic.NewWebError(http.StatusBadRequest, "image_too_large", "Image dimensions are too large")
+	ErrImageInvalid      = generic.NewWebError(http.StatusBadRequest, "image_invalid", "Image could not be read")
+)
+
+// Sniff detects the format of the image from its first bytes
+func Sniff(b []byte) (Format, error) {
+	switch f := Format(http.DetectContentType(b)); f {
+	case PNG, JPEG, GIF, WebP:
+		return f, nil
+	default:
+		return "", ErrUnsupportedFormat
+	}
+}
+
+func Decode(b []byte, maxPixels int) (image.Image, Format, error) {
+	f, err := Sniff(b)
+	if err != nil {
+		return nil, "", err
+	}
+
+	d := decoders[f]
+
+	cfg, err := d.config(bytes.NewReader(b))
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %v", ErrImageInvalid, err)
+	}
+
+	if cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width*cfg.Height > maxPixels {
+		return nil, "", ErrImageTooLarge
+	}
+
+	img, err := d.decode(bytes.NewReader(b))
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %v", ErrImageInvalid, err)
+	}
+
+	if f == JPEG {
+		img = Orient(img, jpegOrientation(b))
+	}
+
+	return img, f, nil
+}
+
+// CropSquare cuts the largest centered square out of the image
+func CropSquare(img image.Image) image.Image {
+	var (
+		b    = img.Bounds()
+		side = b.Dx()
+	)
+
+	if b.Dy() < side {
+		side = b.Dy()
+	}
+
+	var (
+		x0   = b.Min.X + (b.Dx()-side)/2
+		y0   = b.Min.Y + (b.Dy()-side)/2
+		rect = image.Rect(x0, y0, x0+side, y0+side)
+	)
+
+	if si, ok := img.(interface {
+		SubImage(image.Rectangle) image.Image
+	}); ok {
+		return si.SubImage(rect)
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, side, side))
+	draw.Draw(dst, dst.Bounds(), img, rect.Min, draw.Src)
+
+	return dst
+}
+
+// Resize scales the image to exactly w by h pixels
+func Resize(img image.Image, w, h int) *image.RGBA {
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, img.Bounds(), draw.Src, nil)
+
+	return dst
+}
+
+// Encode picks JPEG for opaque images since they're much smaller,
+// and PNG for anything with transparency
+func Encode(img image.Image) ([]byte, Format, error) {
+	if isOpaque(img) {
+		var b bytes.Buffer
+		if err := jpeg.Encode(&b, img, &jpeg.Options{Quality: 85}); err != nil {
+			return nil, "", fmt.Errorf("failed to encode jpeg: %w", err)
+		}
+
+		return b.Bytes(), JPEG, nil
+	}
+
+	b, err := EncodePNG(img)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return b, PNG, nil
+}
+
+func EncodePNG(img image.Image) ([]byte, error) {
+	var b bytes.Buffer
+
+	e := png.Encoder{CompressionLevel: png.BestCompression}
+	if err := e.Encode(&b, img); err != nil {
+		return nil, fmt.Errorf("failed to encode png: %w", err)
+	}
+
+	return b.Bytes(), nil
+}
+
+func isOpaque(img image.Image) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		return o.Opaque()
+	}
+
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if _, _, _, a := img.At(x, y).RGBA(); a != 0xffff {
+				return false
+			}
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,284 @@
+package imaging_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+
+	"github.com/derinil/links/links/imaging"
+	"github.com/stretchr/testify/require"
+)
+
+// 1x1 lossy webp, the standard library can't encode these
+const tinyWebP = "UklGRiQAAABXRUJQVlA4IBgAAAAwAQCdASoBAAEAAwA0JaQAA3AA/vuUAAA="
+
+func checkerboard(w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if (x+y)%2 == 0 {
+				img.Set(x, y, color.RGBA{255, 0, 0, 255})
+			} else {
+				img.Set(x, y, color.RGBA{0, 0, 255, 255})
+			}
+		}
+	}
+
+	return img
+}
+
+func encode(t *testing.T, f func(*bytes.Buffer) error) []byte {
+	var b bytes.Buffer
+	require.Nil(t, f(&b))
+
+	return b.Bytes()
+}
+
+func TestDecode(t *testing.T) {
+	var (
+		img      = checkerboard(30, 20)
+		pngBytes = encode(t, func(b *bytes.Buffer) error { return png.Encode(b, img) })
+		jpgBytes = encode(t, func(b *bytes.Buffer) error { return jpeg.Encode(b, img, nil) })
+		gifBytes = encode(t, func(b *bytes.Buffer) error { return gif.Encode(b, img, nil) })
+		webp, _  = base64.StdEncoding.DecodeString(tinyWebP)
+	)
+
+	testCases := []struct {
+		name      string
+		b         []byte
+		maxPixels int
+		format    imaging.Format
+		w, h      int
+		err       error
+	}{
+		{
+			name:   "png",
+			b:      pngBytes,
+			format: imaging.PNG,
+			w:      30,
+			h:      20,
+		},
+		{
+			name:   "jpeg",
+			b:      jpgBytes,
+			format: imaging.JPEG,
+			w:      30,
+			h:      20,
+		},
+		{
+			name:   "gif",
+			b:      gifBytes,
+			format: imaging.GIF,
+			w:      30,
+			h:      20,
+		},
+		{
+			name:   "webp",
+			b:      webp,
+			format: imaging.WebP,
+			w:      1,
+			h:      1,
+		},
+		{
+			name: "html pretending to be an image",
+			b:    []byte("<html><script>alert(1)</script></html>"),
+			err:  imaging.ErrUnsupportedFormat,
+		},
+		{
+			name: "svg",
+			b:    []byte(`<svg xmlns="http://www.w3.org/2000/svg"></svg>`),
+			err:  imaging.ErrUnsupportedFormat,
+		},
+		{
+			name: "truncated png",
+			b:    pngBytes[:40],
+			err:  imaging.ErrImageInvalid,
+		},
+		{
+			name:      "too many pixels",
+			b:         pngBytes,
+			maxPixels: 599,
+			err:       imaging.ErrImageTooLarge,
+		},
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			if c.maxPixels == 0 {
+				c.maxPixels = imaging.DefaultMaxPixels
+			}
+
+			img, f, err := imaging.Decode(c.b, c.maxPixels)
+			require.ErrorIs(t, err, c.err)
+
+			if c.err != nil {
+				return
+			}
+
+			require.Equal(t, c.format, f)
+			require.Equal(t, c.w, img.Bounds().Dx())
+			require.Equal(t, c.h, img.Bounds().Dy())
+		})
+	}
+}
+
+// withOrientation puts an EXIF segment with the orientation right after the
+// start of the JPEG, in either byte order
+func withOrientation(jpg []byte, o int, order binary.ByteOrder) []byte {
+	tiff := make([]byte, 8+2+12+4)
+	if order == binary.LittleEndian {
+		copy(tiff, "II")
+	} else {
+		copy(tiff, "MM")
+	}
+	order.PutUint16(tiff[2:], 42)
+	order.PutUint32(tiff[4:], 8)
+	order.PutUint16(tiff[8:], 1)
+	order.PutUint16(tiff[10:], 0x0112)
+	order.PutUint16(tiff[12:], 3)
+	order.PutUint32(tiff[14:], 1)
+	order.PutUint16(tiff[18:], uint16(o))
+
+	seg := append([]byte("Exif\x00\x00"), tiff...)
+	app1 := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(app1[2:], uint16(len(seg)+2))
+
+	out := append([]byte{}, jpg[:2]...)
+	out = append(out, app1...)
+	out = append(out, seg...)
+	return append(out, jpg[2:]...)
+}
+
+func TestOrientation(t *testing.T) {
+	// Red on the left half, blue on the right
+	img := image.NewRGBA(image.Rect(0, 0, 32, 16))
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 32; x++ {
+			if x < 16 {
+				img.Set(x, y, color.RGBA{255, 0, 0, 255})
+			} else {
+				img.Set(x, y, color.RGBA{0, 0, 255, 255})
+			}
+		}
+	}
+
+	jpg := encode(t, func(b *bytes.Buffer) error { return jpeg.Encode(b, img, &jpeg.Options{Quality: 100}) })
+
+	testCases := []struct {
+		name        string
+		orientation int
+		w, h        int
+		// A spot that has to be red after turning the image upright
+		red image.Point
+	}{
+		{name: "upright", orientation: 1, w: 32, h: 16, red: image.Pt(4, 8)},
+		{name: "mirrored", orientation: 2, w: 32, h: 16, red: image.Pt(28, 8)},
+		{name: "upside down", orientation: 3, w: 32, h: 16, red: image.Pt(28, 8)},
+		{name: "portrait", orientation: 6, w: 16, h: 32, red: image.Pt(8, 4)},
+		{name: "portrait the other way", orientation: 8, w: 16, h: 32, red: image.Pt(8, 28)},
+	}
+
+	for _, c := range testCases {
+		c := c
+		for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+			t.Run(c.name+" "+order.String(), func(t *testing.T) {
+				got, _, err := imaging.Decode(withOrientation(jpg, c.orientation, order), imaging.DefaultMaxPixels)
+				require.NoError(t, err)
+				require.Equal(t, c.w, got.Bounds().Dx())
+				require.Equal(t, c.h, got.Bounds().Dy())
+
+				r, _, b, _ := got.At(c.red.X, c.red.Y).RGBA()
+				require.Greater(t, r, uint32(0xc000))
+				require.Less(t, b, uint32(0x4000))
+			})
+		}
+	}
+
+	t.Run("every orientation", func(t *testing.T) {
+		// Where the top left pixel of a 3x2 image ends up
+		want := map[imaging.Orientation]image.Point{
+			1: image.Pt(0, 0),
+			2: image.Pt(2, 0),
+			3: image.Pt(2, 1),
+			4: image.Pt(0, 1),
+			5: image.Pt(0, 0),
+			6: image.Pt(1, 0),
+			7: image.Pt(1, 2),
+			8: image.Pt(0, 2),
+		}
+
+		src := image.NewRGBA(image.Rect(0, 0, 3, 2))
+		src.Set(0, 0, color.White)
+
+		for o, p := range want {
+			got := imaging.Orient(src, o)
+			require.Equal(t, color.RGBAModel.Convert(color.White), got.At(p.X, p.Y), "orientation %d", o)
+		}
+	})
+}
+
+func TestCropSquare(t *testing.T) {
+	testCases := []struct {
+		name   string
+		img    image.Image
+		side   int
+		origin image.Point
+	}{
+		{
+			name:   "landscape",
+			img:    checkerboard(30, 20),
+			side:   20,
+			origin: image.Pt(5, 0),
+		},
+		{
+			name:   "portrait",
+			img:    checkerboard(20, 31),
+			side:   20,
+			origin: image.Pt(0, 5),
+		},
+		{
+			name:   "already square",
+			img:    checkerboard(20, 20),
+			side:   20,
+			origin: image.Pt(0, 0),
+		},
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			sq := imaging.CropSquare(c.img)
+
+			require.Equal(t, c.side, sq.Bounds().Dx())
+			require.Equal(t, c.side, sq.Bounds().Dy())
+			require.Equal(t, c.origin, sq.Bounds().Min)
+		})
+	}
+}
+
+func TestResizeAndEncode(t *testing.T) {
+	opaque := imaging.Resize(checkerboard(40, 40), 16, 16)
+	require.Equal(t, image.Rect(0, 0, 16, 16), opaque.Bounds())
+
+	b, f, err := imaging.Encode(opaque)
+	require.Nil(t, err)
+	require.Equal(t, imaging.JPEG, f)
+
+	sf, err := imaging.Sniff(b)
+	require.Nil(t, err)
+	require.Equal(t, imaging.JPEG, sf)
+
+	transparent := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+	b, f, err = imaging.Encode(transparent)
+	require.Nil(t, err)
+	require.Equal(t, imaging.PNG, f)
+
+	sf, err = imaging.Sniff(b)
+	require.Nil(t, err)
+	require.Equal(t, imaging.PNG, sf)
+}
@@ -0,0 +1,134 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+)
+
+// Orientation is the EXIF orientation tag, 1 is upright and 2 to 8 are the
+// flips and rotations a camera stored the pixels with
+type Orientation int
+
+const exifOrientationTag = 0x0112
+
+// Orient turns the image upright, phones store portrait photos sideways and
+// only say so in EXIF, which re-encoding drops
+func Orient(img image.Image, o Orientation) image.Image {
+	if o < 2 || o > 8 {
+		return img
+	}
+
+	var (
+		b      = img.Bounds()
+		dw, dh = b.Dx(), b.Dy()
+	)
+
+	// 5 to 8 turn the image on its side
+	if o >= 5 {
+		dw, dh = dh, dw
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			var sx, sy int
+
+			switch o {
+			case 2:
+				sx, sy = dw-1-x, y
+			case 3:
+				sx, sy = dw-1-x, dh-1-y
+			case 4:
+				sx, sy = x, dh-1-y
+			case 5:
+				sx, sy = y, x
+			case 6:
+				sx, sy = y, dw-1-x
+			case 7:
+				sx, sy = dh-1-y, dw-1-x
+			case 8:
+				sx, sy = dh-1-y, x
+			}
+
+			dst.Set(x, y, img.At(b.Min.X+sx, b.Min.Y+sy))
+		}
+	}
+
+	return dst
+}
+
+// jpegOrientation reads the orientation from the EXIF segment of a JPEG, it
+// returns 1 when there is none or it can't be read
+func jpegOrientation(b []byte) Orientation {
+	if len(b) < 2 || b[0] != 0xFF || b[1] != 0xD8 {
+		return 1
+	}
+
+	// Walk the segments up to the image data, EXIF is an APP1 one
+	for i := 2; i+4 <= len(b); {
+		if b[i] != 0xFF {
+			return 1
+		}
+
+		marker := b[i+1]
+		if marker == 0xDA || marker == 0xD9 {
+			return 1
+		}
+
+		size := int(binary.BigEndian.Uint16(b[i+2:]))
+		if size < 2 || i+2+size > len(b) {
+			return 1
+		}
+
+		if seg := b[i+4 : i+2+size]; marker == 0xE1 && bytes.HasPrefix(seg, []byte("Exif\x00\x00")) {
+			return tiffOrientation(seg[6:])
+		}
+
+		i += 2 + size
+	}
+
+	return 1
+}
+
+// tiffOrientation looks for the orientation in the first IFD of the TIFF
+// structure EXIF is stored in
+func tiffOrientation(t []byte) Orientation {
+	if len(t) < 8 {
+		return 1
+	}
+
+	var order binary.ByteOrder
+	switch string(t[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+
+	ifd := int(order.Uint32(t[4:]))
+	if ifd < 8 || ifd+2 > len(t) {
+		return 1
+	}
+
+	count := int(order.Uint16(t[ifd:]))
+	for i := 0; i < count; i++ {
+		e := ifd + 2 + i*12
+		if e+12 > len(t) {
+			return 1
+		}
+
+		// A SHORT, which is kept in the first two bytes of the value
+		if order.Uint16(t[e:]) == exifOrientationTag && order.Uint16(t[e+2:]) == 3 {
+			if o := Orientation(order.Uint16(t[e+8:])); o >= 1 && o <= 8 {
+				return o
+			}
+
+			return 1
+		}
+	}
+
+	return 1
+}
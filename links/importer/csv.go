@@ -0,0 +1,98 @@
+package importer
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"io"
+	"strings"
+)
+
+// Header names we recognize, in any case
+var (
+	urlColumns   = []string{"url", "link", "href", "address"}
+	titleColumns = []string{"title", "name", "label", "text"}
+)
+
+// parseCSV reads a url and a title from each row. With a header the columns
+// are picked by name, without one the first cell that looks like a link is
+// the URL and the first other cell that isn't empty is the title.
+func parseCSV(data []byte) ([]Link, error) {
+	r := csv.NewReader(bytes.NewReader(data))
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = true
+	r.TrimLeadingSpace = true
+
+	var (
+		links    []Link
+		first    = true
+		urlCol   = -1
+		titleCol = -1
+		cell     = func(row []string, i int) string {
+			if i < 0 || i >= len(row) {
+				return ""
+			}
+			return row[i]
+		}
+	)
+
+	for {
+		row, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			return nil, ErrInvalidFile
+		}
+
+		if first {
+			first = false
+
+			urlCol, titleCol = column(row, urlColumns), column(row, titleColumns)
+			if urlCol >= 0 {
+				continue
+			}
+		}
+
+		if urlCol >= 0 {
+			links = append(links, Link{Title: cell(row, titleCol), URL: cell(row, urlCol)})
+			continue
+		}
+
+		var l Link
+		for _, c := range row {
+			c = strings.TrimSpace(c)
+
+			switch {
+			case l.URL == "" && looksLikeURL(c):
+				l.URL = c
+			case l.Title == "" && c != "":
+				l.Title = c
+			}
+		}
+
+		links = append(links, l)
+	}
+
+	return links, nil
+}
+
+// column returns the index of the first cell that is one of the names, or -1
+func column(row []string, names []string) int {
+	for i, c := range row {
+		c = strings.ToLower(strings.TrimSpace(c))
+		for _, n := range names {
+			if c == n {
+				return i
+			}
+		}
+	}
+
+	return -1
+}
+
+func looksLikeURL(s string) bool {
+	s = strings.ToLower(s)
+	return strings.HasPrefix(s, "http://") || strings.HasPrefix(s, "https://")
+}
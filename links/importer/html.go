@@ -0,0 +1,192 @@
+package importer
+
+import (
+	"html"
+	"strings"
+)
+
+// parseHTML finds every anchor with an href. It's not a full HTML parser,
+// it only knows enough to find tags and their attributes, skip comments
+// and skip what's inside script and style elements.
+func parseHTML(doc string) []Link {
+	var (
+		links []Link
+		// The anchor being read, nil outside of one
+		open *Link
+		text strings.Builder
+	)
+
+	for i := 0; i < len(doc); {
+		lt := strings.IndexByte(doc[i:], '<')
+		if lt < 0 {
+			lt = len(doc) - i
+		}
+
+		if open != nil {
+			text.WriteString(doc[i : i+lt])
+		}
+
+		i += lt
+		if i >= len(doc) {
+			break
+		}
+
+		if strings.HasPrefix(doc[i:], "<!--") {
+			end := strings.Index(doc[i+4:], "-->")
+			if end < 0 {
+				break
+			}
+
+			i += 4 + end + 3
+			continue
+		}
+
+		name, attrs, closing, next := readTag(doc, i)
+		i = next
+
+		// Text in different elements shouldn't run together
+		if open != nil {
+			text.WriteByte(' ')
+		}
+
+		switch {
+		case name == "a" && !closing:
+			// Anchors can't be nested, an unclosed one ends here
+			if open != nil {
+				links = append(links, finish(open, &text))
+			}
+
+			open = &Link{URL: attrs["href"]}
+			if open.URL == "" {
+				open = nil
+				continue
+			}
+
+			// Icon links only have a label
+			open.Title = attrs["aria-label"]
+			if open.Title == "" {
+				open.Title = attrs["title"]
+			}
+		case name == "a" && closing:
+			if open != nil {
+				links = append(links, finish(open, &text))
+				open = nil
+			}
+		case (name == "script" || name == "style") && !closing:
+			end := strings.Index(strings.ToLower(doc[i:]), "</"+name)
+			if end < 0 {
+				i = len(doc)
+				continue
+			}
+
+			i += end
+		}
+	}
+
+	if open != nil {
+		links = append(links, finish(open, &text))
+	}
+
+	return links
+}
+
+// finish prefers the text of the anchor to its label
+func finish(l *Link, text *strings.Builder) Link {
+	if t := strings.TrimSpace(html.UnescapeString(text.String())); t != "" {
+		l.Title = t
+	}
+
+	text.Reset()
+
+	return *l
+}
+
+// readTag reads the tag that starts at i. Names are lowercase and values
+// are unescaped. Anything that isn't a tag, like a lone <, is skipped.
+func readTag(doc string, i int) (name string, attrs map[string]string, closing bool, next int) {
+	i++ // <
+
+	if i < len(doc) && doc[i] == '/' {
+		closing = true
+		i++
+	}
+
+	start := i
+	for i < len(doc) && isNameChar(doc[i]) {
+		i++
+	}
+
+	name = strings.ToLower(doc[start:i])
+	if name == "" {
+		return "", nil, false, i
+	}
+
+	attrs = make(map[string]string)
+
+	for i < len(doc) {
+		for i < len(doc) && (isSpace(doc[i]) || doc[i] == '/') {
+			i++
+		}
+
+		if i >= len(doc) || doc[i] == '>' {
+			i++
+			break
+		}
+
+		start = i
+		for i < len(doc) && !isSpace(doc[i]) && doc[i] != '=' && doc[i] != '>' && doc[i] != '/' {
+			i++
+		}
+
+		key := strings.ToLower(doc[start:i])
+
+		for i < len(doc) && isSpace(doc[i]) {
+			i++
+		}
+
+		if i >= len(doc) || doc[i] != '=' {
+			attrs[key] = ""
+			continue
+		}
+
+		i++ // =
+
+		for i < len(doc) && isSpace(doc[i]) {
+			i++
+		}
+
+		var value string
+		if i < len(doc) && (doc[i] == '"' || doc[i] == '\'') {
+			quote := doc[i]
+			end := strings.IndexByte(doc[i+1:], quote)
+			if end < 0 {
+				return name, attrs, closing, len(doc)
+			}
+
+			value = doc[i+1 : i+1+end]
+			i += end + 2
+		} else {
+			start = i
+			for i < len(doc) && !isSpace(doc[i]) && doc[i] != '>' {
+				i++
+			}
+
+			value = doc[start:i]
+		}
+
+		// The first one wins like it does in browsers
+		if _, ok := attrs[key]; !ok {
+			attrs[key] = html.UnescapeString(value)
+		}
+	}
+
+	return name, attrs, closing, i
+}
+
+func isNameChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-'
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
@@ -0,0 +1,263 @@
+package importer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/derinil/links/links/account"
+	"github.com/derinil/links/links/generic"
+	"github.com/google/uuid"
+)
+
+/*
+	Link Imports:
+		- Links can be imported from a browser's bookmark export, a saved
+			link page like a Linktree profile, or a CSV file. The format is
+			detected from the content, file names and types aren't trusted.
+		- Only http and https links are kept, the first time a URL shows up
+			wins and titles come from the link text, falling back to the host.
+		- Parsing only makes a preview, the user picks which links to add.
+			Picked links are added after the current ones through
+			account.Handler.Update, links that are already on the page or
+			that don't validate are reported back instead of failing the import.
+*/
+
+type (
+	Handler interface {
+		Append(ctx context.Context, cmd *AppendCmd) (*AppendResult, error)
+	}
+
+	HandlerImpl struct {
+		accountHandler account.Handler
+	}
+
+	Format string
+
+	Link struct {
+		Title string
+		URL   string
+	}
+
+	AppendCmd struct {
+		AccountID uuid.UUID
+		Links     []Link
+	}
+
+	AppendResult struct {
+		// The account after the update, or as it was if nothing was added
+		Account *account.Account
+		Added   []Link
+		// Already on the page, or picked more than once
+		Duplicates []Link
+		// Rejected by Link.Validate
+		Invalid []Link
+	}
+)
+
+const (
+	// Netscape bookmark files, which every browser exports
+	Bookmarks Format = "bookmarks"
+	// Any other HTML page, like a saved Linktree profile
+	Page Format = "page"
+	CSV  Format = "csv"
+)
+
+const (
+	// More than this is more than anyone would pick from a preview
+	MaxLinks = 1000
+	// Longer titles are cut to fit Link.Title
+	MaxTitleLength = 128
+)
+
+var (
+	ErrNoLinks       = generic.NewWebError(http.StatusBadRequest, "import_no_links", "No links were found in the file")
+	ErrTooManyLinks  = generic.NewWebError(http.StatusBadRequest, "import_too_many_links", fmt.Sprintf("Files can have at most %d links", MaxLinks))
+	ErrInvalidFile   = generic.NewWebError(http.StatusBadRequest, "import_invalid_file", "File must be a bookmark export, an HTML page or a CSV file")
+	ErrNothingPicked = generic.NewWebError(http.StatusBadRequest, "import_nothing_picked", "Pick at least one link to add")
+)
+
+// Link pages link back to the service that hosts them, those aren't the user's links
+var platformHosts = map[string]struct{}{
+	"linktr.ee":     {},
+	"www.linktr.ee": {},
+}
+
+var _ Handler = (*HandlerImpl)(nil)
+
+func NewHandler(accountHandler account.Handler) *HandlerImpl {
+	return &HandlerImpl{accountHandler: accountHandler}
+}
+
+// Name is how the format is shown to users
+func (f Format) Name() string {
+	switch f {
+	case Bookmarks:
+		return "bookmark export"
+	case Page:
+		return "link page"
+	case CSV:
+		return "CSV file"
+	default:
+		return string(f)
+	}
+}
+
+// Detect guesses the format from the start of the file
+func Detect(data []byte) Format {
+	head := bytes.ToLower(bytes.TrimSpace(data))
+	if len(head) > 512 {
+		head = head[:512]
+	}
+
+	switch {
+	case bytes.HasPrefix(head, []byte("<!doctype netscape-bookmark-file")):
+		return Bookmarks
+	case bytes.HasPrefix(head, []byte("<")):
+		return Page
+	default:
+		return CSV
+	}
+}
+
+// Parse returns the links in the file in the order they appear, without duplicates
+func Parse(data []byte) ([]Link, Format, error) {
+	if !utf8.Valid(data) {
+		return nil, "", ErrInvalidFile
+	}
+
+	// Spreadsheets and some editors save with a byte order mark
+	data = bytes.TrimPrefix(data, []byte("\ufeff"))
+
+	var (
+		ls     []Link
+		err    error
+		format = Detect(data)
+	)
+
+	switch format {
+	case Bookmarks, Page:
+		ls = parseHTML(string(data))
+	case CSV:
+		ls, err = parseCSV(data)
+	}
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	var (
+		links = make([]Link, 0, len(ls))
+		seen  = make(map[string]struct{}, len(ls))
+	)
+
+	for _, l := range ls {
+		l, ok := clean(l)
+		if !ok {
+			continue
+		}
+
+		if _, ok := seen[l.URL]; ok {
+			continue
+		}
+		seen[l.URL] = struct{}{}
+
+		links = append(links, l)
+	}
+
+	if len(links) == 0 {
+		return nil, "", ErrNoLinks
+	}
+
+	if len(links) > MaxLinks {
+		return nil, "", ErrTooManyLinks
+	}
+
+	return links, format, nil
+}
+
+func (s *HandlerImpl) Append(ctx context.Context, cmd *AppendCmd) (*AppendResult, error) {
+	if len(cmd.Links) == 0 {
+		return nil, ErrNothingPicked
+	}
+
+	a, err := s.accountHandler.Get(ctx, &account.GetCmd{ID: cmd.AccountID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get account: %w", err)
+	}
+
+	var (
+		res       = &AppendResult{Account: a}
+		scaffolds = a.Scaffolds()
+		seen      = make(map[string]struct{}, len(a.Links)+len(cmd.Links))
+	)
+
+	for i := range a.Links {
+		seen[a.Links[i].Link] = struct{}{}
+	}
+
+	// Links are checked the way SetLinks does, so the update only
+	// fails for reasons that have nothing to do with the import
+	for _, l := range cmd.Links {
+		nl := account.NewLink(a.ID, l.Title, l.URL, len(scaffolds))
+		nl.Sanitize()
+
+		if err := nl.Validate(); err != nil {
+			res.Invalid = append(res.Invalid, l)
+			continue
+		}
+
+		if _, ok := seen[nl.Link]; ok {
+			res.Duplicates = append(res.Duplicates, l)
+			continue
+		}
+		seen[nl.Link] = struct{}{}
+
+		scaffolds = append(scaffolds, account.LinkScaffold{Title: nl.Title, Link: nl.Link})
+		res.Added = append(res.Added, Link{Title: nl.Title, URL: nl.Link})
+	}
+
+	if len(res.Added) == 0 {
+		return res, nil
+	}
+
+	res.Account, err = s.accountHandler.Update(ctx, &account.UpdateCmd{
+		AccountID: a.ID,
+		Links:     scaffolds,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to update account: %w", err)
+	}
+
+	return res, nil
+}
+
+// clean trims the link and fills in its title, it reports
+// false for anything that isn't an absolute http or https link
+func clean(l Link) (Link, bool) {
+	l.URL = strings.TrimSpace(l.URL)
+	l.Title = strings.Join(strings.Fields(l.Title), " ")
+
+	u, err := url.Parse(l.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return l, false
+	}
+
+	if _, ok := platformHosts[strings.ToLower(u.Hostname())]; ok {
+		return l, false
+	}
+
+	if l.Title == "" {
+		l.Title = strings.TrimPrefix(u.Hostname(), "www.")
+	}
+
+	if utf8.RuneCountInString(l.Title) > MaxTitleLength {
+		l.Title = string([]rune(l.Title)[:MaxTitleLength-1]) + "…"
+	}
+
+	return l, true
+}
@@ -0,0 +1,206 @@
+package importer_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/derinil/links/links/account"
+	"github.com/derinil/links/links/importer"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+// FakeStore is an account reader and writer backed by a map
+type FakeStore struct {
+	accounts map[uuid.UUID]account.Account
+}
+
+func (s *FakeStore) Get(ctx context.Context, cmd *account.GetCmd) (*account.Account, error) {
+	if a, ok := s.accounts[cmd.ID]; ok {
+		a.Links = append([]account.Link(nil), a.Links...)
+		return &a, nil
+	}
+
+	return nil, nil
+}
+
+func (s *FakeStore) GetLink(ctx context.Context, cmd *account.GetLinkCmd) (*account.Link, error) {
+	return nil, nil
+}
+
+func (s *FakeStore) SaveAccount(ctx context.Context, a *account.Account) error {
+	s.accounts[a.ID] = *a
+	return nil
+}
+
+const bookmarks = `<!DOCTYPE NETSCAPE-Bookmark-file-1>
+<!-- This is an automatically generated file.
+     It will be read and overwritten.
+     DO NOT EDIT! -->
+<META HTTP-EQUIV="Content-Type" CONTENT="text/html; charset=UTF-8">
+<TITLE>Bookmarks</TITLE>
+<H1>Bookmarks</H1>
+<DL><p>
+    <DT><H3 ADD_DATE="1700000000">Bookmarks bar</H3>
+    <DL><p>
+        <DT><A HREF="https://blog.example.com/" ADD_DATE="1700000000">My   blog</A>
+        <DT><A HREF="https://code.example.com/?a=1&amp;b=2" ADD_DATE="1700000000">Code &amp; stuff</A>
+        <DT><A HREF="javascript:alert(1)">Bookmarklet</A>
+        <DT><A HREF="https://www.example.org/"></A>
+        <DT><A HREF="https://blog.example.com/">Blog again</A>
+    </DL><p>
+</DL><p>
+`
+
+const page = `<!DOCTYPE html>
+<html>
+<head>
+<style>a { content: "<a href='https://style.example.com'>"; }</style>
+<script>document.write('<a href="https://script.example.com">x</a>')</script>
+</head>
+<body>
+<!-- <a href="https://comment.example.com">hidden</a> -->
+<div><a href="https://shop.example.com" data-testid="LinkButton"><p>Shop</p><span>new</span></a></div>
+<a href='https://music.example.com'
+   target=_blank>
+  Music
+</a>
+<a href="https://social.example.com/jane" aria-label="Social"><svg><path d="M0 0"/></svg></a>
+<a href="https://linktr.ee/">Join jane on Linktree</a>
+<a href="/privacy">Privacy</a>
+</body>
+</html>
+`
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   string
+		format importer.Format
+		links  []importer.Link
+	}{
+		{
+			name:   "bookmarks",
+			data:   bookmarks,
+			format: importer.Bookmarks,
+			links: []importer.Link{
+				{Title: "My blog", URL: "https://blog.example.com/"},
+				{Title: "Code & stuff", URL: "https://code.example.com/?a=1&b=2"},
+				{Title: "example.org", URL: "https://www.example.org/"},
+			},
+		},
+		{
+			name:   "page",
+			data:   page,
+			format: importer.Page,
+			links: []importer.Link{
+				{Title: "Shop new", URL: "https://shop.example.com"},
+				{Title: "Music", URL: "https://music.example.com"},
+				{Title: "Social", URL: "https://social.example.com/jane"},
+			},
+		},
+		{
+			name:   "csv with header",
+			data:   "\ufeffName,URL\nBlog,https://blog.example.com\n\"Code, mostly\",https://code.example.com\nNo link,\n",
+			format: importer.CSV,
+			links: []importer.Link{
+				{Title: "Blog", URL: "https://blog.example.com"},
+				{Title: "Code, mostly", URL: "https://code.example.com"},
+			},
+		},
+		{
+			name:   "csv without header",
+			data:   "https://blog.example.com,Blog\n, Code ,https://code.example.com,extra\nhttps://music.example.com\n",
+			format: importer.CSV,
+			links: []importer.Link{
+				{Title: "Blog", URL: "https://blog.example.com"},
+				{Title: "Code", URL: "https://code.example.com"},
+				{Title: "music.example.com", URL: "https://music.example.com"},
+			},
+		},
+	}
+
+	for _, c := range tests {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			links, format, err := importer.Parse([]byte(c.data))
+			require.NoError(t, err)
+			require.Equal(t, c.format, format)
+			require.Equal(t, c.links, links)
+		})
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	_, _, err := importer.Parse([]byte("<html><body>Nothing here</body></html>"))
+	require.ErrorIs(t, err, importer.ErrNoLinks)
+
+	_, _, err = importer.Parse([]byte{0xff, 0xfe, 0x00})
+	require.ErrorIs(t, err, importer.ErrInvalidFile)
+
+	var b strings.Builder
+	for i := 0; i <= importer.MaxLinks; i++ {
+		fmt.Fprintf(&b, "https://example.com/%d\n", i)
+	}
+
+	_, _, err = importer.Parse([]byte(b.String()))
+	require.ErrorIs(t, err, importer.ErrTooManyLinks)
+
+	links, _, err := importer.Parse([]byte("https://example.com," + strings.Repeat("a", 200)))
+	require.NoError(t, err)
+	require.Len(t, []rune(links[0].Title), importer.MaxTitleLength)
+}
+
+func TestAppend(t *testing.T) {
+	a := account.New("Jane", "jane", "hash")
+	require.NoError(t, a.SetLinks([]account.LinkScaffold{
+		{Title: "Blog", Link: "https://blog.example.com"},
+	}))
+
+	store := &FakeStore{accounts: map[uuid.UUID]account.Account{a.ID: *a}}
+	importerHandler := importer.NewHandler(account.NewHandler(store, store, time.Hour))
+	ctx := context.Background()
+
+	_, err := importerHandler.Append(ctx, &importer.AppendCmd{AccountID: a.ID})
+	require.ErrorIs(t, err, importer.ErrNothingPicked)
+
+	res, err := importerHandler.Append(ctx, &importer.AppendCmd{
+		AccountID: a.ID,
+		Links: []importer.Link{
+			{Title: "Old blog", URL: "https://blog.example.com"},
+			{Title: "Code", URL: "https://code.example.com"},
+			{Title: "", URL: "https://empty.example.com"},
+			{Title: "Code again", URL: "https://code.example.com"},
+			{Title: "Music", URL: "https://music.example.com"},
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []importer.Link{
+		{Title: "Code", URL: "https://code.example.com"},
+		{Title: "Music", URL: "https://music.example.com"},
+	}, res.Added)
+	require.Equal(t, []importer.Link{
+		{Title: "Old blog", URL: "https://blog.example.com"},
+		{Title: "Code again", URL: "https://code.example.com"},
+	}, res.Duplicates)
+	require.Equal(t, []importer.Link{{Title: "", URL: "https://empty.example.com"}}, res.Invalid)
+
+	saved := store.accounts[a.ID]
+	require.Len(t, saved.Links, 3)
+	require.Equal(t, "https://blog.example.com", saved.Links[0].Link)
+	require.Equal(t, "https://music.example.com", saved.Links[2].Link)
+	require.Equal(t, 2, saved.Links[2].Index)
+
+	// Nothing new leaves the account alone
+	res, err = importerHandler.Append(ctx, &importer.AppendCmd{
+		AccountID: a.ID,
+		Links:     []importer.Link{{Title: "Code", URL: "https://code.example.com"}},
+	})
+	require.NoError(t, err)
+	require.Empty(t, res.Added)
+	require.Len(t, res.Duplicates, 1)
+	require.Len(t, res.Account.Links, 3)
+}
@@ -0,0 +1,37 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// LogSender writes messages to w instead of sending them, for development.
+// Only the plain text version is written so links can be copied out of it.
+type LogSender struct {
+	sync.Mutex
+	w io.Writer
+}
+
+var _ Sender = (*LogSender)(nil)
+
+func NewLogSender(w io.Writer) *LogSender {
+	return &LogSender{w: w}
+}
+
+func (s *LogSender) Send(ctx context.Context, m *Message) error {
+	if err := m.Validate(); err != nil {
+		return err
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	_, err := fmt.Fprintf(s.w, "----- mail -----\nTo: %s\nSubject: %s\n\n%s\n----------------\n", m.To, m.Subject, m.Text)
+	if err != nil {
+		return fmt.Errorf("failed to write mail: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,128 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+/*
+	Mail:
+		- Everything that sends mail takes a Sender, so the SMTP server can be
+			swapped for LogSender during development and for fakes in tests.
+		- Messages are built from templates by a Renderer, the views package
+			has the one that's used, next to the page templates. Each message
+			has a plain text and an HTML version.
+		- Addresses and subjects are checked for line breaks before anything is
+			written, so user input can't add headers.
+*/
+
+type (
+	Sender interface {
+		Send(ctx context.Context, m *Message) error
+	}
+
+	// Renderer fills in everything but To from the template with the name
+	Renderer interface {
+		Render(name string, data any) (*Message, error)
+	}
+
+	Message struct {
+		To      string
+		Subject string
+		Text    string
+		HTML    string
+	}
+)
+
+var ErrInvalidMessage = errors.New("invalid message")
+
+// Validate makes sure the message can't smuggle in headers and has somewhere to go
+func (m *Message) Validate() error {
+	if strings.ContainsAny(m.To, "\r\n") || strings.ContainsAny(m.Subject, "\r\n") {
+		return fmt.Errorf("%w: line break in header", ErrInvalidMessage)
+	}
+
+	if _, err := mail.ParseAddress(m.To); err != nil {
+		return fmt.Errorf("%w: bad recipient: %v", ErrInvalidMessage, err)
+	}
+
+	if m.Text == "" && m.HTML == "" {
+		return fmt.Errorf("%w: no body", ErrInvalidMessage)
+	}
+
+	return nil
+}
+
+// encode writes the message out as a multipart/alternative MIME message
+func encode(from string, m *Message, now time.Time) ([]byte, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, fmt.Errorf("failed to read message id: %w", err)
+	}
+
+	domain := "localhost"
+	if a, err := mail.ParseAddress(from); err == nil {
+		if _, d, ok := strings.Cut(a.Address, "@"); ok {
+			domain = d
+		}
+	}
+
+	var (
+		buf bytes.Buffer
+		mw  = multipart.NewWriter(&buf)
+	)
+
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", m.To)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", m.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	fmt.Fprintf(&buf, "Message-ID: <%s@%s>\r\n", hex.EncodeToString(id), domain)
+	fmt.Fprintf(&buf, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&buf, "Content-Type: multipart/alternative; boundary=%q\r\n\r\n", mw.Boundary())
+
+	// Clients show the last part they understand, so HTML goes last
+	parts := []struct{ contentType, body string }{
+		{"text/plain; charset=utf-8", m.Text},
+		{"text/html; charset=utf-8", m.HTML},
+	}
+
+	for _, p := range parts {
+		if p.body == "" {
+			continue
+		}
+
+		pw, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {p.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create part: %w", err)
+		}
+
+		qw := quotedprintable.NewWriter(pw)
+		if _, err := qw.Write([]byte(p.body)); err != nil {
+			return nil, fmt.Errorf("failed to write part: %w", err)
+		}
+
+		if err := qw.Close(); err != nil {
+			return nil, fmt.Errorf("failed to close part: %w", err)
+		}
+	}
+
+	if err := mw.Close(); err != nil {
+		return nil, fmt.Errorf("failed to close message: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
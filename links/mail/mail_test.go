@@ -0,0 +1,77 @@
+package mail_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/derinil/links/links/mail"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidate(t *testing.T) {
+	testCases := []struct {
+		name string
+		m    *mail.Message
+		err  error
+	}{
+		{
+			name: "valid",
+			m:    &mail.Message{To: "jane@example.com", Subject: "Hi", Text: "Hello"},
+		},
+		{
+			name: "with a name",
+			m:    &mail.Message{To: "Jane Doe <jane@example.com>", Subject: "Hi", HTML: "<p>Hello</p>"},
+		},
+		{
+			name: "header in recipient",
+			m:    &mail.Message{To: "jane@example.com\r\nBcc: everyone@example.com", Subject: "Hi", Text: "Hello"},
+			err:  mail.ErrInvalidMessage,
+		},
+		{
+			name: "header in subject",
+			m:    &mail.Message{To: "jane@example.com", Subject: "Hi\nBcc: everyone@example.com", Text: "Hello"},
+			err:  mail.ErrInvalidMessage,
+		},
+		{
+			name: "bad recipient",
+			m:    &mail.Message{To: "jane", Subject: "Hi", Text: "Hello"},
+			err:  mail.ErrInvalidMessage,
+		},
+		{
+			name: "no body",
+			m:    &mail.Message{To: "jane@example.com", Subject: "Hi"},
+			err:  mail.ErrInvalidMessage,
+		},
+	}
+
+	for _, c := range testCases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			require.ErrorIs(t, c.m.Validate(), c.err)
+		})
+	}
+}
+
+func TestLogSender(t *testing.T) {
+	var (
+		buf bytes.Buffer
+		s   = mail.NewLogSender(&buf)
+	)
+
+	err := s.Send(context.Background(), &mail.Message{
+		To:      "jane@example.com",
+		Subject: "Reset your password",
+		Text:    "https://links.test/login/reset?token=abc",
+		HTML:    "<a href=\"https://links.test/login/reset?token=abc\">Reset</a>",
+	})
+	require.NoError(t, err)
+
+	require.Contains(t, buf.String(), "To: jane@example.com")
+	require.Contains(t, buf.String(), "Subject: Reset your password")
+	require.Contains(t, buf.String(), "https://links.test/login/reset?token=abc")
+	require.NotContains(t, buf.String(), "<a href")
+
+	err = s.Send(context.Background(), &mail.Message{To: "jane", Text: "Hello"})
+	require.ErrorIs(t, err, mail.ErrInvalidMessage)
+}
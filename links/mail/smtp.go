@@ -0,0 +1,77 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"time"
+)
+
+type (
+	// SMTPSender hands messages to an SMTP server. The connection is upgraded
+	// with STARTTLS when the server offers it, and credentials are only ever
+	// sent over TLS or to localhost.
+	SMTPSender struct {
+		addr string
+		auth smtp.Auth
+		from string
+		// Just the address part of from, for the envelope
+		envelopeFrom string
+	}
+
+	SMTPConfig struct {
+		Host     string
+		Port     int
+		Username string
+		Password string
+		// From can have a name as well, like "Links <links@example.com>"
+		From string
+	}
+)
+
+var _ Sender = (*SMTPSender)(nil)
+
+func NewSMTPSender(config SMTPConfig) (*SMTPSender, error) {
+	from, err := mail.ParseAddress(config.From)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse from address: %w", err)
+	}
+
+	s := &SMTPSender{
+		addr:         net.JoinHostPort(config.Host, strconv.Itoa(config.Port)),
+		from:         from.String(),
+		envelopeFrom: from.Address,
+	}
+
+	if config.Username != "" {
+		s.auth = smtp.PlainAuth("", config.Username, config.Password, config.Host)
+	}
+
+	return s, nil
+}
+
+// Send doesn't watch the context, net/smtp has no way to cancel a session
+func (s *SMTPSender) Send(ctx context.Context, m *Message) error {
+	if err := m.Validate(); err != nil {
+		return err
+	}
+
+	to, err := mail.ParseAddress(m.To)
+	if err != nil {
+		return fmt.Errorf("failed to parse recipient: %w", err)
+	}
+
+	msg, err := encode(s.from, m, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to encode message: %w", err)
+	}
+
+	if err := smtp.SendMail(s.addr, s.auth, s.envelopeFrom, []string{to.Address}, msg); err != nil {
+		return fmt.Errorf("failed to send mail: %w", err)
+	}
+
+	return nil
+}
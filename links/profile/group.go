@@ -0,0 +1,49 @@
+package profile
+
+import "sync"
+
+type (
+	// group runs one call per key at a time, callers that come in while
+	// one is running wait for it and get the same result
+	group[T any] struct {
+		mu    sync.Mutex
+		calls map[string]*call[T]
+	}
+
+	call[T any] struct {
+		wg  sync.WaitGroup
+		val T
+		err error
+	}
+)
+
+func (g *group[T]) do(key string, fn func() (T, error)) (T, error) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*call[T])
+	}
+
+	if c, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		c.wg.Wait()
+		return c.val, c.err
+	}
+
+	c := &call[T]{}
+	c.wg.Add(1)
+	g.calls[key] = c
+	g.mu.Unlock()
+
+	// Waiters are let go even if fn panics
+	defer func() {
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+
+		c.wg.Done()
+	}()
+
+	c.val, c.err = fn()
+
+	return c.val, c.err
+}
@@ -0,0 +1,291 @@
+package profile
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/derinil/links/links/account"
+	"github.com/derinil/links/links/cache"
+	"github.com/derinil/links/links/favicon"
+	"github.com/google/uuid"
+)
+
+/*
+	Profiles:
+		- Public pages are read through the cache in two steps: the handle
+			resolves to an account ID, and the ID to a snapshot of the account
+			with its links. Saving an account only has to drop its snapshot
+			and the keys of its handles, the old one included when it changes.
+		- Resolutions of previous handles expire with their grace period.
+		- Snapshots leave out everything the public page doesn't show, like
+			the password hash and the email.
+		- Concurrent misses for the same key share one trip to the database,
+			which runs until LoadTimeout even if the caller that started it left.
+		- Accounts that are pending deletion don't resolve. Requesting it is a
+			save, and purged accounts are dropped like saved ones.
+		- A miss that read the database right before a save can still store
+			what it read after the save dropped it, TTL bounds how long that
+			lasts.
+*/
+
+type (
+	Handler interface {
+		// Resolve is account.Handler.Resolve through the cache. The account is
+		// shared with concurrent callers, so it must not be modified.
+		Resolve(ctx context.Context, cmd *account.ResolveCmd) (*account.Account, error)
+	}
+
+	HandlerImpl struct {
+		cache          cache.Cache
+		accountHandler account.Handler
+		config         Config
+		accounts       group[*account.Account]
+	}
+
+	Config struct {
+		// How long snapshots and resolutions are kept
+		TTL time.Duration
+		// Has to match the one account.Handler got
+		HandleGracePeriod time.Duration
+	}
+
+	// Writer drops cached profiles after the account writer commits
+	Writer struct {
+		writer account.Writer
+		cache  cache.Cache
+	}
+
+	// FaviconWriter drops cached profiles after a link gets its favicon
+	FaviconWriter struct {
+		writer favicon.Writer
+		cache  cache.Cache
+	}
+
+	// Deleter drops cached profiles of the accounts the purge deleted
+	Deleter struct {
+		deleter account.Deleter
+		cache   cache.Cache
+	}
+
+	resolution struct {
+		AccountID uuid.UUID
+	}
+)
+
+const (
+	DefaultTTL = 10 * time.Minute
+	// How long a miss can take, it isn't bound by the request that started it
+	LoadTimeout = 10 * time.Second
+)
+
+var (
+	_ Handler         = (*HandlerImpl)(nil)
+	_ account.Writer  = (*Writer)(nil)
+	_ favicon.Writer  = (*FaviconWriter)(nil)
+	_ account.Deleter = (*Deleter)(nil)
+)
+
+func NewHandler(cache cache.Cache, accountHandler account.Handler, config Config) *HandlerImpl {
+	if config.TTL <= 0 {
+		config.TTL = DefaultTTL
+	}
+
+	return &HandlerImpl{
+		cache:          cache,
+		accountHandler: accountHandler,
+		config:         config,
+	}
+}
+
+func (s *HandlerImpl) Resolve(ctx context.Context, cmd *account.ResolveCmd) (*account.Account, error) {
+	var res resolution
+	if err := get(ctx, s.cache, handleKey(cmd.Handle), &res); err == nil {
+		return s.snapshot(ctx, res.AccountID)
+	}
+
+	return s.accounts.do("handle-"+cmd.Handle, func() (*account.Account, error) {
+		ctx, cancel := detach()
+		defer cancel()
+
+		a, err := s.accountHandler.Resolve(ctx, cmd)
+		if err != nil {
+			return nil, err
+		}
+
+		ttl := s.config.TTL
+
+		// A previous handle only resolves until its grace period is over
+		for i := range a.PreviousHandles {
+			ph := &a.PreviousHandles[i]
+			if ph.Handle != cmd.Handle {
+				continue
+			}
+
+			if left := time.Until(ph.ChangedAt.Add(s.config.HandleGracePeriod)); left < ttl {
+				ttl = left
+			}
+		}
+
+		a = strip(a)
+
+		s.put(ctx, accountKey(a.ID), a, s.config.TTL)
+		if ttl > 0 {
+			s.put(ctx, handleKey(cmd.Handle), &resolution{AccountID: a.ID}, ttl)
+		}
+
+		return a, nil
+	})
+}
+
+// snapshot gets the account from the cache or the database if it isn't cached
+func (s *HandlerImpl) snapshot(ctx context.Context, id uuid.UUID) (*account.Account, error) {
+	var a account.Account
+	if err := get(ctx, s.cache, accountKey(id), &a); err == nil {
+		return &a, nil
+	}
+
+	return s.accounts.do("account-"+id.String(), func() (*account.Account, error) {
+		ctx, cancel := detach()
+		defer cancel()
+
+		a, err := s.accountHandler.Get(ctx, &account.GetCmd{ID: id})
+		if err != nil {
+			return nil, err
+		}
+
+		// The resolution can be older than the request
+		if a.PendingDeletion() {
+			return nil, account.ErrAccountNotFound
+		}
+
+		a = strip(a)
+		s.put(ctx, accountKey(a.ID), a, s.config.TTL)
+
+		return a, nil
+	})
+}
+
+// detach makes the context of a miss. Callers that come in while it runs
+// wait for it, so it can't end when the caller that started it goes away.
+func detach() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), LoadTimeout)
+}
+
+// put only logs errors, the page works without the cache
+func (s *HandlerImpl) put(ctx context.Context, key string, v any, ttl time.Duration) {
+	var b bytes.Buffer
+	if err := gob.NewEncoder(&b).Encode(v); err != nil {
+		log.Println("failed to encode profile", err)
+		return
+	}
+
+	if err := s.cache.PutWithTTL(ctx, key, b.Bytes(), ttl); err != nil {
+		log.Println("failed to cache profile", err)
+	}
+}
+
+func NewWriter(writer account.Writer, cache cache.Cache) *Writer {
+	return &Writer{writer: writer, cache: cache}
+}
+
+func (s *Writer) SaveAccount(ctx context.Context, a *account.Account) error {
+	if err := s.writer.SaveAccount(ctx, a); err != nil {
+		return err
+	}
+
+	// The writer adds the old handle to PreviousHandles when it changes,
+	// its resolution is dropped whether or not the snapshot was cached
+	keys := []string{accountKey(a.ID), handleKey(a.Handle)}
+	for i := range a.PreviousHandles {
+		keys = append(keys, handleKey(a.PreviousHandles[i].Handle))
+	}
+
+	invalidate(ctx, s.cache, keys...)
+
+	return nil
+}
+
+func NewFaviconWriter(writer favicon.Writer, cache cache.Cache) *FaviconWriter {
+	return &FaviconWriter{writer: writer, cache: cache}
+}
+
+func (s *FaviconWriter) SaveFavicon(ctx context.Context, job *favicon.Job, b []byte) error {
+	if err := s.writer.SaveFavicon(ctx, job, b); err != nil {
+		return err
+	}
+
+	invalidate(ctx, s.cache, accountKey(job.AccountID))
+
+	return nil
+}
+
+func NewDeleter(deleter account.Deleter, cache cache.Cache) *Deleter {
+	return &Deleter{deleter: deleter, cache: cache}
+}
+
+// DeleteAccounts leaves the keys of previous handles to their TTL, they
+// point to an account that can't be found anymore either way
+func (s *Deleter) DeleteAccounts(ctx context.Context, cmd *account.DeleteAccountsCmd) ([]account.Account, error) {
+	as, err := s.deleter.DeleteAccounts(ctx, cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range as {
+		invalidate(ctx, s.cache, accountKey(as[i].ID), handleKey(as[i].Handle))
+	}
+
+	return as, nil
+}
+
+// invalidate only logs errors since the save already went through,
+// the page is stale until the TTL runs out then
+func invalidate(ctx context.Context, c cache.Cache, keys ...string) {
+	for _, k := range keys {
+		if _, err := c.Invalidate(ctx, k); err != nil {
+			log.Println("failed to invalidate profile", k, err)
+		}
+	}
+}
+
+func get(ctx context.Context, c cache.Cache, key string, v any) error {
+	b, err := c.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	if len(b) == 0 {
+		return cache.ErrNotFound
+	}
+
+	if err := gob.NewDecoder(bytes.NewReader(b)).Decode(v); err != nil {
+		return fmt.Errorf("failed to decode profile: %w", err)
+	}
+
+	return nil
+}
+
+// strip copies what the public page shows
+func strip(a *account.Account) *account.Account {
+	return &account.Account{
+		DBStruct: a.DBStruct,
+		Name:     a.Name,
+		Handle:   a.Handle,
+		CSS:      a.CSS,
+		Theme:    a.Theme,
+		Avi:      a.Avi,
+		Links:    a.Links,
+	}
+}
+
+func handleKey(handle string) string {
+	return "profile-handle-" + handle
+}
+
+func accountKey(id uuid.UUID) string {
+	return "profile-account-" + id.String()
+}
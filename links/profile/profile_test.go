@@ -0,0 +1,379 @@
+package profile_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/derinil/links/links/account"
+	"github.com/derinil/links/links/cache"
+	"github.com/derinil/links/links/favicon"
+	"github.com/derinil/links/links/generic"
+	"github.com/derinil/links/links/profile"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+// FakeStore is an account reader and writer backed by a map that counts
+// the queries it gets. Resolving can be held up with block.
+type FakeStore struct {
+	sync.Mutex
+	accounts map[uuid.UUID]account.Account
+	queries  int
+	block    chan struct{}
+}
+
+func (s *FakeStore) Get(ctx context.Context, cmd *account.GetCmd) (*account.Account, error) {
+	if s.block != nil {
+		<-s.block
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	s.queries++
+
+	for _, a := range s.accounts {
+		if a.ID == cmd.ID || a.Handle == cmd.Handle {
+			return &a, nil
+		}
+
+		for _, ph := range a.PreviousHandles {
+			if cmd.PreviousHandles && ph.Handle == cmd.Handle {
+				return &a, nil
+			}
+		}
+	}
+
+	return nil, nil
+}
+
+func (s *FakeStore) GetLink(ctx context.Context, cmd *account.GetLinkCmd) (*account.Link, error) {
+	return nil, nil
+}
+
+// SaveAccount records handle changes like the database does
+func (s *FakeStore) SaveAccount(ctx context.Context, a *account.Account) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if old, ok := s.accounts[a.ID]; ok && old.Handle != a.Handle {
+		a.PreviousHandles = append([]account.PreviousHandle{{
+			Handle:    old.Handle,
+			AccountID: a.ID,
+			ChangedAt: time.Now(),
+		}}, old.PreviousHandles...)
+	}
+
+	s.accounts[a.ID] = *a
+	return nil
+}
+
+func (s *FakeStore) SaveFavicon(ctx context.Context, job *favicon.Job, b []byte) error {
+	s.Lock()
+	defer s.Unlock()
+
+	a := s.accounts[job.AccountID]
+	for i := range a.Links {
+		if a.Links[i].ID == job.LinkID {
+			a.Links[i].Favicon = b
+		}
+	}
+
+	return nil
+}
+
+// DeleteAccounts deletes every account that asked to be deleted
+func (s *FakeStore) DeleteAccounts(ctx context.Context, cmd *account.DeleteAccountsCmd) ([]account.Account, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	var deleted []account.Account
+	for id, a := range s.accounts {
+		if a.PendingDeletion() {
+			deleted = append(deleted, account.Account{DBStruct: a.DBStruct, Handle: a.Handle})
+			delete(s.accounts, id)
+		}
+	}
+
+	return deleted, nil
+}
+
+func (s *FakeStore) count() int {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.queries
+}
+
+func newAccount(handle string) *account.Account {
+	now := time.Now()
+
+	a := &account.Account{
+		DBStruct:        generic.NewDBStruct(),
+		Name:            "Jane",
+		Handle:          handle,
+		Password:        "hash",
+		Email:           "jane@example.com",
+		EmailVerifiedAt: &now,
+		Avi:             []byte("avi"),
+		AviThumbnail:    []byte("thumbnail"),
+	}
+
+	a.Links = []account.Link{*account.NewLink(a.ID, "First", "https://first.com", 0)}
+
+	return a
+}
+
+func newHandler(t *testing.T, grace time.Duration, accounts ...*account.Account) (*profile.HandlerImpl, *profile.Writer, *FakeStore, *cache.Memory) {
+	store := &FakeStore{accounts: make(map[uuid.UUID]account.Account)}
+	for _, a := range accounts {
+		store.accounts[a.ID] = *a
+	}
+
+	mem := cache.NewMemory(cache.MemoryConfig{})
+	t.Cleanup(func() {
+		require.NoError(t, mem.Close())
+	})
+
+	var (
+		writer         = profile.NewWriter(store, mem)
+		accountHandler = account.NewHandler(store, writer, grace)
+		h              = profile.NewHandler(mem, accountHandler, profile.Config{
+			TTL:               time.Hour,
+			HandleGracePeriod: grace,
+		})
+	)
+
+	return h, writer, store, mem
+}
+
+func TestResolve(t *testing.T) {
+	var (
+		ctx            = context.Background()
+		a              = newAccount("jane")
+		h, _, store, _ = newHandler(t, time.Hour, a)
+	)
+
+	got, err := h.Resolve(ctx, &account.ResolveCmd{Handle: "jane"})
+	require.NoError(t, err)
+	require.Equal(t, 1, store.count())
+
+	got, err = h.Resolve(ctx, &account.ResolveCmd{Handle: "jane"})
+	require.NoError(t, err)
+	require.Equal(t, 1, store.count())
+
+	require.Equal(t, a.ID, got.ID)
+	require.Equal(t, "Jane", got.Name)
+	require.Equal(t, a.Avi, got.Avi)
+	require.Equal(t, a.Links, got.Links)
+
+	// Only what the public page shows is kept
+	require.Empty(t, got.Password)
+	require.Empty(t, got.Email)
+	require.Nil(t, got.EmailVerifiedAt)
+	require.Empty(t, got.AviThumbnail)
+
+	// Missing handles aren't cached
+	for i := 0; i < 2; i++ {
+		_, err = h.Resolve(ctx, &account.ResolveCmd{Handle: "john"})
+		require.ErrorIs(t, err, account.ErrAccountNotFound)
+	}
+
+	require.Equal(t, 3, store.count())
+}
+
+func TestInvalidate(t *testing.T) {
+	var (
+		ctx                   = context.Background()
+		a                     = newAccount("jane")
+		h, writer, store, mem = newHandler(t, time.Hour, a)
+		resolve               = func(handle string) *account.Account {
+			got, err := h.Resolve(ctx, &account.ResolveCmd{Handle: handle})
+			require.NoError(t, err)
+			return got
+		}
+	)
+
+	resolve("jane")
+
+	a.Name = "Jane Doe"
+	require.NoError(t, writer.SaveAccount(ctx, a))
+
+	require.Equal(t, "Jane Doe", resolve("jane").Name)
+	require.Equal(t, 2, store.count())
+
+	// Both handles are read again after a handle change
+	a.Handle = "jane-doe"
+	require.NoError(t, writer.SaveAccount(ctx, a))
+
+	require.Equal(t, "jane-doe", resolve("jane-doe").Handle)
+	require.Equal(t, "jane-doe", resolve("jane").Handle)
+	require.Equal(t, 4, store.count())
+
+	// Favicons are saved without the account, the job says whose page to drop
+	fw := profile.NewFaviconWriter(store, mem)
+	require.NoError(t, fw.SaveFavicon(ctx, &favicon.Job{
+		LinkID:    a.Links[0].ID,
+		AccountID: a.ID,
+		URL:       a.Links[0].Link,
+	}, []byte("favicon")))
+
+	require.Equal(t, []byte("favicon"), resolve("jane-doe").Links[0].Favicon)
+	require.Equal(t, 5, store.count())
+}
+
+func TestInvalidateWithoutSnapshot(t *testing.T) {
+	var (
+		ctx                   = context.Background()
+		a                     = newAccount("jane")
+		h, writer, store, mem = newHandler(t, 0, a)
+	)
+
+	_, err := h.Resolve(ctx, &account.ResolveCmd{Handle: "jane"})
+	require.NoError(t, err)
+
+	// Only the resolution of the old handle is left in the cache
+	require.NoError(t, profile.NewFaviconWriter(store, mem).SaveFavicon(ctx, &favicon.Job{
+		LinkID:    a.Links[0].ID,
+		AccountID: a.ID,
+	}, []byte("favicon")))
+
+	a.Handle = "jane-doe"
+	require.NoError(t, writer.SaveAccount(ctx, a))
+
+	// Without a grace period the old handle is free right away
+	_, err = h.Resolve(ctx, &account.ResolveCmd{Handle: "jane"})
+	require.ErrorIs(t, err, account.ErrAccountNotFound)
+}
+
+func TestDeletion(t *testing.T) {
+	var (
+		ctx                   = context.Background()
+		a                     = newAccount("jane")
+		h, writer, store, mem = newHandler(t, time.Hour, a)
+		resolve               = func() error {
+			_, err := h.Resolve(ctx, &account.ResolveCmd{Handle: "jane"})
+			return err
+		}
+	)
+
+	require.NoError(t, resolve())
+
+	// The page is gone as soon as the deletion is saved
+	now := time.Now()
+	a.DeletionRequestedAt = &now
+	require.NoError(t, writer.SaveAccount(ctx, a))
+	require.ErrorIs(t, resolve(), account.ErrAccountNotFound)
+
+	a.DeletionRequestedAt = nil
+	require.NoError(t, writer.SaveAccount(ctx, a))
+	require.NoError(t, resolve())
+
+	// The purge drops the cached page of what it deleted
+	s := store.accounts[a.ID]
+	s.DeletionRequestedAt = &now
+	store.accounts[a.ID] = s
+
+	deleted, err := profile.NewDeleter(store, mem).DeleteAccounts(ctx, &account.DeleteAccountsCmd{})
+	require.NoError(t, err)
+	require.Len(t, deleted, 1)
+	require.ErrorIs(t, resolve(), account.ErrAccountNotFound)
+}
+
+func TestPreviousHandle(t *testing.T) {
+	var (
+		ctx   = context.Background()
+		grace = time.Hour
+		a     = newAccount("jane-doe")
+	)
+
+	// The grace period of the old handle is almost over
+	a.PreviousHandles = []account.PreviousHandle{{
+		Handle:    "jane",
+		AccountID: a.ID,
+		ChangedAt: time.Now().Add(-grace + 50*time.Millisecond),
+	}}
+
+	h, _, _, _ := newHandler(t, grace, a)
+
+	got, err := h.Resolve(ctx, &account.ResolveCmd{Handle: "jane"})
+	require.NoError(t, err)
+	require.Equal(t, "jane-doe", got.Handle)
+
+	time.Sleep(100 * time.Millisecond)
+
+	_, err = h.Resolve(ctx, &account.ResolveCmd{Handle: "jane"})
+	require.ErrorIs(t, err, account.ErrAccountNotFound)
+
+	_, err = h.Resolve(ctx, &account.ResolveCmd{Handle: "jane-doe"})
+	require.NoError(t, err)
+}
+
+func TestConcurrentMisses(t *testing.T) {
+	var (
+		ctx            = context.Background()
+		a              = newAccount("jane")
+		h, _, store, _ = newHandler(t, time.Hour, a)
+		wg             sync.WaitGroup
+	)
+
+	store.block = make(chan struct{})
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			got, err := h.Resolve(ctx, &account.ResolveCmd{Handle: "jane"})
+			if err != nil || got.ID != a.ID {
+				t.Error("got", got, err)
+			}
+		}()
+	}
+
+	// Give every caller time to line up behind the first one
+	time.Sleep(50 * time.Millisecond)
+	close(store.block)
+	wg.Wait()
+
+	require.Equal(t, 1, store.count())
+}
+
+func TestCanceledMiss(t *testing.T) {
+	var (
+		a              = newAccount("jane")
+		h, _, store, _ = newHandler(t, time.Hour, a)
+		first, cancel  = context.WithCancel(context.Background())
+		wg             sync.WaitGroup
+	)
+
+	store.block = make(chan struct{})
+
+	resolve := func(ctx context.Context) {
+		defer wg.Done()
+
+		got, err := h.Resolve(ctx, &account.ResolveCmd{Handle: "jane"})
+		if err != nil || got.ID != a.ID {
+			t.Error("got", got, err)
+		}
+	}
+
+	wg.Add(2)
+	go resolve(first)
+	time.Sleep(20 * time.Millisecond)
+	go resolve(context.Background())
+	time.Sleep(20 * time.Millisecond)
+
+	// The caller that started the miss leaving doesn't fail the one waiting on it
+	cancel()
+	close(store.block)
+	wg.Wait()
+
+	require.Equal(t, 1, store.count())
+}
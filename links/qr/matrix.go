@@ -0,0 +1,314 @@
+package qr
+
+// matrix is a code being drawn. Function patterns are marked so
+// codewords and masks leave them alone.
+type matrix struct {
+	size     int
+	version  int
+	dark     []bool
+	function []bool
+}
+
+func newMatrix(version int) *matrix {
+	size := version*4 + 17
+
+	return &matrix{
+		size:     size,
+		version:  version,
+		dark:     make([]bool, size*size),
+		function: make([]bool, size*size),
+	}
+}
+
+func (m *matrix) set(x, y int, dark bool) {
+	m.dark[y*m.size+x] = dark
+	m.function[y*m.size+x] = true
+}
+
+func (m *matrix) at(x, y int) bool {
+	return m.dark[y*m.size+x]
+}
+
+func (m *matrix) drawFunctionPatterns() {
+	for i := 0; i < m.size; i++ {
+		m.set(6, i, i%2 == 0)
+		m.set(i, 6, i%2 == 0)
+	}
+
+	m.drawFinder(3, 3)
+	m.drawFinder(m.size-4, 3)
+	m.drawFinder(3, m.size-4)
+
+	// Alignment patterns go everywhere on the grid except over the finders
+	pos := alignmentPositions(m.version)
+	last := len(pos) - 1
+	for i := range pos {
+		for j := range pos {
+			if i == 0 && j == 0 || i == 0 && j == last || i == last && j == 0 {
+				continue
+			}
+
+			m.drawAlignment(pos[i], pos[j])
+		}
+	}
+
+	// Reserves the format areas, they're drawn for real once the mask is picked
+	m.drawFormat(0, 0)
+	m.drawVersion()
+}
+
+// drawFinder draws the pattern with its separator, cut off at the edges
+func (m *matrix) drawFinder(cx, cy int) {
+	for dy := -4; dy <= 4; dy++ {
+		for dx := -4; dx <= 4; dx++ {
+			x, y := cx+dx, cy+dy
+			if x < 0 || y < 0 || x >= m.size || y >= m.size {
+				continue
+			}
+
+			d := dist(dx, dy)
+			m.set(x, y, d != 2 && d != 4)
+		}
+	}
+}
+
+func (m *matrix) drawAlignment(cx, cy int) {
+	for dy := -2; dy <= 2; dy++ {
+		for dx := -2; dx <= 2; dx++ {
+			m.set(cx+dx, cy+dy, dist(dx, dy) != 1)
+		}
+	}
+}
+
+// drawFormat draws both copies of the level and the mask, and the dark module
+func (m *matrix) drawFormat(level Level, mask int) {
+	data := formatBits[level]<<3 | mask
+
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = (rem << 1) ^ ((rem >> 9) * 0x537)
+	}
+
+	bits := (data<<10 | rem) ^ 0x5412
+	bit := func(i int) bool { return bits>>i&1 == 1 }
+
+	// Around the top left finder
+	for i := 0; i <= 5; i++ {
+		m.set(8, i, bit(i))
+	}
+	m.set(8, 7, bit(6))
+	m.set(8, 8, bit(7))
+	m.set(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		m.set(14-i, 8, bit(i))
+	}
+
+	// Split between the other two finders
+	for i := 0; i < 8; i++ {
+		m.set(m.size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		m.set(8, m.size-15+i, bit(i))
+	}
+	m.set(8, m.size-8, true)
+}
+
+// drawVersion draws both copies of the version, which only 7 and up have
+func (m *matrix) drawVersion() {
+	if m.version < 7 {
+		return
+	}
+
+	rem := m.version
+	for i := 0; i < 12; i++ {
+		rem = (rem << 1) ^ ((rem >> 11) * 0x1F25)
+	}
+
+	bits := m.version<<12 | rem
+	for i := 0; i < 18; i++ {
+		var (
+			dark = bits>>i&1 == 1
+			a    = m.size - 11 + i%3
+			b    = i / 3
+		)
+
+		m.set(a, b, dark)
+		m.set(b, a, dark)
+	}
+}
+
+// drawCodewords fills everything that isn't a function pattern in the zigzag
+// order, two columns at a time from the bottom right
+func (m *matrix) drawCodewords(data []byte) {
+	i := 0
+	for right := m.size - 1; right >= 1; right -= 2 {
+		// The vertical timing pattern takes a whole column
+		if right == 6 {
+			right = 5
+		}
+
+		for vert := 0; vert < m.size; vert++ {
+			for j := 0; j < 2; j++ {
+				x := right - j
+
+				y := vert
+				if (right+1)&2 == 0 {
+					y = m.size - 1 - vert
+				}
+
+				if m.function[y*m.size+x] || i >= len(data)*8 {
+					continue
+				}
+
+				m.dark[y*m.size+x] = data[i/8]>>(7-i%8)&1 == 1
+				i++
+			}
+		}
+	}
+}
+
+func (m *matrix) applyMask(mask int) {
+	for y := 0; y < m.size; y++ {
+		for x := 0; x < m.size; x++ {
+			if m.function[y*m.size+x] {
+				continue
+			}
+
+			var flip bool
+			switch mask {
+			case 0:
+				flip = (x+y)%2 == 0
+			case 1:
+				flip = y%2 == 0
+			case 2:
+				flip = x%3 == 0
+			case 3:
+				flip = (x+y)%3 == 0
+			case 4:
+				flip = (x/3+y/2)%2 == 0
+			case 5:
+				flip = x*y%2+x*y%3 == 0
+			case 6:
+				flip = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				flip = ((x+y)%2+x*y%3)%2 == 0
+			}
+
+			if flip {
+				m.dark[y*m.size+x] = !m.dark[y*m.size+x]
+			}
+		}
+	}
+}
+
+// penalty scores how hard the code is to scan, lower is better
+func (m *matrix) penalty() int {
+	var (
+		p    int
+		dark int
+		// Dark light dark dark dark light dark with four light modules on a side
+		finder = []bool{true, false, true, true, true, false, true, false, false, false, false}
+	)
+
+	line := make([]bool, m.size)
+	for _, vertical := range []bool{false, true} {
+		for i := 0; i < m.size; i++ {
+			for j := 0; j < m.size; j++ {
+				if vertical {
+					line[j] = m.at(i, j)
+				} else {
+					line[j] = m.at(j, i)
+				}
+			}
+
+			// Five or more of the same color in a row
+			run := 1
+			for j := 1; j <= m.size; j++ {
+				if j < m.size && line[j] == line[j-1] {
+					run++
+					continue
+				}
+
+				if run >= 5 {
+					p += 3 + run - 5
+				}
+				run = 1
+			}
+
+			// Anything that looks like a finder
+			for j := 0; j+len(finder) <= m.size; j++ {
+				forward, backward := true, true
+				for k, f := range finder {
+					forward = forward && line[j+k] == f
+					backward = backward && line[j+len(finder)-1-k] == f
+				}
+
+				if forward {
+					p += 40
+				}
+				if backward {
+					p += 40
+				}
+			}
+		}
+	}
+
+	for y := 0; y < m.size; y++ {
+		for x := 0; x < m.size; x++ {
+			c := m.at(x, y)
+			if c {
+				dark++
+			}
+
+			// Two by two blocks of the same color
+			if x+1 < m.size && y+1 < m.size && c == m.at(x+1, y) && c == m.at(x, y+1) && c == m.at(x+1, y+1) {
+				p += 3
+			}
+		}
+	}
+
+	// Ten points for every 5% the dark modules are off from half
+	total := m.size * m.size
+	k := (abs(dark*20-total*10)+total-1)/total - 1
+	p += k * 10
+
+	return p
+}
+
+// alignmentPositions returns the rows and columns alignment patterns are centered on
+func alignmentPositions(version int) []int {
+	if version == 1 {
+		return nil
+	}
+
+	var (
+		num  = version/7 + 2
+		size = version*4 + 17
+		step = (version*8 + num*3 + 5) / (num*4 - 4) * 2
+		pos  = make([]int, num)
+	)
+
+	pos[0] = 6
+	for i, p := num-1, size-7; i >= 1; i, p = i-1, p-step {
+		pos[i] = p
+	}
+
+	return pos
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+
+	return x
+}
+
+// dist is how many rings out from the center of a pattern the offset is
+func dist(dx, dy int) int {
+	if abs(dx) > abs(dy) {
+		return abs(dx)
+	}
+
+	return abs(dy)
+}
@@ -0,0 +1,267 @@
+package qr
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/derinil/links/links/generic"
+)
+
+/*
+	QR Codes:
+		- Follows ISO/IEC 18004 for byte mode only, which fits any URL. The
+			smallest version that holds the data is picked, from 1 to 40.
+		- All eight masks are tried and the one with the lowest penalty is
+			kept, like the spec says scanners expect.
+		- Codes are drawn as PNG with a whole number of pixels per module so
+			edges stay sharp, or as SVG with one path for print.
+*/
+
+type (
+	// Level is how much of the code can be damaged and still scan
+	Level int
+
+	Code struct {
+		// Modules per side, without the quiet zone
+		Size    int
+		Version int
+		Level   Level
+		dark    []bool
+	}
+)
+
+const (
+	// Recovers about 7% of the code
+	L Level = iota
+	// Recovers about 15% of the code
+	M
+	// Recovers about 25% of the code
+	Q
+	// Recovers about 30% of the code
+	H
+)
+
+// Limits for the size of rendered codes in pixels, and the margin in modules.
+// Codes are drawn for anyone who asks, 2048 pixels is plenty for print.
+const (
+	MinSize   = 64
+	MaxSize   = 2048
+	MaxMargin = 16
+)
+
+var (
+	ErrTooLong       = generic.NewWebError(http.StatusBadRequest, "qr_too_long", "Too much data for a QR code")
+	ErrInvalidLevel  = generic.NewWebError(http.StatusBadRequest, "qr_invalid_level", "Error correction level must be L, M, Q or H")
+	ErrInvalidSize   = generic.NewWebError(http.StatusBadRequest, "qr_invalid_size", fmt.Sprintf("Size must be between %d and %d pixels", MinSize, MaxSize))
+	ErrInvalidMargin = generic.NewWebError(http.StatusBadRequest, "qr_invalid_margin", fmt.Sprintf("Margin must be between 0 and %d modules", MaxMargin))
+)
+
+// ParseLevel reads L, M, Q or H in any case
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToUpper(s) {
+	case "L":
+		return L, nil
+	case "M":
+		return M, nil
+	case "Q":
+		return Q, nil
+	case "H":
+		return H, nil
+	default:
+		return 0, ErrInvalidLevel
+	}
+}
+
+func (l Level) String() string {
+	return [...]string{"L", "M", "Q", "H"}[l]
+}
+
+// Encode makes the smallest code that holds data in byte mode at the level
+func Encode(data []byte, level Level) (*Code, error) {
+	if level < L || level > H {
+		return nil, ErrInvalidLevel
+	}
+
+	version := 0
+	for v := 1; v <= 40; v++ {
+		if bitsNeeded(data, v) <= dataCodewords(v, level)*8 {
+			version = v
+			break
+		}
+	}
+
+	if version == 0 {
+		return nil, ErrTooLong
+	}
+
+	m := newMatrix(version)
+	m.drawFunctionPatterns()
+	m.drawCodewords(interleave(codewords(data, version, level), version, level))
+
+	best, lowest := 0, -1
+	for mask := 0; mask < 8; mask++ {
+		m.applyMask(mask)
+		m.drawFormat(level, mask)
+
+		if p := m.penalty(); lowest < 0 || p < lowest {
+			best, lowest = mask, p
+		}
+
+		// Masks are XOR, so applying it again undoes it
+		m.applyMask(mask)
+	}
+
+	m.applyMask(best)
+	m.drawFormat(level, best)
+
+	return &Code{
+		Size:    m.size,
+		Version: version,
+		Level:   level,
+		dark:    m.dark,
+	}, nil
+}
+
+// Dark reports whether the module at x, y is dark, anything outside the code is light
+func (c *Code) Dark(x, y int) bool {
+	if x < 0 || y < 0 || x >= c.Size || y >= c.Size {
+		return false
+	}
+
+	return c.dark[y*c.Size+x]
+}
+
+// bitsNeeded is the length of the mode indicator, the character count and the data
+func bitsNeeded(data []byte, version int) int {
+	countBits := 8
+	if version > 9 {
+		countBits = 16
+	}
+
+	// The count can't hold the length
+	if len(data) >= 1<<countBits {
+		return 1 << 30
+	}
+
+	return 4 + countBits + len(data)*8
+}
+
+// codewords puts data in byte mode and pads it to the capacity of the version
+func codewords(data []byte, version int, level Level) []byte {
+	var (
+		b        bitBuffer
+		capacity = dataCodewords(version, level) * 8
+	)
+
+	countBits := 8
+	if version > 9 {
+		countBits = 16
+	}
+
+	b.append(0b0100, 4)
+	b.append(len(data), countBits)
+	for _, d := range data {
+		b.append(int(d), 8)
+	}
+
+	// Up to four zeros end the data, then zeros up to a whole byte
+	terminator := capacity - b.len
+	if terminator > 4 {
+		terminator = 4
+	}
+	b.append(0, terminator)
+	b.append(0, (8-b.len%8)%8)
+
+	// The rest is filled with these two bytes, alternating
+	for pad := 0xEC; b.len < capacity; pad ^= 0xEC ^ 0x11 {
+		b.append(pad, 8)
+	}
+
+	return b.bytes
+}
+
+// interleave splits the data into blocks, adds error correction to each and
+// takes a byte from every block in turn
+func interleave(data []byte, version int, level Level) []byte {
+	var (
+		numBlocks   = blocks[level][version]
+		eccLen      = eccPerBlock[level][version]
+		raw         = rawModules(version) / 8
+		numShort    = numBlocks - raw%numBlocks
+		shortLen    = raw / numBlocks
+		divisor     = rsDivisor(eccLen)
+		dataBlocks  = make([][]byte, numBlocks)
+		eccBlocks   = make([][]byte, numBlocks)
+		out         = make([]byte, 0, raw)
+		maxDataSize = shortLen - eccLen + 1
+	)
+
+	for i, k := 0, 0; i < numBlocks; i++ {
+		// Long blocks have one more data byte than short ones
+		n := shortLen - eccLen
+		if i >= numShort {
+			n++
+		}
+
+		dataBlocks[i] = data[k : k+n]
+		eccBlocks[i] = rsRemainder(dataBlocks[i], divisor)
+		k += n
+	}
+
+	for i := 0; i < maxDataSize; i++ {
+		for _, blk := range dataBlocks {
+			if i < len(blk) {
+				out = append(out, blk[i])
+			}
+		}
+	}
+
+	for i := 0; i < eccLen; i++ {
+		for _, blk := range eccBlocks {
+			out = append(out, blk[i])
+		}
+	}
+
+	return out
+}
+
+// rawModules is how many modules hold codewords, remainder bits included
+func rawModules(version int) int {
+	n := (16*version+128)*version + 64
+
+	if version >= 2 {
+		align := version/7 + 2
+		n -= (25*align-10)*align - 55
+
+		if version >= 7 {
+			n -= 36
+		}
+	}
+
+	return n
+}
+
+func dataCodewords(version int, level Level) int {
+	return rawModules(version)/8 - eccPerBlock[level][version]*blocks[level][version]
+}
+
+type bitBuffer struct {
+	bytes []byte
+	len   int
+}
+
+// append adds the low n bits of v, most significant first
+func (b *bitBuffer) append(v, n int) {
+	for i := n - 1; i >= 0; i-- {
+		if b.len%8 == 0 {
+			b.bytes = append(b.bytes, 0)
+		}
+
+		if v>>i&1 == 1 {
+			b.bytes[b.len/8] |= 0x80 >> (b.len % 8)
+		}
+
+		b.len++
+	}
+}
@@ -0,0 +1,232 @@
+package qr_test
+
+import (
+	"bytes"
+	"image/png"
+	"strings"
+	"testing"
+
+	"github.com/derinil/links/links/qr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCapacity(t *testing.T) {
+	// Byte mode capacities from the spec, the most that fits and one more
+	testCases := []struct {
+		level   qr.Level
+		max     int
+		version int
+	}{
+		{level: qr.L, max: 17, version: 1},
+		{level: qr.H, max: 7, version: 1},
+		{level: qr.M, max: 213, version: 10},
+		{level: qr.Q, max: 482, version: 20},
+		{level: qr.L, max: 2953, version: 40},
+		{level: qr.H, max: 1273, version: 40},
+	}
+
+	for _, c := range testCases {
+		c := c
+		t.Run(c.level.String(), func(t *testing.T) {
+			code, err := qr.Encode(bytes.Repeat([]byte("a"), c.max), c.level)
+			require.NoError(t, err)
+			require.Equal(t, c.version, code.Version)
+			require.Equal(t, c.version*4+17, code.Size)
+
+			code, err = qr.Encode(bytes.Repeat([]byte("a"), c.max+1), c.level)
+			if c.version == 40 {
+				require.ErrorIs(t, err, qr.ErrTooLong)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, c.version+1, code.Version)
+		})
+	}
+}
+
+func TestPatterns(t *testing.T) {
+	for _, l := range []qr.Level{qr.L, qr.M, qr.Q, qr.H} {
+		code, err := qr.Encode([]byte("https://links.example.com/"+strings.Repeat("x", 200)), l)
+		require.NoError(t, err)
+
+		// Finders in three corners, with their light separators
+		for _, corner := range [][2]int{{0, 0}, {code.Size - 7, 0}, {0, code.Size - 7}} {
+			for i := 0; i < 7; i++ {
+				require.True(t, code.Dark(corner[0]+i, corner[1]))
+				require.True(t, code.Dark(corner[0]+i, corner[1]+6))
+			}
+			require.False(t, code.Dark(corner[0]+1, corner[1]+1))
+			require.True(t, code.Dark(corner[0]+3, corner[1]+3))
+		}
+		require.False(t, code.Dark(7, 7))
+
+		// Timing patterns between the finders
+		for i := 8; i < code.Size-8; i++ {
+			require.Equal(t, i%2 == 0, code.Dark(i, 6))
+			require.Equal(t, i%2 == 0, code.Dark(6, i))
+		}
+
+		// The dark module next to the bottom left finder
+		require.True(t, code.Dark(8, code.Size-8))
+
+		// Anything outside the code is light
+		require.False(t, code.Dark(-1, 0))
+		require.False(t, code.Dark(0, code.Size))
+	}
+}
+
+func TestKnownAnswer(t *testing.T) {
+	// References drawn by another encoder, rsc.io/qr's coding package, with
+	// the mask this one picked. # is a dark module.
+	testCases := []struct {
+		name  string
+		data  string
+		level qr.Level
+		want  []string
+	}{
+		{
+			name:  "version 1",
+			data:  "hello",
+			level: qr.L,
+			want: []string{
+				"#######..#.##.#######",
+				"#.....#.##.#..#.....#",
+				"#.###.#.##..#.#.###.#",
+				"#.###.#..#.#..#.###.#",
+				"#.###.#.#...#.#.###.#",
+				"#.....#.#..##.#.....#",
+				"#######.#.#.#.#######",
+				"........#####........",
+				"##.#..##.##...###.##.",
+				".#####.###....#....##",
+				"..##.####.#.##...##.#",
+				"...#.#..#..#.....#.##",
+				"....#.##.##.#.#.#....",
+				"........####...##.#.#",
+				"#######.###..#.#.###.",
+				"#.....#..#####.##....",
+				"#.###.#..#.#..###...#",
+				"#.###.#.#.##...#.####",
+				"#.###.#..##.#...#.#.#",
+				"#.....#.###..##......",
+				"#######.#.###..#.#.#.",
+			},
+		},
+		{
+			// Alignment patterns and the version information blocks
+			name:  "version 7",
+			data:  "https://links.example.com/abcdefghijklmnopqrstuvwxyz0123456789",
+			level: qr.H,
+			want: []string{
+				"#######..#...#####...##.#####.##.#..#.#######",
+				"#.....#...####.....##..##.#......#.#..#.....#",
+				"#.###.#.#.#...#####.#.######.#.#.#.#..#.###.#",
+				"#.###.#.##.##...#.......####.#..#..##.#.###.#",
+				"#.###.#...##....#...#####...#.###.###.#.###.#",
+				"#.....#..#.......#..#...#.####..##....#.....#",
+				"#######.#.#.#.#.#.#.#.#.#.#.#.#.#.#.#.#######",
+				"...........#...#.##.#...#.#......####........",
+				"...##.##...#.#.#..#######.#..#.....#.....##..",
+				"#....#...#.####...#.#..#..#..###..###.#......",
+				".###.##....#.##.####..###.##.#.####..#.#.#.##",
+				"#..#.#..###..#####..######.###..##.##..#.###.",
+				"..##..#..#.#.#.#.#.##.##.#..###.####.##.#....",
+				".#...#.##.##..###..#..##.###.###.#.##.#....#.",
+				".##..###.#.#.##.#.#..#.#.#####.###....###.#..",
+				"..##.#.#.####.#.##.#..#..#.###...####.#..###.",
+				".##..###..#.#.#.#.#...#####.##.##.##.##....#.",
+				".#.....##.###..####..#..###.#..####.##.#....#",
+				"....#.#.########.####...###..#####.###.###.##",
+				".#...#.#.....##..#...#.#.#.#..##.#..#.#.###..",
+				".#.######..##.##.#..#####.#.###..##.#####..#.",
+				"...##...#..#.##.#.#.#...#.....#######...#.#..",
+				".####.#.##..#...##.##.#.##.##.#...###.#.#.###",
+				"..#.#...###..##.###.#...#..###.######...#.#.#",
+				"###.#####..##..#.########.##..####.#######...",
+				"######..#..##.#.##.####.##..#.###..#####.#...",
+				"#...####.#..#...#.#.#.#.##.##..##..#...##....",
+				"#.###..##.##...####...###..#.###.#.##....####",
+				".#..######.###..#.#..#######..###.###.#.##...",
+				"####...##..##.##..##..#.##.##...####.#.####.#",
+				".##..##.###.##.#.#..##.##....##.##.#..#####.#",
+				"#.#.#..##..##.###.######.#..#.##.#.#...#..###",
+				".#..#.#.#..#.#.#..#..####.##.#....#.##...#..#",
+				"....##.#.###.#.###..#.#...#######.####..###..",
+				"....#.##....##.##.#####.#...#.#..####.#..##.#",
+				".####...#..###..#.##....##.##.#.#....##.#.##.",
+				"#..##.###.##........#######...#.#########....",
+				"........#..#....#.#.#...#..###.#.#.##...###..",
+				"#######.##.#..#.#.#.#.#.###.###.##..#.#.###..",
+				"#.....#...#......##.#...#.#...#..####...####.",
+				"#.###.#.##..##.#.########..###.##..######....",
+				"#.###.#.#..#.#..#.##..###.#..##..##....#.####",
+				"#.###.#.......###.####...##..#......#...##.##",
+				"#.....#....#.#..##.#....##.###...#.##########",
+				"#######..##.###.....##.#.##.####...#..#......",
+			},
+		},
+	}
+
+	for _, c := range testCases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			code, err := qr.Encode([]byte(c.data), c.level)
+			require.NoError(t, err)
+			require.Equal(t, len(c.want), code.Size)
+
+			for y, row := range c.want {
+				got := make([]byte, code.Size)
+				for x := range got {
+					got[x] = '.'
+					if code.Dark(x, y) {
+						got[x] = '#'
+					}
+				}
+
+				require.Equal(t, row, string(got), "row %d", y)
+			}
+		})
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	l, err := qr.ParseLevel("q")
+	require.NoError(t, err)
+	require.Equal(t, qr.Q, l)
+
+	_, err = qr.ParseLevel("X")
+	require.ErrorIs(t, err, qr.ErrInvalidLevel)
+}
+
+func TestRender(t *testing.T) {
+	code, err := qr.Encode([]byte("https://links.example.com/jane"), qr.M)
+	require.NoError(t, err)
+	require.Equal(t, 29, code.Size)
+
+	// 37 modules with the margin, 300 / 37 is 8 pixels each
+	b, err := code.PNG(300, qr.DefaultMargin)
+	require.NoError(t, err)
+
+	img, err := png.Decode(bytes.NewReader(b))
+	require.NoError(t, err)
+	require.Equal(t, 296, img.Bounds().Dx())
+
+	r, _, _, _ := img.At(4*8, 4*8).RGBA()
+	require.Zero(t, r)
+	r, _, _, _ = img.At(4*8-1, 4*8-1).RGBA()
+	require.Equal(t, uint32(0xffff), r)
+
+	// Too small still gets one pixel per module
+	b, err = code.PNG(10, 0)
+	require.NoError(t, err)
+
+	img, err = png.Decode(bytes.NewReader(b))
+	require.NoError(t, err)
+	require.Equal(t, 29, img.Bounds().Dx())
+
+	svg := string(code.SVG(300, 2))
+	require.True(t, strings.HasPrefix(svg, `<svg xmlns="http://www.w3.org/2000/svg" width="300" height="300" viewBox="0 0 33 33"`))
+	// The top row of the first finder is one run
+	require.Contains(t, svg, `d="M2 2h7v1h-7z`)
+}
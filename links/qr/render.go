@@ -0,0 +1,92 @@
+package qr
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+)
+
+const (
+	// The quiet zone the spec asks for around the code, in modules
+	DefaultMargin = 4
+	// Big enough to print, in pixels
+	DefaultSize = 512
+)
+
+var palette = color.Palette{color.White, color.Black}
+
+// Image draws the code with scale pixels per module and margin light modules
+// around it. Scale is at least 1.
+func (c *Code) Image(scale, margin int) *image.Paletted {
+	if scale < 1 {
+		scale = 1
+	}
+
+	var (
+		side = (c.Size + margin*2) * scale
+		img  = image.NewPaletted(image.Rect(0, 0, side, side), palette)
+	)
+
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			if !c.Dark(x, y) {
+				continue
+			}
+
+			x0, y0 := (x+margin)*scale, (y+margin)*scale
+			for py := y0; py < y0+scale; py++ {
+				row := img.Pix[py*img.Stride:]
+				for px := x0; px < x0+scale; px++ {
+					row[px] = 1
+				}
+			}
+		}
+	}
+
+	return img
+}
+
+// PNG fits the code in size pixels, or less since modules are whole pixels
+func (c *Code) PNG(size, margin int) ([]byte, error) {
+	var b bytes.Buffer
+
+	e := png.Encoder{CompressionLevel: png.BestCompression}
+	if err := e.Encode(&b, c.Image(size/(c.Size+margin*2), margin)); err != nil {
+		return nil, fmt.Errorf("failed to encode qr code: %w", err)
+	}
+
+	return b.Bytes(), nil
+}
+
+// SVG draws the code as one path, size is the width and height it's shown at
+func (c *Code) SVG(size, margin int) []byte {
+	var (
+		b    bytes.Buffer
+		side = c.Size + margin*2
+	)
+
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`, size, size, side, side)
+	fmt.Fprintf(&b, `<rect width="%d" height="%d" fill="#fff"/><path fill="#000" d="`, side, side)
+
+	// Runs of dark modules in a row are one rectangle
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			if !c.Dark(x, y) {
+				continue
+			}
+
+			start := x
+			for x+1 < c.Size && c.Dark(x+1, y) {
+				x++
+			}
+
+			fmt.Fprintf(&b, "M%d %dh%dv1h-%dz", start+margin, y+margin, x-start+1, x-start+1)
+		}
+	}
+
+	b.WriteString(`"/></svg>`)
+
+	return b.Bytes()
+}
@@ -0,0 +1,52 @@
+package qr
+
+// Reed-Solomon over GF(2^8) with the polynomial x^8 + x^4 + x^3 + x^2 + 1
+
+// rsDivisor returns the generator polynomial of the degree, highest
+// coefficient first and without the leading 1
+func rsDivisor(degree int) []byte {
+	result := make([]byte, degree)
+	result[degree-1] = 1
+
+	// Multiply by (x - 2^i) for every i below the degree
+	root := byte(1)
+	for i := 0; i < degree; i++ {
+		for j := range result {
+			result[j] = gfMul(result[j], root)
+			if j+1 < len(result) {
+				result[j] ^= result[j+1]
+			}
+		}
+
+		root = gfMul(root, 0x02)
+	}
+
+	return result
+}
+
+// rsRemainder returns the error correction codewords of data
+func rsRemainder(data, divisor []byte) []byte {
+	result := make([]byte, len(divisor))
+
+	for _, b := range data {
+		factor := b ^ result[0]
+		copy(result, result[1:])
+		result[len(result)-1] = 0
+
+		for i, d := range divisor {
+			result[i] ^= gfMul(d, factor)
+		}
+	}
+
+	return result
+}
+
+func gfMul(x, y byte) byte {
+	z := 0
+	for i := 7; i >= 0; i-- {
+		z = (z << 1) ^ ((z >> 7) * 0x11D)
+		z ^= int(y>>i&1) * int(x)
+	}
+
+	return byte(z)
+}
@@ -0,0 +1,63 @@
+package ratelimit
+
+import (
+	"errors"
+	"log"
+	"net"
+	"net/http"
+)
+
+// KeyFunc picks what a request is counted under, returning an empty key skips the limit
+type KeyFunc func(r *http.Request) string
+
+// Middleware counts every request that reaches it with Hit and hands the ones over
+// the limit to limited instead of h. Errors from the limiter itself are logged and
+// the request goes through, a broken cache shouldn't take the site down with it.
+func Middleware(
+	limiter Limiter,
+	rule *Rule,
+	key KeyFunc,
+	limited func(w http.ResponseWriter, r *http.Request, err error),
+) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			k := key(r)
+			if k == "" {
+				h.ServeHTTP(w, r)
+				return
+			}
+
+			if err := limiter.Hit(r.Context(), rule, k); err != nil {
+				if errors.Is(err, ErrLimited) {
+					limited(w, r, err)
+					return
+				}
+
+				log.Println("failed to rate limit", rule.Name, err)
+			}
+
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+// ByIP counts requests per IP, RemoteAddr is expected to be set to the
+// real IP by generic.RealIP, which only trusts headers from our proxies
+func ByIP(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return IPKey(host)
+	}
+
+	return IPKey(r.RemoteAddr)
+}
+
+// IPKey is what an IP is counted under. IPv6 clients usually get a whole /64
+// to pick addresses from, so they're counted per /64 instead.
+func IPKey(ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil || parsed.To4() != nil {
+		return ip
+	}
+
+	return parsed.Mask(net.CIDRMask(64, 128)).String() + "/64"
+}
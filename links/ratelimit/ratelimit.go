@@ -0,0 +1,215 @@
+package ratelimit
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/derinil/links/links/cache"
+	"github.com/derinil/links/links/generic"
+)
+
+/*
+	Rate limiting:
+		- Every rule counts attempts per key, like an IP or a handle, in a
+			window that starts with the first attempt. Going over the limit
+			within the window locks the key out, and every lockout after that
+			is twice as long as the one before, up to MaxLockout.
+		- Attempts are counted with cache.Cache.Increment, so attempts racing
+			each other are all counted and only the one that goes over the
+			limit starts the lockout.
+		- Lockouts are remembered for a while after they end, so someone who
+			keeps at it gets longer and longer lockouts. The state expires
+			once the key has been quiet for MaxLockout.
+*/
+
+type (
+	Limiter interface {
+		// Check returns ErrLimited if the key is locked out, without counting an attempt
+		Check(ctx context.Context, rule *Rule, key string) error
+		// Hit counts an attempt and returns ErrLimited if the key is locked out,
+		// either from before or because this attempt went over the limit
+		Hit(ctx context.Context, rule *Rule, key string) error
+		// Reset forgets the attempts and lockouts of the key
+		Reset(ctx context.Context, rule *Rule, key string) error
+	}
+
+	LimiterImpl struct {
+		cache cache.Cache
+	}
+
+	Rule struct {
+		// Name keeps the keys of different rules apart in the cache
+		Name string
+		// Limit attempts are allowed within Window, the one after that locks the key out
+		Limit  int
+		Window time.Duration
+		// The first lockout lasts Lockout, and each one after that twice as long
+		Lockout    time.Duration
+		MaxLockout time.Duration
+	}
+
+	// state is what's kept in the cache for every key that was locked out,
+	// the attempts are counted under their own key
+	state struct {
+		Lockouts    int
+		LockedUntil time.Time
+	}
+)
+
+var ErrLimited = generic.NewWebError(http.StatusTooManyRequests, "rate_limited", "Too many attempts, try again later")
+
+var _ Limiter = (*LimiterImpl)(nil)
+
+func NewLimiter(cache cache.Cache) *LimiterImpl {
+	return &LimiterImpl{cache: cache}
+}
+
+func (s *LimiterImpl) Check(ctx context.Context, rule *Rule, key string) error {
+	st, err := s.get(ctx, rule, key)
+	if err != nil {
+		return err
+	}
+
+	if wait := time.Until(st.LockedUntil); wait > 0 {
+		return limited(wait)
+	}
+
+	return nil
+}
+
+func (s *LimiterImpl) Hit(ctx context.Context, rule *Rule, key string) error {
+	st, err := s.get(ctx, rule, key)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+
+	// Attempts during a lockout don't count, or they'd keep extending it
+	if wait := st.LockedUntil.Sub(now); wait > 0 {
+		return limited(wait)
+	}
+
+	n, err := s.cache.Increment(ctx, countKey(rule, key), rule.Window)
+	if err != nil {
+		return fmt.Errorf("failed to count attempt: %w", err)
+	}
+
+	if n <= int64(rule.Limit) {
+		return nil
+	}
+
+	// Attempts racing the one that went over are limited by its lockout
+	lockout := rule.lockout(st.Lockouts)
+	if n > int64(rule.Limit)+1 {
+		return limited(lockout)
+	}
+
+	st.Lockouts++
+	st.LockedUntil = now.Add(lockout)
+
+	if err := s.put(ctx, rule, key, st); err != nil {
+		return err
+	}
+
+	// The window starts over once the lockout ends
+	if _, err := s.cache.Invalidate(ctx, countKey(rule, key)); err != nil {
+		return fmt.Errorf("failed to reset attempts: %w", err)
+	}
+
+	return limited(lockout)
+}
+
+func (s *LimiterImpl) Reset(ctx context.Context, rule *Rule, key string) error {
+	for _, k := range []string{cacheKey(rule, key), countKey(rule, key)} {
+		if _, err := s.cache.Invalidate(ctx, k); err != nil {
+			return fmt.Errorf("failed to reset rate limit: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// lockout doubles Lockout for every lockout before it
+func (r *Rule) lockout(before int) time.Duration {
+	d := r.Lockout
+	for i := 0; i < before && d < r.MaxLockout; i++ {
+		d *= 2
+	}
+
+	if d > r.MaxLockout {
+		return r.MaxLockout
+	}
+
+	return d
+}
+
+// get returns an empty state if there is nothing cached for the key, cache
+// errors included, so the limiter never locks everyone out when the cache is down
+func (s *LimiterImpl) get(ctx context.Context, rule *Rule, key string) (*state, error) {
+	var st state
+
+	b, err := s.cache.Get(ctx, cacheKey(rule, key))
+	if err != nil || len(b) == 0 {
+		return &st, nil
+	}
+
+	if err := gob.NewDecoder(bytes.NewReader(b)).Decode(&st); err != nil {
+		return nil, fmt.Errorf("failed to decode rate limit: %w", err)
+	}
+
+	return &st, nil
+}
+
+func (s *LimiterImpl) put(ctx context.Context, rule *Rule, key string, st *state) error {
+	var b bytes.Buffer
+	if err := gob.NewEncoder(&b).Encode(st); err != nil {
+		return fmt.Errorf("failed to encode rate limit: %w", err)
+	}
+
+	// Long enough for the lockout, then MaxLockout more to remember it
+	ttl := time.Until(st.LockedUntil) + rule.MaxLockout
+
+	if err := s.cache.PutWithTTL(ctx, cacheKey(rule, key), b.Bytes(), ttl); err != nil {
+		return fmt.Errorf("failed to store rate limit: %w", err)
+	}
+
+	return nil
+}
+
+// limited copies ErrLimited with the wait in it
+func limited(wait time.Duration) error {
+	e := *ErrLimited
+	e.RetryAfter = wait
+	e.ErrMsg = "Too many attempts, try again in " + humanize(wait)
+	return &e
+}
+
+func humanize(d time.Duration) string {
+	switch {
+	case d <= time.Second:
+		return "a second"
+	case d < time.Minute:
+		return fmt.Sprintf("%d seconds", int((d+time.Second-1)/time.Second))
+	case d <= time.Minute:
+		return "a minute"
+	case d < time.Hour:
+		return fmt.Sprintf("%d minutes", int((d+time.Minute-1)/time.Minute))
+	case d <= time.Hour:
+		return "an hour"
+	default:
+		return fmt.Sprintf("%d hours", int((d+time.Hour-1)/time.Hour))
+	}
+}
+
+func cacheKey(rule *Rule, key string) string {
+	return "ratelimit-" + rule.Name + "-" + key
+}
+
+func countKey(rule *Rule, key string) string {
+	return "ratelimit-count-" + rule.Name + "-" + key
+}
@@ -0,0 +1,202 @@
+package ratelimit_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/derinil/links/links/cache"
+	"github.com/derinil/links/links/generic"
+	"github.com/derinil/links/links/ratelimit"
+	"github.com/stretchr/testify/require"
+)
+
+// slowCache takes a while to write, so attempts made at
+// the same time all read before any of them writes
+type slowCache struct {
+	cache.Cache
+}
+
+func (c slowCache) PutWithTTL(ctx context.Context, key string, val []byte, ttl time.Duration) error {
+	time.Sleep(10 * time.Millisecond)
+	return c.Cache.PutWithTTL(ctx, key, val, ttl)
+}
+
+func newLimiter() *ratelimit.LimiterImpl {
+	return ratelimit.NewLimiter(cache.NewMemory(cache.MemoryConfig{}))
+}
+
+func retryAfter(t *testing.T, err error) time.Duration {
+	var we *generic.WebError
+	require.ErrorAs(t, err, &we)
+	require.ErrorIs(t, err, ratelimit.ErrLimited)
+	require.Equal(t, http.StatusTooManyRequests, we.StatusCode)
+
+	return we.RetryAfter
+}
+
+func TestHit(t *testing.T) {
+	var (
+		ctx  = context.Background()
+		l    = newLimiter()
+		rule = &ratelimit.Rule{
+			Name:       "test",
+			Limit:      3,
+			Window:     time.Hour,
+			Lockout:    time.Minute,
+			MaxLockout: 5 * time.Minute,
+		}
+	)
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, l.Hit(ctx, rule, "jane"))
+	}
+
+	require.NoError(t, l.Check(ctx, rule, "jane"))
+
+	err := l.Hit(ctx, rule, "jane")
+	require.Equal(t, time.Minute, retryAfter(t, err))
+	require.Contains(t, err.Error(), "try again in a minute")
+
+	wait := retryAfter(t, l.Check(ctx, rule, "jane"))
+	require.InDelta(t, time.Minute, wait, float64(time.Second))
+
+	// Other keys and rules are counted on their own
+	require.NoError(t, l.Check(ctx, rule, "john"))
+	require.NoError(t, l.Check(ctx, &ratelimit.Rule{Name: "other", Limit: 1, Window: time.Hour}, "jane"))
+
+	require.NoError(t, l.Reset(ctx, rule, "jane"))
+	require.NoError(t, l.Check(ctx, rule, "jane"))
+	require.NoError(t, l.Hit(ctx, rule, "jane"))
+}
+
+func TestWindow(t *testing.T) {
+	var (
+		ctx  = context.Background()
+		l    = newLimiter()
+		rule = &ratelimit.Rule{
+			Name:       "test",
+			Limit:      2,
+			Window:     50 * time.Millisecond,
+			Lockout:    time.Minute,
+			MaxLockout: time.Minute,
+		}
+	)
+
+	require.NoError(t, l.Hit(ctx, rule, "jane"))
+	time.Sleep(30 * time.Millisecond)
+	require.NoError(t, l.Hit(ctx, rule, "jane"))
+	time.Sleep(30 * time.Millisecond)
+
+	// The window started with the first attempt and is over
+	require.NoError(t, l.Hit(ctx, rule, "jane"))
+	require.NoError(t, l.Hit(ctx, rule, "jane"))
+	require.ErrorIs(t, l.Hit(ctx, rule, "jane"), ratelimit.ErrLimited)
+}
+
+func TestConcurrentHits(t *testing.T) {
+	var (
+		ctx  = context.Background()
+		l    = ratelimit.NewLimiter(slowCache{cache.NewMemory(cache.MemoryConfig{})})
+		rule = &ratelimit.Rule{
+			Name:       "test",
+			Limit:      5,
+			Window:     time.Hour,
+			Lockout:    time.Minute,
+			MaxLockout: time.Hour,
+		}
+		wg      sync.WaitGroup
+		allowed atomic.Int32
+	)
+
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			if err := l.Hit(ctx, rule, "jane"); err == nil {
+				allowed.Add(1)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	// Every attempt is counted, however they interleave
+	require.Equal(t, int32(rule.Limit), allowed.Load())
+	require.ErrorIs(t, l.Check(ctx, rule, "jane"), ratelimit.ErrLimited)
+}
+
+func TestBackoff(t *testing.T) {
+	var (
+		ctx  = context.Background()
+		l    = newLimiter()
+		rule = &ratelimit.Rule{
+			Name:       "test",
+			Limit:      1,
+			Window:     time.Hour,
+			Lockout:    10 * time.Millisecond,
+			MaxLockout: 40 * time.Millisecond,
+		}
+	)
+
+	for _, want := range []time.Duration{
+		10 * time.Millisecond,
+		20 * time.Millisecond,
+		40 * time.Millisecond,
+		// Capped at MaxLockout
+		40 * time.Millisecond,
+	} {
+		require.NoError(t, l.Hit(ctx, rule, "jane"))
+		require.Equal(t, want, retryAfter(t, l.Hit(ctx, rule, "jane")))
+
+		// Attempts during the lockout don't extend it
+		require.LessOrEqual(t, retryAfter(t, l.Hit(ctx, rule, "jane")), want)
+
+		time.Sleep(want + 5*time.Millisecond)
+		require.NoError(t, l.Check(ctx, rule, "jane"))
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	var (
+		l    = newLimiter()
+		rule = &ratelimit.Rule{
+			Name:       "test",
+			Limit:      2,
+			Window:     time.Hour,
+			Lockout:    time.Minute,
+			MaxLockout: time.Hour,
+		}
+		mw = ratelimit.Middleware(l, rule, ratelimit.ByIP, func(w http.ResponseWriter, r *http.Request, err error) {
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+		})
+		h = mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		}))
+	)
+
+	serve := func(addr string) int {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/register", nil)
+		r.RemoteAddr = addr
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	require.Equal(t, http.StatusNoContent, serve("192.0.2.1:1000"))
+	// Ports don't matter
+	require.Equal(t, http.StatusNoContent, serve("192.0.2.1:2000"))
+	require.Equal(t, http.StatusTooManyRequests, serve("192.0.2.1:3000"))
+	require.Equal(t, http.StatusNoContent, serve("192.0.2.2:1000"))
+
+	// Addresses from the same IPv6 /64 are counted together
+	require.Equal(t, http.StatusNoContent, serve("[2001:db8::1]:1000"))
+	require.Equal(t, http.StatusNoContent, serve("[2001:db8::2]:1000"))
+	require.Equal(t, http.StatusTooManyRequests, serve("[2001:db8::3]:1000"))
+	require.Equal(t, http.StatusNoContent, serve("[2001:db8:0:1::1]:1000"))
+}
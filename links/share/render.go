@@ -0,0 +1,260 @@
+package share
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"log"
+	"math"
+	"strconv"
+
+	"github.com/derinil/links/links/account"
+	"github.com/derinil/links/links/imaging"
+	"github.com/derinil/links/links/theme"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+// The size Open Graph and Twitter cards show without cropping
+const (
+	Width  = 1200
+	Height = 630
+)
+
+const (
+	margin     = 100
+	aviSize    = 240
+	ringWidth  = 8
+	pillHeight = 64
+)
+
+var (
+	// Fonts are safe to share, faces aren't so every render makes its own
+	regular = mustParse(goregular.TTF)
+	bold    = mustParse(gobold.TTF)
+
+	// Under transparent backgrounds, and when the theme colors can't be read
+	fallbackBackground = color.NRGBA{0x2b, 0x1d, 0x3a, 0xff}
+	fallbackText       = color.NRGBA{0xff, 0xff, 0xff, 0xff}
+)
+
+// Render draws the account's share image in its theme's colors and encodes it as PNG
+func Render(a *account.Account, t *theme.Theme) ([]byte, error) {
+	var (
+		img        = image.NewRGBA(image.Rect(0, 0, Width, Height))
+		text       = parseColor(t.Colors.Text, fallbackText)
+		accent     = parseColor(t.Colors.Accent, text)
+		button     = parseColor(t.Colors.Button, accent)
+		buttonText = parseColor(t.Colors.ButtonText, text)
+		x          = margin
+	)
+
+	background(img, parseColors(t.Background))
+
+	if avi := avatar(a); avi != nil {
+		top := (Height - aviSize) / 2
+
+		ring := image.Rect(x-ringWidth, top-ringWidth, x+aviSize+ringWidth, top+aviSize+ringWidth)
+		draw.DrawMask(img, ring, image.NewUniform(accent), image.Point{}, &roundRect{ring, aviSize/2 + ringWidth}, ring.Min, draw.Over)
+
+		frame := image.Rect(x, top, x+aviSize, top+aviSize)
+		draw.DrawMask(img, frame, avi, image.Point{}, &roundRect{frame, aviSize / 2}, frame.Min, draw.Over)
+
+		x += aviSize + 60
+	}
+
+	var (
+		width      = Width - margin - x
+		nameFace   = newFace(bold, 72)
+		handleFace = newFace(regular, 40)
+		pillFace   = newFace(regular, 32)
+	)
+
+	drawText(img, nameFace, fit(nameFace, a.Name, width), text, x, 280)
+	drawText(img, handleFace, fit(handleFace, "@"+a.Handle, width), accent, x, 345)
+
+	var (
+		count = linkCount(len(a.Links))
+		pill  = image.Rect(x, 390, x+font.MeasureString(pillFace, count).Ceil()+56, 390+pillHeight)
+	)
+
+	draw.DrawMask(img, pill, image.NewUniform(button), image.Point{}, &roundRect{pill, radius(t.ButtonShape)}, pill.Min, draw.Over)
+	drawText(img, pillFace, count, buttonText, x+28, 390+pillHeight/2+11)
+
+	return imaging.EncodePNG(img)
+}
+
+// background fills the image with the colors as a top to bottom gradient,
+// evenly spread since stop positions and angles aren't read
+func background(img *image.RGBA, colors []color.NRGBA) {
+	var stops []color.RGBA
+	for _, c := range colors {
+		stops = append(stops, over(c, fallbackBackground))
+	}
+
+	switch len(stops) {
+	case 0:
+		draw.Draw(img, img.Bounds(), image.NewUniform(fallbackBackground), image.Point{}, draw.Src)
+		return
+	case 1:
+		draw.Draw(img, img.Bounds(), image.NewUniform(stops[0]), image.Point{}, draw.Src)
+		return
+	}
+
+	for y := 0; y < Height; y++ {
+		var (
+			pos = float64(y) / float64(Height-1) * float64(len(stops)-1)
+			i   = int(pos)
+		)
+
+		if i >= len(stops)-1 {
+			i = len(stops) - 2
+		}
+
+		row := image.Rect(0, y, Width, y+1)
+		draw.Draw(img, row, image.NewUniform(mix(stops[i], stops[i+1], pos-float64(i))), image.Point{}, draw.Src)
+	}
+}
+
+// avatar returns nil if the account has none, or if it can't be decoded
+// since the image is still useful without it
+func avatar(a *account.Account) image.Image {
+	if len(a.Avi) == 0 {
+		return nil
+	}
+
+	img, _, err := imaging.Decode(a.Avi, imaging.DefaultMaxPixels)
+	if err != nil {
+		log.Println("failed to decode avatar for share image", a.ID, err)
+		return nil
+	}
+
+	return imaging.Resize(imaging.CropSquare(img), aviSize, aviSize)
+}
+
+func drawText(img draw.Image, face font.Face, s string, c color.Color, x, y int) {
+	d := &font.Drawer{
+		Dst:  img,
+		Src:  image.NewUniform(c),
+		Face: face,
+		Dot:  fixed.P(x, y),
+	}
+
+	d.DrawString(s)
+}
+
+// fit cuts the text short with an ellipsis if it's wider than width
+func fit(face font.Face, s string, width int) string {
+	if font.MeasureString(face, s).Ceil() <= width {
+		return s
+	}
+
+	r := []rune(s)
+	for len(r) > 0 && font.MeasureString(face, string(r)+"…").Ceil() > width {
+		r = r[:len(r)-1]
+	}
+
+	return string(r) + "…"
+}
+
+func linkCount(n int) string {
+	if n == 1 {
+		return "1 link"
+	}
+
+	return strconv.Itoa(n) + " links"
+}
+
+func radius(s theme.Shape) int {
+	switch s {
+	case theme.Pill:
+		return pillHeight / 2
+	case theme.Rounded:
+		return 16
+	default:
+		return 0
+	}
+}
+
+func newFace(f *opentype.Font, size float64) font.Face {
+	face, err := opentype.NewFace(f, &opentype.FaceOptions{
+		Size:    size,
+		DPI:     72,
+		Hinting: font.HintingFull,
+	})
+	if err != nil {
+		// Only happens with invalid options, which are all constants here
+		panic(fmt.Sprintf("failed to create font face: %v", err))
+	}
+
+	return face
+}
+
+func mustParse(ttf []byte) *opentype.Font {
+	f, err := opentype.Parse(ttf)
+	if err != nil {
+		panic(fmt.Sprintf("failed to parse font: %v", err))
+	}
+
+	return f
+}
+
+// over composites c on top of an opaque background
+func over(c, bg color.NRGBA) color.RGBA {
+	a := float64(c.A) / 255
+	blend := func(x, y uint8) uint8 {
+		return uint8(math.Round(float64(x)*a + float64(y)*(1-a)))
+	}
+
+	return color.RGBA{blend(c.R, bg.R), blend(c.G, bg.G), blend(c.B, bg.B), 255}
+}
+
+func mix(a, b color.RGBA, t float64) color.RGBA {
+	lerp := func(x, y uint8) uint8 {
+		return uint8(math.Round(float64(x) + (float64(y)-float64(x))*t))
+	}
+
+	return color.RGBA{lerp(a.R, b.R), lerp(a.G, b.G), lerp(a.B, b.B), 255}
+}
+
+// roundRect is an alpha mask of a rectangle with rounded corners, a square
+// with a radius of half its side is a circle. Edges are antialiased.
+type roundRect struct {
+	rect image.Rectangle
+	r    int
+}
+
+func (m *roundRect) ColorModel() color.Model {
+	return color.AlphaModel
+}
+
+func (m *roundRect) Bounds() image.Rectangle {
+	return m.rect
+}
+
+func (m *roundRect) At(x, y int) color.Color {
+	if !(image.Point{x, y}.In(m.rect)) {
+		return color.Alpha{}
+	}
+
+	var (
+		r = float64(m.r)
+		// Distance from the pixel's center to the inner rectangle the corners curve around
+		px = float64(x) + 0.5
+		py = float64(y) + 0.5
+		dx = math.Max(math.Max(float64(m.rect.Min.X)+r-px, px-(float64(m.rect.Max.X)-r)), 0)
+		dy = math.Max(math.Max(float64(m.rect.Min.Y)+r-py, py-(float64(m.rect.Max.Y)-r)), 0)
+		d  = math.Hypot(dx, dy)
+	)
+
+	a := math.Max(0, math.Min(1, r-d+0.5))
+	if r == 0 {
+		a = 1
+	}
+
+	return color.Alpha{uint8(math.Round(a * 255))}
+}
@@ -0,0 +1,86 @@
+package share
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/derinil/links/links/account"
+	"github.com/derinil/links/links/cache"
+	"github.com/derinil/links/links/theme"
+)
+
+/*
+	Share Images:
+		- Chat apps and social sites show a preview image when a profile is
+			shared. It's drawn with the account's avatar, name, handle and
+			link count in its theme's colors.
+		- Images are cached under the account's UpdatedAt, so a save makes
+			the next request draw a new one without dropping anything. The
+			old images expire with the TTL.
+		- Theme backgrounds are read for their colors only, gradients are
+			always drawn top to bottom and images are left out.
+*/
+
+type (
+	Handler interface {
+		// Image returns the share image of the account as PNG
+		Image(ctx context.Context, cmd *ImageCmd) ([]byte, error)
+	}
+
+	HandlerImpl struct {
+		cache  cache.Cache
+		config Config
+	}
+
+	Config struct {
+		// How long an image is kept after it's drawn
+		TTL time.Duration
+	}
+
+	ImageCmd struct {
+		Account *account.Account
+		Theme   *theme.Theme
+	}
+)
+
+const DefaultTTL = 24 * time.Hour
+
+var _ Handler = (*HandlerImpl)(nil)
+
+func NewHandler(cache cache.Cache, config Config) *HandlerImpl {
+	if config.TTL <= 0 {
+		config.TTL = DefaultTTL
+	}
+
+	return &HandlerImpl{
+		cache:  cache,
+		config: config,
+	}
+}
+
+func (s *HandlerImpl) Image(ctx context.Context, cmd *ImageCmd) ([]byte, error) {
+	key := imageKey(cmd)
+
+	if b, err := s.cache.Get(ctx, key); err == nil && len(b) > 0 {
+		return b, nil
+	}
+
+	b, err := Render(cmd.Account, cmd.Theme)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render share image: %w", err)
+	}
+
+	// The image can be drawn again, so failing to cache it isn't an error
+	if err := s.cache.PutWithTTL(ctx, key, b, s.config.TTL); err != nil {
+		log.Println("failed to cache share image", err)
+	}
+
+	return b, nil
+}
+
+// imageKey has the theme in it since theme files can change without the account
+func imageKey(cmd *ImageCmd) string {
+	return fmt.Sprintf("share-%s-%d-%s", cmd.Account.ID, cmd.Account.UpdatedAt.UnixNano(), cmd.Theme.ID)
+}
@@ -0,0 +1,188 @@
+package share_test
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+	"time"
+
+	"github.com/derinil/links/links/account"
+	"github.com/derinil/links/links/cache"
+	"github.com/derinil/links/links/share"
+	"github.com/derinil/links/links/theme"
+	"github.com/stretchr/testify/require"
+)
+
+// CountingCache counts the images that get stored
+type CountingCache struct {
+	cache.Cache
+	puts int
+}
+
+func (c *CountingCache) PutWithTTL(ctx context.Context, key string, val []byte, ttl time.Duration) error {
+	c.puts++
+	return c.Cache.PutWithTTL(ctx, key, val, ttl)
+}
+
+func newTheme(background string) *theme.Theme {
+	return &theme.Theme{
+		ID:   "test",
+		Name: "Test",
+		Colors: theme.Colors{
+			Text:       "white",
+			Button:     "#000",
+			ButtonText: "white",
+			Accent:     "aquamarine",
+		},
+		ButtonShape: theme.Pill,
+		Background:  background,
+	}
+}
+
+func decode(t *testing.T, b []byte) image.Image {
+	img, err := png.Decode(bytes.NewReader(b))
+	require.NoError(t, err)
+	require.Equal(t, image.Rect(0, 0, share.Width, share.Height), img.Bounds())
+
+	return img
+}
+
+func rgba(img image.Image, x, y int) color.RGBA {
+	return color.RGBAModel.Convert(img.At(x, y)).(color.RGBA)
+}
+
+func TestRender(t *testing.T) {
+	var (
+		fallback = color.RGBA{0x2b, 0x1d, 0x3a, 0xff}
+		white    = color.RGBA{255, 255, 255, 255}
+		black    = color.RGBA{0, 0, 0, 255}
+	)
+
+	testCases := []struct {
+		name        string
+		background  string
+		top, bottom color.RGBA
+	}{
+		{
+			name:       "hex",
+			background: "#f5f0e6",
+			top:        color.RGBA{245, 240, 230, 255},
+			bottom:     color.RGBA{245, 240, 230, 255},
+		},
+		{
+			name:       "named",
+			background: "White",
+			top:        white,
+			bottom:     white,
+		},
+		{
+			name:       "transparent",
+			background: "transparent",
+			top:        fallback,
+			bottom:     fallback,
+		},
+		{
+			name:       "image",
+			background: "url(/static/unico.jpg) repeat",
+			top:        fallback,
+			bottom:     fallback,
+		},
+		{
+			name:       "gradient",
+			background: "linear-gradient(160deg, #000 10%, rgb(255, 255, 255))",
+			top:        black,
+			bottom:     white,
+		},
+		{
+			name:       "space separated rgb",
+			background: "rgb(0 128 255 / 100%)",
+			top:        color.RGBA{0, 128, 255, 255},
+			bottom:     color.RGBA{0, 128, 255, 255},
+		},
+		{
+			name:       "translucent",
+			background: "rgba(255, 255, 255, 0.5)",
+			top:        color.RGBA{149, 142, 157, 255},
+			bottom:     color.RGBA{149, 142, 157, 255},
+		},
+	}
+
+	for _, c := range testCases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			a := account.New("Test", "test", "")
+
+			b, err := share.Render(a, newTheme(c.background))
+			require.NoError(t, err)
+
+			img := decode(t, b)
+			require.Equal(t, c.top, rgba(img, 0, 0))
+			require.Equal(t, c.bottom, rgba(img, 0, share.Height-1))
+		})
+	}
+}
+
+func TestRenderAvatar(t *testing.T) {
+	var (
+		red = color.RGBA{255, 0, 0, 255}
+		avi = image.NewRGBA(image.Rect(0, 0, 50, 50))
+		b   bytes.Buffer
+	)
+
+	for i := 0; i < len(avi.Pix); i += 4 {
+		copy(avi.Pix[i:], []uint8{red.R, red.G, red.B, red.A})
+	}
+	require.NoError(t, png.Encode(&b, avi))
+
+	a := account.New("A name that is far too long to fit on a single line of the share image", "test", "")
+	a.Avi = b.Bytes()
+
+	out, err := share.Render(a, newTheme("#000"))
+	require.NoError(t, err)
+
+	img := decode(t, out)
+
+	// Middle of the avatar, and its corner which is cut off by the circle
+	require.Equal(t, red, rgba(img, 220, 315))
+	require.Equal(t, color.RGBA{0, 0, 0, 255}, rgba(img, 102, 197))
+
+	// Broken avatars are left out
+	a.Avi = []byte("not an image")
+
+	_, err = share.Render(a, newTheme("#000"))
+	require.NoError(t, err)
+}
+
+func TestImage(t *testing.T) {
+	var (
+		ctx = context.Background()
+		mem = cache.NewMemory(cache.MemoryConfig{})
+		kv  = &CountingCache{Cache: mem}
+		h   = share.NewHandler(kv, share.Config{})
+		a   = account.New("Test", "test", "")
+		cmd = &share.ImageCmd{Account: a, Theme: newTheme("#000")}
+	)
+
+	t.Cleanup(func() { require.NoError(t, mem.Close()) })
+
+	first, err := h.Image(ctx, cmd)
+	require.NoError(t, err)
+	require.Equal(t, 1, kv.puts)
+
+	second, err := h.Image(ctx, cmd)
+	require.NoError(t, err)
+	require.Equal(t, first, second)
+	require.Equal(t, 1, kv.puts)
+
+	// Saving the account changes UpdatedAt, which draws a new image
+	a.UpdatedAt = a.UpdatedAt.Add(time.Second)
+	a.Links = append(a.Links, *account.NewLink(a.ID, "Site", "https://example.com", 0))
+
+	third, err := h.Image(ctx, cmd)
+	require.NoError(t, err)
+	require.Equal(t, 2, kv.puts)
+	require.NotEqual(t, first, third)
+}
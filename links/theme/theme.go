@@ -0,0 +1,211 @@
+package theme
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/derinil/links/links/css"
+	"github.com/derinil/links/links/generic"
+)
+
+/*
+	Themes:
+		- Every theme is a JSON file, the file name is its ID. Adding a
+			file is all it takes to add a theme.
+		- Themes only set custom properties, the stylesheets of the pages
+			decide what uses them. Profile CSS comes after the theme, so
+			users can build on top of it.
+		- The properties go through the CSS sanitizer like user CSS does,
+			a theme that wouldn't survive it fails to load.
+		- Accounts without a theme, or with one that was taken out, get
+			the default theme.
+*/
+
+type (
+	Theme struct {
+		ID          string `json:"-"`
+		Name        string `json:"name"`
+		Description string `json:"description"`
+		// Gallery is sorted by order, then by name
+		Order  int    `json:"order"`
+		Colors Colors `json:"colors"`
+		// Font stack, like "Georgia, serif"
+		Font        string `json:"font"`
+		ButtonShape Shape  `json:"button_shape"`
+		// Anything the background property takes, only relative and
+		// https image urls work
+		Background string `json:"background"`
+
+		// The properties scoped to the profile and to the theme's preview
+		profileCSS string
+		previewCSS string
+	}
+
+	Colors struct {
+		Text       string `json:"text"`
+		Button     string `json:"button"`
+		ButtonText string `json:"button_text"`
+		Accent     string `json:"accent"`
+	}
+
+	Shape string
+
+	Registry struct {
+		themes map[string]*Theme
+		list   []*Theme
+	}
+)
+
+const (
+	Square  Shape = "square"
+	Rounded Shape = "rounded"
+	Pill    Shape = "pill"
+)
+
+const DefaultID = "default"
+
+var ErrThemeNotFound = generic.NewWebError(http.StatusBadRequest, "theme_not_found", "Theme not found")
+
+var (
+	idRegex = regexp.MustCompile(`^[a-z0-9-]{1,64}$`)
+	radii   = map[Shape]string{
+		Square:  "0",
+		Rounded: "8px",
+		Pill:    "999px",
+	}
+)
+
+// Load reads every .json file in dir, one of them has to be the default theme
+func Load(fsys fs.FS, dir string) (*Registry, error) {
+	names, err := fs.Glob(fsys, path.Join(dir, "*.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list themes: %w", err)
+	}
+
+	r := &Registry{themes: make(map[string]*Theme, len(names))}
+
+	for _, name := range names {
+		b, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read theme %s: %w", name, err)
+		}
+
+		t := &Theme{ID: strings.TrimSuffix(path.Base(name), ".json")}
+		if err := json.Unmarshal(b, t); err != nil {
+			return nil, fmt.Errorf("failed to parse theme %s: %w", name, err)
+		}
+
+		if err := t.compile(); err != nil {
+			return nil, fmt.Errorf("failed to load theme %s: %w", name, err)
+		}
+
+		r.themes[t.ID] = t
+		r.list = append(r.list, t)
+	}
+
+	if r.themes[DefaultID] == nil {
+		return nil, errors.New("there is no " + DefaultID + " theme")
+	}
+
+	sort.Slice(r.list, func(i, j int) bool {
+		if r.list[i].Order != r.list[j].Order {
+			return r.list[i].Order < r.list[j].Order
+		}
+		return r.list[i].Name < r.list[j].Name
+	})
+
+	return r, nil
+}
+
+// Get returns the default theme if there is no theme with the ID
+func (r *Registry) Get(id string) *Theme {
+	if t, ok := r.themes[id]; ok {
+		return t
+	}
+
+	return r.themes[DefaultID]
+}
+
+func (r *Registry) Has(id string) bool {
+	_, ok := r.themes[id]
+	return ok
+}
+
+// List returns the themes in the order the gallery shows them
+func (r *Registry) List() []*Theme {
+	return r.list
+}
+
+// ProfileCSS returns the theme's properties for the #profile container
+func (t *Theme) ProfileCSS() string {
+	return t.profileCSS
+}
+
+// PreviewCSS returns the theme's properties for elements with the theme-<id> class
+func (t *Theme) PreviewCSS() string {
+	return t.previewCSS
+}
+
+func (t *Theme) compile() error {
+	if !idRegex.MatchString(t.ID) {
+		return fmt.Errorf("id %q can only have lowercase letters, numbers and dashes", t.ID)
+	}
+
+	if t.Name == "" {
+		return errors.New("name is missing")
+	}
+
+	radius, ok := radii[t.ButtonShape]
+	if !ok {
+		return fmt.Errorf("button shape %q isn't one of square, rounded or pill", t.ButtonShape)
+	}
+
+	props := []struct{ name, value string }{
+		{"background", t.Background},
+		{"text", t.Colors.Text},
+		{"button", t.Colors.Button},
+		{"button-text", t.Colors.ButtonText},
+		{"accent", t.Colors.Accent},
+		{"font", t.Font},
+		{"button-radius", radius},
+	}
+
+	// :root is replaced with the scope by the sanitizer
+	var b strings.Builder
+	b.WriteString(":root {\n")
+	for _, p := range props {
+		if p.value == "" {
+			return fmt.Errorf("%s is missing", p.name)
+		}
+
+		fmt.Fprintf(&b, "  --theme-%s: %s;\n", p.name, p.value)
+	}
+	b.WriteString("}\n")
+
+	var err error
+	if t.profileCSS, err = scoped(b.String(), css.DefaultPolicy.Scope); err != nil {
+		return err
+	}
+
+	t.previewCSS, err = scoped(b.String(), ".theme-"+t.ID)
+	return err
+}
+
+func scoped(src, scope string) (string, error) {
+	p := *css.DefaultPolicy
+	p.Scope = scope
+
+	out, errs := p.Sanitize(src)
+	if len(errs) > 0 {
+		return "", errs[0]
+	}
+
+	return out, nil
+}
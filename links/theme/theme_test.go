@@ -0,0 +1,123 @@
+package theme_test
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/derinil/links/links/theme"
+	"github.com/derinil/links/links/views"
+	"github.com/stretchr/testify/require"
+)
+
+func themeFile(name, shape, background string) *fstest.MapFile {
+	return &fstest.MapFile{Data: []byte(`{
+		"name": "` + name + `",
+		"colors": {"text": "#000", "button": "#fff", "button_text": "#000", "accent": "red"},
+		"font": "Georgia, serif",
+		"button_shape": "` + shape + `",
+		"background": "` + background + `"
+	}`)}
+}
+
+func TestLoadEmbedded(t *testing.T) {
+	r, err := theme.Load(views.ThemeFiles, "themes")
+	require.NoError(t, err)
+	require.NotEmpty(t, r.List())
+
+	for _, th := range r.List() {
+		require.NotEmpty(t, th.ProfileCSS(), th.ID)
+		require.NotEmpty(t, th.PreviewCSS(), th.ID)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	testCases := []struct {
+		name   string
+		fsys   fstest.MapFS
+		errStr string
+	}{
+		{
+			name: "valid",
+			fsys: fstest.MapFS{
+				"themes/default.json": themeFile("Classic", "square", "white"),
+				"themes/dark.json":    themeFile("Dark", "pill", "#111"),
+			},
+		},
+		{
+			name: "missing default",
+			fsys: fstest.MapFS{
+				"themes/dark.json": themeFile("Dark", "pill", "#111"),
+			},
+			errStr: "there is no default theme",
+		},
+		{
+			name: "bad shape",
+			fsys: fstest.MapFS{
+				"themes/default.json": themeFile("Classic", "round", "white"),
+			},
+			errStr: `failed to load theme themes/default.json: button shape "round" isn't one of square, rounded or pill`,
+		},
+		{
+			name: "missing value",
+			fsys: fstest.MapFS{
+				"themes/default.json": themeFile("Classic", "square", ""),
+			},
+			errStr: "failed to load theme themes/default.json: background is missing",
+		},
+		{
+			name: "unsafe value",
+			fsys: fstest.MapFS{
+				"themes/default.json": themeFile("Classic", "square", "url(javascript:alert(1))"),
+			},
+			errStr: "failed to load theme themes/default.json: line 2, column 23: invalid url",
+		},
+		{
+			name: "bad id",
+			fsys: fstest.MapFS{
+				"themes/default.json": themeFile("Classic", "square", "white"),
+				"themes/Dark.json":    themeFile("Dark", "pill", "#111"),
+			},
+			errStr: `failed to load theme themes/Dark.json: id "Dark" can only have lowercase letters, numbers and dashes`,
+		},
+	}
+
+	for _, c := range testCases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			_, err := theme.Load(c.fsys, "themes")
+			if c.errStr == "" {
+				require.NoError(t, err)
+				return
+			}
+
+			require.EqualError(t, err, c.errStr)
+		})
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	r, err := theme.Load(fstest.MapFS{
+		"themes/default.json": themeFile("Classic", "square", "white"),
+		"themes/dark.json":    themeFile("Dark", "pill", "#111"),
+		"themes/amber.json":   themeFile("Amber", "rounded", "#fc0"),
+	}, "themes")
+	require.NoError(t, err)
+
+	require.True(t, r.Has("dark"))
+	require.False(t, r.Has("removed"))
+	require.Equal(t, "dark", r.Get("dark").ID)
+	require.Equal(t, theme.DefaultID, r.Get("removed").ID)
+	require.Equal(t, theme.DefaultID, r.Get("").ID)
+
+	var ids []string
+	for _, th := range r.List() {
+		ids = append(ids, th.ID)
+	}
+	require.Equal(t, []string{"amber", "default", "dark"}, ids)
+
+	dark := r.Get("dark")
+	require.Contains(t, dark.ProfileCSS(), "#profile {\n")
+	require.Contains(t, dark.ProfileCSS(), "--theme-button-radius: 999px;")
+	require.Contains(t, dark.PreviewCSS(), ".theme-dark {\n")
+	require.Contains(t, dark.PreviewCSS(), "--theme-font: Georgia, serif;")
+}
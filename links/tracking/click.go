@@ -0,0 +1,45 @@
+package tracking
+
+import (
+	"net/url"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type (
+	Click struct {
+		ID             uuid.UUID      `db:"id"`
+		LinkID         uuid.UUID      `db:"link_id"`
+		AccountID      uuid.UUID      `db:"account_id"`
+		Referrer       string         `db:"referrer"`
+		UserAgentClass UserAgentClass `db:"user_agent_class"`
+		ClickedAt      time.Time      `db:"clicked_at"`
+	}
+)
+
+// Only the host of the referrer is kept, we don't need to know
+// the exact page people came from and it could be sensitive
+func NewClick(accountID, linkID uuid.UUID, referrer, userAgent string) *Click {
+	return &Click{
+		ID:             uuid.New(),
+		LinkID:         linkID,
+		AccountID:      accountID,
+		Referrer:       referrerHost(referrer),
+		UserAgentClass: ClassifyUserAgent(userAgent),
+		ClickedAt:      time.Now().UTC(),
+	}
+}
+
+func referrerHost(referrer string) string {
+	if referrer == "" {
+		return ""
+	}
+
+	u, err := url.Parse(referrer)
+	if err != nil {
+		return ""
+	}
+
+	return u.Hostname()
+}
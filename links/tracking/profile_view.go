@@ -0,0 +1,23 @@
+package tracking
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type ProfileView struct {
+	AccountID      uuid.UUID      `db:"account_id"`
+	Referrer       string         `db:"referrer"`
+	UserAgentClass UserAgentClass `db:"user_agent_class"`
+	ViewedAt       time.Time      `db:"viewed_at"`
+}
+
+func NewProfileView(accountID uuid.UUID, referrer, userAgent string) *ProfileView {
+	return &ProfileView{
+		AccountID:      accountID,
+		Referrer:       referrerHost(referrer),
+		UserAgentClass: ClassifyUserAgent(userAgent),
+		ViewedAt:       time.Now().UTC(),
+	}
+}
@@ -0,0 +1,109 @@
+package tracking
+
+import (
+	"context"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+type (
+	// Recorder must never block the request that records an event
+	Recorder[T any] interface {
+		Record(event *T)
+	}
+
+	// Writer must not hold on to the events slice after Save returns
+	Writer[T any] interface {
+		Save(ctx context.Context, events []T) error
+	}
+
+	// BatchRecorder buffers events in memory and saves them in batches from a
+	// single goroutine, so a slow database only ever slows down the batches
+	// and never the requests. Events are dropped when the buffer is full.
+	BatchRecorder[T any] struct {
+		writer        Writer[T]
+		events        chan T
+		batchSize     int
+		flushInterval time.Duration
+		dropped       atomic.Uint64
+	}
+)
+
+// How long a single batch is allowed to take, this is independent
+// of the context passed to Run so we can flush after it's cancelled.
+const flushTimeout = 10 * time.Second
+
+func NewBatchRecorder[T any](writer Writer[T], bufferSize, batchSize int, flushInterval time.Duration) *BatchRecorder[T] {
+	return &BatchRecorder[T]{
+		writer:        writer,
+		events:        make(chan T, bufferSize),
+		batchSize:     batchSize,
+		flushInterval: flushInterval,
+	}
+}
+
+func (s *BatchRecorder[T]) Record(event *T) {
+	select {
+	case s.events <- *event:
+	default:
+		s.dropped.Add(1)
+	}
+}
+
+// Dropped returns the number of events that didn't fit in the buffer
+func (s *BatchRecorder[T]) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+// Run saves batches until the context is cancelled, then saves
+// whatever is left in the buffer and returns.
+func (s *BatchRecorder[T]) Run(ctx context.Context) {
+	var (
+		ticker = time.NewTicker(s.flushInterval)
+		batch  = make([]T, 0, s.batchSize)
+	)
+
+	defer ticker.Stop()
+
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+
+		fctx, cancel := context.WithTimeout(context.Background(), flushTimeout)
+		defer cancel()
+
+		if err := s.writer.Save(fctx, batch); err != nil {
+			log.Println("failed to save batch of", len(batch), "events", err)
+		}
+
+		batch = batch[:0]
+	}
+
+	add := func(e T) {
+		batch = append(batch, e)
+		if len(batch) >= s.batchSize {
+			flush()
+		}
+	}
+
+	for {
+		select {
+		case e := <-s.events:
+			add(e)
+		case <-ticker.C:
+			flush()
+		case <-ctx.Done():
+			for {
+				select {
+				case e := <-s.events:
+					add(e)
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package tracking_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/derinil/links/links/tracking"
+	"github.com/stretchr/testify/require"
+)
+
+type FakeWriter struct {
+	sync.Mutex
+	batches [][]int
+	err     error
+}
+
+func (w *FakeWriter) Save(ctx context.Context, events []int) error {
+	w.Lock()
+	defer w.Unlock()
+
+	w.batches = append(w.batches, append([]int(nil), events...))
+
+	return w.err
+}
+
+func (w *FakeWriter) Batches() [][]int {
+	w.Lock()
+	defer w.Unlock()
+
+	return w.batches
+}
+
+func record(r *tracking.BatchRecorder[int], from, to int) {
+	for i := from; i < to; i++ {
+		e := i
+		r.Record(&e)
+	}
+}
+
+func TestBatchRecorderFlushesFullBatches(t *testing.T) {
+	var (
+		ctx, cancel = context.WithCancel(context.Background())
+		writer      = new(FakeWriter)
+		recorder    = tracking.NewBatchRecorder[int](writer, 100, 5, time.Hour)
+		done        = make(chan struct{})
+	)
+
+	go func() {
+		recorder.Run(ctx)
+		close(done)
+	}()
+
+	record(recorder, 0, 10)
+
+	require.Eventually(t, func() bool {
+		return len(writer.Batches()) == 2
+	}, time.Second, time.Millisecond)
+
+	cancel()
+	<-done
+
+	require.Equal(t, [][]int{{0, 1, 2, 3, 4}, {5, 6, 7, 8, 9}}, writer.Batches())
+}
+
+func TestBatchRecorderFlushesOnInterval(t *testing.T) {
+	var (
+		ctx, cancel = context.WithCancel(context.Background())
+		writer      = new(FakeWriter)
+		recorder    = tracking.NewBatchRecorder[int](writer, 100, 50, 10*time.Millisecond)
+	)
+
+	defer cancel()
+
+	go recorder.Run(ctx)
+
+	record(recorder, 0, 3)
+
+	require.Eventually(t, func() bool {
+		return len(writer.Batches()) == 1
+	}, time.Second, time.Millisecond)
+
+	require.Equal(t, []int{0, 1, 2}, writer.Batches()[0])
+}
+
+func TestBatchRecorderDrainsOnCancel(t *testing.T) {
+	var (
+		ctx, cancel = context.WithCancel(context.Background())
+		writer      = new(FakeWriter)
+		recorder    = tracking.NewBatchRecorder[int](writer, 100, 50, time.Hour)
+	)
+
+	record(recorder, 0, 7)
+	cancel()
+
+	// Run returns right away since the context is already
+	// cancelled, but only after saving what was buffered
+	recorder.Run(ctx)
+
+	require.Equal(t, [][]int{{0, 1, 2, 3, 4, 5, 6}}, writer.Batches())
+}
+
+func TestBatchRecorderDropsWhenFull(t *testing.T) {
+	var (
+		writer   = new(FakeWriter)
+		recorder = tracking.NewBatchRecorder[int](writer, 3, 50, time.Hour)
+	)
+
+	record(recorder, 0, 5)
+
+	require.Equal(t, uint64(2), recorder.Dropped())
+}
+
+func TestBatchRecorderKeepsGoingAfterErrors(t *testing.T) {
+	var (
+		ctx, cancel = context.WithCancel(context.Background())
+		writer      = &FakeWriter{err: errors.New("haha")}
+		recorder    = tracking.NewBatchRecorder[int](writer, 100, 2, time.Hour)
+		done        = make(chan struct{})
+	)
+
+	go func() {
+		recorder.Run(ctx)
+		close(done)
+	}()
+
+	record(recorder, 0, 4)
+
+	require.Eventually(t, func() bool {
+		return len(writer.Batches()) == 2
+	}, time.Second, time.Millisecond)
+
+	cancel()
+	<-done
+}
@@ -0,0 +1,54 @@
+package tracking
+
+import "strings"
+
+type UserAgentClass string
+
+const (
+	UserAgentUnknown UserAgentClass = "unknown"
+	UserAgentBot     UserAgentClass = "bot"
+	UserAgentMobile  UserAgentClass = "mobile"
+	UserAgentTablet  UserAgentClass = "tablet"
+	UserAgentDesktop UserAgentClass = "desktop"
+)
+
+var (
+	botMarkers    = [...]string{"bot", "crawl", "spider", "slurp", "preview", "facebookexternalhit", "curl", "wget", "python", "go-http-client"}
+	tabletMarkers = [...]string{"ipad", "tablet", "kindle", "silk", "playbook"}
+	mobileMarkers = [...]string{"mobi", "iphone", "ipod", "android", "windows phone", "blackberry", "opera mini"}
+)
+
+// ClassifyUserAgent puts a user agent into a coarse bucket. This is a best
+// effort guess, we only want to know roughly what kind of device clicked
+// and we don't want to store the whole user agent.
+func ClassifyUserAgent(ua string) UserAgentClass {
+	ua = strings.ToLower(strings.TrimSpace(ua))
+	if ua == "" {
+		return UserAgentUnknown
+	}
+
+	if containsAny(ua, botMarkers[:]) {
+		return UserAgentBot
+	}
+
+	// Android tablets don't have "mobile" in their user agents
+	if containsAny(ua, tabletMarkers[:]) || (strings.Contains(ua, "android") && !strings.Contains(ua, "mobile")) {
+		return UserAgentTablet
+	}
+
+	if containsAny(ua, mobileMarkers[:]) {
+		return UserAgentMobile
+	}
+
+	return UserAgentDesktop
+}
+
+func containsAny(s string, subs []string) bool {
+	for _, sub := range subs {
+		if strings.Contains(s, sub) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,67 @@
+package tracking_test
+
+import (
+	"testing"
+
+	"github.com/derinil/links/links/tracking"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClassifyUserAgent(t *testing.T) {
+	testCases := []struct {
+		name  string
+		ua    string
+		class tracking.UserAgentClass
+	}{
+		{
+			name:  "empty",
+			class: tracking.UserAgentUnknown,
+		},
+		{
+			name:  "desktop chrome",
+			ua:    "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/110.0.0.0 Safari/537.36",
+			class: tracking.UserAgentDesktop,
+		},
+		{
+			name:  "desktop firefox",
+			ua:    "Mozilla/5.0 (X11; Linux x86_64; rv:109.0) Gecko/20100101 Firefox/110.0",
+			class: tracking.UserAgentDesktop,
+		},
+		{
+			name:  "iphone",
+			ua:    "Mozilla/5.0 (iPhone; CPU iPhone OS 16_3 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/16.3 Mobile/15E148 Safari/604.1",
+			class: tracking.UserAgentMobile,
+		},
+		{
+			name:  "android phone",
+			ua:    "Mozilla/5.0 (Linux; Android 13; Pixel 7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/110.0.0.0 Mobile Safari/537.36",
+			class: tracking.UserAgentMobile,
+		},
+		{
+			name:  "android tablet",
+			ua:    "Mozilla/5.0 (Linux; Android 12; SM-X700) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/110.0.0.0 Safari/537.36",
+			class: tracking.UserAgentTablet,
+		},
+		{
+			name:  "ipad",
+			ua:    "Mozilla/5.0 (iPad; CPU OS 16_3 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/16.3 Mobile/15E148 Safari/604.1",
+			class: tracking.UserAgentTablet,
+		},
+		{
+			name:  "googlebot",
+			ua:    "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)",
+			class: tracking.UserAgentBot,
+		},
+		{
+			name:  "curl",
+			ua:    "curl/7.88.1",
+			class: tracking.UserAgentBot,
+		},
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			require.Equal(t, c.class, tracking.ClassifyUserAgent(c.ua))
+		})
+	}
+}
@@ -36,12 +36,18 @@ window.addEventListener("DOMContentLoaded", function () {
 
     const upLink = (event) => {
         let p = event.target.parentElement.parentElement;
+        if (!p.previousElementSibling) {
+            return;
+        }
         p.parentElement.insertBefore(p, p.previousElementSibling);
         refreshInfo();
     };
 
     const downLink = (event) => {
         let p = event.target.parentElement.parentElement;
+        if (!p.nextElementSibling) {
+            return;
+        }
         p.parentElement.insertBefore(p, p.nextElementSibling.nextElementSibling);
         refreshInfo();
     };
@@ -64,7 +70,9 @@ window.addEventListener("DOMContentLoaded", function () {
         <div class="link-entry">
             <div class="link-edit">
               <label class="italic link-title">Link #${i + 1}</label>
-    
+
+              <input type="hidden" name="links_id[]" value="" />
+
               <label class="sub-label" for="links_${i}_title">Title</label>
               <input
                 type="text"
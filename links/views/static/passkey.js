@@ -0,0 +1,100 @@
+// Passes the options from the server to the authenticator and posts the
+// response back through a hidden form, so errors and redirects work the
+// same way they do for every other form.
+window.addEventListener("DOMContentLoaded", function () {
+    const toBytes = (s) => {
+        s = s.replace(/-/g, "+").replace(/_/g, "/");
+        return Uint8Array.from(atob(s), c => c.charCodeAt(0));
+    };
+
+    const fromBytes = (b) => {
+        let s = "";
+        new Uint8Array(b).forEach(c => s += String.fromCharCode(c));
+        return btoa(s).replace(/\+/g, "-").replace(/\//g, "_").replace(/=+$/, "");
+    };
+
+    const getOptions = async (url) => {
+        const res = await fetch(url, { credentials: "same-origin" });
+        if (!res.ok) {
+            throw new Error(await res.text());
+        }
+        return (await res.json()).publicKey;
+    };
+
+    const submit = (form, response) => {
+        form.querySelector("input[name=response]").value = JSON.stringify(response);
+        form.submit();
+    };
+
+    const showError = (form, err) => {
+        const p = form.querySelector(".passkey-error");
+        if (p) {
+            p.textContent = err.message || "Something went wrong with the passkey";
+            p.hidden = false;
+        }
+    };
+
+    const supported = window.PublicKeyCredential !== undefined;
+    Array.prototype.forEach.call(document.getElementsByClassName("passkey"), element => {
+        element.hidden = !supported;
+    });
+
+    if (!supported) {
+        return;
+    }
+
+    const login = document.getElementById("passkey-login-form");
+    if (login) {
+        login.addEventListener("submit", async (event) => {
+            event.preventDefault();
+            try {
+                const opts = await getOptions("/login/passkey/options");
+                opts.challenge = toBytes(opts.challenge);
+
+                const cred = await navigator.credentials.get({ publicKey: opts });
+                submit(login, {
+                    id: cred.id,
+                    rawId: fromBytes(cred.rawId),
+                    type: cred.type,
+                    response: {
+                        clientDataJSON: fromBytes(cred.response.clientDataJSON),
+                        authenticatorData: fromBytes(cred.response.authenticatorData),
+                        signature: fromBytes(cred.response.signature),
+                        userHandle: cred.response.userHandle ? fromBytes(cred.response.userHandle) : "",
+                    },
+                });
+            } catch (err) {
+                showError(login, err);
+            }
+        });
+    }
+
+    const register = document.getElementById("passkey-add-form");
+    if (register) {
+        register.addEventListener("submit", async (event) => {
+            event.preventDefault();
+            if (!register.checkValidity()) {
+                return;
+            }
+            try {
+                const opts = await getOptions("/account/passkeys/options");
+                opts.challenge = toBytes(opts.challenge);
+                opts.user.id = toBytes(opts.user.id);
+                opts.excludeCredentials.forEach(c => c.id = toBytes(c.id));
+
+                const cred = await navigator.credentials.create({ publicKey: opts });
+                submit(register, {
+                    id: cred.id,
+                    rawId: fromBytes(cred.rawId),
+                    type: cred.type,
+                    response: {
+                        clientDataJSON: fromBytes(cred.response.clientDataJSON),
+                        attestationObject: fromBytes(cred.response.attestationObject),
+                    },
+                });
+            } catch (err) {
+                showError(register, err);
+            }
+        });
+    }
+});
@@ -3,14 +3,29 @@ package views
 import (
 	"context"
 	"embed"
+	"fmt"
 	"html/template"
+	"io/fs"
 	"net/http"
+	"path"
+	"strings"
+	textTemplate "text/template"
 	"time"
 
 	"github.com/derinil/links/links/account"
+	"github.com/derinil/links/links/account/oidc"
+	"github.com/derinil/links/links/account/passkey"
 	"github.com/derinil/links/links/account/session"
+	"github.com/derinil/links/links/account/token"
+	"github.com/derinil/links/links/account/totp"
+	"github.com/derinil/links/links/analytics"
 	"github.com/derinil/links/links/crypto/csrf"
 	"github.com/derinil/links/links/generic"
+	"github.com/derinil/links/links/importer"
+	"github.com/derinil/links/links/mail"
+	"github.com/derinil/links/links/share"
+	"github.com/derinil/links/links/theme"
+	"github.com/google/uuid"
 )
 
 //go:embed *.html
@@ -19,6 +34,12 @@ var files embed.FS
 //go:embed static/*
 var StaticFiles embed.FS
 
+//go:embed themes/*.json
+var ThemeFiles embed.FS
+
+//go:embed mail/*
+var mailFiles embed.FS
+
 type (
 	// This handler will panic at any error as it does not rely
 	// on user input and if anything goes wrong it is a crucial
@@ -62,11 +83,52 @@ type (
 	}
 
 	AccountPageCmd struct {
-		Account *account.Account
+		Account   *account.Account
+		Analytics *analytics.Summary
+		Tokens    []token.Token
+		// Plaintext of a token that was just created, this is the only time it's shown
+		NewToken string
+		// Nil if two factor authentication was never set up
+		TOTP              *totp.TOTP
+		RecoveryCodesLeft int
+		// Only set right after two factor authentication is turned on
+		RecoveryCodes []string
+		Passkeys      []passkey.Credential
+		// Every configured provider and the ones the account is connected to
+		Providers  []oidc.Provider
+		Identities []oidc.Identity
+		// Sessions of the account, CurrentSession is the one looking at the page
+		Sessions       []session.Session
+		CurrentSession uuid.UUID
+		// Every theme for the gallery, Theme is the one the account uses
+		Themes []*theme.Theme
+		Theme  string
+		// Days a deleted account can still be saved by logging in
+		DeletionGraceDays int
+		// Links read from an uploaded file, shown for the user to pick from
+		ImportedLinks []importer.Link
+		ImportFormat  importer.Format
+	}
+
+	LoginPageCmd struct {
+		Providers []oidc.Provider
+	}
+
+	OIDCSignupPageCmd struct {
+		Signup *oidc.Signup
+	}
+
+	// Token comes from the link in the mail
+	ResetPasswordPageCmd struct {
+		Token string
 	}
 
 	LinksPageCmd struct {
 		Account *account.Account
+		Theme   *theme.Theme
+		// Absolute URLs of the page and its share image for link previews
+		URL      string
+		ImageURL string
 	}
 )
 
@@ -76,6 +138,13 @@ const (
 	Links    Page = "links"
 	Register Page = "register"
 	Account  Page = "account"
+	// Asks for the code after a password login on accounts with two factor authentication
+	TwoFactor Page = "two_factor"
+	// Picks a handle for an account created through an OpenID Connect provider
+	OIDCSignup Page = "oidc_signup"
+	// Asks for the email to send a reset link to, then for the new password
+	ForgotPassword Page = "forgot_password"
+	ResetPassword  Page = "reset_password"
 )
 
 var _ Handler = (*HandlerImpl)(nil)
@@ -161,13 +230,76 @@ func LoginPageRenderer() *RendererImpl {
 	}
 }
 
+func TwoFactorPageRenderer() *RendererImpl {
+	tmpl := template.Must(template.ParseFS(files, "base.html", "two_factor.html"))
+
+	return &RendererImpl{
+		page: TwoFactor,
+		handle: func(w http.ResponseWriter, rc *internalCmd) {
+			tmpl.Execute(w, rc)
+		},
+	}
+}
+
+func OIDCSignupPageRenderer() *RendererImpl {
+	tmpl := template.Must(template.ParseFS(files, "base.html", "oidc_signup.html"))
+
+	return &RendererImpl{
+		page: OIDCSignup,
+		handle: func(w http.ResponseWriter, rc *internalCmd) {
+			tmpl.Execute(w, rc)
+		},
+	}
+}
+
+func ForgotPasswordPageRenderer() *RendererImpl {
+	tmpl := template.Must(template.ParseFS(files, "base.html", "forgot_password.html"))
+
+	return &RendererImpl{
+		page: ForgotPassword,
+		handle: func(w http.ResponseWriter, rc *internalCmd) {
+			tmpl.Execute(w, rc)
+		},
+	}
+}
+
+func ResetPasswordPageRenderer() *RendererImpl {
+	tmpl := template.Must(template.ParseFS(files, "base.html", "reset_password.html"))
+
+	return &RendererImpl{
+		page: ResetPassword,
+		handle: func(w http.ResponseWriter, rc *internalCmd) {
+			tmpl.Execute(w, rc)
+		},
+	}
+}
+
 func LinksPageRenderer() *RendererImpl {
-	tmpl := template.Must(template.ParseFS(files, "base.html", "links.html"))
+	var (
+		funcs = template.FuncMap{
+			// Account CSS was sanitized when it was saved, it's scoped to
+			// #profile and can't close the element
+			"css": func(s string) template.CSS {
+				return template.CSS(s)
+			},
+			// Themes went through the sanitizer when they were loaded
+			"themecss": func(t *theme.Theme) template.CSS {
+				return template.CSS(t.ProfileCSS())
+			},
+			"shareWidth": func() int {
+				return share.Width
+			},
+			"shareHeight": func() int {
+				return share.Height
+			},
+		}
+		tmpl = template.Must(template.New("").Funcs(funcs).ParseFS(files, "base.html", "links.html"))
+	)
 
 	return &RendererImpl{
 		page: Links,
 		handle: func(w http.ResponseWriter, rc *internalCmd) {
-			tmpl.Execute(w, rc)
+			tmpl.ExecuteTemplate(w, "base.html", rc)
 		},
 	}
 }
@@ -178,6 +310,41 @@ func AccountPageRenderer() *RendererImpl {
 			"add": func(x, y int) int {
 				return x + y
 			},
+			// Percentage of x in max, for drawing bars
+			"percent": func(x, max int) int {
+				if max == 0 {
+					return 0
+				}
+				return x * 100 / max
+			},
+			"rate": func(r float64) string {
+				return fmt.Sprintf("%.1f%%", r*100)
+			},
+			"windows": func() []analytics.Window {
+				return analytics.Windows[:]
+			},
+			// html/template doesn't trust otpauth links, but we build this one ourselves
+			"otpauth": func(t *totp.TOTP, handle string) template.URL {
+				return template.URL(t.URI(handle))
+			},
+			"scopes": func() []token.Scope {
+				return token.Scopes
+			},
+			"previewcss": func(ts []*theme.Theme) template.CSS {
+				var b strings.Builder
+				for _, t := range ts {
+					b.WriteString(t.PreviewCSS())
+				}
+				return template.CSS(b.String())
+			},
+			// Token lifetimes in days
+			"lifetimes": func() []int {
+				ds := make([]int, len(token.Lifetimes))
+				for i := range token.Lifetimes {
+					ds[i] = int(token.Lifetimes[i] / (24 * time.Hour))
+				}
+				return ds
+			},
 		}
 		tmpl = template.Must(template.New("").Funcs(funcs).ParseFS(files, "base.html", "account.html"))
 	)
@@ -189,3 +356,62 @@ func AccountPageRenderer() *RendererImpl {
 		},
 	}
 }
+
+// MailRenderer renders the mails under mail/. Each mail has a .txt template
+// that also defines its "subject", and a .html template for mail/base.html.
+type MailRenderer struct {
+	mails map[string]*mailTemplates
+}
+
+type mailTemplates struct {
+	text *textTemplate.Template
+	html *template.Template
+}
+
+var _ mail.Renderer = (*MailRenderer)(nil)
+
+// NewMailRenderer panics for the same reasons page renderers do
+func NewMailRenderer() *MailRenderer {
+	names, err := fs.Glob(mailFiles, "mail/*.txt")
+	if err != nil {
+		panic(err)
+	}
+
+	m := make(map[string]*mailTemplates, len(names))
+	for _, n := range names {
+		name := strings.TrimSuffix(path.Base(n), ".txt")
+		m[name] = &mailTemplates{
+			text: textTemplate.Must(textTemplate.ParseFS(mailFiles, n)),
+			html: template.Must(template.ParseFS(mailFiles, "mail/base.html", "mail/"+name+".html")),
+		}
+	}
+
+	return &MailRenderer{mails: m}
+}
+
+func (s *MailRenderer) Render(name string, data any) (*mail.Message, error) {
+	t, ok := s.mails[name]
+	if !ok {
+		return nil, fmt.Errorf("no mail named %q", name)
+	}
+
+	var subject, text, html strings.Builder
+
+	if err := t.text.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return nil, fmt.Errorf("failed to render subject: %w", err)
+	}
+
+	if err := t.text.Execute(&text, data); err != nil {
+		return nil, fmt.Errorf("failed to render text: %w", err)
+	}
+
+	if err := t.html.ExecuteTemplate(&html, "base.html", data); err != nil {
+		return nil, fmt.Errorf("failed to render html: %w", err)
+	}
+
+	return &mail.Message{
+		Subject: strings.TrimSpace(subject.String()),
+		Text:    text.String(),
+		HTML:    html.String(),
+	}, nil
+}
@@ -0,0 +1,49 @@
+package views_test
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/derinil/links/links/account"
+	"github.com/derinil/links/links/generic"
+	"github.com/derinil/links/links/theme"
+	"github.com/derinil/links/links/views"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLinksPageRenderer(t *testing.T) {
+	themes, err := theme.Load(views.ThemeFiles, "themes")
+	require.NoError(t, err)
+
+	a := account.New("Jane", "jane", "hash")
+	// Like it was stored, CSS is sanitized on save
+	a.CSS = account.SanitizeCSS("a { color: red; }")
+	require.NoError(t, a.SetLinks([]account.LinkScaffold{
+		{Title: "My blog", Link: "https://blog.example.com"},
+	}))
+
+	var (
+		ctx          = context.WithValue(context.Background(), generic.RequestBeginTimeKey, time.Now())
+		w            = httptest.NewRecorder()
+		viewsHandler = views.NewHandler(views.LinksPageRenderer())
+	)
+
+	viewsHandler.Render(ctx, w, views.Links, &views.RenderCmd{
+		Cmd: &views.LinksPageCmd{
+			Account:  a,
+			Theme:    themes.Get(""),
+			URL:      "https://links.example.com/jane",
+			ImageURL: "https://links.example.com/jane/share.png",
+		},
+	})
+
+	body := w.Body.String()
+	require.Contains(t, body, "<html")
+	require.Contains(t, body, "Jane (@jane) - Links")
+	require.Contains(t, body, "My blog")
+	require.Contains(t, body, "/jane/l/"+a.Links[0].ID.String())
+	require.Contains(t, body, "#profile a {\n  color: red;\n}")
+	require.Contains(t, body, `content="https://links.example.com/jane/share.png"`)
+}
@@ -0,0 +1,399 @@
+package api
+
+import (
+	"context"
+	_ "embed"
+	"net/http"
+	"strings"
+
+	"github.com/derinil/links/links/account"
+	"github.com/derinil/links/links/account/auth"
+	"github.com/derinil/links/links/account/auth/handlers"
+	"github.com/derinil/links/links/account/session"
+	"github.com/derinil/links/links/account/token"
+	"github.com/derinil/links/links/favicon"
+	"github.com/derinil/links/links/ratelimit"
+	"github.com/derinil/links/links/web"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+/*
+	JSON API:
+		- Mounted at /api/v1 and documented in openapi.yaml, which is served
+			from /api/v1/openapi.yaml.
+		- Requests are authenticated with a personal access token in the
+			Authorization header, or with the same session cookie as the site.
+			Tokens are limited to their scopes, sessions can do anything.
+		- There are no CSRF tokens here, instead every request with a body
+			has to be JSON, which browsers won't send cross origin without
+			a CORS preflight that we never allow.
+		- Errors are always {"error": {"key": ..., "message": ...}} with the
+			status code of the generic.WebError behind them.
+		- Changes are rate limited per account under the same rule as the
+			account page forms, so both count towards one budget.
+*/
+
+//go:embed openapi.yaml
+var OpenAPI []byte
+
+type (
+	Handler struct {
+		authHandler    auth.Handler
+		accountHandler account.Handler
+		sessionHandler session.Handler
+		faviconQueue   favicon.Queue
+		limiter        ratelimit.Limiter
+	}
+
+	UpdateAccountRequest struct {
+		Name   string `json:"name"`
+		Handle string `json:"handle"`
+		CSS    string `json:"css"`
+	}
+
+	LinkRequest struct {
+		Title string `json:"title"`
+		URL   string `json:"url"`
+	}
+
+	ReorderLinksRequest struct {
+		IDs []uuid.UUID `json:"ids"`
+	}
+)
+
+func NewHandler(
+	authHandler auth.Handler,
+	accountHandler account.Handler,
+	sessionHandler session.Handler,
+	faviconQueue favicon.Queue,
+	limiter ratelimit.Limiter,
+) *Handler {
+	return &Handler{
+		authHandler:    authHandler,
+		accountHandler: accountHandler,
+		sessionHandler: sessionHandler,
+		faviconQueue:   faviconQueue,
+		limiter:        limiter,
+	}
+}
+
+func (s *Handler) Router() *chi.Mux {
+	var (
+		r            = chi.NewMux()
+		limitAccount = ratelimit.Middleware(s.limiter, web.AccountUpdateRule, byAccount, func(w http.ResponseWriter, r *http.Request, err error) {
+			writeError(w, err)
+		})
+	)
+
+	r.NotFound(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, ErrNotFound)
+	})
+
+	r.MethodNotAllowed(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, ErrMethodNotAllowed)
+	})
+
+	r.Get("/openapi.yaml", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/yaml")
+		w.Write(OpenAPI)
+	})
+
+	r.Group(func(r chi.Router) {
+		r.Use(session.ParseSession(s.sessionHandler))
+		// Runs after ParseSession so the token wins if a request has both
+		r.Use(s.parseAccessToken)
+		r.Use(requireSession)
+		r.Use(requireJSON)
+
+		r.With(requireScope(token.AccountRead)).Get("/account", s.getAccount)
+		r.With(requireScope(token.AccountWrite), limitAccount).Patch("/account", s.updateAccount)
+
+		r.With(requireScope(token.LinksRead)).Group(func(r chi.Router) {
+			r.Get("/links", s.listLinks)
+			r.Get("/links/{linkID}", s.getLink)
+		})
+
+		r.With(requireScope(token.LinksWrite), limitAccount).Group(func(r chi.Router) {
+			r.Post("/links", s.createLink)
+			r.Put("/links/order", s.reorderLinks)
+			r.Patch("/links/{linkID}", s.updateLink)
+			r.Delete("/links/{linkID}", s.deleteLink)
+		})
+	})
+
+	return r
+}
+
+// parseAccessToken authenticates requests with an Authorization: Bearer header. A
+// bad token fails the request right away instead of falling back to the cookie.
+func (s *Handler) parseAccessToken(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get("Authorization")
+		if header == "" {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		scheme, t, ok := strings.Cut(header, " ")
+		if !ok || !strings.EqualFold(scheme, "Bearer") {
+			writeError(w, token.ErrInvalidToken)
+			return
+		}
+
+		a, err := s.authHandler.Handle(r.Context(), &auth.AuthCmd{
+			Method: auth.AccessToken,
+			Cmd:    &handlers.AccessTokenCmd{Token: strings.TrimSpace(t)},
+		})
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), session.SessionObjectKey, a.Session)
+		ctx = context.WithValue(ctx, token.TokenObjectKey, a.Token)
+
+		h.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// requireScope only restricts access tokens, sessions can do everything
+func requireScope(scope token.Scope) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if t, ok := r.Context().Value(token.TokenObjectKey).(*token.Token); ok && !t.HasScope(scope) {
+				writeError(w, token.ErrMissingScope)
+				return
+			}
+
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+func requireSession(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := r.Context().Value(session.SessionObjectKey).(*session.Session); !ok {
+			writeError(w, session.ErrNotAuthenticated)
+			return
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
+
+func requireJSON(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost, http.MethodPut, http.MethodPatch:
+			if !isJSON(r) {
+				writeError(w, ErrUnsupportedMediaType)
+				return
+			}
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
+
+// accountID is only called behind requireSession
+func accountID(r *http.Request) uuid.UUID {
+	return r.Context().Value(session.SessionObjectKey).(*session.Session).AccountID
+}
+
+// byAccount counts requests per account, it's only used behind requireSession
+func byAccount(r *http.Request) string {
+	return accountID(r).String()
+}
+
+// currentHandle is the account's handle now, the session keeps the one it
+// was issued for and previous handles stop working in URLs after a while
+func (s *Handler) currentHandle(r *http.Request) (string, error) {
+	a, err := s.accountHandler.Get(r.Context(), &account.GetCmd{ID: accountID(r), Shallow: true})
+	if err != nil {
+		return "", err
+	}
+
+	return a.Handle, nil
+}
+
+func linkID(r *http.Request) (uuid.UUID, error) {
+	id, err := uuid.Parse(chi.URLParam(r, "linkID"))
+	if err != nil {
+		return uuid.Nil, account.ErrLinkNotFound
+	}
+
+	return id, nil
+}
+
+func (s *Handler) getAccount(w http.ResponseWriter, r *http.Request) {
+	a, err := s.accountHandler.Get(r.Context(), &account.GetCmd{ID: accountID(r)})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, newAccountResponse(a))
+}
+
+func (s *Handler) updateAccount(w http.ResponseWriter, r *http.Request) {
+	var req UpdateAccountRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	a, err := s.accountHandler.Update(r.Context(), &account.UpdateCmd{
+		AccountID: accountID(r),
+		Name:      req.Name,
+		Handle:    req.Handle,
+		CSS:       req.CSS,
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, newAccountResponse(a))
+}
+
+func (s *Handler) listLinks(w http.ResponseWriter, r *http.Request) {
+	a, err := s.accountHandler.Get(r.Context(), &account.GetCmd{ID: accountID(r)})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, &LinksResponse{Links: newLinkResponses(a.Handle, a.Links)})
+}
+
+func (s *Handler) getLink(w http.ResponseWriter, r *http.Request) {
+	id, err := linkID(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	a, err := s.accountHandler.Get(r.Context(), &account.GetCmd{ID: accountID(r)})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	for i := range a.Links {
+		if a.Links[i].ID == id {
+			writeJSON(w, http.StatusOK, newLinkResponse(a.Handle, &a.Links[i]))
+			return
+		}
+	}
+
+	writeError(w, account.ErrLinkNotFound)
+}
+
+func (s *Handler) createLink(w http.ResponseWriter, r *http.Request) {
+	var req LinkRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	l, err := s.accountHandler.CreateLink(r.Context(), &account.CreateLinkCmd{
+		AccountID: accountID(r),
+		Title:     req.Title,
+		Link:      req.URL,
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	s.fetchFavicon(l)
+
+	h, err := s.currentHandle(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Location", r.URL.Path+"/"+l.ID.String())
+	writeJSON(w, http.StatusCreated, newLinkResponse(h, l))
+}
+
+func (s *Handler) updateLink(w http.ResponseWriter, r *http.Request) {
+	id, err := linkID(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	var req LinkRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	l, err := s.accountHandler.UpdateLink(r.Context(), &account.UpdateLinkCmd{
+		AccountID: accountID(r),
+		ID:        id,
+		Title:     req.Title,
+		Link:      req.URL,
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	s.fetchFavicon(l)
+
+	h, err := s.currentHandle(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, newLinkResponse(h, l))
+}
+
+func (s *Handler) deleteLink(w http.ResponseWriter, r *http.Request) {
+	id, err := linkID(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	err = s.accountHandler.DeleteLink(r.Context(), &account.DeleteLinkCmd{
+		AccountID: accountID(r),
+		ID:        id,
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Handler) reorderLinks(w http.ResponseWriter, r *http.Request) {
+	var req ReorderLinksRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	a, err := s.accountHandler.ReorderLinks(r.Context(), &account.ReorderLinksCmd{
+		AccountID: accountID(r),
+		IDs:       req.IDs,
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, &LinksResponse{Links: newLinkResponses(a.Handle, a.Links)})
+}
+
+func (s *Handler) fetchFavicon(l *account.Link) {
+	if len(l.Favicon) == 0 {
+		s.faviconQueue.Enqueue(&favicon.Job{LinkID: l.ID, AccountID: l.AccountID, URL: l.Link})
+	}
+}
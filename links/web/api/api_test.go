@@ -0,0 +1,557 @@
+package api_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/derinil/links/links/account"
+	"github.com/derinil/links/links/account/auth"
+	"github.com/derinil/links/links/account/auth/handlers"
+	"github.com/derinil/links/links/account/session"
+	"github.com/derinil/links/links/account/token"
+	"github.com/derinil/links/links/cache"
+	"github.com/derinil/links/links/favicon"
+	"github.com/derinil/links/links/ratelimit"
+	"github.com/derinil/links/links/web"
+	"github.com/derinil/links/links/web/api"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+type (
+	// FakeStore keeps accounts in memory and implements both account.Reader and account.Writer
+	FakeStore struct {
+		sync.Mutex
+		accounts map[uuid.UUID]account.Account
+	}
+
+	FakeSessions struct {
+		sessions map[string]*session.Session
+	}
+
+	FakeQueue struct {
+		jobs []favicon.Job
+	}
+
+	// FakeTokens implements both token.Reader and token.Writer
+	FakeTokens struct {
+		tokens []*token.Token
+	}
+)
+
+func (s *FakeStore) Get(ctx context.Context, cmd *account.GetCmd) (*account.Account, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	for _, a := range s.accounts {
+		if a.ID == cmd.ID || (cmd.Handle != "" && a.Handle == cmd.Handle) {
+			a.Links = append([]account.Link(nil), a.Links...)
+			return &a, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func (s *FakeStore) GetLink(ctx context.Context, cmd *account.GetLinkCmd) (*account.Link, error) {
+	return nil, nil
+}
+
+func (s *FakeStore) SaveAccount(ctx context.Context, a *account.Account) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if err := a.BeforeSave(); err != nil {
+		return err
+	}
+
+	for i := range a.Links {
+		if err := a.Links[i].BeforeSave(); err != nil {
+			return err
+		}
+	}
+
+	a.RemovedLinks = nil
+	s.accounts[a.ID] = *a
+
+	return nil
+}
+
+func (s *FakeSessions) Get(ctx context.Context, token string) (*session.Session, error) {
+	if se, ok := s.sessions[token]; ok {
+		return se, nil
+	}
+
+	return nil, session.ErrSessionNotFound
+}
+
+func (s *FakeSessions) Destroy(ctx context.Context, token string) error {
+	return nil
+}
+
+func (s *FakeSessions) Issue(ctx context.Context, accountID uuid.UUID, handle string, remember bool) (*session.Session, string, error) {
+	return nil, "", nil
+}
+
+func (s *FakeSessions) Touch(ctx context.Context, token string, se *session.Session, client *session.Client) error {
+	return nil
+}
+
+func (s *FakeSessions) List(ctx context.Context, accountID uuid.UUID) ([]session.Session, error) {
+	return nil, nil
+}
+
+func (s *FakeSessions) Revoke(ctx context.Context, accountID, id uuid.UUID) error {
+	return nil
+}
+
+func (s *FakeSessions) RevokeAll(ctx context.Context, accountID, except uuid.UUID) error {
+	return nil
+}
+
+func (q *FakeQueue) Enqueue(job *favicon.Job) {
+	q.jobs = append(q.jobs, *job)
+}
+
+func (s *FakeTokens) GetTokenByHash(ctx context.Context, hash []byte) (*token.Token, error) {
+	for _, t := range s.tokens {
+		if bytes.Equal(t.Hash, hash) {
+			c := *t
+			return &c, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func (s *FakeTokens) ListTokensByAccountID(ctx context.Context, accountID uuid.UUID) ([]token.Token, error) {
+	return nil, nil
+}
+
+func (s *FakeTokens) SaveToken(ctx context.Context, t *token.Token) error {
+	s.tokens = append(s.tokens, t)
+	return nil
+}
+
+func (s *FakeTokens) DeleteToken(ctx context.Context, accountID, id uuid.UUID) (bool, error) {
+	return false, nil
+}
+
+func (s *FakeTokens) TouchToken(ctx context.Context, id uuid.UUID, at time.Time) error {
+	return nil
+}
+
+func TestAPI(t *testing.T) {
+	var (
+		a      = account.New("name", "handle", "password")
+		first  = *account.NewLink(a.ID, "First", "https://first.com", 0)
+		second = *account.NewLink(a.ID, "Second", "https://second.com", 1)
+	)
+
+	first.Favicon = []byte("icon")
+	a.Links = []account.Link{first, second}
+
+	testCases := []struct {
+		name        string
+		method      string
+		path        string
+		body        string
+		contentType string
+		noSession   bool
+		// One of the tokens created for each case, or anything else to send as is
+		bearer string
+		status int
+		errKey string
+		check  func(t *testing.T, body []byte, store *FakeStore, queue *FakeQueue, location string)
+	}{
+		{
+			name:   "get account",
+			method: http.MethodGet,
+			path:   "/account",
+			status: http.StatusOK,
+			check: func(t *testing.T, body []byte, _ *FakeStore, _ *FakeQueue, _ string) {
+				var res api.AccountResponse
+				require.Nil(t, json.Unmarshal(body, &res))
+				require.Equal(t, a.ID, res.ID)
+				require.Equal(t, "handle", res.Handle)
+				require.Len(t, res.Links, 2)
+				require.Equal(t, "https://second.com", res.Links[1].URL)
+			},
+		},
+		{
+			name:      "not logged in",
+			method:    http.MethodGet,
+			path:      "/account",
+			noSession: true,
+			status:    http.StatusUnauthorized,
+			errKey:    "not_authorized",
+		},
+		{
+			name:   "update account",
+			method: http.MethodPatch,
+			path:   "/account",
+			body:   `{"name": "new name", "css": "body { color: red; }"}`,
+			status: http.StatusOK,
+			check: func(t *testing.T, body []byte, store *FakeStore, _ *FakeQueue, _ string) {
+				require.Equal(t, "new name", store.accounts[a.ID].Name)
+				require.Equal(t, "handle", store.accounts[a.ID].Handle)
+			},
+		},
+		{
+			name:   "invalid handle",
+			method: http.MethodPatch,
+			path:   "/account",
+			body:   `{"handle": "NO"}`,
+			status: http.StatusBadRequest,
+			errKey: "invalid_data",
+		},
+		{
+			name:   "unknown field",
+			method: http.MethodPatch,
+			path:   "/account",
+			body:   `{"nmae": "typo"}`,
+			status: http.StatusBadRequest,
+			errKey: "invalid_json",
+		},
+		{
+			name:        "form body",
+			method:      http.MethodPatch,
+			path:        "/account",
+			body:        `name=new`,
+			contentType: "application/x-www-form-urlencoded",
+			status:      http.StatusUnsupportedMediaType,
+			errKey:      "unsupported_media_type",
+		},
+		{
+			name:   "list links",
+			method: http.MethodGet,
+			path:   "/links",
+			status: http.StatusOK,
+			check: func(t *testing.T, body []byte, _ *FakeStore, _ *FakeQueue, _ string) {
+				var res api.LinksResponse
+				require.Nil(t, json.Unmarshal(body, &res))
+				require.Len(t, res.Links, 2)
+				require.Equal(t, first.ID, res.Links[0].ID)
+			},
+		},
+		{
+			name:   "get link",
+			method: http.MethodGet,
+			path:   "/links/" + second.ID.String(),
+			status: http.StatusOK,
+			check: func(t *testing.T, body []byte, _ *FakeStore, _ *FakeQueue, _ string) {
+				var res api.LinkResponse
+				require.Nil(t, json.Unmarshal(body, &res))
+				require.Equal(t, second.ID, res.ID)
+				require.Equal(t, 1, res.Index)
+			},
+		},
+		{
+			name:   "get unknown link",
+			method: http.MethodGet,
+			path:   "/links/" + uuid.NewString(),
+			status: http.StatusNotFound,
+			errKey: "link_not_found",
+		},
+		{
+			name:   "get garbage link id",
+			method: http.MethodGet,
+			path:   "/links/garbage",
+			status: http.StatusNotFound,
+			errKey: "link_not_found",
+		},
+		{
+			name:   "create link",
+			method: http.MethodPost,
+			path:   "/links",
+			body:   `{"title": "Third", "url": "https://third.com"}`,
+			status: http.StatusCreated,
+			check: func(t *testing.T, body []byte, store *FakeStore, queue *FakeQueue, location string) {
+				var res api.LinkResponse
+				require.Nil(t, json.Unmarshal(body, &res))
+				require.Equal(t, 2, res.Index)
+				require.Len(t, store.accounts[a.ID].Links, 3)
+				require.Equal(t, []favicon.Job{{LinkID: res.ID, AccountID: a.ID, URL: "https://third.com"}}, queue.jobs)
+				require.Equal(t, "/api/v1/links/"+res.ID.String(), location)
+			},
+		},
+		{
+			name:   "create duplicate link",
+			method: http.MethodPost,
+			path:   "/links",
+			body:   `{"title": "Again", "url": "https://first.com"}`,
+			status: http.StatusBadRequest,
+			errKey: "duplicate_link",
+		},
+		{
+			name:   "update link",
+			method: http.MethodPatch,
+			path:   "/links/" + first.ID.String(),
+			body:   `{"title": "Renamed"}`,
+			status: http.StatusOK,
+			check: func(t *testing.T, body []byte, store *FakeStore, _ *FakeQueue, _ string) {
+				require.Equal(t, "Renamed", store.accounts[a.ID].Links[0].Title)
+				require.Equal(t, "https://first.com", store.accounts[a.ID].Links[0].Link)
+
+				// Not the handle the session was issued for
+				var res api.LinkResponse
+				require.Nil(t, json.Unmarshal(body, &res))
+				require.True(t, strings.HasPrefix(res.FaviconURL, "/handle/l/"+first.ID.String()), res.FaviconURL)
+			},
+		},
+		{
+			name:   "delete link",
+			method: http.MethodDelete,
+			path:   "/links/" + first.ID.String(),
+			status: http.StatusNoContent,
+			check: func(t *testing.T, body []byte, store *FakeStore, _ *FakeQueue, _ string) {
+				require.Len(t, store.accounts[a.ID].Links, 1)
+				require.Equal(t, second.ID, store.accounts[a.ID].Links[0].ID)
+				require.Equal(t, 0, store.accounts[a.ID].Links[0].Index)
+			},
+		},
+		{
+			name:   "reorder links",
+			method: http.MethodPut,
+			path:   "/links/order",
+			body:   `{"ids": ["` + second.ID.String() + `", "` + first.ID.String() + `"]}`,
+			status: http.StatusOK,
+			check: func(t *testing.T, body []byte, store *FakeStore, _ *FakeQueue, _ string) {
+				require.Equal(t, second.ID, store.accounts[a.ID].Links[0].ID)
+				require.Equal(t, first.ID, store.accounts[a.ID].Links[1].ID)
+			},
+		},
+		{
+			name:   "reorder with missing links",
+			method: http.MethodPut,
+			path:   "/links/order",
+			body:   `{"ids": ["` + second.ID.String() + `"]}`,
+			status: http.StatusBadRequest,
+			errKey: "link_order_invalid",
+		},
+		{
+			name:      "token lists links",
+			method:    http.MethodGet,
+			path:      "/links",
+			noSession: true,
+			bearer:    "links:read",
+			status:    http.StatusOK,
+		},
+		{
+			name:      "write scope includes reading",
+			method:    http.MethodGet,
+			path:      "/links/" + first.ID.String(),
+			noSession: true,
+			bearer:    "links:write",
+			status:    http.StatusOK,
+		},
+		{
+			name:      "token creates link",
+			method:    http.MethodPost,
+			path:      "/links",
+			body:      `{"title": "Third", "url": "https://third.com"}`,
+			noSession: true,
+			bearer:    "links:write",
+			status:    http.StatusCreated,
+		},
+		{
+			name:      "token without scope",
+			method:    http.MethodPost,
+			path:      "/links",
+			body:      `{"title": "Third", "url": "https://third.com"}`,
+			noSession: true,
+			bearer:    "links:read",
+			status:    http.StatusForbidden,
+			errKey:    "access_token_scope_missing",
+		},
+		{
+			name:      "token without account scope",
+			method:    http.MethodGet,
+			path:      "/account",
+			noSession: true,
+			bearer:    "links:write",
+			status:    http.StatusForbidden,
+			errKey:    "access_token_scope_missing",
+		},
+		{
+			name:      "expired token",
+			method:    http.MethodGet,
+			path:      "/links",
+			noSession: true,
+			bearer:    "expired",
+			status:    http.StatusUnauthorized,
+			errKey:    "access_token_expired",
+		},
+		{
+			name:   "unknown token wins over session",
+			method: http.MethodGet,
+			path:   "/links",
+			bearer: token.Prefix + "nope",
+			status: http.StatusUnauthorized,
+			errKey: "access_token_invalid",
+		},
+		{
+			name:      "garbage token",
+			method:    http.MethodGet,
+			path:      "/links",
+			noSession: true,
+			bearer:    "garbage",
+			status:    http.StatusUnauthorized,
+			errKey:    "access_token_invalid",
+		},
+		{
+			name:   "unknown route",
+			method: http.MethodGet,
+			path:   "/nope",
+			status: http.StatusNotFound,
+			errKey: "not_found",
+		},
+		{
+			name:   "wrong method",
+			method: http.MethodDelete,
+			path:   "/account",
+			status: http.StatusMethodNotAllowed,
+			errKey: "method_not_allowed",
+		},
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			var (
+				store = &FakeStore{accounts: map[uuid.UUID]account.Account{a.ID: *a}}
+				queue = new(FakeQueue)
+				// Issued before the handle changed
+				se       = session.New(a.ID, "oldhandle")
+				sessions = &FakeSessions{sessions: map[string]*session.Session{
+					"token": se,
+				}}
+				tokens         = new(FakeTokens)
+				accountHandler = account.NewHandler(store, store, time.Hour)
+				tokenHandler   = token.NewHandler(tokens, tokens)
+				authHandler    = auth.NewHandler(handlers.AccessTokenHandler(accountHandler, tokenHandler))
+				apiHandler     = api.NewHandler(authHandler, accountHandler, sessions, queue, ratelimit.NewLimiter(cache.NewMemory(cache.MemoryConfig{})))
+				w              = httptest.NewRecorder()
+				r              = httptest.NewRequest(c.method, "/api/v1"+c.path, strings.NewReader(c.body))
+				plaintexts     = make(map[string]string)
+			)
+
+			for _, sc := range []token.Scope{token.LinksRead, token.LinksWrite} {
+				_, p, err := tokenHandler.Create(context.Background(), &token.CreateCmd{
+					AccountID: a.ID,
+					Name:      string(sc),
+					Scopes:    []token.Scope{sc},
+					ExpiresIn: time.Hour,
+				})
+				require.Nil(t, err)
+				plaintexts[string(sc)] = p
+			}
+
+			_, p, err := tokenHandler.Create(context.Background(), &token.CreateCmd{
+				AccountID: a.ID,
+				Name:      "expired",
+				Scopes:    token.Scopes,
+				ExpiresIn: time.Hour,
+			})
+			require.Nil(t, err)
+			tokens.tokens[len(tokens.tokens)-1].ExpiresAt = time.Now().Add(-time.Minute)
+			plaintexts["expired"] = p
+
+			if c.bearer != "" {
+				bearer, ok := plaintexts[c.bearer]
+				if !ok {
+					bearer = c.bearer
+				}
+				r.Header.Set("Authorization", "Bearer "+bearer)
+			}
+
+			if c.body != "" {
+				ct := c.contentType
+				if ct == "" {
+					ct = "application/json; charset=utf-8"
+				}
+				r.Header.Set("Content-Type", ct)
+			}
+
+			if !c.noSession {
+				r.AddCookie(session.Cookie("token", se))
+			}
+
+			router := chi.NewMux()
+			router.Mount("/api/v1", apiHandler.Router())
+			router.ServeHTTP(w, r)
+
+			require.Equal(t, c.status, w.Code, w.Body.String())
+
+			if c.errKey != "" {
+				var res api.ErrorResponse
+				require.Nil(t, json.Unmarshal(w.Body.Bytes(), &res))
+				require.Equal(t, c.errKey, res.Error.Key)
+				require.NotEmpty(t, res.Error.Message)
+				return
+			}
+
+			if c.check != nil {
+				c.check(t, w.Body.Bytes(), store, queue, w.Header().Get("Location"))
+			}
+		})
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	var (
+		a              = account.New("name", "handle", "password")
+		store          = &FakeStore{accounts: map[uuid.UUID]account.Account{a.ID: *a}}
+		se             = session.New(a.ID, a.Handle)
+		sessions       = &FakeSessions{sessions: map[string]*session.Session{"token": se}}
+		accountHandler = account.NewHandler(store, store, time.Hour)
+		limiter        = ratelimit.NewLimiter(cache.NewMemory(cache.MemoryConfig{}))
+		apiHandler     = api.NewHandler(nil, accountHandler, sessions, new(FakeQueue), limiter)
+		router         = chi.NewMux()
+	)
+
+	router.Mount("/api/v1", apiHandler.Router())
+
+	request := func(method, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, "/api/v1"+path, strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		r.AddCookie(session.Cookie("token", se))
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	// Account and link changes share the budget of the account page forms
+	for i := 0; i < web.AccountUpdateRule.Limit; i++ {
+		w := request(http.MethodPatch, "/account", `{"name": "new name"}`)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	}
+
+	w := request(http.MethodPost, "/links", `{"title": "Blog", "url": "https://blog.example.com"}`)
+	require.Equal(t, http.StatusTooManyRequests, w.Code, w.Body.String())
+	require.NotEmpty(t, w.Header().Get("Retry-After"))
+
+	// Reads aren't limited
+	w = request(http.MethodGet, "/account", "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+}
+
+func TestOpenAPI(t *testing.T) {
+	var (
+		apiHandler = api.NewHandler(nil, nil, nil, nil, nil)
+		w          = httptest.NewRecorder()
+		r          = httptest.NewRequest(http.MethodGet, "/openapi.yaml", nil)
+	)
+
+	apiHandler.Router().ServeHTTP(w, r)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, api.OpenAPI, w.Body.Bytes())
+}
@@ -0,0 +1,161 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/derinil/links/links/account"
+	"github.com/derinil/links/links/generic"
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+)
+
+type (
+	AccountResponse struct {
+		ID        uuid.UUID      `json:"id"`
+		Name      string         `json:"name"`
+		Handle    string         `json:"handle"`
+		CSS       string         `json:"css"`
+		AvatarURL string         `json:"avatar_url,omitempty"`
+		Links     []LinkResponse `json:"links"`
+		CreatedAt time.Time      `json:"created_at"`
+		UpdatedAt time.Time      `json:"updated_at"`
+	}
+
+	LinkResponse struct {
+		ID         uuid.UUID `json:"id"`
+		Title      string    `json:"title"`
+		URL        string    `json:"url"`
+		Index      int       `json:"index"`
+		FaviconURL string    `json:"favicon_url,omitempty"`
+		CreatedAt  time.Time `json:"created_at"`
+		UpdatedAt  time.Time `json:"updated_at"`
+	}
+
+	LinksResponse struct {
+		Links []LinkResponse `json:"links"`
+	}
+
+	ErrorResponse struct {
+		Error ErrorBody `json:"error"`
+	}
+
+	ErrorBody struct {
+		Key     string `json:"key"`
+		Message string `json:"message"`
+	}
+)
+
+// Request bodies are tiny, anything bigger than this is a mistake
+const maxBodySize = 64 << 10
+
+var (
+	ErrNotFound             = generic.NewWebError(http.StatusNotFound, "not_found", "Not found")
+	ErrMethodNotAllowed     = generic.NewWebError(http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+	ErrUnsupportedMediaType = generic.NewWebError(http.StatusUnsupportedMediaType, "unsupported_media_type", "Request body must be JSON")
+	ErrInvalidJSON          = generic.NewWebError(http.StatusBadRequest, "invalid_json", "Request body is not valid JSON")
+	ErrInvalidData          = generic.NewWebError(http.StatusBadRequest, "invalid_data", "Data is invalid")
+	ErrInternal             = generic.NewWebError(http.StatusInternalServerError, "internal_error", "Internal error, contact us!")
+)
+
+func newAccountResponse(a *account.Account) *AccountResponse {
+	res := &AccountResponse{
+		ID:        a.ID,
+		Name:      a.Name,
+		Handle:    a.Handle,
+		CSS:       a.CSS,
+		Links:     newLinkResponses(a.Handle, a.Links),
+		CreatedAt: a.InsertedAt,
+		UpdatedAt: a.UpdatedAt,
+	}
+
+	if len(a.Avi) > 0 {
+		res.AvatarURL = fmt.Sprintf("/%s/avatar?v=%d", a.Handle, a.UpdatedAt.Unix())
+	}
+
+	return res
+}
+
+func newLinkResponse(handle string, l *account.Link) *LinkResponse {
+	res := &LinkResponse{
+		ID:        l.ID,
+		Title:     l.Title,
+		URL:       l.Link,
+		Index:     l.Index,
+		CreatedAt: l.InsertedAt,
+		UpdatedAt: l.UpdatedAt,
+	}
+
+	if len(l.Favicon) > 0 {
+		res.FaviconURL = fmt.Sprintf("/%s/l/%s/favicon?v=%d", handle, l.ID, l.UpdatedAt.Unix())
+	}
+
+	return res
+}
+
+func newLinkResponses(handle string, ls []account.Link) []LinkResponse {
+	res := make([]LinkResponse, len(ls))
+	for i := range ls {
+		res[i] = *newLinkResponse(handle, &ls[i])
+	}
+
+	return res
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Println("failed to encode response", err)
+	}
+}
+
+// writeError sends web errors as they are and hides everything else
+// behind a generic internal error, like responder.Handler does
+func writeError(w http.ResponseWriter, err error) {
+	var we *generic.WebError
+
+	switch v := generic.Unwrap(err).(type) {
+	case *generic.WebError:
+		we = v
+	case validator.FieldError, validator.ValidationErrors, *validator.InvalidValidationError:
+		we = ErrInvalidData
+	default:
+		log.Println("unexpected error!", err)
+		we = ErrInternal
+	}
+
+	if we.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(we.RetryAfterSeconds()))
+	}
+
+	writeJSON(w, we.StatusCode, &ErrorResponse{
+		Error: ErrorBody{
+			Key:     we.ErrKey,
+			Message: we.ErrMsg,
+		},
+	})
+}
+
+// decodeJSON rejects unknown fields so typos don't get silently ignored
+func decodeJSON(w http.ResponseWriter, r *http.Request, v any) error {
+	d := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize))
+	d.DisallowUnknownFields()
+
+	if err := d.Decode(v); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidJSON, err)
+	}
+
+	return nil
+}
+
+func isJSON(r *http.Request) bool {
+	mt, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mt == "application/json"
+}
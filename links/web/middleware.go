@@ -0,0 +1,129 @@
+package web
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/derinil/links/links/account/session"
+	"github.com/derinil/links/links/generic"
+	"github.com/derinil/links/links/ratelimit"
+	"github.com/derinil/links/links/web/responder"
+)
+
+// Enough for a photo straight out of a phone camera
+const MaxUploadSize = 8 << 20
+
+var (
+	// Accounts created from one IP
+	RegisterRule = &ratelimit.Rule{
+		Name:       "register",
+		Limit:      5,
+		Window:     time.Hour,
+		Lockout:    10 * time.Minute,
+		MaxLockout: 24 * time.Hour,
+	}
+	// Two factor codes from one IP. Pending logins also run out of attempts on
+	// their own, and handlers.TwoFactorAccountRule counts the codes per account.
+	TwoFactorRule = &ratelimit.Rule{
+		Name:       "two-factor",
+		Limit:      10,
+		Window:     15 * time.Minute,
+		Lockout:    5 * time.Minute,
+		MaxLockout: 24 * time.Hour,
+	}
+	// Password reset requests from one IP, each account also gets one mail a minute at most
+	ForgotPasswordRule = &ratelimit.Rule{
+		Name:       "forgot-password",
+		Limit:      5,
+		Window:     time.Hour,
+		Lockout:    10 * time.Minute,
+		MaxLockout: 24 * time.Hour,
+	}
+	// Passkey and OIDC logins started from one IP. Each one leaves state in the
+	// cache until it expires, so this keeps anyone from filling the cache with them.
+	LoginStartRule = &ratelimit.Rule{
+		Name:       "login-start",
+		Limit:      30,
+		Window:     15 * time.Minute,
+		Lockout:    5 * time.Minute,
+		MaxLockout: 24 * time.Hour,
+	}
+	// QR codes drawn for one IP, drawing a large PNG takes a while
+	QRCodeRule = &ratelimit.Rule{
+		Name:       "qr-code",
+		Limit:      60,
+		Window:     10 * time.Minute,
+		Lockout:    time.Minute,
+		MaxLockout: time.Hour,
+	}
+	// Changes to an account that ask for a password or code, or could be used to spam
+	AccountUpdateRule = &ratelimit.Rule{
+		Name:       "account-update",
+		Limit:      30,
+		Window:     10 * time.Minute,
+		Lockout:    time.Minute,
+		MaxLockout: time.Hour,
+	}
+)
+
+// LimitUpload rejects requests with bodies larger than max before anything parses
+// the form and sends people back to path. It has to run before ValidateCSRF since
+// that reads the form.
+func LimitUpload(max int64, path string, responderHandler responder.Handler) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.ContentLength > max {
+				responderHandler.Respond(w, r, &responder.ResponseCmd{
+					Path:     path,
+					ErrorMsg: "Upload is too large",
+				})
+				return
+			}
+
+			r.Body = http.MaxBytesReader(w, r.Body, max)
+
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+// LimitRate counts requests under rule and sends the ones over it back to path
+func LimitRate(
+	limiter ratelimit.Limiter,
+	rule *ratelimit.Rule,
+	key ratelimit.KeyFunc,
+	path string,
+	responderHandler responder.Handler,
+) func(http.Handler) http.Handler {
+	return ratelimit.Middleware(limiter, rule, key, func(w http.ResponseWriter, r *http.Request, err error) {
+		responderHandler.Respond(w, r, &responder.ResponseCmd{
+			Path:  path,
+			Error: err,
+		})
+	})
+}
+
+// LimitRateStatus is LimitRate for routes that scripts fetch, which
+// get a 429 with Retry-After instead of a redirect
+func LimitRateStatus(limiter ratelimit.Limiter, rule *ratelimit.Rule, key ratelimit.KeyFunc) func(http.Handler) http.Handler {
+	return ratelimit.Middleware(limiter, rule, key, func(w http.ResponseWriter, r *http.Request, err error) {
+		var we *generic.WebError
+		if errors.As(err, &we) && we.RetryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(we.RetryAfterSeconds()))
+		}
+
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+	})
+}
+
+// byAccount counts requests per logged in account, it has to run after ParseSession
+func byAccount(r *http.Request) string {
+	se, ok := r.Context().Value(session.SessionObjectKey).(*session.Session)
+	if !ok {
+		return ""
+	}
+
+	return se.AccountID.String()
+}
@@ -4,6 +4,7 @@ import (
 	"log"
 	"net/http"
 	"net/url"
+	"strconv"
 
 	"github.com/derinil/links/links/generic"
 	"github.com/go-playground/validator/v10"
@@ -38,6 +39,9 @@ func (s *HandlerImpl) Respond(w http.ResponseWriter, r *http.Request, cmd *Respo
 		switch v := err.(type) {
 		case *generic.WebError:
 			errorMsg = v.ErrMsg
+			if v.RetryAfter > 0 {
+				w.Header().Set("Retry-After", strconv.Itoa(v.RetryAfterSeconds()))
+			}
 		case validator.FieldError:
 			errorMsg = "Data is invalid"
 		case validator.ValidationErrors:
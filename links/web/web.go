@@ -1,16 +1,43 @@
 package web
 
 import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/derinil/links/links/account"
 	"github.com/derinil/links/links/account/auth"
 	"github.com/derinil/links/links/account/auth/handlers"
+	"github.com/derinil/links/links/account/oidc"
+	"github.com/derinil/links/links/account/passkey"
+	"github.com/derinil/links/links/account/recovery"
 	"github.com/derinil/links/links/account/session"
+	"github.com/derinil/links/links/account/token"
+	"github.com/derinil/links/links/account/totp"
+	"github.com/derinil/links/links/analytics"
+	"github.com/derinil/links/links/archive"
 	"github.com/derinil/links/links/crypto/csrf"
+	"github.com/derinil/links/links/css"
+	"github.com/derinil/links/links/favicon"
+	"github.com/derinil/links/links/importer"
+	"github.com/derinil/links/links/profile"
+	"github.com/derinil/links/links/qr"
+	"github.com/derinil/links/links/ratelimit"
+	"github.com/derinil/links/links/share"
+	"github.com/derinil/links/links/theme"
+	"github.com/derinil/links/links/tracking"
 	"github.com/derinil/links/links/views"
 	"github.com/derinil/links/links/web/responder"
 	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
 )
 
 type Handler struct {
@@ -20,6 +47,25 @@ type Handler struct {
 	accountHandler   account.Handler
 	sessionHandler   session.Handler
 	responderHandler responder.Handler
+	clickRecorder    tracking.Recorder[tracking.Click]
+	viewRecorder     tracking.Recorder[tracking.ProfileView]
+	analyticsHandler analytics.Handler
+	faviconQueue     favicon.Queue
+	tokenHandler     token.Handler
+	totpHandler      totp.Handler
+	passkeyHandler   passkey.Handler
+	oidcHandler      oidc.Handler
+	recoveryHandler  recovery.Handler
+	limiter          ratelimit.Limiter
+	profileHandler   profile.Handler
+	themes           *theme.Registry
+	shareHandler     share.Handler
+	archiveHandler   archive.Handler
+	importerHandler  importer.Handler
+	// Public pages link to themselves with this, like https://links.example.com
+	baseURL string
+	// How long a deleted account can still be saved by logging in
+	deletionGracePeriod time.Duration
 }
 
 func NewHandler(
@@ -29,14 +75,48 @@ func NewHandler(
 	accountHandler account.Handler,
 	sessionHandler session.Handler,
 	responderHandler responder.Handler,
+	clickRecorder tracking.Recorder[tracking.Click],
+	viewRecorder tracking.Recorder[tracking.ProfileView],
+	analyticsHandler analytics.Handler,
+	faviconQueue favicon.Queue,
+	tokenHandler token.Handler,
+	totpHandler totp.Handler,
+	passkeyHandler passkey.Handler,
+	oidcHandler oidc.Handler,
+	recoveryHandler recovery.Handler,
+	limiter ratelimit.Limiter,
+	profileHandler profile.Handler,
+	themes *theme.Registry,
+	shareHandler share.Handler,
+	archiveHandler archive.Handler,
+	importerHandler importer.Handler,
+	baseURL string,
+	deletionGracePeriod time.Duration,
 ) *Handler {
 	return &Handler{
-		authHandler:      authHandler,
-		csrfHandler:      csrfHandler,
-		viewsHandler:     viewsHandler,
-		accountHandler:   accountHandler,
-		sessionHandler:   sessionHandler,
-		responderHandler: responderHandler,
+		authHandler:         authHandler,
+		csrfHandler:         csrfHandler,
+		viewsHandler:        viewsHandler,
+		accountHandler:      accountHandler,
+		sessionHandler:      sessionHandler,
+		responderHandler:    responderHandler,
+		clickRecorder:       clickRecorder,
+		viewRecorder:        viewRecorder,
+		analyticsHandler:    analyticsHandler,
+		faviconQueue:        faviconQueue,
+		tokenHandler:        tokenHandler,
+		totpHandler:         totpHandler,
+		passkeyHandler:      passkeyHandler,
+		oidcHandler:         oidcHandler,
+		recoveryHandler:     recoveryHandler,
+		limiter:             limiter,
+		profileHandler:      profileHandler,
+		themes:              themes,
+		shareHandler:        shareHandler,
+		archiveHandler:      archiveHandler,
+		importerHandler:     importerHandler,
+		baseURL:             baseURL,
+		deletionGracePeriod: deletionGracePeriod,
 	}
 }
 
@@ -44,11 +124,19 @@ func (s *Handler) Router() *chi.Mux {
 	var (
 		r = chi.NewMux()
 
-		parseSession   = session.ParseSession(s.sessionHandler)
-		forceSession   = session.ForceSession(s.responderHandler)
-		forceNoSession = session.ForceNoSession(s.responderHandler)
-		injectCSRF     = csrf.InjectCSRF(s.csrfHandler)
-		validateCSRF   = csrf.ValidateCSRF(s.csrfHandler, s.responderHandler)
+		parseSession      = session.ParseSession(s.sessionHandler)
+		forceSession      = session.ForceSession(s.responderHandler)
+		forceNoSession    = session.ForceNoSession(s.responderHandler)
+		injectCSRF        = csrf.InjectCSRF(s.csrfHandler)
+		validateCSRF      = csrf.ValidateCSRF(s.csrfHandler, s.responderHandler)
+		limitUpload       = LimitUpload(MaxUploadSize, "/account", s.responderHandler)
+		limitAccount      = LimitRate(s.limiter, AccountUpdateRule, byAccount, "/account", s.responderHandler)
+		limitRegister     = LimitRate(s.limiter, RegisterRule, ratelimit.ByIP, "/register", s.responderHandler)
+		limitTwoFactor    = LimitRate(s.limiter, TwoFactorRule, ratelimit.ByIP, "/login/2fa", s.responderHandler)
+		limitForgot       = LimitRate(s.limiter, ForgotPasswordRule, ratelimit.ByIP, "/login/forgot", s.responderHandler)
+		limitOIDCLogin    = LimitRate(s.limiter, LoginStartRule, ratelimit.ByIP, "/login", s.responderHandler)
+		limitPasskeyLogin = LimitRateStatus(s.limiter, LoginStartRule, ratelimit.ByIP)
+		limitQRCode       = LimitRateStatus(s.limiter, QRCodeRule, ratelimit.ByIP)
 	)
 
 	r.Use(parseSession)
@@ -67,7 +155,39 @@ func (s *Handler) Router() *chi.Mux {
 			// Account page
 			r.Get("/", s.renderAccountPage)
 			// Update account
-			r.With(validateCSRF).Post("/", s.handleUpdateAccount)
+			r.With(limitAccount, validateCSRF).Post("/", s.handleUpdateAccount)
+			// Upload or remove avatar
+			r.With(limitUpload, validateCSRF).Post("/avatar", s.handleUpdateAvatar)
+			r.With(validateCSRF).Post("/avatar/delete", s.handleRemoveAvatar)
+			// Create or revoke personal access tokens
+			r.With(limitAccount, validateCSRF).Post("/tokens", s.handleCreateToken)
+			r.With(validateCSRF).Post("/tokens/{tokenID}/revoke", s.handleRevokeToken)
+			// Set up, turn on or turn off two factor authentication
+			r.With(validateCSRF).Post("/2fa/enroll", s.handleEnrollTOTP)
+			r.With(limitAccount, validateCSRF).Post("/2fa/confirm", s.handleConfirmTOTP)
+			r.With(limitAccount, validateCSRF).Post("/2fa/disable", s.handleDisableTOTP)
+			// Add, rename or remove passkeys
+			r.Get("/passkeys/options", s.renderPasskeyCreationOptions)
+			r.With(validateCSRF).Post("/passkeys", s.handleAddPasskey)
+			r.With(validateCSRF).Post("/passkeys/{passkeyID}/rename", s.handleRenamePasskey)
+			r.With(validateCSRF).Post("/passkeys/{passkeyID}/delete", s.handleDeletePasskey)
+			// Connect or disconnect logins at OpenID Connect providers
+			r.With(validateCSRF).Post("/oidc/{provider}/connect", s.handleConnectOIDC)
+			r.With(validateCSRF).Post("/identities/{identityID}/disconnect", s.handleDisconnectOIDC)
+			// Change the email or send the verification link again
+			r.With(limitAccount, validateCSRF).Post("/email", s.handleUpdateEmail)
+			r.With(validateCSRF).Post("/email/verify", s.handleSendVerification)
+			// Log out other browsers
+			r.With(validateCSRF).Post("/sessions/{sessionID}/revoke", s.handleRevokeSession)
+			r.With(validateCSRF).Post("/sessions/revoke-others", s.handleRevokeOtherSessions)
+
+			r.With(limitAccount, validateCSRF).Post("/delete", s.handleDeleteAccount)
+
+			r.Get("/export", s.handleExport)
+			r.With(limitUpload, limitAccount, validateCSRF).Post("/import", s.handleImport)
+			// Read links from a file, then add the ones that were picked
+			r.With(limitUpload, validateCSRF).Post("/links/import", s.handleReadImport)
+			r.With(limitAccount, validateCSRF).Post("/links/import/add", s.handleAddImport)
 		})
 
 		// Log out
@@ -78,15 +198,33 @@ func (s *Handler) Router() *chi.Mux {
 	r.With(forceNoSession).Group(func(r chi.Router) {
 		// GET forms
 		r.Get("/register", s.genericRenderPage(views.Register))
-		r.Get("/login", s.genericRenderPage(views.Login))
+		r.Get("/login", s.renderLoginPage)
+		r.Get("/login/2fa", s.genericRenderPage(views.TwoFactor))
+		r.With(limitPasskeyLogin).Get("/login/passkey/options", s.renderPasskeyRequestOptions)
+		r.Get("/login/oidc/signup", s.renderOIDCSignupPage)
+		r.With(limitOIDCLogin).Get("/login/oidc/{provider}", s.handleBeginOIDC)
+		r.Get("/login/forgot", s.genericRenderPage(views.ForgotPassword))
+		r.Get("/login/reset", s.renderResetPasswordPage)
 
 		// POST forms
 		r.With(validateCSRF).Group(func(r chi.Router) {
-			r.Post("/register", s.handleRegistration)
+			r.With(limitRegister).Post("/register", s.handleRegistration)
+			// Login failures are counted by the login handler per IP and handle
 			r.Post("/login", s.handleLogin)
+			r.With(limitTwoFactor).Post("/login/2fa", s.handleTwoFactor)
+			r.Post("/login/passkey", s.handlePasskeyLogin)
+			r.Post("/login/oidc/signup", s.handleOIDCSignup)
+			r.With(limitForgot).Post("/login/forgot", s.handleForgotPassword)
+			r.Post("/login/reset", s.handleResetPassword)
 		})
 	})
 
+	// Providers send both logins and connects from the account page back here
+	r.Get("/login/oidc/callback", s.handleOIDCCallback)
+
+	// Links in verification mails, they might be opened without a session
+	r.Get("/email/verify", s.handleVerifyEmail)
+
 	// Index page
 	r.Get("/", s.genericRenderPage(views.Index))
 
@@ -97,6 +235,21 @@ func (s *Handler) Router() *chi.Mux {
 	// Links page for a user
 	r.Get("/{handle}", s.renderLinksPage)
 
+	// Avatar of a user
+	r.Get("/{handle}/avatar", s.renderAvatar)
+
+	// Preview image for when the page is shared
+	r.Get("/{handle}/share.png", s.renderShareImage)
+
+	// QR code of the links page, for printing
+	r.With(limitQRCode).Get("/{handle}/qr.{format:png|svg}", s.renderQRCode)
+
+	// Tracked redirect to one of the user's links
+	r.Get("/{handle}/l/{linkID}", s.handleLinkClick)
+
+	// Favicon of one of the user's links
+	r.Get("/{handle}/l/{linkID}/favicon", s.renderFavicon)
+
 	return r
 }
 
@@ -132,7 +285,7 @@ func (s *Handler) handleRegistration(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	http.SetCookie(w, session.Cookie(a.SessionToken))
+	http.SetCookie(w, session.Cookie(a.SessionToken, a.Session))
 
 	s.responderHandler.Respond(w, r, &responder.ResponseCmd{
 		Path:    "/account",
@@ -147,6 +300,7 @@ func (s *Handler) handleLogin(w http.ResponseWriter, r *http.Request) {
 		cmd = &handlers.LoginCmd{
 			Handle:   f.Get("handle"),
 			Password: f.Get("password"),
+			Remember: f.Get("remember") == "on",
 		}
 	)
 
@@ -162,11 +316,67 @@ func (s *Handler) handleLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	http.SetCookie(w, session.Cookie(a.SessionToken))
+	// The password was right but the account wants a code as well
+	if a.PendingToken != "" {
+		http.SetCookie(w, totp.Cookie(a.PendingToken))
+		http.Redirect(w, r, "/login/2fa", http.StatusFound)
+		return
+	}
+
+	http.SetCookie(w, session.Cookie(a.SessionToken, a.Session))
+
+	s.responderHandler.Respond(w, r, &responder.ResponseCmd{
+		Path:    "/account",
+		Message: loginMessage(a),
+	})
+}
+
+// loginMessage lets users know that logging in kept their account
+func loginMessage(a *auth.Auth) string {
+	if a.DeletionCancelled {
+		return "Welcome back! Your account is no longer scheduled for deletion."
+	}
+
+	return "Successfully logged in!"
+}
+
+func (s *Handler) handleTwoFactor(w http.ResponseWriter, r *http.Request) {
+	var (
+		ctx = r.Context()
+		cmd = &handlers.TwoFactorCmd{
+			Code: r.Form.Get("code"),
+		}
+	)
+
+	if c, err := r.Cookie(totp.CookieName); err == nil {
+		cmd.PendingToken = c.Value
+	}
+
+	a, err := s.authHandler.Handle(ctx, &auth.AuthCmd{
+		Method: auth.TwoFactor,
+		Cmd:    cmd,
+	})
+	if err != nil {
+		path := "/login/2fa"
+		// Out of attempts or out of time, the password has to be entered again
+		if errors.Is(err, totp.ErrPendingLoginGone) {
+			http.SetCookie(w, totp.RemoveCookie())
+			path = "/login"
+		}
+
+		s.responderHandler.Respond(w, r, &responder.ResponseCmd{
+			Path:  path,
+			Error: err,
+		})
+		return
+	}
+
+	http.SetCookie(w, totp.RemoveCookie())
+	http.SetCookie(w, session.Cookie(a.SessionToken, a.Session))
 
 	s.responderHandler.Respond(w, r, &responder.ResponseCmd{
 		Path:    "/account",
-		Message: "Successfully logged in!",
+		Message: loginMessage(a),
 	})
 }
 
@@ -208,6 +418,12 @@ func (s *Handler) handleLogout(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Handler) renderAccountPage(w http.ResponseWriter, r *http.Request) {
+	s.accountPage(w, r, &views.AccountPageCmd{})
+}
+
+// accountPage fills in the rest of cmd and renders the account page. Secrets that
+// are only shown once are passed in through cmd instead of going through a redirect.
+func (s *Handler) accountPage(w http.ResponseWriter, r *http.Request, cmd *views.AccountPageCmd) {
 	ctx := r.Context()
 
 	so, ok := ctx.Value(session.SessionObjectKey).(*session.Session)
@@ -228,17 +444,100 @@ func (s *Handler) renderAccountPage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if len(a.Links) == 0 {
-		a.Links = append(a.Links, account.Link{
-			Title: "My Github Link!",
-			Link:  "http://github.com",
+	window, err := analytics.ParseWindow(r.URL.Query().Get("window"))
+	if err != nil {
+		s.responderHandler.Respond(w, r, &responder.ResponseCmd{
+			Path:  "/account",
+			Error: err,
+		})
+		return
+	}
+
+	sm, err := s.analyticsHandler.Summarize(ctx, &analytics.SummarizeCmd{
+		AccountID: a.ID,
+		Window:    window,
+	})
+	if err != nil {
+		s.responderHandler.Respond(w, r, &responder.ResponseCmd{
+			Path:  "/",
+			Error: err,
+		})
+		return
+	}
+
+	ts, err := s.tokenHandler.List(ctx, &token.ListCmd{AccountID: a.ID})
+	if err != nil {
+		s.responderHandler.Respond(w, r, &responder.ResponseCmd{
+			Path:  "/",
+			Error: err,
+		})
+		return
+	}
+
+	tp, err := s.totpHandler.Get(ctx, &totp.GetCmd{AccountID: a.ID})
+	if err != nil {
+		s.responderHandler.Respond(w, r, &responder.ResponseCmd{
+			Path:  "/",
+			Error: err,
+		})
+		return
+	}
+
+	if tp != nil && tp.Confirmed() {
+		cmd.RecoveryCodesLeft, err = s.totpHandler.RecoveryCodesLeft(ctx, &totp.GetCmd{AccountID: a.ID})
+		if err != nil {
+			s.responderHandler.Respond(w, r, &responder.ResponseCmd{
+				Path:  "/",
+				Error: err,
+			})
+			return
+		}
+	}
+
+	ps, err := s.passkeyHandler.List(ctx, &passkey.ListCmd{AccountID: a.ID})
+	if err != nil {
+		s.responderHandler.Respond(w, r, &responder.ResponseCmd{
+			Path:  "/",
+			Error: err,
+		})
+		return
+	}
+
+	ids, err := s.oidcHandler.List(ctx, &oidc.ListCmd{AccountID: a.ID})
+	if err != nil {
+		s.responderHandler.Respond(w, r, &responder.ResponseCmd{
+			Path:  "/",
+			Error: err,
+		})
+		return
+	}
+
+	ses, err := s.sessionHandler.List(ctx, a.ID)
+	if err != nil {
+		s.responderHandler.Respond(w, r, &responder.ResponseCmd{
+			Path:  "/",
+			Error: err,
 		})
+		return
 	}
 
+	cmd.Account = a
+	cmd.Analytics = sm
+	cmd.Tokens = ts
+	cmd.TOTP = tp
+	cmd.Passkeys = ps
+	cmd.Providers = s.oidcHandler.Providers()
+	cmd.Identities = ids
+	cmd.Sessions = ses
+	cmd.CurrentSession = so.ID
+	cmd.Themes = s.themes.List()
+	cmd.Theme = s.themes.Get(a.Theme).ID
+	cmd.DeletionGraceDays = int(s.deletionGracePeriod.Hours() / 24)
+
 	s.viewsHandler.Render(r.Context(), w, views.Account, &views.RenderCmd{
 		Error:   r.URL.Query().Get("error"),
 		Message: r.URL.Query().Get("message"),
-		Cmd:     views.AccountPageCmd{Account: a},
+		Cmd:     cmd,
 	})
 }
 
@@ -253,12 +552,42 @@ func (s *Handler) handleUpdateAccount(w http.ResponseWriter, r *http.Request) {
 		}
 	)
 
-	if len(f["links_title[]"]) > 0 && len(f["links_title[]"]) == len(f["links_url[]"]) {
-		for i := range f["links_title[]"] {
-			cmd.Links = append(cmd.Links, account.LinkScaffold{
-				Title: f["links_title[]"][i],
-				Link:  f["links_url[]"][i],
+	// Unknown themes would silently fall back to the default one
+	if id := f.Get("theme"); id != "" {
+		if !s.themes.Has(id) {
+			s.responderHandler.Respond(w, r, &responder.ResponseCmd{
+				Path:  "/account",
+				Error: theme.ErrThemeNotFound,
 			})
+			return
+		}
+
+		cmd.Theme = id
+	}
+
+	// The form always posts every link, so no links means they were all removed
+	if titles, urls, ids := f["links_title[]"], f["links_url[]"], f["links_id[]"]; len(titles) == len(urls) {
+		cmd.Links = make([]account.LinkScaffold, 0, len(titles))
+
+		for i := range titles {
+			// The empty row shown while there are no links
+			if titles[i] == "" && urls[i] == "" && (len(ids) != len(titles) || ids[i] == "") {
+				continue
+			}
+
+			ls := account.LinkScaffold{
+				Title: titles[i],
+				Link:  urls[i],
+			}
+
+			// New links don't have an ID yet
+			if len(ids) == len(titles) {
+				if id, err := uuid.Parse(ids[i]); err == nil {
+					ls.ID = id
+				}
+			}
+
+			cmd.Links = append(cmd.Links, ls)
 		}
 	}
 
@@ -273,7 +602,7 @@ func (s *Handler) handleUpdateAccount(w http.ResponseWriter, r *http.Request) {
 
 	cmd.AccountID = so.AccountID
 
-	_, err := s.accountHandler.Update(ctx, cmd)
+	a, err := s.accountHandler.Update(ctx, cmd)
 	if err != nil {
 		s.responderHandler.Respond(w, r, &responder.ResponseCmd{
 			Path:  "/account",
@@ -282,9 +611,18 @@ func (s *Handler) handleUpdateAccount(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	s.fetchFavicons(a)
+
+	// Update keeps what the policy allows, this says what was left out and where
+	var left string
+	if err := css.DefaultPolicy.Validate(cmd.CSS); err != nil {
+		left = "Some of your CSS was left out, " + err.Error()
+	}
+
 	s.responderHandler.Respond(w, r, &responder.ResponseCmd{
-		Path:    "/account",
-		Message: "Successfully updated account information!",
+		Path:     "/account",
+		Message:  "Successfully updated account information!",
+		ErrorMsg: left,
 	})
 }
 
@@ -294,7 +632,7 @@ func (s *Handler) renderLinksPage(w http.ResponseWriter, r *http.Request) {
 		handle = chi.URLParam(r, "handle")
 	)
 
-	a, err := s.accountHandler.Get(ctx, &account.GetCmd{Handle: handle})
+	a, err := s.profileHandler.Resolve(ctx, &account.ResolveCmd{Handle: handle})
 	if err != nil {
 		s.responderHandler.Respond(w, r, &responder.ResponseCmd{
 			Path:  "/",
@@ -303,7 +641,1376 @@ func (s *Handler) renderLinksPage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// The handle was changed recently, send people to the new one. Not
+	// permanently, browsers would keep redirecting after the grace period.
+	if a.Handle != handle {
+		http.Redirect(w, r, "/"+a.Handle, http.StatusFound)
+		return
+	}
+
+	// Don't count people looking at their own page
+	if so, ok := ctx.Value(session.SessionObjectKey).(*session.Session); !ok || so.AccountID != a.ID {
+		s.viewRecorder.Record(tracking.NewProfileView(a.ID, externalReferrer(r), r.UserAgent()))
+	}
+
 	s.viewsHandler.Render(r.Context(), w, views.Links, &views.RenderCmd{
-		Cmd: &views.LinksPageCmd{Account: a},
+		Cmd: &views.LinksPageCmd{
+			Account:  a,
+			Theme:    s.themes.Get(a.Theme),
+			URL:      s.baseURL + "/" + a.Handle,
+			ImageURL: fmt.Sprintf("%s/%s/share.png?v=%d", s.baseURL, a.Handle, a.UpdatedAt.Unix()),
+		},
+	})
+}
+
+func (s *Handler) handleLinkClick(w http.ResponseWriter, r *http.Request) {
+	var (
+		ctx    = r.Context()
+		handle = chi.URLParam(r, "handle")
+	)
+
+	id, err := uuid.Parse(chi.URLParam(r, "linkID"))
+	if err != nil {
+		s.responderHandler.Respond(w, r, &responder.ResponseCmd{
+			Path:  "/" + handle,
+			Error: account.ErrLinkNotFound,
+		})
+		return
+	}
+
+	l, err := s.accountHandler.GetLink(ctx, &account.GetLinkCmd{ID: id, Handle: handle})
+	if err != nil {
+		s.responderHandler.Respond(w, r, &responder.ResponseCmd{
+			Path:  "/" + handle,
+			Error: err,
+		})
+		return
+	}
+
+	s.clickRecorder.Record(tracking.NewClick(l.AccountID, l.ID, externalReferrer(r), r.UserAgent()))
+
+	http.Redirect(w, r, l.Link, http.StatusFound)
+}
+
+func (s *Handler) renderFavicon(w http.ResponseWriter, r *http.Request) {
+	var (
+		ctx    = r.Context()
+		handle = chi.URLParam(r, "handle")
+	)
+
+	id, err := uuid.Parse(chi.URLParam(r, "linkID"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	l, err := s.accountHandler.GetLink(ctx, &account.GetLinkCmd{ID: id, Handle: handle})
+	if err != nil || len(l.Favicon) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+
+	serveImage(w, r, l.Favicon, l.UpdatedAt)
+}
+
+// fetchFavicons queues up the links that don't have a favicon yet
+func (s *Handler) fetchFavicons(a *account.Account) {
+	for i := range a.Links {
+		if len(a.Links[i].Favicon) == 0 {
+			s.faviconQueue.Enqueue(&favicon.Job{
+				LinkID:    a.Links[i].ID,
+				AccountID: a.ID,
+				URL:       a.Links[i].Link,
+			})
+		}
+	}
+}
+
+// externalReferrer returns the referrer unless it's one of our own pages,
+// moving around our own site doesn't tell the user where people come from
+func externalReferrer(r *http.Request) string {
+	ref := r.Referer()
+	if ref == "" {
+		return ""
+	}
+
+	u, err := url.Parse(ref)
+	if err != nil || u.Host == r.Host {
+		return ""
+	}
+
+	return ref
+}
+
+func (s *Handler) handleUpdateAvatar(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	so, ok := ctx.Value(session.SessionObjectKey).(*session.Session)
+	if !ok {
+		s.responderHandler.Respond(w, r, &responder.ResponseCmd{
+			Path:  "/login",
+			Error: session.ErrNotAuthenticated,
+		})
+		return
+	}
+
+	f, _, err := r.FormFile("avatar")
+	if err != nil {
+		s.responderHandler.Respond(w, r, &responder.ResponseCmd{
+			Path:     "/account",
+			ErrorMsg: "Pick an image to upload!",
+		})
+		return
+	}
+	defer f.Close()
+
+	b, err := io.ReadAll(f)
+	if err != nil {
+		s.responderHandler.Respond(w, r, &responder.ResponseCmd{
+			Path:  "/account",
+			Error: fmt.Errorf("failed to read avatar: %w", err),
+		})
+		return
+	}
+
+	if len(b) == 0 {
+		s.responderHandler.Respond(w, r, &responder.ResponseCmd{
+			Path:     "/account",
+			ErrorMsg: "Pick an image to upload!",
+		})
+		return
+	}
+
+	_, err = s.accountHandler.UpdateAvatar(ctx, &account.UpdateAvatarCmd{
+		AccountID: so.AccountID,
+		Image:     b,
+	})
+	if err != nil {
+		s.responderHandler.Respond(w, r, &responder.ResponseCmd{
+			Path:  "/account",
+			Error: err,
+		})
+		return
+	}
+
+	s.responderHandler.Respond(w, r, &responder.ResponseCmd{
+		Path:    "/account",
+		Message: "Successfully updated your avatar!",
+	})
+}
+
+func (s *Handler) handleRemoveAvatar(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	so, ok := ctx.Value(session.SessionObjectKey).(*session.Session)
+	if !ok {
+		s.responderHandler.Respond(w, r, &responder.ResponseCmd{
+			Path:  "/login",
+			Error: session.ErrNotAuthenticated,
+		})
+		return
+	}
+
+	_, err := s.accountHandler.UpdateAvatar(ctx, &account.UpdateAvatarCmd{AccountID: so.AccountID})
+	if err != nil {
+		s.responderHandler.Respond(w, r, &responder.ResponseCmd{
+			Path:  "/account",
+			Error: err,
+		})
+		return
+	}
+
+	s.responderHandler.Respond(w, r, &responder.ResponseCmd{
+		Path:    "/account",
+		Message: "Successfully removed your avatar!",
+	})
+}
+
+func (s *Handler) renderShareImage(w http.ResponseWriter, r *http.Request) {
+	var (
+		ctx    = r.Context()
+		handle = chi.URLParam(r, "handle")
+	)
+
+	a, err := s.profileHandler.Resolve(ctx, &account.ResolveCmd{Handle: handle})
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	img, err := s.shareHandler.Image(ctx, &share.ImageCmd{Account: a, Theme: s.themes.Get(a.Theme)})
+	if err != nil {
+		log.Println("failed to get share image", a.ID, err)
+		http.Error(w, "Failed to draw share image", http.StatusInternalServerError)
+		return
+	}
+
+	serveImage(w, r, img, a.UpdatedAt)
+}
+
+func (s *Handler) renderQRCode(w http.ResponseWriter, r *http.Request) {
+	var (
+		ctx    = r.Context()
+		q      = r.URL.Query()
+		handle = chi.URLParam(r, "handle")
+		format = chi.URLParam(r, "format")
+		size   = qr.DefaultSize
+		margin = qr.DefaultMargin
+		level  = qr.M
+		err    error
+	)
+
+	if v := q.Get("size"); v != "" {
+		if size, err = strconv.Atoi(v); err != nil || size < qr.MinSize || size > qr.MaxSize {
+			http.Error(w, qr.ErrInvalidSize.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if v := q.Get("margin"); v != "" {
+		if margin, err = strconv.Atoi(v); err != nil || margin < 0 || margin > qr.MaxMargin {
+			http.Error(w, qr.ErrInvalidMargin.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if v := q.Get("level"); v != "" {
+		if level, err = qr.ParseLevel(v); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	a, err := s.profileHandler.Resolve(ctx, &account.ResolveCmd{Handle: handle})
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	// Codes printed with a previous handle would stop working, so only the current one gets one
+	if a.Handle != handle {
+		u := *r.URL
+		u.Path = "/" + a.Handle + "/qr." + format
+		// Not permanent, the old handle stops redirecting after the grace period
+		http.Redirect(w, r, u.String(), http.StatusFound)
+		return
+	}
+
+	var (
+		link = s.baseURL + "/" + a.Handle
+		tag  = etag([]byte(link), []byte(format), []byte(strconv.Itoa(size)), []byte(strconv.Itoa(margin)), []byte(level.String()))
+	)
+
+	// The code only changes with the handle, so there's no need to draw it again
+	if r.Header.Get("If-None-Match") == tag {
+		w.Header().Set("ETag", tag)
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	code, err := qr.Encode([]byte(link), level)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if format == "svg" {
+		serveCached(w, r, code.SVG(size, margin), "image/svg+xml", tag, time.Time{})
+		return
+	}
+
+	b, err := code.PNG(size, margin)
+	if err != nil {
+		log.Println("failed to draw qr code", a.ID, err)
+		http.Error(w, "Failed to draw QR code", http.StatusInternalServerError)
+		return
+	}
+
+	serveCached(w, r, b, "image/png", tag, time.Time{})
+}
+
+func (s *Handler) renderAvatar(w http.ResponseWriter, r *http.Request) {
+	var (
+		ctx    = r.Context()
+		handle = chi.URLParam(r, "handle")
+	)
+
+	a, err := s.accountHandler.Get(ctx, &account.GetCmd{Handle: handle, Shallow: true})
+	if err != nil || a.PendingDeletion() {
+		http.NotFound(w, r)
+		return
+	}
+
+	img := a.Avi
+	if r.URL.Query().Get("size") == strconv.Itoa(account.AvatarThumbnailSize) {
+		img = a.AviThumbnail
+	}
+
+	if len(img) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+
+	serveImage(w, r, img, a.UpdatedAt)
+}
+
+func (s *Handler) handleCreateToken(w http.ResponseWriter, r *http.Request) {
+	var (
+		f   = r.Form
+		ctx = r.Context()
+		cmd = &token.CreateCmd{
+			Name: f.Get("name"),
+		}
+	)
+
+	so, ok := ctx.Value(session.SessionObjectKey).(*session.Session)
+	if !ok {
+		s.responderHandler.Respond(w, r, &responder.ResponseCmd{
+			Path:  "/login",
+			Error: session.ErrNotAuthenticated,
+		})
+		return
+	}
+
+	cmd.AccountID = so.AccountID
+
+	for _, sc := range f["scopes"] {
+		cmd.Scopes = append(cmd.Scopes, token.Scope(sc))
+	}
+
+	if len(cmd.Scopes) == 0 {
+		s.responderHandler.Respond(w, r, &responder.ResponseCmd{
+			Path:     "/account",
+			ErrorMsg: "Pick at least one scope for the token!",
+		})
+		return
+	}
+
+	// Anything that isn't a number ends up as 0 days and is rejected by Create
+	days, _ := strconv.Atoi(f.Get("expires_in"))
+	cmd.ExpiresIn = time.Duration(days) * 24 * time.Hour
+
+	_, plaintext, err := s.tokenHandler.Create(ctx, cmd)
+	if err != nil {
+		s.responderHandler.Respond(w, r, &responder.ResponseCmd{
+			Path:  "/account",
+			Error: err,
+		})
+		return
+	}
+
+	// The plaintext can't go in a redirect, so the page is rendered right away
+	s.accountPage(w, r, &views.AccountPageCmd{NewToken: plaintext})
+}
+
+func (s *Handler) handleRevokeToken(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	so, ok := ctx.Value(session.SessionObjectKey).(*session.Session)
+	if !ok {
+		s.responderHandler.Respond(w, r, &responder.ResponseCmd{
+			Path:  "/login",
+			Error: session.ErrNotAuthenticated,
+		})
+		return
+	}
+
+	id, err := uuid.Parse(chi.URLParam(r, "tokenID"))
+	if err != nil {
+		s.responderHandler.Respond(w, r, &responder.ResponseCmd{
+			Path:  "/account",
+			Error: token.ErrTokenNotFound,
+		})
+		return
+	}
+
+	if err := s.tokenHandler.Revoke(ctx, &token.RevokeCmd{AccountID: so.AccountID, ID: id}); err != nil {
+		s.responderHandler.Respond(w, r, &responder.ResponseCmd{
+			Path:  "/account",
+			Error: err,
+		})
+		return
+	}
+
+	s.responderHandler.Respond(w, r, &responder.ResponseCmd{
+		Path:    "/account",
+		Message: "Successfully revoked the token!",
+	})
+}
+
+func (s *Handler) handleEnrollTOTP(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	so, ok := ctx.Value(session.SessionObjectKey).(*session.Session)
+	if !ok {
+		s.responderHandler.Respond(w, r, &responder.ResponseCmd{
+			Path:  "/login",
+			Error: session.ErrNotAuthenticated,
+		})
+		return
+	}
+
+	if _, err := s.totpHandler.Enroll(ctx, &totp.EnrollCmd{AccountID: so.AccountID}); err != nil {
+		s.responderHandler.Respond(w, r, &responder.ResponseCmd{
+			Path:  "/account",
+			Error: err,
+		})
+		return
+	}
+
+	s.responderHandler.Respond(w, r, &responder.ResponseCmd{
+		Path:    "/account",
+		Message: "Add the account to your authenticator app and enter the first code to finish!",
+	})
+}
+
+func (s *Handler) handleConfirmTOTP(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	so, ok := ctx.Value(session.SessionObjectKey).(*session.Session)
+	if !ok {
+		s.responderHandler.Respond(w, r, &responder.ResponseCmd{
+			Path:  "/login",
+			Error: session.ErrNotAuthenticated,
+		})
+		return
+	}
+
+	codes, err := s.totpHandler.Confirm(ctx, &totp.ConfirmCmd{
+		AccountID: so.AccountID,
+		Code:      r.Form.Get("code"),
+	})
+	if err != nil {
+		s.responderHandler.Respond(w, r, &responder.ResponseCmd{
+			Path:  "/account",
+			Error: err,
+		})
+		return
+	}
+
+	// Same as tokens, the recovery codes can't go in a redirect
+	s.accountPage(w, r, &views.AccountPageCmd{RecoveryCodes: codes})
+}
+
+func (s *Handler) handleDisableTOTP(w http.ResponseWriter, r *http.Request) {
+	var (
+		f   = r.Form
+		ctx = r.Context()
+	)
+
+	so, ok := ctx.Value(session.SessionObjectKey).(*session.Session)
+	if !ok {
+		s.responderHandler.Respond(w, r, &responder.ResponseCmd{
+			Path:  "/login",
+			Error: session.ErrNotAuthenticated,
+		})
+		return
+	}
+
+	err := s.totpHandler.Disable(ctx, &totp.DisableCmd{
+		AccountID: so.AccountID,
+		Password:  f.Get("password"),
+		Code:      f.Get("code"),
+	})
+	if err != nil {
+		s.responderHandler.Respond(w, r, &responder.ResponseCmd{
+			Path:  "/account",
+			Error: err,
+		})
+		return
+	}
+
+	s.responderHandler.Respond(w, r, &responder.ResponseCmd{
+		Path:    "/account",
+		Message: "Two factor authentication is off!",
+	})
+}
+
+func (s *Handler) handlePasskeyLogin(w http.ResponseWriter, r *http.Request) {
+	var (
+		ctx = r.Context()
+		cmd = &handlers.PasskeyCmd{
+			Response: []byte(r.Form.Get("response")),
+		}
+	)
+
+	a, err := s.authHandler.Handle(ctx, &auth.AuthCmd{
+		Method: auth.Passkey,
+		Cmd:    cmd,
+	})
+	if err != nil {
+		s.responderHandler.Respond(w, r, &responder.ResponseCmd{
+			Path:  "/login",
+			Error: err,
+		})
+		return
+	}
+
+	http.SetCookie(w, session.Cookie(a.SessionToken, a.Session))
+
+	s.responderHandler.Respond(w, r, &responder.ResponseCmd{
+		Path:    "/account",
+		Message: loginMessage(a),
+	})
+}
+
+// renderPasskeyRequestOptions hands passkey.js what it passes to navigator.credentials.get
+func (s *Handler) renderPasskeyRequestOptions(w http.ResponseWriter, r *http.Request) {
+	opts, err := s.passkeyHandler.BeginLogin(r.Context())
+	if err != nil {
+		log.Println("failed to begin passkey login", err)
+		http.Error(w, "Failed to start passkey login", http.StatusInternalServerError)
+		return
+	}
+
+	writePublicKeyOptions(w, opts)
+}
+
+// renderPasskeyCreationOptions hands passkey.js what it passes to navigator.credentials.create
+func (s *Handler) renderPasskeyCreationOptions(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	so, ok := ctx.Value(session.SessionObjectKey).(*session.Session)
+	if !ok {
+		http.Error(w, session.ErrNotAuthenticated.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	a, err := s.accountHandler.Get(ctx, &account.GetCmd{ID: so.AccountID, Shallow: true})
+	if err != nil {
+		log.Println("failed to get account", err)
+		http.Error(w, "Failed to start passkey registration", http.StatusInternalServerError)
+		return
+	}
+
+	opts, err := s.passkeyHandler.BeginRegistration(ctx, &passkey.BeginRegistrationCmd{
+		AccountID: a.ID,
+		Handle:    a.Handle,
+		Name:      a.Name,
+	})
+	if err != nil {
+		log.Println("failed to begin passkey registration", err)
+		http.Error(w, "Failed to start passkey registration", http.StatusInternalServerError)
+		return
+	}
+
+	writePublicKeyOptions(w, opts)
+}
+
+func writePublicKeyOptions(w http.ResponseWriter, opts any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+
+	if err := json.NewEncoder(w).Encode(map[string]any{"publicKey": opts}); err != nil {
+		log.Println("failed to write passkey options", err)
+	}
+}
+
+func (s *Handler) handleAddPasskey(w http.ResponseWriter, r *http.Request) {
+	var (
+		f   = r.Form
+		ctx = r.Context()
+	)
+
+	so, ok := ctx.Value(session.SessionObjectKey).(*session.Session)
+	if !ok {
+		s.responderHandler.Respond(w, r, &responder.ResponseCmd{
+			Path:  "/login",
+			Error: session.ErrNotAuthenticated,
+		})
+		return
+	}
+
+	_, err := s.authHandler.Handle(ctx, &auth.AuthCmd{
+		Method: auth.PasskeyRegistration,
+		Cmd: &handlers.PasskeyRegistrationCmd{
+			AccountID: so.AccountID,
+			Name:      f.Get("name"),
+			Response:  []byte(f.Get("response")),
+		},
+	})
+	if err != nil {
+		s.responderHandler.Respond(w, r, &responder.ResponseCmd{
+			Path:  "/account",
+			Error: err,
+		})
+		return
+	}
+
+	s.responderHandler.Respond(w, r, &responder.ResponseCmd{
+		Path:    "/account",
+		Message: "Successfully added the passkey!",
+	})
+}
+
+func (s *Handler) handleRenamePasskey(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	so, ok := ctx.Value(session.SessionObjectKey).(*session.Session)
+	if !ok {
+		s.responderHandler.Respond(w, r, &responder.ResponseCmd{
+			Path:  "/login",
+			Error: session.ErrNotAuthenticated,
+		})
+		return
+	}
+
+	id, err := uuid.Parse(chi.URLParam(r, "passkeyID"))
+	if err != nil {
+		s.responderHandler.Respond(w, r, &responder.ResponseCmd{
+			Path:  "/account",
+			Error: passkey.ErrPasskeyNotFound,
+		})
+		return
+	}
+
+	err = s.passkeyHandler.Rename(ctx, &passkey.RenameCmd{
+		AccountID: so.AccountID,
+		ID:        id,
+		Name:      r.Form.Get("name"),
+	})
+	if err != nil {
+		s.responderHandler.Respond(w, r, &responder.ResponseCmd{
+			Path:  "/account",
+			Error: err,
+		})
+		return
+	}
+
+	s.responderHandler.Respond(w, r, &responder.ResponseCmd{
+		Path:    "/account",
+		Message: "Successfully renamed the passkey!",
+	})
+}
+
+func (s *Handler) handleDeletePasskey(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	so, ok := ctx.Value(session.SessionObjectKey).(*session.Session)
+	if !ok {
+		s.responderHandler.Respond(w, r, &responder.ResponseCmd{
+			Path:  "/login",
+			Error: session.ErrNotAuthenticated,
+		})
+		return
+	}
+
+	id, err := uuid.Parse(chi.URLParam(r, "passkeyID"))
+	if err != nil {
+		s.responderHandler.Respond(w, r, &responder.ResponseCmd{
+			Path:  "/account",
+			Error: passkey.ErrPasskeyNotFound,
+		})
+		return
+	}
+
+	if err := s.passkeyHandler.Delete(ctx, &passkey.DeleteCmd{AccountID: so.AccountID, ID: id}); err != nil {
+		s.responderHandler.Respond(w, r, &responder.ResponseCmd{
+			Path:  "/account",
+			Error: err,
+		})
+		return
+	}
+
+	s.responderHandler.Respond(w, r, &responder.ResponseCmd{
+		Path:    "/account",
+		Message: "Successfully removed the passkey!",
+	})
+}
+
+func (s *Handler) renderLoginPage(w http.ResponseWriter, r *http.Request) {
+	s.viewsHandler.Render(r.Context(), w, views.Login, &views.RenderCmd{
+		Error:   r.URL.Query().Get("error"),
+		Message: r.URL.Query().Get("message"),
+		Cmd:     &views.LoginPageCmd{Providers: s.oidcHandler.Providers()},
+	})
+}
+
+func (s *Handler) handleBeginOIDC(w http.ResponseWriter, r *http.Request) {
+	rd, err := s.oidcHandler.Begin(r.Context(), &oidc.BeginCmd{Provider: chi.URLParam(r, "provider")})
+	if err != nil {
+		s.responderHandler.Respond(w, r, &responder.ResponseCmd{
+			Path:  "/login",
+			Error: err,
+		})
+		return
+	}
+
+	http.SetCookie(w, oidc.StateCookie(rd.State))
+	http.Redirect(w, r, rd.URL, http.StatusFound)
+}
+
+func (s *Handler) handleConnectOIDC(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	so, ok := ctx.Value(session.SessionObjectKey).(*session.Session)
+	if !ok {
+		s.responderHandler.Respond(w, r, &responder.ResponseCmd{
+			Path:  "/login",
+			Error: session.ErrNotAuthenticated,
+		})
+		return
+	}
+
+	rd, err := s.oidcHandler.Begin(ctx, &oidc.BeginCmd{
+		Provider:  chi.URLParam(r, "provider"),
+		AccountID: so.AccountID,
+	})
+	if err != nil {
+		s.responderHandler.Respond(w, r, &responder.ResponseCmd{
+			Path:  "/account",
+			Error: err,
+		})
+		return
+	}
+
+	http.SetCookie(w, oidc.StateCookie(rd.State))
+	http.Redirect(w, r, rd.URL, http.StatusFound)
+}
+
+func (s *Handler) handleOIDCCallback(w http.ResponseWriter, r *http.Request) {
+	var (
+		q   = r.URL.Query()
+		ctx = r.Context()
+		cmd = &handlers.OIDCCmd{
+			State: q.Get("state"),
+			Code:  q.Get("code"),
+			Error: q.Get("error"),
+		}
+		// Where to go if it doesn't work out
+		path = "/login"
+	)
+
+	if so, ok := ctx.Value(session.SessionObjectKey).(*session.Session); ok {
+		cmd.AccountID = so.AccountID
+		path = "/account"
+	}
+
+	http.SetCookie(w, oidc.RemoveCookie(oidc.StateCookieName))
+
+	// The state has to come back to the browser that asked for it, otherwise
+	// someone could log a victim in to the attacker's account
+	c, err := r.Cookie(oidc.StateCookieName)
+	if err != nil || cmd.State == "" || subtle.ConstantTimeCompare([]byte(c.Value), []byte(cmd.State)) != 1 {
+		s.responderHandler.Respond(w, r, &responder.ResponseCmd{
+			Path:  path,
+			Error: oidc.ErrStateInvalid,
+		})
+		return
+	}
+
+	a, err := s.authHandler.Handle(ctx, &auth.AuthCmd{
+		Method: auth.OIDC,
+		Cmd:    cmd,
+	})
+	if err != nil {
+		s.responderHandler.Respond(w, r, &responder.ResponseCmd{
+			Path:  path,
+			Error: err,
+		})
+		return
+	}
+
+	switch {
+	case a.Identity != nil:
+		s.responderHandler.Respond(w, r, &responder.ResponseCmd{
+			Path:    "/account",
+			Message: "Successfully connected the login!",
+		})
+	case a.SignupToken != "":
+		http.SetCookie(w, oidc.SignupCookie(a.SignupToken))
+		http.Redirect(w, r, "/login/oidc/signup", http.StatusFound)
+	case a.PendingToken != "":
+		http.SetCookie(w, totp.Cookie(a.PendingToken))
+		http.Redirect(w, r, "/login/2fa", http.StatusFound)
+	default:
+		http.SetCookie(w, session.Cookie(a.SessionToken, a.Session))
+		s.responderHandler.Respond(w, r, &responder.ResponseCmd{
+			Path:    "/account",
+			Message: loginMessage(a),
+		})
+	}
+}
+
+func (s *Handler) renderOIDCSignupPage(w http.ResponseWriter, r *http.Request) {
+	var token string
+	if c, err := r.Cookie(oidc.SignupCookieName); err == nil {
+		token = c.Value
+	}
+
+	su, err := s.oidcHandler.GetSignup(r.Context(), &oidc.SignupCmd{Token: token})
+	if err != nil {
+		http.SetCookie(w, oidc.RemoveCookie(oidc.SignupCookieName))
+		s.responderHandler.Respond(w, r, &responder.ResponseCmd{
+			Path:  "/login",
+			Error: err,
+		})
+		return
+	}
+
+	s.viewsHandler.Render(r.Context(), w, views.OIDCSignup, &views.RenderCmd{
+		Error:   r.URL.Query().Get("error"),
+		Message: r.URL.Query().Get("message"),
+		Cmd:     &views.OIDCSignupPageCmd{Signup: su},
+	})
+}
+
+func (s *Handler) handleOIDCSignup(w http.ResponseWriter, r *http.Request) {
+	var (
+		f   = r.Form
+		ctx = r.Context()
+		cmd = &handlers.OIDCSignupCmd{
+			Name:   f.Get("name"),
+			Handle: f.Get("handle"),
+		}
+	)
+
+	if c, err := r.Cookie(oidc.SignupCookieName); err == nil {
+		cmd.SignupToken = c.Value
+	}
+
+	a, err := s.authHandler.Handle(ctx, &auth.AuthCmd{
+		Method: auth.OIDCSignup,
+		Cmd:    cmd,
+	})
+	if err != nil {
+		path := "/login/oidc/signup"
+		if errors.Is(err, oidc.ErrSignupGone) {
+			http.SetCookie(w, oidc.RemoveCookie(oidc.SignupCookieName))
+			path = "/login"
+		}
+
+		s.responderHandler.Respond(w, r, &responder.ResponseCmd{
+			Path:  path,
+			Error: err,
+		})
+		return
+	}
+
+	http.SetCookie(w, oidc.RemoveCookie(oidc.SignupCookieName))
+	http.SetCookie(w, session.Cookie(a.SessionToken, a.Session))
+
+	s.responderHandler.Respond(w, r, &responder.ResponseCmd{
+		Path:    "/account",
+		Message: "Successfully registered!",
+	})
+}
+
+func (s *Handler) handleDisconnectOIDC(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	so, ok := ctx.Value(session.SessionObjectKey).(*session.Session)
+	if !ok {
+		s.responderHandler.Respond(w, r, &responder.ResponseCmd{
+			Path:  "/login",
+			Error: session.ErrNotAuthenticated,
+		})
+		return
+	}
+
+	id, err := uuid.Parse(chi.URLParam(r, "identityID"))
+	if err != nil {
+		s.responderHandler.Respond(w, r, &responder.ResponseCmd{
+			Path:  "/account",
+			Error: oidc.ErrIdentityMissing,
+		})
+		return
+	}
+
+	if err := s.oidcHandler.Unlink(ctx, &oidc.UnlinkCmd{AccountID: so.AccountID, ID: id}); err != nil {
+		s.responderHandler.Respond(w, r, &responder.ResponseCmd{
+			Path:  "/account",
+			Error: err,
+		})
+		return
+	}
+
+	s.responderHandler.Respond(w, r, &responder.ResponseCmd{
+		Path:    "/account",
+		Message: "Successfully disconnected the login!",
+	})
+}
+
+func (s *Handler) handleUpdateEmail(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	so, ok := ctx.Value(session.SessionObjectKey).(*session.Session)
+	if !ok {
+		s.responderHandler.Respond(w, r, &responder.ResponseCmd{
+			Path:  "/login",
+			Error: session.ErrNotAuthenticated,
+		})
+		return
+	}
+
+	a, err := s.accountHandler.UpdateEmail(ctx, &account.UpdateEmailCmd{
+		AccountID: so.AccountID,
+		Email:     r.Form.Get("email"),
+	})
+	if err != nil {
+		s.responderHandler.Respond(w, r, &responder.ResponseCmd{
+			Path:  "/account",
+			Error: err,
+		})
+		return
+	}
+
+	if a.Email == "" || a.EmailVerified() {
+		s.responderHandler.Respond(w, r, &responder.ResponseCmd{
+			Path:    "/account",
+			Message: "Successfully updated email!",
+		})
+		return
+	}
+
+	if err := s.recoveryHandler.SendVerification(ctx, &recovery.SendVerificationCmd{AccountID: a.ID}); err != nil {
+		s.responderHandler.Respond(w, r, &responder.ResponseCmd{
+			Path:  "/account",
+			Error: err,
+		})
+		return
+	}
+
+	s.responderHandler.Respond(w, r, &responder.ResponseCmd{
+		Path:    "/account",
+		Message: "Email saved, check your inbox to verify it!",
+	})
+}
+
+func (s *Handler) handleSendVerification(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	so, ok := ctx.Value(session.SessionObjectKey).(*session.Session)
+	if !ok {
+		s.responderHandler.Respond(w, r, &responder.ResponseCmd{
+			Path:  "/login",
+			Error: session.ErrNotAuthenticated,
+		})
+		return
+	}
+
+	if err := s.recoveryHandler.SendVerification(ctx, &recovery.SendVerificationCmd{AccountID: so.AccountID}); err != nil {
+		s.responderHandler.Respond(w, r, &responder.ResponseCmd{
+			Path:  "/account",
+			Error: err,
+		})
+		return
+	}
+
+	s.responderHandler.Respond(w, r, &responder.ResponseCmd{
+		Path:    "/account",
+		Message: "Sent, check your inbox!",
+	})
+}
+
+func (s *Handler) handleVerifyEmail(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	path := "/login"
+	if _, ok := ctx.Value(session.SessionObjectKey).(*session.Session); ok {
+		path = "/account"
+	}
+
+	if _, err := s.recoveryHandler.Verify(ctx, &recovery.VerifyCmd{Token: r.URL.Query().Get("token")}); err != nil {
+		s.responderHandler.Respond(w, r, &responder.ResponseCmd{
+			Path:  path,
+			Error: err,
+		})
+		return
+	}
+
+	s.responderHandler.Respond(w, r, &responder.ResponseCmd{
+		Path:    path,
+		Message: "Successfully verified your email!",
+	})
+}
+
+func (s *Handler) handleForgotPassword(w http.ResponseWriter, r *http.Request) {
+	err := s.recoveryHandler.RequestReset(r.Context(), &recovery.RequestResetCmd{Email: r.Form.Get("email")})
+	if err != nil {
+		s.responderHandler.Respond(w, r, &responder.ResponseCmd{
+			Path:  "/login/forgot",
+			Error: err,
+		})
+		return
+	}
+
+	// Same answer whether or not there is such an account
+	s.responderHandler.Respond(w, r, &responder.ResponseCmd{
+		Path:    "/login/forgot",
+		Message: "If an account has that email verified, a link is on its way!",
+	})
+}
+
+func (s *Handler) renderResetPasswordPage(w http.ResponseWriter, r *http.Request) {
+	var (
+		ctx = r.Context()
+		cmd = &recovery.ResetCmd{Token: r.URL.Query().Get("token")}
+	)
+
+	if err := s.recoveryHandler.CheckReset(ctx, cmd); err != nil {
+		s.responderHandler.Respond(w, r, &responder.ResponseCmd{
+			Path:  "/login/forgot",
+			Error: err,
+		})
+		return
+	}
+
+	// The token is in the URL, it shouldn't go anywhere else
+	w.Header().Set("Referrer-Policy", "no-referrer")
+
+	s.viewsHandler.Render(ctx, w, views.ResetPassword, &views.RenderCmd{
+		Error:   r.URL.Query().Get("error"),
+		Message: r.URL.Query().Get("message"),
+		Cmd:     &views.ResetPasswordPageCmd{Token: cmd.Token},
+	})
+}
+
+func (s *Handler) handleResetPassword(w http.ResponseWriter, r *http.Request) {
+	var (
+		f   = r.Form
+		cmd = &recovery.ResetCmd{
+			Token:    f.Get("token"),
+			Password: f.Get("password"),
+		}
+	)
+
+	if _, err := s.recoveryHandler.Reset(r.Context(), cmd); err != nil {
+		s.responderHandler.Respond(w, r, &responder.ResponseCmd{
+			Path:  "/login/forgot",
+			Error: err,
+		})
+		return
+	}
+
+	s.responderHandler.Respond(w, r, &responder.ResponseCmd{
+		Path:    "/login",
+		Message: "Password changed, log in with the new one!",
+	})
+}
+
+func (s *Handler) handleRevokeSession(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	so, ok := ctx.Value(session.SessionObjectKey).(*session.Session)
+	if !ok {
+		s.responderHandler.Respond(w, r, &responder.ResponseCmd{
+			Path:  "/login",
+			Error: session.ErrNotAuthenticated,
+		})
+		return
+	}
+
+	id, err := uuid.Parse(chi.URLParam(r, "sessionID"))
+	if err != nil {
+		s.responderHandler.Respond(w, r, &responder.ResponseCmd{
+			Path:  "/account",
+			Error: session.ErrUnknownSession,
+		})
+		return
+	}
+
+	if err := s.sessionHandler.Revoke(ctx, so.AccountID, id); err != nil {
+		s.responderHandler.Respond(w, r, &responder.ResponseCmd{
+			Path:  "/account",
+			Error: err,
+		})
+		return
+	}
+
+	// Revoking the current session is just logging out
+	if id == so.ID {
+		http.SetCookie(w, session.RemoveCookie())
+		s.responderHandler.Respond(w, r, &responder.ResponseCmd{
+			Path:    "/",
+			Message: "Successfully logged out!",
+		})
+		return
+	}
+
+	s.responderHandler.Respond(w, r, &responder.ResponseCmd{
+		Path:    "/account",
+		Message: "Successfully logged out the session!",
+	})
+}
+
+func (s *Handler) handleRevokeOtherSessions(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	so, ok := ctx.Value(session.SessionObjectKey).(*session.Session)
+	if !ok {
+		s.responderHandler.Respond(w, r, &responder.ResponseCmd{
+			Path:  "/login",
+			Error: session.ErrNotAuthenticated,
+		})
+		return
+	}
+
+	if err := s.sessionHandler.RevokeAll(ctx, so.AccountID, so.ID); err != nil {
+		s.responderHandler.Respond(w, r, &responder.ResponseCmd{
+			Path:  "/account",
+			Error: err,
+		})
+		return
+	}
+
+	s.responderHandler.Respond(w, r, &responder.ResponseCmd{
+		Path:    "/account",
+		Message: "Successfully logged out everywhere else!",
+	})
+}
+
+func (s *Handler) handleExport(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	so, ok := ctx.Value(session.SessionObjectKey).(*session.Session)
+	if !ok {
+		s.responderHandler.Respond(w, r, &responder.ResponseCmd{
+			Path:  "/login",
+			Error: session.ErrNotAuthenticated,
+		})
+		return
+	}
+
+	format, err := archive.ParseFormat(r.URL.Query().Get("format"))
+	if err != nil {
+		s.responderHandler.Respond(w, r, &responder.ResponseCmd{
+			Path:  "/account",
+			Error: err,
+		})
+		return
+	}
+
+	b, err := s.archiveHandler.Export(ctx, &archive.ExportCmd{
+		AccountID: so.AccountID,
+		Format:    format,
+	})
+	if err != nil {
+		s.responderHandler.Respond(w, r, &responder.ResponseCmd{
+			Path:  "/account",
+			Error: err,
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", format.ContentType())
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="links-%s.%s"`, time.Now().UTC().Format("2006-01-02"), format))
+	w.Header().Set("Cache-Control", "no-store")
+
+	if _, err := w.Write(b); err != nil {
+		log.Println("failed to write archive", err)
+	}
+}
+
+func (s *Handler) handleImport(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	so, ok := ctx.Value(session.SessionObjectKey).(*session.Session)
+	if !ok {
+		s.responderHandler.Respond(w, r, &responder.ResponseCmd{
+			Path:  "/login",
+			Error: session.ErrNotAuthenticated,
+		})
+		return
+	}
+
+	f, _, err := r.FormFile("archive")
+	if err != nil {
+		s.responderHandler.Respond(w, r, &responder.ResponseCmd{
+			Path:     "/account",
+			ErrorMsg: "Pick an archive to import!",
+		})
+		return
+	}
+	defer f.Close()
+
+	b, err := io.ReadAll(f)
+	if err != nil {
+		s.responderHandler.Respond(w, r, &responder.ResponseCmd{
+			Path:  "/account",
+			Error: fmt.Errorf("failed to read archive: %w", err),
+		})
+		return
+	}
+
+	a, err := s.archiveHandler.Import(ctx, &archive.ImportCmd{
+		AccountID: so.AccountID,
+		Data:      b,
+	})
+	if err != nil {
+		s.responderHandler.Respond(w, r, &responder.ResponseCmd{
+			Path:  "/account",
+			Error: err,
+		})
+		return
+	}
+
+	s.fetchFavicons(a)
+
+	s.responderHandler.Respond(w, r, &responder.ResponseCmd{
+		Path:    "/account",
+		Message: "Successfully imported your archive!",
+	})
+}
+
+func (s *Handler) handleReadImport(w http.ResponseWriter, r *http.Request) {
+	f, _, err := r.FormFile("file")
+	if err != nil {
+		s.responderHandler.Respond(w, r, &responder.ResponseCmd{
+			Path:     "/account",
+			ErrorMsg: "Pick a file to import links from!",
+		})
+		return
+	}
+	defer f.Close()
+
+	b, err := io.ReadAll(f)
+	if err != nil {
+		s.responderHandler.Respond(w, r, &responder.ResponseCmd{
+			Path:  "/account",
+			Error: fmt.Errorf("failed to read import: %w", err),
+		})
+		return
+	}
+
+	links, format, err := importer.Parse(b)
+	if err != nil {
+		s.responderHandler.Respond(w, r, &responder.ResponseCmd{
+			Path:  "/account",
+			Error: err,
+		})
+		return
+	}
+
+	// Nothing is saved until the user picks, the preview carries the links along
+	s.accountPage(w, r, &views.AccountPageCmd{
+		ImportedLinks: links,
+		ImportFormat:  format,
+	})
+}
+
+func (s *Handler) handleAddImport(w http.ResponseWriter, r *http.Request) {
+	var (
+		f   = r.Form
+		ctx = r.Context()
+		cmd = &importer.AppendCmd{}
+	)
+
+	so, ok := ctx.Value(session.SessionObjectKey).(*session.Session)
+	if !ok {
+		s.responderHandler.Respond(w, r, &responder.ResponseCmd{
+			Path:  "/login",
+			Error: session.ErrNotAuthenticated,
+		})
+		return
+	}
+
+	cmd.AccountID = so.AccountID
+
+	// Every link is posted, the checkboxes say which ones were picked
+	titles, urls := f["links_title[]"], f["links_url[]"]
+	for _, p := range f["links_picked[]"] {
+		i, err := strconv.Atoi(p)
+		if err != nil || i < 0 || i >= len(titles) || i >= len(urls) {
+			continue
+		}
+
+		cmd.Links = append(cmd.Links, importer.Link{Title: titles[i], URL: urls[i]})
+	}
+
+	res, err := s.importerHandler.Append(ctx, cmd)
+	if err != nil {
+		s.responderHandler.Respond(w, r, &responder.ResponseCmd{
+			Path:  "/account",
+			Error: err,
+		})
+		return
+	}
+
+	s.fetchFavicons(res.Account)
+
+	var (
+		message string
+		skipped []string
+	)
+
+	if n := len(res.Added); n == 1 {
+		message = "Added 1 link!"
+	} else if n > 1 {
+		message = fmt.Sprintf("Added %d links!", n)
+	}
+
+	if len(res.Duplicates) > 0 {
+		skipped = append(skipped, "Already on your page: "+listLinks(res.Duplicates))
+	}
+	if len(res.Invalid) > 0 {
+		skipped = append(skipped, "Not valid: "+listLinks(res.Invalid))
+	}
+
+	s.responderHandler.Respond(w, r, &responder.ResponseCmd{
+		Path:     "/account",
+		Message:  message,
+		ErrorMsg: strings.Join(skipped, ". "),
+	})
+}
+
+// listLinks names the first few links for a message
+func listLinks(ls []importer.Link) string {
+	const max = 3
+
+	names := make([]string, 0, max)
+	for i := 0; i < len(ls) && i < max; i++ {
+		names = append(names, ls[i].URL)
+	}
+
+	list := strings.Join(names, ", ")
+	if len(ls) > max {
+		list += fmt.Sprintf(" and %d more", len(ls)-max)
+	}
+
+	return list
+}
+
+func (s *Handler) handleDeleteAccount(w http.ResponseWriter, r *http.Request) {
+	var (
+		f   = r.Form
+		ctx = r.Context()
+	)
+
+	so, ok := ctx.Value(session.SessionObjectKey).(*session.Session)
+	if !ok {
+		s.responderHandler.Respond(w, r, &responder.ResponseCmd{
+			Path:  "/login",
+			Error: session.ErrNotAuthenticated,
+		})
+		return
+	}
+
+	a, err := s.accountHandler.RequestDeletion(ctx, &account.RequestDeletionCmd{
+		AccountID: so.AccountID,
+		Password:  f.Get("password"),
+	})
+	if err != nil {
+		s.responderHandler.Respond(w, r, &responder.ResponseCmd{
+			Path:  "/account",
+			Error: err,
+		})
+		return
+	}
+
+	// The deletion went through, so a session that outlives this only
+	// means the user can't be logged out everywhere yet
+	if err := s.sessionHandler.RevokeAll(ctx, so.AccountID, uuid.Nil); err != nil {
+		log.Println("failed to revoke sessions of deleted account", err)
+	}
+
+	http.SetCookie(w, session.RemoveCookie())
+
+	s.responderHandler.Respond(w, r, &responder.ResponseCmd{
+		Path: "/",
+		Message: fmt.Sprintf(
+			"Your account will be deleted on %s. Log back in before then to keep it.",
+			a.DeletionRequestedAt.Add(s.deletionGracePeriod).Format("January 2, 2006"),
+		),
 	})
 }
@@ -2,26 +2,46 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/derinil/links/links/account"
 	"github.com/derinil/links/links/account/auth"
 	"github.com/derinil/links/links/account/auth/handlers"
+	"github.com/derinil/links/links/account/oidc"
+	"github.com/derinil/links/links/account/passkey"
+	"github.com/derinil/links/links/account/recovery"
 	"github.com/derinil/links/links/account/session"
+	"github.com/derinil/links/links/account/token"
+	"github.com/derinil/links/links/account/totp"
+	"github.com/derinil/links/links/analytics"
+	"github.com/derinil/links/links/archive"
 	"github.com/derinil/links/links/cache"
 	"github.com/derinil/links/links/crypto/csrf"
 	"github.com/derinil/links/links/database"
 	"github.com/derinil/links/links/database/migrator"
+	"github.com/derinil/links/links/favicon"
 	"github.com/derinil/links/links/generic"
+	"github.com/derinil/links/links/importer"
+	"github.com/derinil/links/links/mail"
+	"github.com/derinil/links/links/profile"
+	"github.com/derinil/links/links/ratelimit"
+	"github.com/derinil/links/links/share"
+	"github.com/derinil/links/links/theme"
+	"github.com/derinil/links/links/tracking"
 	"github.com/derinil/links/links/views"
 	"github.com/derinil/links/links/web"
+	"github.com/derinil/links/links/web/api"
 	"github.com/derinil/links/links/web/responder"
 	"github.com/derinil/links/migrations"
 	"github.com/go-chi/chi/v5"
@@ -36,17 +56,132 @@ type config struct {
 		MaxConns int    `default:"100"`
 		DSN      string `required:"true"`
 	}
+	// Cache is either "redis" or "memory", which keeps everything in the
+	// process and only works with a single instance
+	Cache struct {
+		Driver string `default:"redis"`
+		// Bounds the memory cache's pool for what anonymous requests can add,
+		// like cached profiles and passkey and OIDC logins in progress
+		MaxEntries int   `split_words:"true" default:"100000"`
+		MaxBytes   int64 `split_words:"true" default:"67108864"`
+		// Sessions, pending logins and recovery tokens get a pool with these
+		// bounds, and rate limits another one, so the first pool filling up
+		// can't evict them
+		AuthMaxEntries  int           `split_words:"true" default:"1000000"`
+		AuthMaxBytes    int64         `split_words:"true" default:"268435456"`
+		JanitorInterval time.Duration `split_words:"true" default:"1m"`
+	}
+	// Only needed with the redis cache driver
 	Redis struct {
-		Address  string `required:"true"`
+		Address  string
 		Password string
 	}
 	Server struct {
 		RequestTimeout    time.Duration `default:"30s"`
 		ReadHeaderTimeout time.Duration `default:"5s"`
+		// Link previews need absolute URLs, so public pages point here
+		BaseURL string `split_words:"true" default:"http://localhost:8080"`
+		// X-Forwarded-For and X-Real-IP are only read from requests that come
+		// from these addresses or CIDR ranges, like LINKS_SERVER_TRUSTED_PROXIES=10.0.0.0/8,127.0.0.1
+		TrustedProxies trustedProxies `split_words:"true"`
 	}
 	Secrets struct {
 		CSRFKey []byte `split_words:"true" required:"true"`
 	}
+	Accounts struct {
+		HandleGracePeriod time.Duration `split_words:"true" default:"720h"`
+		// Deleted accounts are purged after this, logging in before cancels the deletion
+		DeletionGracePeriod time.Duration `split_words:"true" default:"720h"`
+		PurgeInterval       time.Duration `split_words:"true" default:"1h"`
+	}
+	// Public profile pages are cached until the account is saved, or CacheTTL
+	Profiles struct {
+		CacheTTL time.Duration `split_words:"true" default:"10m"`
+		// Share images are drawn again when the account changes, old ones expire after this
+		ShareImageTTL time.Duration `split_words:"true" default:"24h"`
+	}
+	// Sessions are extended as they are used, up to MaxLifetime
+	Sessions struct {
+		IdleTimeout         time.Duration `split_words:"true" default:"24h"`
+		RememberIdleTimeout time.Duration `split_words:"true" default:"720h"`
+		MaxLifetime         time.Duration `split_words:"true" default:"2160h"`
+	}
+	Tracking struct {
+		BufferSize    int           `split_words:"true" default:"10000"`
+		BatchSize     int           `split_words:"true" default:"500"`
+		FlushInterval time.Duration `split_words:"true" default:"5s"`
+	}
+	Favicons struct {
+		BufferSize  int `split_words:"true" default:"1000"`
+		Concurrency int `default:"4"`
+	}
+	// Passkeys only work on the domain in RPID, and only when the
+	// site is opened from Origin
+	WebAuthn struct {
+		RPID   string `split_words:"true" default:"localhost"`
+		RPName string `split_words:"true" default:"Links"`
+		Origin string `default:"http://localhost:8080"`
+	}
+	OIDC struct {
+		// Every provider has to allow this as a redirect URI
+		RedirectURL string `split_words:"true" default:"http://localhost:8080/login/oidc/callback"`
+		Providers   oidcProviders
+	}
+	Mail struct {
+		// Links in mails point here
+		BaseURL string `split_words:"true" default:"http://localhost:8080"`
+		From    string `default:"Links <links@localhost>"`
+		// Without an SMTP host mails are written to File,
+		// or to the log if that's empty as well
+		SMTPHost     string `split_words:"true"`
+		SMTPPort     int    `split_words:"true" default:"587"`
+		SMTPUsername string `split_words:"true"`
+		SMTPPassword string `split_words:"true"`
+		File         string
+	}
+}
+
+// oidcProviders is read from JSON so any number of providers fit in one variable, like
+// LINKS_OIDC_PROVIDERS='[{"name":"corp","display_name":"Corp SSO","issuer":"https://sso.corp.com","client_id":"links","client_secret":"..."}]'
+type oidcProviders []oidc.ProviderConfig
+
+func (p *oidcProviders) Decode(value string) error {
+	return json.Unmarshal([]byte(value), (*[]oidc.ProviderConfig)(p))
+}
+
+// trustedProxies takes single addresses as well as CIDR ranges
+type trustedProxies []*net.IPNet
+
+func (p *trustedProxies) Decode(value string) error {
+	for _, v := range strings.Split(value, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+
+		if !strings.Contains(v, "/") {
+			ip := net.ParseIP(v)
+			if ip == nil {
+				return fmt.Errorf("invalid trusted proxy %q", v)
+			}
+
+			if ip4 := ip.To4(); ip4 != nil {
+				*p = append(*p, &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)})
+			} else {
+				*p = append(*p, &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)})
+			}
+			continue
+		}
+
+		_, n, err := net.ParseCIDR(v)
+		if err != nil {
+			return fmt.Errorf("invalid trusted proxy %q: %w", v, err)
+		}
+
+		*p = append(*p, n)
+	}
+
+	return nil
 }
 
 func main() {
@@ -84,24 +219,123 @@ func runServer(cfg *config) error {
 		}
 	}()
 
-	rds, err := cache.NewRedis(ctx, cfg.Redis.Address, cfg.Redis.Password)
+	kv, closeCache, err := newCaches(ctx, cfg)
 	if err != nil {
-		return fmt.Errorf("failed to open redis: %w", err)
+		return fmt.Errorf("failed to set up cache: %w", err)
 	}
 
 	defer func() {
-		if err := rds.Close(); err != nil {
-			log.Fatalln("failed to close redis", err)
+		if err := closeCache(); err != nil {
+			log.Fatalln("failed to close cache", err)
 		}
 	}()
 
 	var (
-		accountReader = database.NewAccountReader(db)
-		accountWriter = database.NewAccountWriter(db)
+		accountReader   = database.NewAccountReader(db)
+		accountWriter   = profile.NewWriter(database.NewAccountWriter(db), kv.public)
+		accountDeleter  = profile.NewDeleter(database.NewAccountWriter(db), kv.public)
+		clickWriter     = database.NewClickWriter(db)
+		viewWriter      = database.NewProfileViewWriter(db)
+		analyticsReader = database.NewAnalyticsReader(db)
+		linkWriter      = profile.NewFaviconWriter(database.NewLinkWriter(db), kv.public)
+		tokenReader     = database.NewTokenReader(db)
+		tokenWriter     = database.NewTokenWriter(db)
+		totpReader      = database.NewTOTPReader(db)
+		totpWriter      = database.NewTOTPWriter(db)
+		passkeyReader   = database.NewPasskeyReader(db)
+		passkeyWriter   = database.NewPasskeyWriter(db)
+		identityReader  = database.NewIdentityReader(db)
+		identityWriter  = database.NewIdentityWriter(db)
 	)
 
+	purgerConfig := account.PurgerConfig{
+		GracePeriod: cfg.Accounts.DeletionGracePeriod,
+		Interval:    cfg.Accounts.PurgeInterval,
+	}
+
 	var (
-		sessionHandler = session.NewHandler(rds)
+		clickRecorder = tracking.NewBatchRecorder[tracking.Click](
+			clickWriter,
+			cfg.Tracking.BufferSize,
+			cfg.Tracking.BatchSize,
+			cfg.Tracking.FlushInterval,
+		)
+		viewRecorder = tracking.NewBatchRecorder[tracking.ProfileView](
+			viewWriter,
+			cfg.Tracking.BufferSize,
+			cfg.Tracking.BatchSize,
+			cfg.Tracking.FlushInterval,
+		)
+		faviconWorker = favicon.NewWorker(
+			favicon.NewFetcher(favicon.NewClient()),
+			linkWriter,
+			cfg.Favicons.BufferSize,
+			cfg.Favicons.Concurrency,
+		)
+		accountPurger = account.NewPurger(accountDeleter, purgerConfig)
+		workers       sync.WaitGroup
+	)
+
+	workers.Add(4)
+	go func() {
+		defer workers.Done()
+		clickRecorder.Run(ctx)
+	}()
+	go func() {
+		defer workers.Done()
+		viewRecorder.Run(ctx)
+	}()
+	go func() {
+		defer workers.Done()
+		faviconWorker.Run(ctx)
+	}()
+	go func() {
+		defer workers.Done()
+		accountPurger.Run(ctx)
+	}()
+
+	passkeyConfig := passkey.Config{
+		RPID:   cfg.WebAuthn.RPID,
+		RPName: cfg.WebAuthn.RPName,
+		Origin: cfg.WebAuthn.Origin,
+	}
+
+	oidcConfig := oidc.Config{
+		RedirectURL: cfg.OIDC.RedirectURL,
+		Providers:   cfg.OIDC.Providers,
+	}
+
+	mailSender, closeMail, err := newMailSender(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to set up mail: %w", err)
+	}
+
+	defer func() {
+		if err := closeMail(); err != nil {
+			log.Println("failed to close mail file", err)
+		}
+	}()
+
+	themes, err := theme.Load(views.ThemeFiles, "themes")
+	if err != nil {
+		return fmt.Errorf("failed to load themes: %w", err)
+	}
+
+	recoveryConfig := recovery.Config{BaseURL: cfg.Mail.BaseURL}
+	profileConfig := profile.Config{
+		TTL:               cfg.Profiles.CacheTTL,
+		HandleGracePeriod: cfg.Accounts.HandleGracePeriod,
+	}
+	shareConfig := share.Config{TTL: cfg.Profiles.ShareImageTTL}
+	sessionConfig := session.Config{
+		IdleTimeout:         cfg.Sessions.IdleTimeout,
+		RememberIdleTimeout: cfg.Sessions.RememberIdleTimeout,
+		MaxLifetime:         cfg.Sessions.MaxLifetime,
+	}
+
+	var (
+		sessionHandler = session.NewHandler(kv.auth, sessionConfig)
+		limiter        = ratelimit.NewLimiter(kv.limits)
 		csrfHandler    = csrf.NewHandler(cfg.Secrets.CSRFKey)
 		viewsHandler   = views.NewHandler(
 			views.IndexPageRenderer(),
@@ -109,12 +343,32 @@ func runServer(cfg *config) error {
 			views.LinksPageRenderer(),
 			views.AccountPageRenderer(),
 			views.RegisterPageRenderer(),
+			views.TwoFactorPageRenderer(),
+			views.OIDCSignupPageRenderer(),
+			views.ForgotPasswordPageRenderer(),
+			views.ResetPasswordPageRenderer(),
 		)
-		accountHandler = account.NewHandler(accountReader, accountWriter)
-		authHandler    = auth.NewHandler(
+		accountHandler   = account.NewHandler(accountReader, accountWriter, cfg.Accounts.HandleGracePeriod)
+		analyticsHandler = analytics.NewHandler(analyticsReader)
+		tokenHandler     = token.NewHandler(tokenReader, tokenWriter)
+		totpHandler      = totp.NewHandler(totpReader, totpWriter, kv.auth, accountHandler)
+		passkeyHandler   = passkey.NewHandler(passkeyReader, passkeyWriter, kv.public, passkeyConfig)
+		oidcHandler      = oidc.NewHandler(identityReader, identityWriter, kv.public, accountHandler, nil, oidcConfig)
+		recoveryHandler  = recovery.NewHandler(kv.auth, accountHandler, sessionHandler, mailSender, views.NewMailRenderer(), recoveryConfig)
+		profileHandler   = profile.NewHandler(kv.public, accountHandler, profileConfig)
+		shareHandler     = share.NewHandler(kv.public, shareConfig)
+		archiveHandler   = archive.NewHandler(accountHandler, accountWriter, analyticsHandler, themes)
+		importerHandler  = importer.NewHandler(accountHandler)
+		authHandler      = auth.NewHandler(
 			handlers.LogoutHandler(sessionHandler),
-			handlers.LoginHandler(accountHandler, sessionHandler),
+			handlers.LoginHandler(accountHandler, sessionHandler, totpHandler, limiter),
+			handlers.TwoFactorHandler(accountHandler, sessionHandler, totpHandler, limiter),
 			handlers.RegistrationHandler(accountHandler, sessionHandler),
+			handlers.AccessTokenHandler(accountHandler, tokenHandler),
+			handlers.PasskeyHandler(accountHandler, sessionHandler, passkeyHandler),
+			handlers.PasskeyRegistrationHandler(passkeyHandler),
+			handlers.OIDCHandler(accountHandler, sessionHandler, totpHandler, oidcHandler),
+			handlers.OIDCSignupHandler(accountHandler, sessionHandler, oidcHandler),
 		)
 	)
 
@@ -127,7 +381,25 @@ func runServer(cfg *config) error {
 			accountHandler,
 			sessionHandler,
 			responderHandler,
+			clickRecorder,
+			viewRecorder,
+			analyticsHandler,
+			faviconWorker,
+			tokenHandler,
+			totpHandler,
+			passkeyHandler,
+			oidcHandler,
+			recoveryHandler,
+			limiter,
+			profileHandler,
+			themes,
+			shareHandler,
+			archiveHandler,
+			importerHandler,
+			strings.TrimSuffix(cfg.Server.BaseURL, "/"),
+			cfg.Accounts.DeletionGracePeriod,
 		)
+		apiHandler = api.NewHandler(authHandler, accountHandler, sessionHandler, faviconWorker, limiter)
 
 		router = chi.NewMux()
 		server = &http.Server{
@@ -144,7 +416,7 @@ func runServer(cfg *config) error {
 	)
 
 	router.Use(generic.RequestBeginTime)
-	router.Use(middleware.RealIP)
+	router.Use(generic.RealIP(cfg.Server.TrustedProxies))
 	router.Use(middleware.Recoverer)
 	if cfg.Environment == "local" {
 		router.Use(middleware.NoCache)
@@ -157,6 +429,7 @@ func runServer(cfg *config) error {
 		router.Handle("/static/*", http.FileServer(http.FS(views.StaticFiles)))
 	}
 
+	router.Mount("/api/v1", apiHandler.Router())
 	router.Mount("/", webHandler.Router())
 
 	go server.ListenAndServe()
@@ -168,9 +441,107 @@ func runServer(cfg *config) error {
 	<-quit
 	cancel()
 
+	// Save the events that are still buffered and let the favicon
+	// fetches and reset mails in progress finish before the database closes
+	workers.Wait()
+	recoveryHandler.Wait()
+
 	return nil
 }
 
+// caches keeps what anonymous requests can add apart from what they can't, so
+// filling the cache with the first can't evict sessions or lockouts
+type caches struct {
+	// Sessions, pending two factor logins and recovery tokens
+	auth cache.Cache
+	// Rate limit counters and lockouts
+	limits cache.Cache
+	// Everything else, like cached profiles and share images and
+	// passkey and OIDC logins in progress
+	public cache.Cache
+}
+
+// newCaches opens the configured cache, the returned func closes it. Redis
+// has a single pool, so all of them are the same client then.
+func newCaches(ctx context.Context, cfg *config) (*caches, func() error, error) {
+	switch cfg.Cache.Driver {
+	case "redis":
+		if cfg.Redis.Address == "" {
+			return nil, nil, errors.New("LINKS_REDIS_ADDRESS is required with the redis cache driver")
+		}
+
+		rds, err := cache.NewRedis(ctx, cfg.Redis.Address, cfg.Redis.Password)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to open redis: %w", err)
+		}
+
+		return &caches{auth: rds, limits: rds, public: rds}, rds.Close, nil
+	case "memory":
+		var (
+			auth = cache.NewMemory(cache.MemoryConfig{
+				MaxEntries:      cfg.Cache.AuthMaxEntries,
+				MaxBytes:        cfg.Cache.AuthMaxBytes,
+				JanitorInterval: cfg.Cache.JanitorInterval,
+			})
+			limits = cache.NewMemory(cache.MemoryConfig{
+				MaxEntries:      cfg.Cache.AuthMaxEntries,
+				MaxBytes:        cfg.Cache.AuthMaxBytes,
+				JanitorInterval: cfg.Cache.JanitorInterval,
+			})
+			public = cache.NewMemory(cache.MemoryConfig{
+				MaxEntries:      cfg.Cache.MaxEntries,
+				MaxBytes:        cfg.Cache.MaxBytes,
+				JanitorInterval: cfg.Cache.JanitorInterval,
+			})
+		)
+
+		closeAll := func() error {
+			var err error
+			for _, c := range []*cache.Memory{auth, limits, public} {
+				if cerr := c.Close(); cerr != nil && err == nil {
+					err = cerr
+				}
+			}
+			return err
+		}
+
+		return &caches{auth: auth, limits: limits, public: public}, closeAll, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown cache driver %q", cfg.Cache.Driver)
+	}
+}
+
+// newMailSender picks SMTP when there's a host, the returned func closes the mail file if one was opened
+func newMailSender(cfg *config) (mail.Sender, func() error, error) {
+	noop := func() error { return nil }
+
+	if cfg.Mail.SMTPHost != "" {
+		s, err := mail.NewSMTPSender(mail.SMTPConfig{
+			Host:     cfg.Mail.SMTPHost,
+			Port:     cfg.Mail.SMTPPort,
+			Username: cfg.Mail.SMTPUsername,
+			Password: cfg.Mail.SMTPPassword,
+			From:     cfg.Mail.From,
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to create smtp sender: %w", err)
+		}
+
+		return s, noop, nil
+	}
+
+	if cfg.Mail.File != "" {
+		f, err := os.OpenFile(cfg.Mail.File, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to open mail file: %w", err)
+		}
+
+		return mail.NewLogSender(f), f.Close, nil
+	}
+
+	return mail.NewLogSender(log.Writer()), noop, nil
+}
+
 func runMigrations(cfg *config) error {
 	ctx := context.Background()
 
@@ -191,5 +562,14 @@ func runMigrations(cfg *config) error {
 		return fmt.Errorf("failed to up migrations: %w", err)
 	}
 
+	n, err := database.NewAccountWriter(db).SanitizeCSS(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to sanitize account css: %w", err)
+	}
+
+	if n > 0 {
+		log.Println("sanitized the css of", n, "accounts")
+	}
+
 	return nil
 }
@@ -0,0 +1,13 @@
+create table identities (
+    id uuid primary key,
+    account_id uuid not null,
+    provider text not null,
+    subject text not null,
+    email text not null,
+    inserted_at timestamp not null,
+    updated_at timestamp not null,
+    unique (provider, subject),
+    foreign key (account_id) references accounts (id) on delete cascade
+);
+
+create index identities_account_id_index on identities (account_id);
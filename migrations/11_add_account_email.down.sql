@@ -0,0 +1,3 @@
+drop index if exists accounts_email_index;
+alter table accounts drop column if exists email_verified_at;
+alter table accounts drop column if exists email;
@@ -0,0 +1,5 @@
+alter table accounts add column email text not null default '';
+alter table accounts add column email_verified_at timestamp;
+
+-- Anyone can type in any email, so only verified ones have to be unique
+create unique index accounts_email_index on accounts (lower(email)) where email_verified_at is not null;
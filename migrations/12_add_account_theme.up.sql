@@ -0,0 +1,2 @@
+-- Empty is the default theme
+alter table accounts add column theme text not null default '';
@@ -0,0 +1,2 @@
+drop index if exists accounts_deletion_requested_at_index;
+alter table accounts drop column if exists deletion_requested_at;
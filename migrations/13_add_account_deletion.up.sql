@@ -0,0 +1,4 @@
+alter table accounts add column deletion_requested_at timestamp;
+
+-- The purge only looks at accounts that asked to be deleted
+create index accounts_deletion_requested_at_index on accounts (deletion_requested_at) where deletion_requested_at is not null;
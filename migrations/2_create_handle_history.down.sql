@@ -0,0 +1 @@
+drop table if exists handle_history;
@@ -0,0 +1,8 @@
+create table handle_history (
+    handle text primary key,
+    account_id uuid not null,
+    changed_at timestamp not null,
+    foreign key (account_id) references accounts (id) on delete cascade
+);
+
+create index handle_history_account_id_index on handle_history (account_id);
@@ -0,0 +1,3 @@
+alter table links drop constraint links_account_id_link_key;
+
+alter table links add constraint links_account_id_link_key unique (account_id, link);
@@ -0,0 +1,7 @@
+-- Reordering links or swapping their URLs within a single transaction
+-- temporarily violates the uniqueness of (account_id, link), so only
+-- check it once the transaction commits.
+alter table links drop constraint links_account_id_link_key;
+
+alter table links add constraint links_account_id_link_key
+    unique (account_id, link) deferrable initially deferred;
@@ -0,0 +1,14 @@
+create table clicks (
+    id uuid primary key,
+    link_id uuid not null,
+    account_id uuid not null,
+    referrer text not null,
+    user_agent_class text not null,
+    clicked_at timestamp not null,
+    foreign key (link_id) references links (id) on delete cascade,
+    foreign key (account_id) references accounts (id) on delete cascade
+);
+
+create index clicks_account_id_clicked_at_index on clicks (account_id, clicked_at);
+
+create index clicks_link_id_index on clicks (link_id);
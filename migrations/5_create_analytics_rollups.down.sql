@@ -0,0 +1,3 @@
+drop table if exists link_click_rollups;
+
+drop table if exists profile_view_rollups;
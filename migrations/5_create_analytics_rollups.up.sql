@@ -0,0 +1,28 @@
+-- Daily rollups that back the analytics on the account page. Bots are
+-- left out of the rollups, raw clicks still keep them.
+create table profile_view_rollups (
+    account_id uuid not null,
+    day date not null,
+    referrer text not null,
+    views integer not null,
+    primary key (account_id, day, referrer),
+    foreign key (account_id) references accounts (id) on delete cascade
+);
+
+create table link_click_rollups (
+    account_id uuid not null,
+    link_id uuid not null,
+    day date not null,
+    clicks integer not null,
+    primary key (link_id, day),
+    foreign key (link_id) references links (id) on delete cascade,
+    foreign key (account_id) references accounts (id) on delete cascade
+);
+
+create index link_click_rollups_account_id_day_index on link_click_rollups (account_id, day);
+
+insert into link_click_rollups (account_id, link_id, day, clicks)
+select account_id, link_id, clicked_at::date, count(*)
+from clicks
+where user_agent_class <> 'bot'
+group by account_id, link_id, clicked_at::date;
@@ -0,0 +1 @@
+alter table accounts add column avi_thumbnail bytea;
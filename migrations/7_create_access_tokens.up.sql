@@ -0,0 +1,14 @@
+create table access_tokens (
+    id uuid primary key,
+    account_id uuid not null,
+    name text not null,
+    hash bytea not null unique,
+    scopes text not null,
+    expires_at timestamp not null,
+    last_used_at timestamp,
+    inserted_at timestamp not null,
+    updated_at timestamp not null,
+    foreign key (account_id) references accounts (id) on delete cascade
+);
+
+create index access_tokens_account_id_index on access_tokens (account_id);
@@ -0,0 +1,20 @@
+create table totp (
+    account_id uuid primary key,
+    secret bytea not null,
+    confirmed_at timestamp,
+    last_step bigint not null default 0,
+    inserted_at timestamp not null,
+    updated_at timestamp not null,
+    foreign key (account_id) references accounts (id) on delete cascade
+);
+
+create table recovery_codes (
+    id uuid primary key,
+    account_id uuid not null,
+    hash bytea not null unique,
+    used_at timestamp,
+    inserted_at timestamp not null,
+    foreign key (account_id) references accounts (id) on delete cascade
+);
+
+create index recovery_codes_account_id_index on recovery_codes (account_id);
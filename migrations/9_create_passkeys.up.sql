@@ -0,0 +1,14 @@
+create table passkeys (
+    id uuid primary key,
+    account_id uuid not null,
+    name text not null,
+    credential_id bytea not null unique,
+    public_key bytea not null,
+    sign_count bigint not null,
+    last_used_at timestamp,
+    inserted_at timestamp not null,
+    updated_at timestamp not null,
+    foreign key (account_id) references accounts (id) on delete cascade
+);
+
+create index passkeys_account_id_index on passkeys (account_id);